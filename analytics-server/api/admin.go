@@ -0,0 +1,73 @@
+package api
+
+import (
+	"cyber-swipe-analytics/storage"
+	"errors"
+	"math"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// compactDatabase reclaims the space left behind by deleted rows. Since
+// compaction locks and rebuilds tables, it refuses to run in production
+// unless the request carries confirm=true.
+func (h *AnalyticsHandler) compactDatabase(c *gin.Context) {
+	if h.cfg.Environment == "production" && c.Query("confirm") != "true" {
+		c.JSON(http.StatusConflict, gin.H{
+			"error": "Compaction rebuilds tables and blocks writes; repeat the request with confirm=true to run it in production",
+		})
+		return
+	}
+
+	result, err := h.db.Compact(c.Request.Context())
+	if errors.Is(err, storage.ErrCompactUnsupported) {
+		c.JSON(http.StatusNotImplemented, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to compact database"})
+		return
+	}
+
+	c.JSON(http.StatusOK, result)
+}
+
+// checkIntegrity reports orphaned child rows and sessions without events.
+// It is read-only.
+func (h *AnalyticsHandler) checkIntegrity(c *gin.Context) {
+	report, err := h.db.CheckIntegrity(c.Request.Context())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check integrity"})
+		return
+	}
+
+	c.JSON(http.StatusOK, report)
+}
+
+// getIndexAdvice runs EXPLAIN on the core aggregation queries and reports
+// full table scans along with indexes that would avoid them. Tables with
+// fewer than min_rows estimated rows (default 1000) are not flagged, since
+// scanning them is cheap. It is read-only.
+func (h *AnalyticsHandler) getIndexAdvice(c *gin.Context) {
+	minRows, err := queryInt(c, "min_rows", 1000, 0, math.MaxInt32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	advice, err := h.db.AdviseIndexes(c.Request.Context(), int64(minRows))
+	if errors.Is(err, storage.ErrExplainUnsupported) {
+		c.JSON(http.StatusNotImplemented, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to analyze queries"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"min_rows": minRows,
+		"queries":  advice,
+	})
+}
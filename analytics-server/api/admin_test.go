@@ -0,0 +1,191 @@
+package api
+
+import (
+	"cyber-swipe-analytics/config"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestCompactDatabaseRequiresConfirmationInProduction(t *testing.T) {
+	h, mock := newTestHandler(t, map[string]string{"ENVIRONMENT": "production"})
+	router := newTestRouter(h)
+
+	expectAdminKey(mock, config.ScopeAdmin)
+	response := serve(router, http.MethodPost, "/api/analytics/admin/compact", nil, adminHeader)
+	if response.Code != http.StatusConflict {
+		t.Fatalf("unconfirmed: status %d, want 409: %s", response.Code, response.Body)
+	}
+
+	expectAdminKey(mock, config.ScopeAdmin)
+	mock.ExpectQuery(`FROM information_schema.tables`).WillReturnRows(sqlmock.NewRows([]string{"size"}).AddRow(2048))
+	mock.ExpectQuery(`OPTIMIZE TABLE`).WillReturnRows(sqlmock.NewRows([]string{"Table"}))
+	mock.ExpectQuery(`FROM information_schema.tables`).WillReturnRows(sqlmock.NewRows([]string{"size"}).AddRow(1024))
+	response = serve(router, http.MethodPost, "/api/analytics/admin/compact?confirm=true", nil, adminHeader)
+	if response.Code != http.StatusOK {
+		t.Fatalf("confirmed: status %d, want 200: %s", response.Code, response.Body)
+	}
+	if body := decodeBody(t, response); body["size_after"] != 1024.0 {
+		t.Errorf("body = %v", body)
+	}
+}
+
+func TestCompactDatabaseUnsupportedDriver(t *testing.T) {
+	h, mock := newTestHandler(t, nil)
+	h.db.Driver = "sqlite3"
+	expectAdminKey(mock, config.ScopeAdmin)
+
+	response := serve(newTestRouter(h), http.MethodPost, "/api/analytics/admin/compact", nil, adminHeader)
+	if response.Code != http.StatusNotImplemented {
+		t.Fatalf("status %d, want 501: %s", response.Code, response.Body)
+	}
+	if message, _ := decodeBody(t, response)["error"].(string); !strings.Contains(message, "sqlite3") {
+		t.Errorf("error = %q, want it to name the driver", message)
+	}
+}
+
+func TestCheckIntegrity(t *testing.T) {
+	h, mock := newTestHandler(t, nil)
+	expectAdminKey(mock, config.ScopeAdmin)
+	for _, count := range []int{2, 0, 1} {
+		mock.ExpectQuery(`LEFT JOIN sessions s`).WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(count))
+	}
+	mock.ExpectQuery(`WHERE NOT EXISTS`).WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+
+	response := serve(newTestRouter(h), http.MethodGet, "/api/analytics/admin/integrity", nil, adminHeader)
+	if response.Code != http.StatusOK {
+		t.Fatalf("status %d, want 200: %s", response.Code, response.Body)
+	}
+	body := decodeBody(t, response)
+	orphaned := body["orphaned_rows"].(map[string]interface{})
+	if orphaned["events"] != 2.0 || orphaned["performance_metrics"] != 0.0 || orphaned["category_stats"] != 1.0 {
+		t.Errorf("orphaned_rows = %v, want 2 events and 1 category row", orphaned)
+	}
+	if body["sessions_without_events"] != 0.0 {
+		t.Errorf("sessions_without_events = %v, want 0", body["sessions_without_events"])
+	}
+}
+
+func TestCheckIntegrityRequiresAdminScope(t *testing.T) {
+	h, mock := newTestHandler(t, nil)
+	expectAdminKey(mock, config.ScopeRead)
+
+	response := serve(newTestRouter(h), http.MethodGet, "/api/analytics/admin/integrity", nil, adminHeader)
+	if response.Code != http.StatusForbidden {
+		t.Errorf("status %d, want 403", response.Code)
+	}
+}
+
+func TestGetIndexAdvice(t *testing.T) {
+	h, mock := newTestHandler(t, nil)
+	expectAdminKey(mock, config.ScopeAdmin)
+
+	// Every core query is explained, and the full scan of sessions by the
+	// first one is flagged
+	const explained = 6
+	for range explained {
+		mock.ExpectQuery(`^EXPLAIN SELECT`).
+			WillReturnRows(sqlmock.NewRows([]string{"table", "type", "rows"}).AddRow("sessions", "ALL", "500"))
+	}
+
+	response := serve(newTestRouter(h), http.MethodGet, "/api/analytics/admin/index-advice?min_rows=100", nil, adminHeader)
+	if response.Code != http.StatusOK {
+		t.Fatalf("status %d, want 200: %s", response.Code, response.Body)
+	}
+	body := decodeBody(t, response)
+	queries := body["queries"].([]interface{})
+	if body["min_rows"] != 100.0 || len(queries) != explained {
+		t.Fatalf("body = %v, want %d explained queries", body, explained)
+	}
+	for _, q := range queries {
+		query := q.(map[string]interface{})
+		plan := query["plan"].([]interface{})
+		if len(plan) != 1 || plan[0].(map[string]interface{})["type"] != "ALL" {
+			t.Errorf("%v: plan = %v, want the EXPLAIN output", query["name"], plan)
+		}
+	}
+	if first := queries[0].(map[string]interface{}); first["full_scan"] != true || first["suggestion"] == nil {
+		t.Errorf("sessions scan not flagged: %v", first)
+	}
+}
+
+func TestGetIndexAdviceUnsupportedDriver(t *testing.T) {
+	h, mock := newTestHandler(t, nil)
+	h.db.Driver = "sqlite3"
+	expectAdminKey(mock, config.ScopeAdmin)
+
+	response := serve(newTestRouter(h), http.MethodGet, "/api/analytics/admin/index-advice", nil, adminHeader)
+	if response.Code != http.StatusNotImplemented {
+		t.Errorf("status %d, want 501", response.Code)
+	}
+}
+
+// expectTableCounts expects the count of each analytics table and answers
+// with rows rows, or an empty table when rows is 0.
+func expectTableCounts(mock sqlmock.Sqlmock, rows map[string]int) {
+	for _, table := range []string{"sessions", "events", "performance_metrics", "category_stats"} {
+		result := sqlmock.NewRows([]string{"count", "oldest", "newest"})
+		if rows[table] > 0 {
+			result.AddRow(rows[table], time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC), time.Date(2024, 5, 7, 0, 0, 0, 0, time.UTC))
+		} else {
+			result.AddRow(0, nil, nil)
+		}
+		mock.ExpectQuery(`SELECT COUNT\(\*\), MIN\(\w+\), MAX\(\w+\) FROM ` + table + `$`).WillReturnRows(result)
+	}
+}
+
+func TestGetTableCounts(t *testing.T) {
+	h, mock := newTestHandler(t, nil)
+	router := newTestRouter(h)
+	seeded := map[string]int{"sessions": 2, "events": 25, "performance_metrics": 6}
+
+	expectAdminKey(mock, config.ScopeAdmin)
+	expectTableCounts(mock, seeded)
+	response := serve(router, http.MethodGet, "/api/analytics/admin/counts", nil, adminHeader)
+	if response.Code != http.StatusOK {
+		t.Fatalf("status %d, want 200: %s", response.Code, response.Body)
+	}
+	body := decodeBody(t, response)
+	tables := body["tables"].(map[string]interface{})
+	for _, table := range []string{"sessions", "events", "performance_metrics", "category_stats"} {
+		count := tables[table].(map[string]interface{})
+		if count["rows"] != float64(seeded[table]) {
+			t.Errorf("%s rows = %v, want %d", table, count["rows"], seeded[table])
+		}
+		if (count["oldest"] == nil) != (seeded[table] == 0) {
+			t.Errorf("%s oldest = %v", table, count["oldest"])
+		}
+	}
+
+	// Within COUNTS_CACHE_TTL the cached counts are served without queries
+	expectAdminKey(mock, config.ScopeAdmin)
+	cached := serve(router, http.MethodGet, "/api/analytics/admin/counts", nil, adminHeader)
+	if cached.Code != http.StatusOK {
+		t.Fatalf("cached: status %d, want 200: %s", cached.Code, cached.Body)
+	}
+	if countedAt := decodeBody(t, cached)["counted_at"]; countedAt != body["counted_at"] {
+		t.Errorf("counted_at = %v, want the cached %v", countedAt, body["counted_at"])
+	}
+}
+
+func TestGetTableCountsWithoutCache(t *testing.T) {
+	h, mock := newTestHandler(t, map[string]string{"COUNTS_CACHE_TTL": "0s"})
+	router := newTestRouter(h)
+
+	// With caching off every request counts again
+	for _, events := range []int{1, 2} {
+		expectAdminKey(mock, config.ScopeAdmin)
+		expectTableCounts(mock, map[string]int{"events": events})
+		response := serve(router, http.MethodGet, "/api/analytics/admin/counts", nil, adminHeader)
+		if response.Code != http.StatusOK {
+			t.Fatalf("status %d, want 200: %s", response.Code, response.Body)
+		}
+		count := decodeBody(t, response)["tables"].(map[string]interface{})["events"].(map[string]interface{})
+		if count["rows"] != float64(events) {
+			t.Errorf("events rows = %v, want %d", count["rows"], events)
+		}
+	}
+}
@@ -0,0 +1,121 @@
+package api
+
+import (
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// defaultMinVersionSamples is the number of performance samples below which
+// an app version is flagged as having insufficient data.
+const defaultMinVersionSamples = 30
+
+// parseAppVersion extracts the numeric major, minor, and patch components
+// of an app version string such as "1.4.2" or "v1.4". It reports false
+// when the string contains no version number.
+func parseAppVersion(raw string) ([3]int, bool) {
+	var version [3]int
+	match := osVersionPattern.FindStringSubmatch(raw)
+	if match == nil {
+		return version, false
+	}
+	for i, component := range match[1:] {
+		if component == "" {
+			continue
+		}
+		n, err := strconv.Atoi(component)
+		if err != nil {
+			return version, false
+		}
+		version[i] = n
+	}
+	return version, true
+}
+
+// lessAppVersion orders app versions by their numeric components. Versions
+// without a version number sort after all others, and ties are broken by
+// the raw string.
+func lessAppVersion(a, b string) bool {
+	va, okA := parseAppVersion(a)
+	vb, okB := parseAppVersion(b)
+	if okA != okB {
+		return okA
+	}
+	for i := range va {
+		if va[i] != vb[i] {
+			return va[i] < vb[i]
+		}
+	}
+	return a < b
+}
+
+// getPerformanceByAppVersion reports the average and 95th percentile FPS
+// of each app version, ordered by version, so that a release that tanked
+// performance stands out. Versions with fewer samples than min_samples are
+// flagged as having insufficient data.
+func (h *AnalyticsHandler) getPerformanceByAppVersion(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	minSamples, err := queryInt(c, "min_samples", defaultMinVersionSamples, 1, math.MaxInt32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	rows, err := h.db.QueryContext(ctx, `
+		SELECT TRIM(s.app_version), pm.fps
+		FROM performance_metrics pm
+		JOIN sessions s ON s.session_id = pm.session_id
+		WHERE pm.fps IS NOT NULL
+			AND s.app_version IS NOT NULL
+			AND TRIM(s.app_version) != ''
+	`)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get FPS by app version"})
+		return
+	}
+	defer rows.Close()
+
+	samples := make(map[string][]float64)
+	for rows.Next() {
+		var version string
+		var fps float64
+		if err := rows.Scan(&version, &fps); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get FPS by app version"})
+			return
+		}
+		samples[version] = append(samples[version], fps)
+	}
+	if err := rows.Err(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get FPS by app version"})
+		return
+	}
+
+	versionNames := make([]string, 0, len(samples))
+	for version := range samples {
+		versionNames = append(versionNames, version)
+	}
+	sort.Slice(versionNames, func(i, j int) bool {
+		return lessAppVersion(versionNames[i], versionNames[j])
+	})
+
+	versions := make([]gin.H, 0, len(versionNames))
+	for _, version := range versionNames {
+		values := samples[version]
+		versions = append(versions, gin.H{
+			"app_version":       version,
+			"samples":           len(values),
+			"avg_fps":           mean(values),
+			"p95_fps":           percentile(values, 95),
+			"insufficient_data": len(values) < minSamples,
+		})
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"min_samples": minSamples,
+		"versions":    versions,
+	})
+}
@@ -0,0 +1,52 @@
+package api
+
+import (
+	"cyber-swipe-analytics/config"
+	"net/http"
+	"sort"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestLessAppVersion(t *testing.T) {
+	versions := []string{"beta", "1.10.0", "v1.2", "1.2.1", "2.0", "1.9.9"}
+	sort.Slice(versions, func(i, j int) bool { return lessAppVersion(versions[i], versions[j]) })
+
+	want := []string{"v1.2", "1.2.1", "1.9.9", "1.10.0", "2.0", "beta"}
+	for i := range want {
+		if versions[i] != want[i] {
+			t.Fatalf("sorted versions = %q, want %q", versions, want)
+		}
+	}
+}
+
+func TestGetPerformanceByAppVersion(t *testing.T) {
+	h, mock := newTestHandler(t, nil)
+	expectAdminKey(mock, config.ScopeRead)
+
+	// 1.10.0 regressed against 1.9.0 and has too few samples to be sure
+	rows := sqlmock.NewRows([]string{"app_version", "fps"}).
+		AddRow("1.10.0", 30.0).
+		AddRow("1.9.0", 60.0).
+		AddRow("1.9.0", 58.0).
+		AddRow("1.9.0", 62.0)
+	mock.ExpectQuery(`SELECT TRIM\(s.app_version\), pm.fps\s+FROM performance_metrics pm\s+JOIN sessions s`).
+		WillReturnRows(rows)
+
+	response := serve(newTestRouter(h), http.MethodGet, "/api/analytics/performance/by-app-version?min_samples=2", nil, adminHeader)
+	if response.Code != http.StatusOK {
+		t.Fatalf("status %d, want 200: %s", response.Code, response.Body)
+	}
+	versions := decodeBody(t, response)["versions"].([]interface{})
+	if len(versions) != 2 {
+		t.Fatalf("got %d versions, want 2", len(versions))
+	}
+	older, newer := versions[0].(map[string]interface{}), versions[1].(map[string]interface{})
+	if older["app_version"] != "1.9.0" || older["avg_fps"] != 60.0 || older["samples"] != 3.0 || older["insufficient_data"] != false {
+		t.Errorf("first version = %v, want 1.9.0 averaging 60 over 3 samples", older)
+	}
+	if newer["app_version"] != "1.10.0" || newer["avg_fps"] != 30.0 || newer["insufficient_data"] != true {
+		t.Errorf("second version = %v, want 1.10.0 averaging 30 with insufficient data", newer)
+	}
+}
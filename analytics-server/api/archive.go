@@ -0,0 +1,101 @@
+package api
+
+import (
+	"context"
+	"log"
+	"math"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	defaultArchiveBatchSize = 100
+	maxArchiveBatchSize     = 1000
+	defaultArchiveBatches   = 50
+)
+
+// archiveSessions moves sessions older than ArchiveAfter, with their
+// events, performance metrics, and category stats, into the archive
+// tables. older_than (a duration such as 2160h) overrides the threshold.
+// Each call moves at most max_batches batches of batch_size sessions;
+// callers repeat the request until done is true.
+func (h *AnalyticsHandler) archiveSessions(c *gin.Context) {
+	olderThan := h.cfg.ArchiveAfter
+	if value := c.Query("older_than"); value != "" {
+		parsed, err := time.ParseDuration(value)
+		if err != nil || parsed <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid older_than: must be a positive duration such as 2160h"})
+			return
+		}
+		olderThan = parsed
+	}
+	if olderThan <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "no archive threshold: set ARCHIVE_AFTER or pass older_than"})
+		return
+	}
+
+	batchSize, err := queryInt(c, "batch_size", defaultArchiveBatchSize, 1, maxArchiveBatchSize)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	maxBatches, err := queryInt(c, "max_batches", defaultArchiveBatches, 1, math.MaxInt32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	result, err := h.db.ArchiveSessions(c.Request.Context(), time.Now().Add(-olderThan), batchSize, maxBatches)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to archive sessions"})
+		return
+	}
+
+	c.JSON(http.StatusOK, result)
+}
+
+// StartArchiveWorker starts a background worker that archives sessions
+// older than ArchiveAfter every ArchiveInterval. It does nothing when
+// ArchiveAfter is zero. The returned function stops the worker and waits
+// for the current run to finish.
+func (h *AnalyticsHandler) StartArchiveWorker() (stop func()) {
+	if h.cfg.ArchiveAfter <= 0 {
+		return func() {}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		ticker := time.NewTicker(h.cfg.ArchiveInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				before := time.Now().Add(-h.cfg.ArchiveAfter)
+				result, err := h.db.ArchiveSessions(ctx, before, defaultArchiveBatchSize, defaultArchiveBatches)
+				if err != nil {
+					if ctx.Err() == nil {
+						log.Printf("Archival failed: %v", err)
+					}
+					continue
+				}
+				if result.Batches > 0 {
+					log.Printf("Archived %d sessions created before %s", result.Moved["sessions"], before.Format(time.RFC3339))
+				}
+			}
+		}
+	}()
+
+	return func() {
+		cancel()
+		wg.Wait()
+	}
+}
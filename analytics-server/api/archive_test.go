@@ -0,0 +1,20 @@
+package api
+
+import (
+	"cyber-swipe-analytics/config"
+	"net/http"
+	"testing"
+)
+
+func TestArchiveSessionsRejectsInvalidThreshold(t *testing.T) {
+	h, mock := newTestHandler(t, nil)
+	router := newTestRouter(h)
+
+	for _, query := range []string{"", "?older_than=soon", "?older_than=-24h", "?older_than=24h&batch_size=0"} {
+		expectAdminKey(mock, config.ScopeAdmin)
+		response := serve(router, http.MethodPost, "/api/analytics/admin/archive"+query, nil, adminHeader)
+		if response.Code != http.StatusBadRequest {
+			t.Errorf("%q: status %d, want 400", query, response.Code)
+		}
+	}
+}
@@ -0,0 +1,171 @@
+package api
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// derivedColumns maps each derived events column to the SQL expression that
+// computes it from the raw swipe columns. recordEvent fills these columns on
+// insert; the backfill endpoint recomputes them for historical rows.
+var derivedColumns = map[string]string{
+	"swipe_distance": "ABS(end_x - start_x)",
+	"swipe_velocity": "CASE WHEN duration > 0 THEN ABS(end_x - start_x) / duration END",
+}
+
+const (
+	defaultBackfillBatchSize = 500
+	maxBackfillBatchSize     = 5000
+	defaultBackfillBatches   = 20
+)
+
+// swipeDerivedValues computes the derived columns for a new event. The
+// velocity is nil when the event carries no positive duration.
+func swipeDerivedValues(event EventRequest) (float64, interface{}) {
+	distance := math.Abs(event.EndX - event.StartX)
+	if event.Duration <= 0 {
+		return distance, nil
+	}
+	return distance, distance / event.Duration
+}
+
+// backfillDerivedColumn recomputes a derived events column for historical
+// rows in id-ordered batches. Progress is stored in backfill_progress after
+// every batch, so a backfill interrupted by a restart resumes where it
+// stopped. Each call processes at most max_batches batches; callers repeat
+// the request until done is true.
+func (h *AnalyticsHandler) backfillDerivedColumn(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	column := c.Query("column")
+	expression, ok := derivedColumns[column]
+	if !ok {
+		names := make([]string, 0, len(derivedColumns))
+		for name := range derivedColumns {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown derived column", "valid_columns": names})
+		return
+	}
+
+	batchSize, err := queryInt(c, "batch_size", defaultBackfillBatchSize, 1, maxBackfillBatchSize)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	maxBatches, err := queryInt(c, "max_batches", defaultBackfillBatches, 1, math.MaxInt32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if c.Query("reset") == "true" {
+		if _, err := h.db.ExecContext(ctx, "DELETE FROM backfill_progress WHERE target_column = ?", column); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to reset backfill progress"})
+			return
+		}
+	}
+
+	var cursor int64
+	err = h.db.QueryRowContext(ctx, "SELECT last_id FROM backfill_progress WHERE target_column = ?", column).Scan(&cursor)
+	if err != nil && err != sql.ErrNoRows {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read backfill progress"})
+		return
+	}
+
+	processed := 0
+	batches := 0
+	for batches < maxBatches {
+		updated, next, err := h.backfillBatch(ctx, column, expression, cursor, batchSize)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to backfill batch", "cursor": cursor})
+			return
+		}
+		if updated == 0 {
+			break
+		}
+		cursor = next
+		processed += updated
+		batches++
+	}
+
+	var remaining int
+	if err := h.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM events WHERE id > ?", cursor).Scan(&remaining); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to count remaining rows"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"column":    column,
+		"processed": processed,
+		"batches":   batches,
+		"cursor":    cursor,
+		"remaining": remaining,
+		"done":      remaining == 0,
+	})
+}
+
+// backfillBatch recomputes column for the next batchSize events after cursor
+// and stores the new cursor in the same transaction. It returns the number
+// of rows in the batch and the id of the last one.
+func (h *AnalyticsHandler) backfillBatch(ctx context.Context, column, expression string, cursor int64, batchSize int) (int, int64, error) {
+	var count int
+	var lastID sql.NullInt64
+	err := h.db.WithTx(ctx, func(tx *sql.Tx) error {
+		err := tx.QueryRowContext(ctx, h.db.Rebind(`
+			SELECT COUNT(*), MAX(id) FROM (
+				SELECT id FROM events WHERE id > ? ORDER BY id LIMIT ?
+			) batch
+		`), cursor, batchSize).Scan(&count, &lastID)
+		if err != nil || count == 0 {
+			return err
+		}
+
+		// column and expression come from derivedColumns, never from user input
+		_, err = tx.ExecContext(ctx, h.db.Rebind(fmt.Sprintf("UPDATE events SET %s = %s WHERE id > ? AND id <= ?", column, expression)),
+			cursor, lastID.Int64)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.ExecContext(ctx, h.db.Rebind(`
+			INSERT INTO backfill_progress (target_column, last_id) VALUES (?, ?)
+		`+h.db.Upsert([]string{"target_column"},
+			"last_id = "+h.db.Inserted("last_id"),
+			"updated_at = CURRENT_TIMESTAMP(3)",
+		)), column, lastID.Int64)
+		return err
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+	if count == 0 {
+		return 0, cursor, nil
+	}
+	return count, lastID.Int64, nil
+}
+
+// queryInt parses an optional integer query parameter, applying
+// defaultValue when it is absent and rejecting values outside [min, max].
+func queryInt(c *gin.Context, name string, defaultValue, min, max int) (int, error) {
+	value := c.Query(name)
+	if value == "" {
+		return defaultValue, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s: must be an integer", name)
+	}
+	if n < min || n > max {
+		return 0, fmt.Errorf("invalid %s: must be between %d and %d", name, min, max)
+	}
+	return n, nil
+}
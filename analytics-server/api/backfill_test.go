@@ -0,0 +1,81 @@
+package api
+
+import (
+	"cyber-swipe-analytics/config"
+	"net/http"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestSwipeDerivedValues(t *testing.T) {
+	distance, velocity := swipeDerivedValues(EventRequest{StartX: 300, EndX: 100, Duration: 0.5})
+	if distance != 200 || velocity != 400.0 {
+		t.Errorf("got distance %v and velocity %v, want 200 and 400", distance, velocity)
+	}
+
+	// Without a duration there is no velocity
+	distance, velocity = swipeDerivedValues(EventRequest{StartX: 100, EndX: 150})
+	if distance != 50 || velocity != nil {
+		t.Errorf("got distance %v and velocity %v, want 50 and nil", distance, velocity)
+	}
+}
+
+// expectBackfillBatch expects a backfill batch after cursor that covers
+// count legacy rows up to lastID.
+func expectBackfillBatch(mock sqlmock.Sqlmock, cursor, count, lastID int64) {
+	mock.ExpectBegin()
+	rows := sqlmock.NewRows([]string{"count", "max"})
+	if count == 0 {
+		rows.AddRow(0, nil)
+	} else {
+		rows.AddRow(count, lastID)
+	}
+	mock.ExpectQuery(`SELECT COUNT\(\*\), MAX\(id\) FROM`).WithArgs(cursor, 2).WillReturnRows(rows)
+	if count > 0 {
+		mock.ExpectExec(`UPDATE events SET swipe_distance = ABS\(end_x - start_x\) WHERE id > \? AND id <= \?`).
+			WithArgs(cursor, lastID).
+			WillReturnResult(sqlmock.NewResult(0, count))
+		mock.ExpectExec(`INSERT INTO backfill_progress \(target_column, last_id\) VALUES \(\?, \?\)\s+ON DUPLICATE KEY UPDATE`).
+			WithArgs("swipe_distance", lastID).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+	}
+	mock.ExpectCommit()
+}
+
+func TestBackfillDerivedColumnResumesFromProgress(t *testing.T) {
+	h, mock := newTestHandler(t, nil)
+	expectAdminKey(mock, config.ScopeAdmin)
+
+	// An earlier run stopped after the row with id 10; three legacy rows
+	// are left
+	mock.ExpectQuery(`SELECT last_id FROM backfill_progress WHERE target_column = \?`).
+		WithArgs("swipe_distance").
+		WillReturnRows(sqlmock.NewRows([]string{"last_id"}).AddRow(10))
+	expectBackfillBatch(mock, 10, 2, 12)
+	expectBackfillBatch(mock, 12, 1, 13)
+	expectBackfillBatch(mock, 13, 0, 0)
+	mock.ExpectQuery(`SELECT COUNT\(\*\) FROM events WHERE id > \?`).
+		WithArgs(13).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+
+	response := serve(newTestRouter(h), http.MethodPost,
+		"/api/analytics/admin/backfill?column=swipe_distance&batch_size=2", nil, adminHeader)
+	if response.Code != http.StatusOK {
+		t.Fatalf("status %d, want 200: %s", response.Code, response.Body)
+	}
+	body := decodeBody(t, response)
+	if body["processed"] != 3.0 || body["batches"] != 2.0 || body["cursor"] != 13.0 || body["done"] != true {
+		t.Errorf("body = %v, want 3 rows in 2 batches up to id 13, done", body)
+	}
+}
+
+func TestBackfillDerivedColumnRejectsUnknownColumn(t *testing.T) {
+	h, mock := newTestHandler(t, nil)
+	expectAdminKey(mock, config.ScopeAdmin)
+
+	response := serve(newTestRouter(h), http.MethodPost, "/api/analytics/admin/backfill?column=duration", nil, adminHeader)
+	if response.Code != http.StatusBadRequest {
+		t.Fatalf("status %d, want 400: %s", response.Code, response.Body)
+	}
+}
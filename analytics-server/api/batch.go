@@ -0,0 +1,188 @@
+package api
+
+import (
+	"context"
+	"cyber-swipe-analytics/storage"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+)
+
+// maxEventBatchSize is the largest number of events accepted by one batch
+// request.
+const maxEventBatchSize = 500
+
+// recordEvents records a JSON array of events in a single transaction, so
+// clients can send a burst of swipes in one request. Every event is
+// validated like a single event first; if any is invalid, nothing is stored
+// and the response lists the errors by index. Events whose sequence number
+// or client event ID is already stored are skipped and counted as
+// duplicates. With dedupe=true, elements identical to an earlier element of
+// the same batch, including ones without a sequence number or client event
+// ID, are collapsed before the insert and counted as collapsed. A batch
+// that fails for a transient reason is dead-lettered event by event.
+func (h *AnalyticsHandler) recordEvents(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	var raw []json.RawMessage
+	if err := c.ShouldBindJSON(&raw); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if len(raw) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "batch must contain at least one event"})
+		return
+	}
+	if len(raw) > maxEventBatchSize {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{
+			"error": fmt.Sprintf("batch must not contain more than %d events", maxEventBatchSize),
+		})
+		return
+	}
+
+	events := make([]EventRequest, len(raw))
+	var invalid []gin.H
+	for i, body := range raw {
+		if err := h.validateBatchEvent(body, &events[i]); err != nil {
+			invalid = append(invalid, gin.H{"index": i, "error": err.Error()})
+		}
+	}
+	if len(invalid) > 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid events in batch", "errors": invalid})
+		return
+	}
+
+	dedupe := c.Query("dedupe") == "true"
+	collapsed := 0
+	if dedupe {
+		events, collapsed = collapseEvents(events)
+	}
+
+	checked := make(map[string]bool)
+	for _, event := range events {
+		if checked[event.SessionID] {
+			continue
+		}
+		checked[event.SessionID] = true
+		optedOut, err := h.sessionOptedOut(ctx, event.SessionID)
+		if optedOut || err != nil {
+			h.refuseOptedOut(c, optedOut, err)
+			return
+		}
+	}
+
+	if h.refusePropertyKeys(c, h.trackPropertyKeys(ctx, events...)) {
+		return
+	}
+
+	inserted, duplicates, err := h.insertEvents(ctx, events)
+	if err != nil {
+		h.handleBatchIngestError(c, events, err)
+		return
+	}
+
+	response := gin.H{
+		"status":     "success",
+		"inserted":   inserted,
+		"duplicates": duplicates,
+	}
+	if dedupe {
+		response["collapsed"] = collapsed
+	}
+	c.JSON(http.StatusCreated, response)
+}
+
+// collapseEvents drops the events identical to an earlier one, keeping the
+// order of the rest, and returns the remaining events with the number
+// dropped. Events are compared by their encoded fields, so elements that
+// only differ in key order or whitespace are identical.
+func collapseEvents(events []EventRequest) ([]EventRequest, int) {
+	seen := make(map[string]bool, len(events))
+	unique := events[:0]
+	for _, event := range events {
+		encoded, err := json.Marshal(event)
+		if err == nil {
+			if seen[string(encoded)] {
+				continue
+			}
+			seen[string(encoded)] = true
+		}
+		unique = append(unique, event)
+	}
+	return unique, len(events) - len(unique)
+}
+
+// validateBatchEvent binds one element of a batch into event as
+// recordEvent binds its body, and applies the validation of recordEvent.
+func (h *AnalyticsHandler) validateBatchEvent(body json.RawMessage, event *EventRequest) error {
+	if err := binding.JSON.BindBody(body, event); err != nil {
+		return describeBindingError(err)
+	}
+	if !allowedEventTypes[event.EventType] {
+		return fmt.Errorf("unknown event type %q, valid types are %s", event.EventType, strings.Join(validEventTypes(), ", "))
+	}
+	if missing := h.missingEventFields(event.EventType, body); len(missing) > 0 {
+		return fmt.Errorf("missing required fields for event type %s: %v", event.EventType, missing)
+	}
+	if err := h.validateRotation(*event); err != nil {
+		return err
+	}
+	return validateProperties(event.Properties)
+}
+
+// insertEvents stores validated events in one transaction and returns the
+// number inserted and the number skipped as duplicates.
+func (h *AnalyticsHandler) insertEvents(ctx context.Context, events []EventRequest) (int, int, error) {
+	var inserted []EventRequest
+	duplicates := 0
+	err := h.db.WithTx(ctx, func(tx *sql.Tx) error {
+		stmt, err := tx.PrepareContext(ctx, h.db.Rebind(insertEventQuery))
+		if err != nil {
+			return err
+		}
+		defer stmt.Close()
+
+		for _, event := range events {
+			// A failed statement doesn't abort a MySQL transaction, but it
+			// does abort a PostgreSQL one, so events that may be duplicates
+			// are inserted under a savepoint there
+			savepoint := h.db.Driver == storage.DriverPostgres && (event.Seq != nil || event.ClientEventID != "")
+			if savepoint {
+				if _, err := tx.ExecContext(ctx, "SAVEPOINT batch_event"); err != nil {
+					return err
+				}
+			}
+			_, err := stmt.ExecContext(ctx, insertEventArgs(event)...)
+			if isDuplicateEvent(event, err) {
+				if savepoint {
+					if _, err := tx.ExecContext(ctx, "ROLLBACK TO SAVEPOINT batch_event"); err != nil {
+						return err
+					}
+				}
+				duplicates++
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			// Savepoints would otherwise pile up until the commit
+			if savepoint {
+				if _, err := tx.ExecContext(ctx, "RELEASE SAVEPOINT batch_event"); err != nil {
+					return err
+				}
+			}
+			inserted = append(inserted, event)
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+	h.eventsRecorded(inserted...)
+	return len(inserted), duplicates, nil
+}
@@ -0,0 +1,230 @@
+package api
+
+import (
+	"context"
+	"cyber-swipe-analytics/storage"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/go-sql-driver/mysql"
+	"github.com/lib/pq"
+)
+
+// duplicateKeyError is the MySQL error for a unique key violation.
+var duplicateKeyError = &mysql.MySQLError{Number: 1062, Message: "Duplicate entry"}
+
+func TestRecordEvents(t *testing.T) {
+	h, mock := newTestHandler(t, nil)
+
+	// The opt-out check runs once per session, then all events are
+	// inserted in one transaction with one prepared statement
+	expectSessionNotOptedOut(mock, "s1")
+	expectSessionNotOptedOut(mock, "s2")
+	mock.ExpectBegin()
+	prepared := mock.ExpectPrepare(`INSERT INTO events`)
+	for id := int64(1); id <= 3; id++ {
+		prepared.ExpectExec().WillReturnResult(sqlmock.NewResult(id, 1))
+	}
+	mock.ExpectCommit()
+
+	batch := []interface{}{
+		map[string]interface{}{"session_id": "s1", "event_type": "button_tap"},
+		map[string]interface{}{"session_id": "s2", "event_type": "button_tap"},
+		map[string]interface{}{"session_id": "s1", "event_type": "button_tap"},
+	}
+	response := serve(newTestRouter(h), http.MethodPost, "/api/analytics/events/batch", batch, nil)
+	if response.Code != http.StatusCreated {
+		t.Fatalf("status %d, want 201: %s", response.Code, response.Body)
+	}
+	body := decodeBody(t, response)
+	if body["inserted"] != 3.0 || body["duplicates"] != 0.0 {
+		t.Errorf("inserted %v with %v duplicates, want 3 with none", body["inserted"], body["duplicates"])
+	}
+}
+
+func TestRecordEventsRejectsInvalidElement(t *testing.T) {
+	h, _ := newTestHandler(t, nil)
+
+	// Nothing is stored when a single element is invalid
+	batch := []interface{}{
+		map[string]interface{}{"session_id": "s1", "event_type": "button_tap"},
+		map[string]interface{}{"session_id": "s1", "event_type": "teleport"},
+		map[string]interface{}{"session_id": "s1", "event_type": "button_tap"},
+	}
+	response := serve(newTestRouter(h), http.MethodPost, "/api/analytics/events/batch", batch, nil)
+	if response.Code != http.StatusBadRequest {
+		t.Fatalf("status %d, want 400: %s", response.Code, response.Body)
+	}
+	invalid := decodeBody(t, response)["errors"].([]interface{})
+	if len(invalid) != 1 || invalid[0].(map[string]interface{})["index"] != 1.0 {
+		t.Errorf("errors = %v, want only index 1", invalid)
+	}
+}
+
+func TestRecordEventsDescribesBindingErrors(t *testing.T) {
+	h, _ := newTestHandler(t, nil)
+
+	// The elements are bound like the body of a single event, so the
+	// messages name the invalid fields the same way
+	batch := []interface{}{
+		map[string]interface{}{"event_type": "button_tap", "card_id": strings.Repeat("c", 256)},
+	}
+	response := serve(newTestRouter(h), http.MethodPost, "/api/analytics/events/batch", batch, nil)
+	if response.Code != http.StatusBadRequest {
+		t.Fatalf("status %d, want 400: %s", response.Code, response.Body)
+	}
+	invalid := decodeBody(t, response)["errors"].([]interface{})
+	want := "session_id is required; card_id must be at most 255 characters"
+	if message := invalid[0].(map[string]interface{})["error"]; message != want {
+		t.Errorf("error = %q, want %q", message, want)
+	}
+}
+
+func TestRecordEventsDeadLettersTransientFailure(t *testing.T) {
+	h, mock := newTestHandler(t, nil)
+	expectSessionNotOptedOut(mock, "s1")
+	mock.ExpectBegin()
+	mock.ExpectPrepare(`INSERT INTO events`).ExpectExec().WillReturnError(deadlockError)
+	mock.ExpectRollback()
+
+	// Each event is queued on its own, so it is replayed and erased like a
+	// single event
+	mock.ExpectBegin()
+	for i := 0; i < 2; i++ {
+		mock.ExpectExec(`INSERT INTO dead_letters`).
+			WithArgs(deadLetterEvent, sqlmock.AnyArg(), sqlmock.AnyArg(), deadLetterPending).
+			WillReturnResult(sqlmock.NewResult(int64(i+1), 1))
+	}
+	mock.ExpectCommit()
+
+	batch := []interface{}{
+		map[string]interface{}{"session_id": "s1", "event_type": "button_tap", "card_id": "c1"},
+		map[string]interface{}{"session_id": "s1", "event_type": "button_tap", "card_id": "c2"},
+	}
+	response := serve(newTestRouter(h), http.MethodPost, "/api/analytics/events/batch", batch, nil)
+	if response.Code != http.StatusAccepted {
+		t.Fatalf("status %d, want 202: %s", response.Code, response.Body)
+	}
+}
+
+func TestRecordEventsRejectsBatchSize(t *testing.T) {
+	// A batch over the size limit is over the default burst as well
+	h, _ := newTestHandler(t, map[string]string{"RATE_LIMIT_RPS": "0"})
+	event := map[string]interface{}{"session_id": "s1", "event_type": "button_tap"}
+	oversized := make([]interface{}, maxEventBatchSize+1)
+	for i := range oversized {
+		oversized[i] = event
+	}
+
+	tests := []struct {
+		name       string
+		batch      []interface{}
+		wantStatus int
+	}{
+		{"empty", []interface{}{}, http.StatusBadRequest},
+		{"oversized", oversized, http.StatusRequestEntityTooLarge},
+	}
+	for _, tt := range tests {
+		response := serve(newTestRouter(h), http.MethodPost, "/api/analytics/events/batch", tt.batch, nil)
+		if response.Code != tt.wantStatus {
+			t.Errorf("%s batch: status %d, want %d", tt.name, response.Code, tt.wantStatus)
+		}
+	}
+}
+
+func TestRecordEventsSkipsDuplicateElements(t *testing.T) {
+	h, mock := newTestHandler(t, nil)
+	expectSessionNotOptedOut(mock, "s1")
+
+	// The batch repeats its first event; the repeat hits the client event
+	// ID key and is counted as a duplicate instead of failing the batch
+	mock.ExpectBegin()
+	prepared := mock.ExpectPrepare(`INSERT INTO events`)
+	prepared.ExpectExec().WillReturnResult(sqlmock.NewResult(1, 1))
+	prepared.ExpectExec().WillReturnError(duplicateKeyError)
+	prepared.ExpectExec().WillReturnResult(sqlmock.NewResult(2, 1))
+	mock.ExpectCommit()
+
+	tap := map[string]interface{}{"session_id": "s1", "event_type": "button_tap", "client_event_id": "tap-1"}
+	other := map[string]interface{}{"session_id": "s1", "event_type": "button_tap", "client_event_id": "tap-2"}
+	response := serve(newTestRouter(h), http.MethodPost, "/api/analytics/events/batch", []interface{}{tap, tap, other}, nil)
+	if response.Code != http.StatusCreated {
+		t.Fatalf("status %d, want 201: %s", response.Code, response.Body)
+	}
+	body := decodeBody(t, response)
+	if body["inserted"] != 2.0 || body["duplicates"] != 1.0 {
+		t.Errorf("inserted %v with %v duplicates, want 2 with 1", body["inserted"], body["duplicates"])
+	}
+}
+
+func TestRecordEventsCollapsesIdenticalElements(t *testing.T) {
+	h, mock := newTestHandler(t, nil)
+	expectSessionNotOptedOut(mock, "s1")
+
+	// Without a key the repeats can't be caught by the database, so only
+	// the two distinct taps are inserted
+	mock.ExpectBegin()
+	prepared := mock.ExpectPrepare(`INSERT INTO events`)
+	prepared.ExpectExec().WillReturnResult(sqlmock.NewResult(1, 1))
+	prepared.ExpectExec().WillReturnResult(sqlmock.NewResult(2, 1))
+	mock.ExpectCommit()
+
+	tap := map[string]interface{}{"session_id": "s1", "event_type": "button_tap", "card_id": "c1"}
+	other := map[string]interface{}{"session_id": "s1", "event_type": "button_tap", "card_id": "c2"}
+	response := serve(newTestRouter(h), http.MethodPost, "/api/analytics/events/batch?dedupe=true",
+		[]interface{}{tap, tap, other, tap}, nil)
+	if response.Code != http.StatusCreated {
+		t.Fatalf("status %d, want 201: %s", response.Code, response.Body)
+	}
+	body := decodeBody(t, response)
+	if body["inserted"] != 2.0 || body["duplicates"] != 0.0 || body["collapsed"] != 2.0 {
+		t.Errorf("inserted %v with %v duplicates and %v collapsed, want 2 with 0 and 2",
+			body["inserted"], body["duplicates"], body["collapsed"])
+	}
+}
+
+func TestCollapseEvents(t *testing.T) {
+	first, second := 1, 2
+	events := []EventRequest{
+		{SessionID: "s1", EventType: "card_swipe", Seq: &first},
+		{SessionID: "s1", EventType: "card_swipe", Seq: &first},
+		{SessionID: "s1", EventType: "card_swipe", Seq: &second},
+		{SessionID: "s1", EventType: "button_tap"},
+		{SessionID: "s1", EventType: "button_tap"},
+	}
+	unique, collapsed := collapseEvents(events)
+	if collapsed != 2 || len(unique) != 3 {
+		t.Fatalf("collapsed %d leaving %d, want 2 leaving 3", collapsed, len(unique))
+	}
+	if *unique[1].Seq != 2 || unique[2].EventType != "button_tap" {
+		t.Errorf("unique = %+v, want the first of each in order", unique)
+	}
+}
+
+func TestInsertEventsReleasesSavepoints(t *testing.T) {
+	h, mock := newTestHandler(t, nil)
+	h.db.Driver = storage.DriverPostgres
+
+	// Each keyed event is inserted under a savepoint that is released once
+	// stored and rolled back to when it is a duplicate
+	mock.ExpectBegin()
+	prepared := mock.ExpectPrepare(`INSERT INTO events`)
+	mock.ExpectExec(`^SAVEPOINT batch_event$`).WillReturnResult(sqlmock.NewResult(0, 0))
+	prepared.ExpectExec().WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec(`^RELEASE SAVEPOINT batch_event$`).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(`^SAVEPOINT batch_event$`).WillReturnResult(sqlmock.NewResult(0, 0))
+	prepared.ExpectExec().WillReturnError(&pq.Error{Code: "23505"})
+	mock.ExpectExec(`^ROLLBACK TO SAVEPOINT batch_event$`).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectCommit()
+
+	event := EventRequest{SessionID: "s1", EventType: "button_tap", ClientEventID: "tap-1"}
+	inserted, duplicates, err := h.insertEvents(context.Background(), []EventRequest{event, event})
+	if err != nil {
+		t.Fatalf("insertEvents: %v", err)
+	}
+	if inserted != 1 || duplicates != 1 {
+		t.Errorf("inserted %d with %d duplicates, want 1 with 1", inserted, duplicates)
+	}
+}
@@ -0,0 +1,51 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/gin-gonic/gin/binding"
+	"github.com/go-playground/validator/v10"
+)
+
+func init() {
+	// Report validation failures under the JSON names clients send
+	if v, ok := binding.Validator.Engine().(*validator.Validate); ok {
+		v.RegisterTagNameFunc(jsonFieldName)
+	}
+}
+
+// jsonFieldName returns the name of field in JSON documents.
+func jsonFieldName(field reflect.StructField) string {
+	name := strings.SplitN(field.Tag.Get("json"), ",", 2)[0]
+	if name == "" {
+		return field.Name
+	}
+	return name
+}
+
+// describeBindingError rewrites the validation errors of a request body
+// into one message naming each invalid field, e.g. "session_id must be at
+// most 255 characters". Other errors, such as malformed JSON, are returned
+// unchanged.
+func describeBindingError(err error) error {
+	var validationErrors validator.ValidationErrors
+	if !errors.As(err, &validationErrors) {
+		return err
+	}
+
+	messages := make([]string, len(validationErrors))
+	for i, fieldErr := range validationErrors {
+		switch {
+		case fieldErr.Tag() == "max" && fieldErr.Kind() == reflect.String:
+			messages[i] = fmt.Sprintf("%s must be at most %s characters", fieldErr.Field(), fieldErr.Param())
+		case fieldErr.Tag() == "required":
+			messages[i] = fmt.Sprintf("%s is required", fieldErr.Field())
+		default:
+			messages[i] = fmt.Sprintf("%s failed the %s validation", fieldErr.Field(), fieldErr.Tag())
+		}
+	}
+	return errors.New(strings.Join(messages, "; "))
+}
@@ -0,0 +1,54 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestCreateSessionRejectsOverlongFields(t *testing.T) {
+	h, _ := newTestHandler(t, nil)
+	router := newTestRouter(h)
+
+	tests := []struct {
+		field, value, want string
+	}{
+		{"session_id", strings.Repeat("s", 256), "session_id must be at most 255 characters"},
+		{"platform", strings.Repeat("p", 51), "platform must be at most 50 characters"},
+	}
+	for _, tt := range tests {
+		body := map[string]interface{}{"session_id": "s1", "user_id": "u1", "platform": "ios", "resolution": "1080x1920"}
+		body[tt.field] = tt.value
+		response := serve(router, http.MethodPost, "/api/analytics/session", body, nil)
+		if response.Code != http.StatusBadRequest {
+			t.Errorf("%s: status %d, want 400", tt.field, response.Code)
+			continue
+		}
+		if message := decodeBody(t, response)["error"]; message != tt.want {
+			t.Errorf("%s: error = %q, want %q", tt.field, message, tt.want)
+		}
+	}
+}
+
+func TestDescribeBindingErrorListsEveryField(t *testing.T) {
+	h, _ := newTestHandler(t, nil)
+
+	// Each invalid field is named once, under its JSON name
+	body := map[string]interface{}{"event_type": "card_swipe", "card_id": strings.Repeat("c", 256), "direction": "diagonally-up"}
+	response := serve(newTestRouter(h), http.MethodPost, "/api/analytics/event", body, nil)
+	if response.Code != http.StatusBadRequest {
+		t.Fatalf("status %d, want 400", response.Code)
+	}
+	want := "session_id is required; card_id must be at most 255 characters; direction must be at most 10 characters"
+	if message := decodeBody(t, response)["error"]; message != want {
+		t.Errorf("error = %q, want %q", message, want)
+	}
+}
+
+func TestDescribeBindingErrorKeepsOtherErrors(t *testing.T) {
+	err := errors.New("unexpected EOF")
+	if got := describeBindingError(err); got != err {
+		t.Errorf("describeBindingError = %v, want the error unchanged", got)
+	}
+}
@@ -0,0 +1,60 @@
+package api
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// parseBucketEdges parses a comma-separated list of strictly increasing,
+// non-negative bucket edges.
+func parseBucketEdges(value string) ([]float64, error) {
+	var edges []float64
+	for _, part := range strings.Split(value, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		edge, err := strconv.ParseFloat(part, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid bucket edge %q", part)
+		}
+		if edge < 0 {
+			return nil, fmt.Errorf("bucket edges must not be negative")
+		}
+		if len(edges) > 0 && edge <= edges[len(edges)-1] {
+			return nil, fmt.Errorf("bucket edges must be strictly increasing")
+		}
+		edges = append(edges, edge)
+	}
+	if len(edges) == 0 {
+		return nil, fmt.Errorf("at least one bucket edge is required")
+	}
+	return edges, nil
+}
+
+// bucketLabel names bucket i of the buckets delimited by edges, with unit
+// appended to each bound. Bucket 0 starts at lower, and the last bucket is
+// open-ended.
+func bucketLabel(edges []float64, i int, lower float64, unit string) string {
+	format := func(v float64) string {
+		return strconv.FormatFloat(v, 'f', -1, 64) + unit
+	}
+	if i == 0 {
+		return format(lower) + "-" + format(edges[0])
+	}
+	if i == len(edges) {
+		return format(edges[i-1]) + "+"
+	}
+	return format(edges[i-1]) + "-" + format(edges[i])
+}
+
+// edgeArgs returns the arguments passing edges to the placeholders of
+// storage.DB.BucketIndex.
+func edgeArgs(edges []float64) []interface{} {
+	args := make([]interface{}, len(edges))
+	for i, edge := range edges {
+		args[i] = edge
+	}
+	return args
+}
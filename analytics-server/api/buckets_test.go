@@ -0,0 +1,29 @@
+package api
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseBucketEdges(t *testing.T) {
+	edges, err := parseBucketEdges("30, 60,120")
+	if err != nil || !reflect.DeepEqual(edges, []float64{30, 60, 120}) {
+		t.Errorf("parseBucketEdges = %v, %v", edges, err)
+	}
+
+	for _, value := range []string{"", "30,x", "-1,5", "60,30", "30,30"} {
+		if _, err := parseBucketEdges(value); err == nil {
+			t.Errorf("parseBucketEdges(%q): want an error", value)
+		}
+	}
+}
+
+func TestBucketLabel(t *testing.T) {
+	edges := []float64{30, 60}
+	want := []string{"0s-30s", "30s-60s", "60s+"}
+	for i, label := range want {
+		if got := bucketLabel(edges, i, 0, "s"); got != label {
+			t.Errorf("bucketLabel(%d) = %q, want %q", i, got, label)
+		}
+	}
+}
@@ -0,0 +1,110 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// getSessionDetail returns one session with its events, performance
+// timeline, and category stats in chronological order, for support staff
+// debugging a playthrough. Unknown sessions yield 404.
+func (h *AnalyticsHandler) getSessionDetail(c *gin.Context) {
+	detail, ok := h.sessionDetail(c)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, detail)
+}
+
+// getSessionBundle returns everything stored about one session in a single
+// JSON object, for attaching to bug reports: the session row with all its
+// columns, and all of its events, performance samples, and category stats
+// in the order they were recorded. Unknown sessions yield 404.
+func (h *AnalyticsHandler) getSessionBundle(c *gin.Context) {
+	bundle, ok := h.sessionDetail(c)
+	if !ok {
+		return
+	}
+	bundle["exported_at"] = time.Now().UTC()
+	c.JSON(http.StatusOK, bundle)
+}
+
+// sessionDetail loads the session named by the session_id parameter with
+// all of its columns, and its events, performance samples, and category
+// stats ordered by time. When the session can't be loaded it writes the
+// error response and returns false.
+func (h *AnalyticsHandler) sessionDetail(c *gin.Context) (gin.H, bool) {
+	ctx := c.Request.Context()
+	sessionID := c.Param("session_id")
+
+	sessions, err := h.queryRowMaps(ctx, "SELECT * FROM sessions WHERE session_id = ?", sessionID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to look up session"})
+		return nil, false
+	}
+	if len(sessions) == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Session not found"})
+		return nil, false
+	}
+
+	detail := gin.H{
+		"session_id": sessionID,
+		"session":    sessions[0],
+	}
+	for _, part := range []struct{ key, table string }{
+		{"events", "events"},
+		{"performance_metrics", "performance_metrics"},
+		{"category_stats", "category_stats"},
+	} {
+		rows, err := h.queryRowMaps(ctx, "SELECT * FROM "+part.table+" WHERE session_id = ? ORDER BY "+
+			timeColumns[part.table]+", id", sessionID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get session " + part.key})
+			return nil, false
+		}
+		detail[part.key] = rows
+	}
+	return detail, true
+}
+
+// queryRowMaps runs query and returns each row as a map of column name to
+// value, so that every column is included without listing them. Text
+// values are returned as strings and NULLs as nil.
+func (h *AnalyticsHandler) queryRowMaps(ctx context.Context, query string, args ...interface{}) ([]map[string]interface{}, error) {
+	rows, err := h.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]map[string]interface{}, 0)
+	for rows.Next() {
+		values := make([]interface{}, len(columns))
+		dest := make([]interface{}, len(columns))
+		for i := range values {
+			dest[i] = &values[i]
+		}
+		if err := rows.Scan(dest...); err != nil {
+			return nil, err
+		}
+
+		row := make(map[string]interface{}, len(columns))
+		for i, column := range columns {
+			if b, ok := values[i].([]byte); ok {
+				row[column] = string(b)
+			} else {
+				row[column] = values[i]
+			}
+		}
+		result = append(result, row)
+	}
+	return result, rows.Err()
+}
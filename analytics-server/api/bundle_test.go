@@ -0,0 +1,90 @@
+package api
+
+import (
+	"cyber-swipe-analytics/config"
+	"net/http"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestGetSessionBundle(t *testing.T) {
+	h, mock := newTestHandler(t, nil)
+	expectAdminKey(mock, config.ScopeRead)
+
+	// Every column of every related row is included, text columns as
+	// strings and NULLs as null
+	mock.ExpectQuery(`SELECT \* FROM sessions WHERE session_id = \?`).WithArgs("s1").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "session_id", "user_id", "platform", "device_model"}).
+			AddRow(1, "s1", []byte("u1"), "ios", nil))
+	mock.ExpectQuery(`SELECT \* FROM events WHERE session_id = \? ORDER BY created_at, id`).WithArgs("s1").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "session_id", "event_type", "card_id"}).
+			AddRow(10, "s1", []byte("card_swipe"), "c1").
+			AddRow(11, "s1", []byte("button_tap"), nil))
+	mock.ExpectQuery(`SELECT \* FROM performance_metrics WHERE session_id = \? ORDER BY timestamp, id`).WithArgs("s1").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "session_id", "fps"}).AddRow(20, "s1", 58.5))
+	mock.ExpectQuery(`SELECT \* FROM category_stats WHERE session_id = \? ORDER BY created_at, id`).WithArgs("s1").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "session_id", "category_name", "total_cards"}).
+			AddRow(30, "s1", "Sports", 10))
+
+	response := serve(newTestRouter(h), http.MethodGet, "/api/analytics/session/s1/bundle", nil, adminHeader)
+	if response.Code != http.StatusOK {
+		t.Fatalf("status %d, want 200: %s", response.Code, response.Body)
+	}
+	bundle := decodeBody(t, response)
+	if bundle["session_id"] != "s1" || bundle["exported_at"] == nil {
+		t.Errorf("bundle = %v, want session_id and exported_at", bundle)
+	}
+	session := bundle["session"].(map[string]interface{})
+	if session["user_id"] != "u1" || session["platform"] != "ios" || session["device_model"] != nil {
+		t.Errorf("session = %v", session)
+	}
+
+	events := bundle["events"].([]interface{})
+	if len(events) != 2 {
+		t.Fatalf("got %d events, want 2", len(events))
+	}
+	if first := events[0].(map[string]interface{}); first["event_type"] != "card_swipe" || first["card_id"] != "c1" {
+		t.Errorf("first event = %v", first)
+	}
+	if second := events[1].(map[string]interface{}); second["event_type"] != "button_tap" || second["card_id"] != nil {
+		t.Errorf("second event = %v", second)
+	}
+	if performance := bundle["performance_metrics"].([]interface{}); len(performance) != 1 ||
+		performance[0].(map[string]interface{})["fps"] != 58.5 {
+		t.Errorf("performance_metrics = %v", performance)
+	}
+	if categories := bundle["category_stats"].([]interface{}); len(categories) != 1 ||
+		categories[0].(map[string]interface{})["category_name"] != "Sports" {
+		t.Errorf("category_stats = %v", categories)
+	}
+}
+
+func TestGetSessionBundleEmptyAndUnknown(t *testing.T) {
+	h, mock := newTestHandler(t, nil)
+	router := newTestRouter(h)
+
+	// A session without related rows has empty arrays, not null
+	expectAdminKey(mock, config.ScopeRead)
+	mock.ExpectQuery(`SELECT \* FROM sessions`).WithArgs("s1").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "session_id"}).AddRow(1, "s1"))
+	for _, table := range []string{"events", "performance_metrics", "category_stats"} {
+		mock.ExpectQuery(`SELECT \* FROM ` + table).WithArgs("s1").WillReturnRows(sqlmock.NewRows([]string{"id"}))
+	}
+	response := serve(router, http.MethodGet, "/api/analytics/session/s1/bundle", nil, adminHeader)
+	if response.Code != http.StatusOK {
+		t.Fatalf("status %d, want 200: %s", response.Code, response.Body)
+	}
+	bundle := decodeBody(t, response)
+	for _, key := range []string{"events", "performance_metrics", "category_stats"} {
+		if rows, ok := bundle[key].([]interface{}); !ok || len(rows) != 0 {
+			t.Errorf("%s = %v, want []", key, bundle[key])
+		}
+	}
+
+	expectAdminKey(mock, config.ScopeRead)
+	mock.ExpectQuery(`SELECT \* FROM sessions`).WithArgs("nope").WillReturnRows(sqlmock.NewRows([]string{"id"}))
+	if response := serve(router, http.MethodGet, "/api/analytics/session/nope/bundle", nil, adminHeader); response.Code != http.StatusNotFound {
+		t.Errorf("unknown session: status %d, want 404", response.Code)
+	}
+}
@@ -0,0 +1,44 @@
+package api
+
+import (
+	"net/http"
+	"sort"
+
+	"github.com/gin-gonic/gin"
+)
+
+// getCategories ranks the categories by the per-category aggregation of
+// /stats. order_by selects the ranking: success_rate (default) or
+// total_cards, both descending. The statistics filters of /stats apply.
+func (h *AnalyticsHandler) getCategories(c *gin.Context) {
+	orderBy := c.DefaultQuery("order_by", "success_rate")
+	if orderBy != "success_rate" && orderBy != "total_cards" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "order_by must be success_rate or total_cards"})
+		return
+	}
+
+	filter, err := h.parseStatsFilter(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	categories, err := h.getCategoryStatistics(c.Request.Context(), filter)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get category statistics"})
+		return
+	}
+
+	// The statistics come ordered by total cards, which breaks ties
+	sort.SliceStable(categories, func(i, j int) bool {
+		return categories[i][orderBy].(float64) > categories[j][orderBy].(float64)
+	})
+	if categories == nil {
+		categories = []map[string]interface{}{}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"order_by":   orderBy,
+		"categories": categories,
+	})
+}
@@ -0,0 +1,114 @@
+package api
+
+import (
+	"cyber-swipe-analytics/config"
+	"net/http"
+	"reflect"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+// expectCategoryStatistics expects the per-category aggregation and answers
+// it with two categories, ordered by total cards as the query does:
+// phishing with many cards but a 50% success rate, and malware with fewer
+// cards but an 80% success rate.
+func expectCategoryStatistics(mock sqlmock.Sqlmock) {
+	mock.ExpectQuery(`FROM category_stats\s+GROUP BY category_name\s+ORDER BY total_cards DESC`).
+		WillReturnRows(sqlmock.NewRows([]string{"category_name", "total_cards", "accepted_cards",
+			"avg_decision_time", "avg_completion_time", "unique_sessions"}).
+			AddRow("phishing", 40.0, 20.0, 1.2, 45.0, 4).
+			AddRow("malware", 10.0, 8.0, 2.5, 30.0, 2))
+}
+
+func TestGetCategories(t *testing.T) {
+	tests := []struct {
+		query   string
+		orderBy string
+		want    []string
+	}{
+		{"", "success_rate", []string{"malware", "phishing"}},
+		{"?order_by=success_rate", "success_rate", []string{"malware", "phishing"}},
+		{"?order_by=total_cards", "total_cards", []string{"phishing", "malware"}},
+	}
+	for _, tt := range tests {
+		h, mock := newTestHandler(t, nil)
+		expectAdminKey(mock, config.ScopeRead)
+		expectCategoryStatistics(mock)
+
+		response := serve(newTestRouter(h), http.MethodGet, "/api/analytics/categories"+tt.query, nil, adminHeader)
+		if response.Code != http.StatusOK {
+			t.Fatalf("%q: status %d, want 200: %s", tt.query, response.Code, response.Body)
+		}
+		body := decodeBody(t, response)
+		if body["order_by"] != tt.orderBy {
+			t.Errorf("%q: order_by = %v, want %s", tt.query, body["order_by"], tt.orderBy)
+		}
+		var got []string
+		for _, category := range body["categories"].([]interface{}) {
+			got = append(got, category.(map[string]interface{})["category"].(string))
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%q: categories = %v, want %v", tt.query, got, tt.want)
+		}
+	}
+}
+
+func TestGetCategoriesReportsAggregation(t *testing.T) {
+	h, mock := newTestHandler(t, nil)
+	expectAdminKey(mock, config.ScopeRead)
+	expectCategoryStatistics(mock)
+
+	// Each entry carries the same fields as in /stats
+	response := serve(newTestRouter(h), http.MethodGet, "/api/analytics/categories", nil, adminHeader)
+	if response.Code != http.StatusOK {
+		t.Fatalf("status %d, want 200: %s", response.Code, response.Body)
+	}
+	malware := decodeBody(t, response)["categories"].([]interface{})[0].(map[string]interface{})
+	for field, want := range map[string]interface{}{
+		"total_cards":     10.0,
+		"accepted_cards":  8.0,
+		"success_rate":    80.0,
+		"unique_sessions": 2.0,
+	} {
+		if malware[field] != want {
+			t.Errorf("%s = %v, want %v", field, malware[field], want)
+		}
+	}
+}
+
+func TestGetCategoriesEmpty(t *testing.T) {
+	h, mock := newTestHandler(t, nil)
+	expectAdminKey(mock, config.ScopeRead)
+	mock.ExpectQuery(`FROM category_stats`).
+		WillReturnRows(sqlmock.NewRows([]string{"category_name", "total_cards", "accepted_cards",
+			"avg_decision_time", "avg_completion_time", "unique_sessions"}))
+
+	// No categories yet is an empty list rather than null
+	response := serve(newTestRouter(h), http.MethodGet, "/api/analytics/categories", nil, adminHeader)
+	if response.Code != http.StatusOK {
+		t.Fatalf("status %d, want 200: %s", response.Code, response.Body)
+	}
+	if categories, ok := decodeBody(t, response)["categories"].([]interface{}); !ok || len(categories) != 0 {
+		t.Errorf("categories = %v, want an empty list", categories)
+	}
+}
+
+func TestGetCategoriesRejectsInvalidOrder(t *testing.T) {
+	h, mock := newTestHandler(t, nil)
+	expectAdminKey(mock, config.ScopeRead)
+
+	response := serve(newTestRouter(h), http.MethodGet, "/api/analytics/categories?order_by=category", nil, adminHeader)
+	if response.Code != http.StatusBadRequest {
+		t.Errorf("status %d, want 400", response.Code)
+	}
+}
+
+func TestGetCategoriesRequiresAdminKey(t *testing.T) {
+	h, _ := newTestHandler(t, nil)
+
+	response := serve(newTestRouter(h), http.MethodGet, "/api/analytics/categories", nil, nil)
+	if response.Code != http.StatusUnauthorized {
+		t.Errorf("status %d, want 401", response.Code)
+	}
+}
@@ -0,0 +1,101 @@
+package api
+
+import (
+	"database/sql"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// outOfBounds reports whether a coordinate lies outside [0, limit]. NULL
+// coordinates are never out of bounds.
+func outOfBounds(value sql.NullFloat64, limit int) bool {
+	return value.Valid && (value.Float64 < 0 || value.Float64 > float64(limit))
+}
+
+// getOutOfBoundsEvents flags events whose start or end coordinates fall
+// outside the screen bounds of their session's resolution, which points to
+// a client bug or spoofed data. Offending events are grouped by session;
+// at most limit events are returned, and total counts all of them.
+// Sessions with an unparseable resolution cannot be checked and are
+// skipped.
+func (h *AnalyticsHandler) getOutOfBoundsEvents(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	limit, err := queryInt(c, "limit", defaultPageLimit, 1, maxPageLimit)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	rows, err := h.db.QueryContext(ctx, `
+		SELECT
+			e.id, e.session_id, s.resolution, e.event_type, e.created_at,
+			e.start_x, e.start_y, e.end_x, e.end_y
+		FROM events e
+		JOIN sessions s ON s.session_id = e.session_id
+		WHERE e.start_x IS NOT NULL OR e.start_y IS NOT NULL
+			OR e.end_x IS NOT NULL OR e.end_y IS NOT NULL
+		ORDER BY e.session_id, e.id
+	`)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get out of bounds events"})
+		return
+	}
+	defer rows.Close()
+
+	sessions := make([]gin.H, 0)
+	var current gin.H
+	total := 0
+	for rows.Next() {
+		var id int64
+		var sessionID, resolution, eventType string
+		var createdAt sql.NullTime
+		var startX, startY, endX, endY sql.NullFloat64
+		if err := rows.Scan(&id, &sessionID, &resolution, &eventType, &createdAt, &startX, &startY, &endX, &endY); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get out of bounds events"})
+			return
+		}
+
+		width, height, ok := parseResolution(resolution)
+		if !ok {
+			continue
+		}
+		if !outOfBounds(startX, width) && !outOfBounds(endX, width) &&
+			!outOfBounds(startY, height) && !outOfBounds(endY, height) {
+			continue
+		}
+
+		total++
+		if total > limit {
+			continue
+		}
+		if current == nil || current["session_id"] != sessionID {
+			current = gin.H{
+				"session_id": sessionID,
+				"resolution": resolution,
+				"events":     make([]gin.H, 0),
+			}
+			sessions = append(sessions, current)
+		}
+		current["events"] = append(current["events"].([]gin.H), gin.H{
+			"id":         id,
+			"event_type": eventType,
+			"start_x":    nullableFloat(startX),
+			"start_y":    nullableFloat(startY),
+			"end_x":      nullableFloat(endX),
+			"end_y":      nullableFloat(endY),
+			"created_at": createdAt.Time,
+		})
+	}
+	if err := rows.Err(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get out of bounds events"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"sessions":     sessions,
+		"total_events": total,
+		"truncated":    total > limit,
+	})
+}
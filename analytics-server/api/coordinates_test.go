@@ -0,0 +1,96 @@
+package api
+
+import (
+	"cyber-swipe-analytics/config"
+	"database/sql"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestOutOfBounds(t *testing.T) {
+	tests := []struct {
+		value sql.NullFloat64
+		want  bool
+	}{
+		{sql.NullFloat64{}, false},
+		{sql.NullFloat64{Float64: 0, Valid: true}, false},
+		{sql.NullFloat64{Float64: 1080, Valid: true}, false},
+		{sql.NullFloat64{Float64: 1080.5, Valid: true}, true},
+		{sql.NullFloat64{Float64: -1, Valid: true}, true},
+	}
+	for _, tt := range tests {
+		if got := outOfBounds(tt.value, 1080); got != tt.want {
+			t.Errorf("outOfBounds(%v, 1080) = %v, want %v", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestGetOutOfBoundsEvents(t *testing.T) {
+	h, mock := newTestHandler(t, nil)
+	expectAdminKey(mock, config.ScopeRead)
+	created := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	// s1 has one swipe past the right edge and one within bounds, s2 one
+	// above the top edge; s3's resolution can't be parsed and is skipped
+	mock.ExpectQuery(`FROM events e\s+JOIN sessions s ON s.session_id = e.session_id`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "session_id", "resolution", "event_type", "created_at",
+			"start_x", "start_y", "end_x", "end_y"}).
+			AddRow(1, "s1", "1080x1920", "swipe", created, 500.0, 900.0, 1400.0, 900.0).
+			AddRow(2, "s1", "1080x1920", "swipe", created, 500.0, 900.0, 100.0, 900.0).
+			AddRow(3, "s2", "720x1280", "swipe", created, 300.0, -20.0, nil, nil).
+			AddRow(4, "s3", "unknown", "swipe", created, 9000.0, 9000.0, 9000.0, 9000.0))
+
+	response := serve(newTestRouter(h), http.MethodGet, "/api/analytics/events/out-of-bounds", nil, adminHeader)
+	if response.Code != http.StatusOK {
+		t.Fatalf("status %d, want 200: %s", response.Code, response.Body)
+	}
+	body := decodeBody(t, response)
+	if body["total_events"] != 2.0 || body["truncated"] != false {
+		t.Errorf("total = %v, truncated = %v; want 2, false", body["total_events"], body["truncated"])
+	}
+	sessions := body["sessions"].([]interface{})
+	want := []struct {
+		sessionID string
+		eventID   float64
+	}{
+		{"s1", 1},
+		{"s2", 3},
+	}
+	if len(sessions) != len(want) {
+		t.Fatalf("got %d sessions, want %d: %v", len(sessions), len(want), sessions)
+	}
+	for i, session := range want {
+		got := sessions[i].(map[string]interface{})
+		events := got["events"].([]interface{})
+		if got["session_id"] != session.sessionID || len(events) != 1 ||
+			events[0].(map[string]interface{})["id"] != session.eventID {
+			t.Errorf("session %d = %v, want %s with event %v", i, got, session.sessionID, session.eventID)
+		}
+	}
+}
+
+func TestGetOutOfBoundsEventsTruncates(t *testing.T) {
+	h, mock := newTestHandler(t, nil)
+	expectAdminKey(mock, config.ScopeRead)
+	created := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	rows := sqlmock.NewRows([]string{"id", "session_id", "resolution", "event_type", "created_at",
+		"start_x", "start_y", "end_x", "end_y"})
+	for id := 1; id <= 3; id++ {
+		rows.AddRow(id, "s1", "1080x1920", "swipe", created, 2000.0, nil, nil, nil)
+	}
+	mock.ExpectQuery(`FROM events e`).WillReturnRows(rows)
+
+	response := serve(newTestRouter(h), http.MethodGet, "/api/analytics/events/out-of-bounds?limit=2", nil, adminHeader)
+	if response.Code != http.StatusOK {
+		t.Fatalf("status %d, want 200: %s", response.Code, response.Body)
+	}
+	body := decodeBody(t, response)
+	events := body["sessions"].([]interface{})[0].(map[string]interface{})["events"].([]interface{})
+	if body["total_events"] != 3.0 || body["truncated"] != true || len(events) != 2 {
+		t.Errorf("total = %v, truncated = %v, returned %d; want 3, true, 2", body["total_events"], body["truncated"], len(events))
+	}
+}
@@ -0,0 +1,42 @@
+package api
+
+import (
+	"cyber-swipe-analytics/storage"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// tableCountsCache holds the last table counts for CountsCacheTTL, so that
+// dashboards polling the counts don't rescan the tables each time.
+type tableCountsCache struct {
+	mu        sync.Mutex
+	counts    map[string]storage.TableCount
+	countedAt time.Time
+}
+
+// getTableCounts reports the row count and the oldest and newest row time
+// of each analytics table. Results are cached for CountsCacheTTL; the
+// response's counted_at tells how fresh they are.
+func (h *AnalyticsHandler) getTableCounts(c *gin.Context) {
+	cache := &h.tableCounts
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	if cache.counts == nil || time.Since(cache.countedAt) >= h.cfg.CountsCacheTTL {
+		counts, err := h.db.TableCounts(c.Request.Context())
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to count table rows"})
+			return
+		}
+		cache.counts = counts
+		cache.countedAt = time.Now()
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"tables":     cache.counts,
+		"counted_at": cache.countedAt.UTC(),
+	})
+}
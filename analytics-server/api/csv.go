@@ -0,0 +1,177 @@
+package api
+
+import (
+	"database/sql"
+	"encoding/csv"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// csvContentType is the content type of CSV responses.
+const csvContentType = "text/csv; charset=utf-8"
+
+// categoryCSVColumns and platformCSVColumns are the columns of the category
+// and platform sections of the CSV statistics, in order.
+var (
+	categoryCSVColumns = []string{
+		"category", "total_cards", "accepted_cards", "success_rate",
+		"avg_decision_time", "avg_completion_time", "unique_sessions",
+	}
+	platformCSVColumns = []string{"platform", "total_sessions", "unique_users"}
+	eventCSVColumns    = []string{
+		"id", "session_id", "event_type", "card_id", "direction", "success",
+		"duration", "start_x", "end_x", "max_rotation", "created_at",
+	}
+)
+
+// csvFlushRows is the number of rows written between flushes of a streamed
+// CSV export.
+const csvFlushRows = 500
+
+// wantsCSV reports whether the client asked for CSV, either with
+// format=csv or by accepting text/csv. JSON stays the default.
+func wantsCSV(c *gin.Context) bool {
+	if format := c.Query("format"); format != "" {
+		return strings.EqualFold(format, "csv")
+	}
+	return c.NegotiateFormat(gin.MIMEJSON, "text/csv") == "text/csv"
+}
+
+// writeStatsCSV renders aggregated statistics as CSV. The output has a
+// metrics section with the scalar key metrics, followed by the category
+// and platform breakdowns. Each section starts with a row holding its
+// name, then a header row, and sections are separated by an empty line.
+func writeStatsCSV(c *gin.Context, stats gin.H) {
+	var builder strings.Builder
+	w := csv.NewWriter(&builder)
+
+	w.Write([]string{"metrics"})
+	w.Write([]string{"metric", "value"})
+	for _, group := range []string{"sessions", "performance", "events"} {
+		metrics, _ := stats[group].(gin.H)
+		names := make([]string, 0, len(metrics))
+		for name, value := range metrics {
+			if isCSVScalar(value) {
+				names = append(names, name)
+			}
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			w.Write([]string{group + "." + name, fmt.Sprint(metrics[name])})
+		}
+	}
+
+	writeCSVSection(w, "categories", categoryCSVColumns, stats["categories"])
+	writeCSVSection(w, "platforms", platformCSVColumns, stats["platforms"])
+
+	w.Flush()
+	if err := w.Error(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to render CSV"})
+		return
+	}
+	c.Data(http.StatusOK, csvContentType, []byte(builder.String()))
+}
+
+// writeCSVSection writes one breakdown section with the given columns.
+// rows is expected to be a []map[string]interface{}; anything else yields
+// a section with only its header.
+func writeCSVSection(w *csv.Writer, name string, columns []string, rows interface{}) {
+	w.Write(nil)
+	w.Write([]string{name})
+	w.Write(columns)
+
+	records, _ := rows.([]map[string]interface{})
+	for _, row := range records {
+		record := make([]string, len(columns))
+		for i, column := range columns {
+			if value, ok := row[column]; ok && value != nil {
+				record[i] = fmt.Sprint(value)
+			}
+		}
+		w.Write(record)
+	}
+}
+
+// isCSVScalar reports whether value can be written as a single CSV field.
+func isCSVScalar(value interface{}) bool {
+	switch value.(type) {
+	case int, int64, float64, string, bool:
+		return true
+	}
+	return false
+}
+
+// streamEventsCSV writes the events matching options as CSV, with the
+// columns of the raw events listing. Rows are streamed to the client as
+// they are read rather than buffered, and pagination does not apply. Once
+// the first row has been sent an error can no longer change the status,
+// so it is logged and the export ends early.
+func (h *AnalyticsHandler) streamEventsCSV(c *gin.Context, options rawDataOptions) {
+	where, args := options.where("events", "created_at")
+	rows, err := h.db.QueryContext(c.Request.Context(), `
+		SELECT `+strings.Join(eventCSVColumns, ", ")+`
+		FROM events
+		`+where+`
+		ORDER BY created_at, id
+	`, args...)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to export events"})
+		return
+	}
+	defer rows.Close()
+
+	c.Header("Content-Type", csvContentType)
+	c.Header("Content-Disposition", `attachment; filename="events.csv"`)
+	c.Status(http.StatusOK)
+
+	w := csv.NewWriter(c.Writer)
+	w.Write(eventCSVColumns)
+
+	written := 0
+	for rows.Next() {
+		var id int64
+		var sessionID, eventType, cardID, direction string
+		// A NULL success is exported as false
+		var success sql.NullBool
+		var duration, startX, endX, maxRotation float64
+		var createdAt time.Time
+		if err := rows.Scan(&id, &sessionID, &eventType, &cardID, &direction, &success, &duration, &startX, &endX, &maxRotation, &createdAt); err != nil {
+			log.Printf("Failed to export events: %v", err)
+			break
+		}
+		w.Write([]string{
+			strconv.FormatInt(id, 10),
+			sessionID,
+			eventType,
+			cardID,
+			direction,
+			strconv.FormatBool(success.Valid && success.Bool),
+			strconv.FormatFloat(duration, 'f', -1, 64),
+			strconv.FormatFloat(startX, 'f', -1, 64),
+			strconv.FormatFloat(endX, 'f', -1, 64),
+			strconv.FormatFloat(maxRotation, 'f', -1, 64),
+			createdAt.UTC().Format(time.RFC3339Nano),
+		})
+
+		written++
+		if written%csvFlushRows == 0 {
+			w.Flush()
+			c.Writer.Flush()
+		}
+	}
+	if err := rows.Err(); err != nil {
+		log.Printf("Failed to export events: %v", err)
+	}
+
+	w.Flush()
+	if err := w.Error(); err != nil {
+		log.Printf("Failed to write events CSV: %v", err)
+	}
+}
@@ -0,0 +1,162 @@
+package api
+
+import (
+	"cyber-swipe-analytics/config"
+	"encoding/csv"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gin-gonic/gin"
+)
+
+func TestWantsCSV(t *testing.T) {
+	tests := []struct {
+		query  string
+		accept string
+		want   bool
+	}{
+		{"", "", false},
+		{"", "application/json", false},
+		{"", "text/csv", true},
+		{"format=csv", "", true},
+		{"format=CSV", "application/json", true},
+		{"format=json", "text/csv", false},
+	}
+	for _, tt := range tests {
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		c.Request = httptest.NewRequest(http.MethodGet, "/api/analytics/stats?"+tt.query, nil)
+		if tt.accept != "" {
+			c.Request.Header.Set("Accept", tt.accept)
+		}
+		if got := wantsCSV(c); got != tt.want {
+			t.Errorf("wantsCSV(%q, Accept %q) = %v, want %v", tt.query, tt.accept, got, tt.want)
+		}
+	}
+}
+
+func TestWriteStatsCSVCategoryBreakdown(t *testing.T) {
+	stats := gin.H{
+		"sessions": gin.H{"total_sessions": 3, "session_length_histogram": []gin.H{}},
+		"categories": []map[string]interface{}{
+			{"category": "Sci-fi, \"retro\"", "total_cards": 10, "accepted_cards": 4, "success_rate": 40.0,
+				"avg_decision_time": 1.5, "avg_completion_time": nil, "unique_sessions": 2},
+			{"category": "Sports", "total_cards": 5, "accepted_cards": 5, "success_rate": 100.0,
+				"avg_decision_time": 0.8, "avg_completion_time": 12.0, "unique_sessions": 1},
+		},
+		"platforms": []map[string]interface{}{{"platform": "ios", "total_sessions": 3, "unique_users": 2}},
+	}
+
+	recorder := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(recorder)
+	writeStatsCSV(c, stats)
+	if recorder.Code != http.StatusOK || recorder.Header().Get("Content-Type") != csvContentType {
+		t.Fatalf("status %d with %q", recorder.Code, recorder.Header().Get("Content-Type"))
+	}
+
+	reader := csv.NewReader(strings.NewReader(recorder.Body.String()))
+	reader.FieldsPerRecord = -1
+	records, err := reader.ReadAll()
+	if err != nil {
+		t.Fatalf("malformed CSV: %v\n%s", err, recorder.Body)
+	}
+
+	// Nested values are left out of the metrics section
+	if !reflect.DeepEqual(records[:3], [][]string{{"metrics"}, {"metric", "value"}, {"sessions.total_sessions", "3"}}) {
+		t.Errorf("metrics section = %q", records[:3])
+	}
+
+	// Empty lines are skipped by the reader, so the categories section
+	// follows directly
+	categories := records[3:7]
+	want := [][]string{
+		{"categories"},
+		categoryCSVColumns,
+		{"Sci-fi, \"retro\"", "10", "4", "40", "1.5", "", "2"},
+		{"Sports", "5", "5", "100", "0.8", "12", "1"},
+	}
+	if !reflect.DeepEqual(categories, want) {
+		t.Errorf("categories section = %q, want %q", categories, want)
+	}
+	if !reflect.DeepEqual(records[7:], [][]string{{"platforms"}, platformCSVColumns, {"ios", "3", "2"}}) {
+		t.Errorf("platforms section = %q", records[7:])
+	}
+}
+
+func TestGetStatsEventsCSV(t *testing.T) {
+	h, mock := newTestHandler(t, nil)
+	created := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	expectAdminKey(mock, config.ScopeRead)
+	expectLastModified(mock, created)
+
+	// More rows than are written between flushes, and no pagination
+	const events = csvFlushRows*2 + 7
+	rows := sqlmock.NewRows(eventCSVColumns)
+	for id := 1; id <= events; id++ {
+		rows.AddRow(id, "s1", "card_swipe", "c1", "left", false, 0.5, 300.0, 20.0, -8.5, created)
+	}
+	mock.ExpectQuery(`SELECT id, session_id, event_type, card_id, direction, success, duration, start_x, end_x, max_rotation, created_at\s+FROM events\s+ORDER BY created_at, id`).
+		WillReturnRows(rows)
+
+	response := serve(newTestRouter(h), http.MethodGet, "/api/analytics/stats?format=csv&table=events", nil, adminHeader)
+	if response.Code != http.StatusOK || response.Header().Get("Content-Type") != csvContentType {
+		t.Fatalf("status %d with %q: %s", response.Code, response.Header().Get("Content-Type"), response.Body)
+	}
+	records, err := csv.NewReader(response.Body).ReadAll()
+	if err != nil {
+		t.Fatalf("malformed CSV: %v", err)
+	}
+	if !reflect.DeepEqual(records[0], eventCSVColumns) {
+		t.Errorf("header = %q, want %q", records[0], eventCSVColumns)
+	}
+	if len(records)-1 != events {
+		t.Fatalf("got %d rows, want %d", len(records)-1, events)
+	}
+	want := []string{"1", "s1", "card_swipe", "c1", "left", "false", "0.5", "300", "20", "-8.5", "2024-05-01T12:00:00Z"}
+	if !reflect.DeepEqual(records[1], want) {
+		t.Errorf("first row = %q, want %q", records[1], want)
+	}
+}
+
+func TestGetStatsEventsCSVNullSuccess(t *testing.T) {
+	h, mock := newTestHandler(t, nil)
+	created := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	expectAdminKey(mock, config.ScopeRead)
+	expectLastModified(mock, created)
+
+	// Events recorded before success was required have none
+	mock.ExpectQuery(`FROM events\s+ORDER BY created_at, id`).
+		WillReturnRows(sqlmock.NewRows(eventCSVColumns).
+			AddRow(1, "s1", "card_swipe", "c1", "left", nil, 0.5, 300.0, 20.0, -8.5, created).
+			AddRow(2, "s1", "card_swipe", "c2", "right", true, 0.5, 300.0, 20.0, -8.5, created))
+
+	response := serve(newTestRouter(h), http.MethodGet, "/api/analytics/stats?format=csv&table=events", nil, adminHeader)
+	if response.Code != http.StatusOK {
+		t.Fatalf("status %d, want 200: %s", response.Code, response.Body)
+	}
+	records, err := csv.NewReader(response.Body).ReadAll()
+	if err != nil {
+		t.Fatalf("malformed CSV: %v", err)
+	}
+	if len(records) != 3 {
+		t.Fatalf("got %d rows, want 2: %q", len(records)-1, records)
+	}
+	if records[1][5] != "false" || records[2][5] != "true" {
+		t.Errorf("success = %q and %q, want false and true", records[1][5], records[2][5])
+	}
+}
+
+func TestGetStatsCSVRejectsUnknownTable(t *testing.T) {
+	h, mock := newTestHandler(t, nil)
+	expectAdminKey(mock, config.ScopeRead)
+
+	response := serve(newTestRouter(h), http.MethodGet, "/api/analytics/stats?table=sessions", nil,
+		http.Header{"Accept": {"text/csv"}, "X-Admin-Secret": adminHeader["X-Admin-Secret"]})
+	if response.Code != http.StatusBadRequest {
+		t.Errorf("status %d, want 400", response.Code)
+	}
+}
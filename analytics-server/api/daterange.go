@@ -0,0 +1,135 @@
+package api
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// dateLayout is the layout of date-only range bounds.
+const dateLayout = "2006-01-02"
+
+// timeRange restricts rows to a time range. Zero bounds are open-ended.
+type timeRange struct {
+	// From is the inclusive lower bound.
+	From time.Time
+	// To is the upper bound, inclusive unless ToExclusive is set.
+	To          time.Time
+	ToExclusive bool
+}
+
+// windowPattern matches a rolling window such as "24h" or "7d".
+var windowPattern = regexp.MustCompile(`^([1-9][0-9]{0,3})([hd])$`)
+
+// parseTimeRange reads the from and to query parameters, each either an
+// RFC3339 timestamp or a YYYY-MM-DD date in UTC. A date as the upper bound
+// includes the whole day. Alternatively, window selects a rolling range
+// ending now, e.g. 7d or 24h.
+func parseTimeRange(c *gin.Context) (timeRange, error) {
+	var r timeRange
+
+	if window := c.Query("window"); window != "" {
+		if c.Query("from") != "" || c.Query("to") != "" {
+			return r, fmt.Errorf("invalid window: cannot be combined with from or to")
+		}
+		length, err := parseWindow(window)
+		if err != nil {
+			return r, fmt.Errorf("invalid window: %v", err)
+		}
+		r.From = time.Now().Add(-length)
+		return r, nil
+	}
+
+	if value := c.Query("from"); value != "" {
+		from, _, err := parseRangeBound(value)
+		if err != nil {
+			return r, fmt.Errorf("invalid from: %v", err)
+		}
+		r.From = from
+	}
+	if value := c.Query("to"); value != "" {
+		to, dateOnly, err := parseRangeBound(value)
+		if err != nil {
+			return r, fmt.Errorf("invalid to: %v", err)
+		}
+		if dateOnly {
+			to = to.AddDate(0, 0, 1)
+			r.ToExclusive = true
+		}
+		r.To = to
+	}
+
+	if !r.From.IsZero() && !r.To.IsZero() && r.From.After(r.To) {
+		return r, fmt.Errorf("invalid range: from must not be after to")
+	}
+	return r, nil
+}
+
+// parseWindow parses a rolling window of hours or days, such as "24h" or
+// "30d", into its length.
+func parseWindow(value string) (time.Duration, error) {
+	match := windowPattern.FindStringSubmatch(value)
+	if match == nil {
+		return 0, fmt.Errorf("expected a number of hours or days such as 24h or 7d")
+	}
+	n, _ := strconv.Atoi(match[1])
+	if match[2] == "d" {
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+	return time.Duration(n) * time.Hour, nil
+}
+
+// parseRangeBound parses an RFC3339 timestamp or a YYYY-MM-DD date and
+// reports whether it was a date.
+func parseRangeBound(value string) (time.Time, bool, error) {
+	if t, err := time.Parse(dateLayout, value); err == nil {
+		return t, true, nil
+	}
+	t, err := time.Parse(time.RFC3339Nano, value)
+	if err != nil {
+		return time.Time{}, false, fmt.Errorf("expected an RFC3339 timestamp or a YYYY-MM-DD date")
+	}
+	return t, false, nil
+}
+
+// closedBefore reports whether the range has an upper bound and it lies
+// before t.
+func (r timeRange) closedBefore(t time.Time) bool {
+	return !r.To.IsZero() && r.To.Before(t)
+}
+
+// setCacheControl sets the Cache-Control header of a successful response
+// computed over r. Statistics over a range that ended more than
+// CacheFreshnessMargin ago no longer change and may be cached publicly for
+// HistoricalCacheMaxAge; open or recent ranges must be revalidated.
+func (h *AnalyticsHandler) setCacheControl(c *gin.Context, r timeRange) {
+	maxAge := h.cfg.HistoricalCacheMaxAge
+	if maxAge <= 0 || !r.closedBefore(time.Now().Add(-h.cfg.CacheFreshnessMargin)) {
+		c.Header("Cache-Control", "no-cache")
+		return
+	}
+	c.Header("Cache-Control", fmt.Sprintf("public, max-age=%d", int(maxAge/time.Second)))
+}
+
+// conditions returns the conditions, without WHERE, restricting column to
+// the range, and their arguments.
+func (r timeRange) conditions(column string) ([]string, []interface{}) {
+	var conditions []string
+	var args []interface{}
+	if !r.From.IsZero() {
+		conditions = append(conditions, column+" >= ?")
+		args = append(args, r.From)
+	}
+	if !r.To.IsZero() {
+		operator := " <= ?"
+		if r.ToExclusive {
+			operator = " < ?"
+		}
+		conditions = append(conditions, column+operator)
+		args = append(args, r.To)
+	}
+	return conditions, args
+}
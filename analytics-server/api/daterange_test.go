@@ -0,0 +1,218 @@
+package api
+
+import (
+	"cyber-swipe-analytics/config"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gin-gonic/gin"
+)
+
+func TestParseTimeRange(t *testing.T) {
+	day := func(d int) time.Time { return time.Date(2024, 5, d, 0, 0, 0, 0, time.UTC) }
+	tests := []struct {
+		query   string
+		want    timeRange
+		wantErr bool
+	}{
+		{"", timeRange{}, false},
+		// A date as the upper bound includes the whole day
+		{"from=2024-05-01&to=2024-05-07", timeRange{From: day(1), To: day(8), ToExclusive: true}, false},
+		{"from=2024-05-01T08:00:00Z&to=2024-05-01T20:00:00Z",
+			timeRange{From: day(1).Add(8 * time.Hour), To: day(1).Add(20 * time.Hour)}, false},
+		{"from=2024-05-01", timeRange{From: day(1)}, false},
+		{"to=2024-05-07", timeRange{To: day(8), ToExclusive: true}, false},
+		{"from=2024-05-07&to=2024-05-07", timeRange{From: day(7), To: day(8), ToExclusive: true}, false},
+		{"from=2024-13-01", timeRange{}, true},
+		{"to=yesterday", timeRange{}, true},
+		{"from=2024-05-08&to=2024-05-01", timeRange{}, true},
+		{"window=7x", timeRange{}, true},
+		{"window=7d&from=2024-05-01", timeRange{}, true},
+	}
+	for _, tt := range tests {
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		c.Request = httptest.NewRequest(http.MethodGet, "/api/analytics/stats?"+tt.query, nil)
+		got, err := parseTimeRange(c)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%q: error = %v, want error %v", tt.query, err, tt.wantErr)
+			continue
+		}
+		if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%q: range = %+v, want %+v", tt.query, got, tt.want)
+		}
+	}
+}
+
+func TestParseTimeRangeWindow(t *testing.T) {
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest(http.MethodGet, "/api/analytics/stats?window=24h", nil)
+	got, err := parseTimeRange(c)
+	if err != nil {
+		t.Fatalf("parseTimeRange: %v", err)
+	}
+	if since := time.Since(got.From); since < 24*time.Hour || since > 24*time.Hour+time.Minute || !got.To.IsZero() {
+		t.Errorf("range = %+v, want the last 24 hours", got)
+	}
+}
+
+func TestStatsFilterTimeRange(t *testing.T) {
+	h, _ := newTestHandler(t, nil)
+	filter, err := parseTestFilter(t, h, "from=2024-05-01&to=2024-05-07")
+	if err != nil {
+		t.Fatalf("parseStatsFilter: %v", err)
+	}
+
+	// Each table is scoped by its own time column
+	for table, want := range map[string]string{
+		"sessions":            "WHERE created_at >= ? AND created_at < ?",
+		"events":              "WHERE created_at >= ? AND created_at < ?",
+		"performance_metrics": "WHERE timestamp >= ? AND timestamp < ?",
+	} {
+		where, args := filter.where(table)
+		if where != want {
+			t.Errorf("%s where = %q, want %q", table, where, want)
+		}
+		wantArgs := []interface{}{
+			time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC),
+			time.Date(2024, 5, 8, 0, 0, 0, 0, time.UTC),
+		}
+		if !reflect.DeepEqual(args, wantArgs) {
+			t.Errorf("%s args = %v, want %v", table, args, wantArgs)
+		}
+	}
+}
+
+func TestGetStatsRejectsInvalidDate(t *testing.T) {
+	h, mock := newTestHandler(t, nil)
+	expectAdminKey(mock, config.ScopeRead)
+
+	response := serve(newTestRouter(h), http.MethodGet, "/api/analytics/stats?from=2024-02-30", nil, adminHeader)
+	if response.Code != http.StatusBadRequest {
+		t.Errorf("status %d, want 400", response.Code)
+	}
+}
+
+func TestParseWindow(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    time.Duration
+		wantErr bool
+	}{
+		{"24h", 24 * time.Hour, false},
+		{"7d", 7 * 24 * time.Hour, false},
+		{"30d", 30 * 24 * time.Hour, false},
+		{"0d", 0, true},
+		{"7w", 0, true},
+		{"-7d", 0, true},
+		{"10000d", 0, true},
+		{"d", 0, true},
+	}
+	for _, tt := range tests {
+		got, err := parseWindow(tt.value)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("parseWindow(%q) = %v, %v; want %v, error %v", tt.value, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestStatsFilterWindow(t *testing.T) {
+	h, _ := newTestHandler(t, nil)
+	before := time.Now()
+	filter, err := parseTestFilter(t, h, "window=7d")
+	if err != nil {
+		t.Fatalf("parseStatsFilter: %v", err)
+	}
+
+	// The window resolves to an open-ended range starting seven days ago
+	where, args := filter.where("events")
+	if where != "WHERE created_at >= ?" || len(args) != 1 {
+		t.Fatalf("where = %q with %v, want a lower bound only", where, args)
+	}
+	from := args[0].(time.Time)
+	if from.Before(before.AddDate(0, 0, -7)) || from.After(time.Now().AddDate(0, 0, -7)) {
+		t.Errorf("from = %v, want seven days before %v", from, before)
+	}
+}
+
+func TestGetStatsRejectsWindowWithRange(t *testing.T) {
+	h, mock := newTestHandler(t, nil)
+	expectAdminKey(mock, config.ScopeRead)
+
+	response := serve(newTestRouter(h), http.MethodGet, "/api/analytics/stats?window=7d&to=2024-05-07", nil, adminHeader)
+	if response.Code != http.StatusBadRequest {
+		t.Errorf("status %d, want 400", response.Code)
+	}
+}
+
+func TestGetStatsCacheControl(t *testing.T) {
+	tests := []struct {
+		query string
+		want  string
+	}{
+		{"from=2024-05-01&to=2024-05-07", "public, max-age=3600"},
+		{"window=7d", "no-cache"},
+		{"from=2024-05-01", "no-cache"},
+		{"to=" + time.Now().Add(-time.Minute).UTC().Format(time.RFC3339), "no-cache"},
+	}
+	for _, tt := range tests {
+		h, mock := newTestHandler(t, nil)
+		expectAdminKey(mock, config.ScopeRead)
+		for _, rows := range aggregatedStatisticsRows() {
+			mock.ExpectQuery(`.`).WillReturnRows(rows)
+		}
+
+		// Only a range that ended before the freshness margin is immutable
+		response := serve(newTestRouter(h), http.MethodGet, "/api/analytics/stats?include=summary&"+tt.query, nil, adminHeader)
+		if response.Code != http.StatusOK {
+			t.Fatalf("%s: status %d, want 200: %s", tt.query, response.Code, response.Body)
+		}
+		if got := response.Header().Get("Cache-Control"); got != tt.want {
+			t.Errorf("%s: Cache-Control = %q, want %q", tt.query, got, tt.want)
+		}
+	}
+}
+
+func TestGetTimeseriesCacheControl(t *testing.T) {
+	now := time.Now().UTC()
+	tests := []struct {
+		query string
+		want  string
+	}{
+		{"from=2024-05-01&to=2024-05-03", "public, max-age=600"},
+		{"from=" + now.AddDate(0, 0, -2).Format(dateLayout) + "&to=" + now.Format(dateLayout), "no-cache"},
+		{"", "no-cache"},
+	}
+	for _, tt := range tests {
+		h, mock := newTestHandler(t, map[string]string{"HISTORICAL_CACHE_MAX_AGE": "10m"})
+		expectAdminKey(mock, config.ScopeRead)
+		mock.ExpectQuery(`FROM sessions`).WillReturnRows(sqlmock.NewRows([]string{"bucket", "count"}))
+		mock.ExpectQuery(`FROM events`).WillReturnRows(sqlmock.NewRows([]string{"bucket", "events", "swipes", "successful"}))
+
+		response := serve(newTestRouter(h), http.MethodGet, "/api/analytics/timeseries?"+tt.query, nil, adminHeader)
+		if response.Code != http.StatusOK {
+			t.Fatalf("%q: status %d, want 200: %s", tt.query, response.Code, response.Body)
+		}
+		if got := response.Header().Get("Cache-Control"); got != tt.want {
+			t.Errorf("%q: Cache-Control = %q, want %q", tt.query, got, tt.want)
+		}
+	}
+}
+
+func TestGetStatsFailureIsNotCached(t *testing.T) {
+	h, mock := newTestHandler(t, nil)
+	expectAdminKey(mock, config.ScopeRead)
+	mock.ExpectQuery(`.`).WillReturnError(deadlockError)
+
+	// A failed section must not be cached, even for a closed range
+	response := serve(newTestRouter(h), http.MethodGet, "/api/analytics/stats?include=summary&from=2024-05-01&to=2024-05-07", nil, adminHeader)
+	if response.Code != http.StatusInternalServerError {
+		t.Fatalf("status %d, want 500: %s", response.Code, response.Body)
+	}
+	if got := response.Header().Get("Cache-Control"); got != "no-cache" {
+		t.Errorf("Cache-Control = %q, want no-cache", got)
+	}
+}
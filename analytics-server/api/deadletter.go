@@ -0,0 +1,334 @@
+package api
+
+import (
+	"context"
+	"cyber-swipe-analytics/storage"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Kinds of dead-lettered ingestion requests.
+const (
+	deadLetterSession     = "session"
+	deadLetterEvent       = "event"
+	deadLetterPerformance = "performance"
+	deadLetterCategory    = "category"
+)
+
+// Dead letter states. Pending rows are retried by the dead-letter worker
+// until they succeed or fail permanently, or are dropped because the user
+// opted out in the meantime.
+const (
+	deadLetterPending   = "pending"
+	deadLetterSucceeded = "succeeded"
+	deadLetterFailed    = "failed"
+	deadLetterDropped   = "dropped"
+)
+
+// errOptedOut is returned when replaying a dead letter of a user who has
+// opted out of data collection since the request was queued.
+var errOptedOut = errors.New("user has opted out of data collection")
+
+// deadLetterBatchSize is the maximum number of dead letters retried per tick.
+const deadLetterBatchSize = 100
+
+// handleIngestError writes the response for a failed ingestion insert.
+// Transient database failures are stored in the dead_letters table for the
+// retry worker and acknowledged with 202; everything else is a 500.
+func (h *AnalyticsHandler) handleIngestError(c *gin.Context, kind string, payload interface{}, err error, message string) {
+	if storage.IsTransientError(err) {
+		deadLetterErr := h.deadLetter(c.Request.Context(), kind, payload, err)
+		if deadLetterErr == nil {
+			c.JSON(http.StatusAccepted, gin.H{"status": "queued"})
+			return
+		}
+		log.Printf("Failed to dead-letter %s request: %v", kind, deadLetterErr)
+	}
+	c.JSON(http.StatusInternalServerError, gin.H{"error": message})
+}
+
+// handleBatchIngestError writes the response for a failed batch insert
+// like handleIngestError, dead-lettering each event of the batch.
+func (h *AnalyticsHandler) handleBatchIngestError(c *gin.Context, events []EventRequest, err error) {
+	if storage.IsTransientError(err) {
+		deadLetterErr := h.deadLetterEvents(c.Request.Context(), events, err)
+		if deadLetterErr == nil {
+			c.JSON(http.StatusAccepted, gin.H{"status": "queued"})
+			return
+		}
+		log.Printf("Failed to dead-letter batch request: %v", deadLetterErr)
+	}
+	c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to record events"})
+}
+
+// insertDeadLetterQuery stores one dead letter, due right away.
+const insertDeadLetterQuery = `
+	INSERT INTO dead_letters (kind, payload, last_error, attempts, status, next_attempt_at)
+	VALUES (?, ?, ?, 0, ?, CURRENT_TIMESTAMP(3))
+`
+
+// deadLetter stores a failed ingestion request for a later retry.
+func (h *AnalyticsHandler) deadLetter(ctx context.Context, kind string, payload interface{}, cause error) error {
+	encoded, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	_, err = h.db.ExecContext(ctx, insertDeadLetterQuery, kind, string(encoded), cause.Error(), deadLetterPending)
+	return err
+}
+
+// deadLetterEvents stores the events of a failed batch for a later retry,
+// all or none. Each event gets a dead letter of its own, so it is replayed,
+// checked for opt-outs, and erased with its user like a single event.
+func (h *AnalyticsHandler) deadLetterEvents(ctx context.Context, events []EventRequest, cause error) error {
+	return h.db.WithTx(ctx, func(tx *sql.Tx) error {
+		for _, event := range events {
+			encoded, err := json.Marshal(event)
+			if err != nil {
+				return err
+			}
+			_, err = tx.ExecContext(ctx, h.db.Rebind(insertDeadLetterQuery),
+				deadLetterEvent, string(encoded), cause.Error(), deadLetterPending)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// replayDeadLetter decodes a dead-lettered payload and runs its insert again
+// on tx, so the replay commits or rolls back with the status update of the
+// dead letter. Like the ingestion handlers it first checks the opt-out
+// status of the user, and returns errOptedOut rather than storing the data
+// of a user who has opted out. A replayed event is returned, to be
+// published once tx has committed.
+func (h *AnalyticsHandler) replayDeadLetter(ctx context.Context, tx *sql.Tx, kind string, payload []byte) (*EventRequest, error) {
+	switch kind {
+	case deadLetterSession:
+		var session SessionRequest
+		if err := json.Unmarshal(payload, &session); err != nil {
+			return nil, err
+		}
+		if err := checkOptedOut(h.optedOutOnTx(ctx, tx, userOptedOutQuery, session.UserID)); err != nil {
+			return nil, err
+		}
+		query, args, err := h.insertSessionStatement(session)
+		if err != nil {
+			return nil, err
+		}
+		_, err = tx.ExecContext(ctx, h.db.Rebind(query), args...)
+		return nil, err
+	case deadLetterEvent:
+		var event EventRequest
+		if err := json.Unmarshal(payload, &event); err != nil {
+			return nil, err
+		}
+		if err := checkOptedOut(h.optedOutOnTx(ctx, tx, sessionOptedOutQuery, event.SessionID)); err != nil {
+			return nil, err
+		}
+		_, err := tx.ExecContext(ctx, h.db.Rebind(insertEventQuery), insertEventArgs(event)...)
+		if isDuplicateEvent(event, err) {
+			return nil, errDuplicateEvent
+		}
+		if err != nil {
+			return nil, err
+		}
+		return &event, nil
+	case deadLetterPerformance:
+		var metrics PerformanceMetricsRequest
+		if err := json.Unmarshal(payload, &metrics); err != nil {
+			return nil, err
+		}
+		if err := checkOptedOut(h.optedOutOnTx(ctx, tx, sessionOptedOutQuery, metrics.SessionID)); err != nil {
+			return nil, err
+		}
+		_, err := tx.ExecContext(ctx, h.db.Rebind(insertPerformanceMetricsQuery), h.insertPerformanceMetricsArgs(metrics)...)
+		return nil, err
+	case deadLetterCategory:
+		var stats CategoryStatsRequest
+		if err := json.Unmarshal(payload, &stats); err != nil {
+			return nil, err
+		}
+		if err := checkOptedOut(h.optedOutOnTx(ctx, tx, sessionOptedOutQuery, stats.SessionID)); err != nil {
+			return nil, err
+		}
+		return nil, h.upsertCategoryStats(ctx, tx, stats)
+	default:
+		return nil, fmt.Errorf("unknown dead letter kind %q", kind)
+	}
+}
+
+// optedOutOnTx runs the opt-out lookup query, userOptedOutQuery or
+// sessionOptedOutQuery, for id on tx.
+func (h *AnalyticsHandler) optedOutOnTx(ctx context.Context, tx *sql.Tx, query, id string) (bool, error) {
+	var optedOut bool
+	err := tx.QueryRowContext(ctx, h.db.Rebind(query), id).Scan(&optedOut)
+	return optedOut, err
+}
+
+// checkOptedOut turns the result of an opt-out lookup into errOptedOut when
+// the user has opted out.
+func checkOptedOut(optedOut bool, err error) error {
+	if err != nil {
+		return err
+	}
+	if optedOut {
+		return errOptedOut
+	}
+	return nil
+}
+
+// StartDeadLetterWorker starts a background worker that retries pending
+// dead letters every DeadLetterRetryInterval. The returned function stops
+// the worker and waits for the current retry round to finish.
+func (h *AnalyticsHandler) StartDeadLetterWorker() (stop func()) {
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		ticker := time.NewTicker(h.cfg.DeadLetterRetryInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := h.retryDeadLetters(ctx); err != nil && ctx.Err() == nil {
+					log.Printf("Dead letter retry failed: %v", err)
+				}
+			}
+		}
+	}()
+
+	return func() {
+		cancel()
+		wg.Wait()
+	}
+}
+
+// retryDeadLetters retries the pending dead letters that are due, skipping
+// those deleted since, e.g. by EraseUser. A successful retry marks the row
+// succeeded, and one whose user has opted out marks it dropped. A permanent error, or a
+// transient one on the last allowed attempt, marks it failed; otherwise the
+// next attempt is scheduled with exponential backoff.
+func (h *AnalyticsHandler) retryDeadLetters(ctx context.Context) error {
+	rows, err := h.db.QueryContext(ctx, `
+		SELECT id, kind, payload, attempts
+		FROM dead_letters
+		WHERE status = ? AND next_attempt_at <= CURRENT_TIMESTAMP(3)
+		ORDER BY id
+		LIMIT ?
+	`, deadLetterPending, deadLetterBatchSize)
+	if err != nil {
+		return err
+	}
+
+	type deadLetter struct {
+		id       int64
+		kind     string
+		payload  []byte
+		attempts int
+	}
+	var due []deadLetter
+	for rows.Next() {
+		var letter deadLetter
+		if err := rows.Scan(&letter.id, &letter.kind, &letter.payload, &letter.attempts); err != nil {
+			rows.Close()
+			return err
+		}
+		due = append(due, letter)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, letter := range due {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		// The replay runs in the transaction that records its outcome. The
+		// row stays locked until then, which holds back EraseUser deleting
+		// it, and a row erased since the batch was read is skipped rather
+		// than replayed
+		var recorded *EventRequest
+		err := h.db.WithTx(ctx, func(tx *sql.Tx) error {
+			var id int64
+			err := tx.QueryRowContext(ctx, h.db.Rebind(`
+				SELECT id FROM dead_letters WHERE id = ? AND status = ? FOR UPDATE
+			`), letter.id, deadLetterPending).Scan(&id)
+			if errors.Is(err, sql.ErrNoRows) {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+
+			// A failed replay is undone up to the savepoint, which also
+			// keeps PostgreSQL from aborting the transaction before the
+			// outcome is recorded
+			if _, err := tx.ExecContext(ctx, "SAVEPOINT dead_letter_replay"); err != nil {
+				return err
+			}
+			attempts := letter.attempts + 1
+			replayed, replayErr := h.replayDeadLetter(ctx, tx, letter.kind, letter.payload)
+			savepoint := "RELEASE SAVEPOINT dead_letter_replay"
+			if replayErr != nil {
+				savepoint = "ROLLBACK TO SAVEPOINT dead_letter_replay"
+			}
+			if _, err := tx.ExecContext(ctx, savepoint); err != nil {
+				return err
+			}
+			if errors.Is(replayErr, errDuplicateEvent) {
+				// The event was stored after all, e.g. by a client resend
+				replayErr = nil
+			}
+			recorded = replayed
+
+			switch {
+			case replayErr == nil:
+				_, err = tx.ExecContext(ctx, h.db.Rebind(`
+					UPDATE dead_letters SET status = ?, attempts = ? WHERE id = ?
+				`), deadLetterSucceeded, attempts, letter.id)
+			case errors.Is(replayErr, errOptedOut):
+				_, err = tx.ExecContext(ctx, h.db.Rebind(`
+					UPDATE dead_letters SET status = ?, attempts = ? WHERE id = ?
+				`), deadLetterDropped, attempts, letter.id)
+			case !storage.IsTransientError(replayErr) || attempts >= h.cfg.DeadLetterMaxAttempts:
+				_, err = tx.ExecContext(ctx, h.db.Rebind(`
+					UPDATE dead_letters SET status = ?, attempts = ?, last_error = ? WHERE id = ?
+				`), deadLetterFailed, attempts, replayErr.Error(), letter.id)
+			default:
+				// The next attempt is scheduled on the database clock, which
+				// due letters are selected by
+				backoff := h.cfg.DeadLetterRetryInterval * time.Duration(1<<min(attempts, 10))
+				_, err = tx.ExecContext(ctx, h.db.Rebind(`
+					UPDATE dead_letters
+					SET attempts = ?, last_error = ?, next_attempt_at = `+h.db.AddMicroseconds("CURRENT_TIMESTAMP(3)")+`
+					WHERE id = ?
+				`), attempts, replayErr.Error(), backoff.Microseconds(), letter.id)
+			}
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		if recorded != nil {
+			h.eventsRecorded(*recorded)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,208 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/go-sql-driver/mysql"
+)
+
+// deadlockError is a transient MySQL error.
+var deadlockError = &mysql.MySQLError{Number: 1213, Message: "Deadlock found when trying to get lock"}
+
+const deadLetterPayload = `{"session_id":"s1","memory_usage":512}`
+
+// expectDueDeadLetter expects the lookup of due dead letters and answers
+// it with one performance sample on its attempts-th retry, then expects the
+// row to be locked and the savepoint of the replay to be set.
+func expectDueDeadLetter(mock sqlmock.Sqlmock, attempts int) {
+	mock.ExpectQuery(`SELECT id, kind, payload, attempts\s+FROM dead_letters`).
+		WithArgs(deadLetterPending, deadLetterBatchSize).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "kind", "payload", "attempts"}).
+			AddRow(1, deadLetterPerformance, deadLetterPayload, attempts))
+	mock.ExpectBegin()
+	mock.ExpectQuery(`SELECT id FROM dead_letters WHERE id = \? AND status = \? FOR UPDATE`).
+		WithArgs(1, deadLetterPending).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	mock.ExpectExec(`^SAVEPOINT dead_letter_replay$`).WillReturnResult(sqlmock.NewResult(0, 0))
+}
+
+// expectSavepointRelease expects the savepoint of a successful replay to
+// be released.
+func expectSavepointRelease(mock sqlmock.Sqlmock) {
+	mock.ExpectExec(`^RELEASE SAVEPOINT dead_letter_replay$`).WillReturnResult(sqlmock.NewResult(0, 0))
+}
+
+// expectSavepointRollback expects a failed replay to be rolled back to its
+// savepoint.
+func expectSavepointRollback(mock sqlmock.Sqlmock) {
+	mock.ExpectExec(`^ROLLBACK TO SAVEPOINT dead_letter_replay$`).WillReturnResult(sqlmock.NewResult(0, 0))
+}
+
+func TestRecordPerformanceMetricsDeadLettersTransientFailure(t *testing.T) {
+	h, mock := newTestHandler(t, nil)
+	expectSessionNotOptedOut(mock, "s1")
+	mock.ExpectExec(`INSERT INTO performance_metrics`).WillReturnError(deadlockError)
+	mock.ExpectExec(`INSERT INTO dead_letters`).
+		WithArgs(deadLetterPerformance, sqlmock.AnyArg(), sqlmock.AnyArg(), deadLetterPending).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+
+	response := serve(newTestRouter(h), http.MethodPost, "/api/analytics/performance",
+		map[string]interface{}{"session_id": "s1", "memory_usage": 512}, nil)
+	if response.Code != http.StatusAccepted {
+		t.Fatalf("status %d, want 202: %s", response.Code, response.Body)
+	}
+}
+
+func TestRecordPerformanceMetricsPermanentFailure(t *testing.T) {
+	h, mock := newTestHandler(t, nil)
+	expectSessionNotOptedOut(mock, "s1")
+	mock.ExpectExec(`INSERT INTO performance_metrics`).
+		WillReturnError(&mysql.MySQLError{Number: 1452, Message: "Cannot add or update a child row"})
+
+	response := serve(newTestRouter(h), http.MethodPost, "/api/analytics/performance",
+		map[string]interface{}{"session_id": "s1", "memory_usage": 512}, nil)
+	if response.Code != http.StatusInternalServerError {
+		t.Fatalf("status %d, want 500: %s", response.Code, response.Body)
+	}
+}
+
+func TestRetryDeadLettersSucceedsAfterTransientFailure(t *testing.T) {
+	h, mock := newTestHandler(t, nil)
+	ctx := context.Background()
+
+	// The first retry hits another deadlock and is rescheduled
+	expectDueDeadLetter(mock, 0)
+	expectSessionNotOptedOut(mock, "s1")
+	mock.ExpectExec(`INSERT INTO performance_metrics`).WillReturnError(deadlockError)
+	expectSavepointRollback(mock)
+	mock.ExpectExec(`UPDATE dead_letters\s+SET attempts = \?, last_error = \?, next_attempt_at = TIMESTAMPADD\(MICROSECOND, \?, CURRENT_TIMESTAMP\(3\)\)`).
+		WithArgs(1, sqlmock.AnyArg(), (2 * h.cfg.DeadLetterRetryInterval).Microseconds(), 1).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	if err := h.retryDeadLetters(ctx); err != nil {
+		t.Fatalf("first retry: %v", err)
+	}
+
+	// The second retry goes through
+	expectDueDeadLetter(mock, 1)
+	expectSessionNotOptedOut(mock, "s1")
+	mock.ExpectExec(`INSERT INTO performance_metrics`).
+		WithArgs("s1", 0.0, 512.0, 0.0, 0.0, 0.0, nil, nil, 1.0).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	expectSavepointRelease(mock)
+	mock.ExpectExec(`UPDATE dead_letters SET status = \?, attempts = \? WHERE id = \?`).
+		WithArgs(deadLetterSucceeded, 2, 1).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	if err := h.retryDeadLetters(ctx); err != nil {
+		t.Fatalf("second retry: %v", err)
+	}
+}
+
+func TestRetryDeadLettersGivesUpAfterMaxAttempts(t *testing.T) {
+	h, mock := newTestHandler(t, map[string]string{"DEAD_LETTER_MAX_ATTEMPTS": "3"})
+
+	expectDueDeadLetter(mock, 2)
+	expectSessionNotOptedOut(mock, "s1")
+	mock.ExpectExec(`INSERT INTO performance_metrics`).WillReturnError(deadlockError)
+	expectSavepointRollback(mock)
+	mock.ExpectExec(`UPDATE dead_letters SET status = \?, attempts = \?, last_error = \? WHERE id = \?`).
+		WithArgs(deadLetterFailed, 3, sqlmock.AnyArg(), 1).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	if err := h.retryDeadLetters(context.Background()); err != nil {
+		t.Fatalf("retryDeadLetters: %v", err)
+	}
+}
+
+func TestRetryDeadLettersDropsOptedOutUser(t *testing.T) {
+	h, mock := newTestHandler(t, nil)
+
+	// The user opted out after the request was queued: nothing is inserted
+	expectDueDeadLetter(mock, 0)
+	expectSessionOptedOut(mock, "s1")
+	expectSavepointRollback(mock)
+	mock.ExpectExec(`UPDATE dead_letters SET status = \?, attempts = \? WHERE id = \?`).
+		WithArgs(deadLetterDropped, 1, 1).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	if err := h.retryDeadLetters(context.Background()); err != nil {
+		t.Fatalf("retryDeadLetters: %v", err)
+	}
+}
+
+func TestRetryDeadLettersSkipsErasedRows(t *testing.T) {
+	h, mock := newTestHandler(t, nil)
+
+	// The user was erased after the batch was read, deleting the row:
+	// nothing is replayed
+	mock.ExpectQuery(`SELECT id, kind, payload, attempts\s+FROM dead_letters`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "kind", "payload", "attempts"}).
+			AddRow(1, deadLetterPerformance, deadLetterPayload, 0))
+	mock.ExpectBegin()
+	mock.ExpectQuery(`FROM dead_letters WHERE id = \? AND status = \? FOR UPDATE`).
+		WithArgs(1, deadLetterPending).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}))
+	mock.ExpectCommit()
+	if err := h.retryDeadLetters(context.Background()); err != nil {
+		t.Fatalf("retryDeadLetters: %v", err)
+	}
+}
+
+func TestReplayDeadLetterChecksSessionUserOptOut(t *testing.T) {
+	h, mock := newTestHandler(t, nil)
+	mock.ExpectBegin()
+	mock.ExpectQuery(`SELECT EXISTS\(SELECT 1 FROM opt_outs WHERE user_id = \?\)`).
+		WithArgs("u1").
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+	mock.ExpectRollback()
+
+	tx, err := h.db.Begin()
+	if err != nil {
+		t.Fatalf("Begin: %v", err)
+	}
+	defer tx.Rollback()
+	_, err = h.replayDeadLetter(context.Background(), tx, deadLetterSession, []byte(`{"session_id":"s1","user_id":"u1"}`))
+	if !errors.Is(err, errOptedOut) {
+		t.Errorf("replayDeadLetter = %v, want errOptedOut", err)
+	}
+}
+
+func TestRetryDeadLettersRollsBackReplayWithStatus(t *testing.T) {
+	h, mock := newTestHandler(t, nil)
+	events := h.events.subscribe()
+	defer h.events.unsubscribe(events)
+
+	// The replayed event is inserted in the transaction of the status
+	// update, so it is undone when the update fails and replayed once on
+	// the next pass, and it is only published once committed
+	payload := `{"session_id":"s1","event_type":"button_tap"}`
+	mock.ExpectQuery(`SELECT id, kind, payload, attempts\s+FROM dead_letters`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "kind", "payload", "attempts"}).
+			AddRow(1, deadLetterEvent, payload, 0))
+	mock.ExpectBegin()
+	mock.ExpectQuery(`FROM dead_letters WHERE id = \? AND status = \? FOR UPDATE`).
+		WithArgs(1, deadLetterPending).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	mock.ExpectExec(`^SAVEPOINT dead_letter_replay$`).WillReturnResult(sqlmock.NewResult(0, 0))
+	expectSessionNotOptedOut(mock, "s1")
+	mock.ExpectExec(`INSERT INTO events`).WillReturnResult(sqlmock.NewResult(1, 1))
+	expectSavepointRelease(mock)
+	mock.ExpectExec(`UPDATE dead_letters SET status = \?, attempts = \? WHERE id = \?`).
+		WithArgs(deadLetterSucceeded, 1, 1).
+		WillReturnError(deadlockError)
+	mock.ExpectRollback()
+
+	if err := h.retryDeadLetters(context.Background()); err == nil {
+		t.Fatal("retryDeadLetters succeeded although the status update failed")
+	}
+	select {
+	case message := <-events:
+		t.Errorf("published %s from a rolled back replay", message)
+	default:
+	}
+}
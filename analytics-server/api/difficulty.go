@@ -0,0 +1,103 @@
+package api
+
+import (
+	"database/sql"
+	"net/http"
+	"sort"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// difficultyWeight returns the weight of a swipe on a card of the given
+// difficulty: its DifficultyWeights entry, else the difficulty itself when
+// it is a positive number, else 1.
+func (h *AnalyticsHandler) difficultyWeight(difficulty string) float64 {
+	if weight, ok := h.cfg.DifficultyWeights[difficulty]; ok {
+		return weight
+	}
+	if weight, err := strconv.ParseFloat(difficulty, 64); err == nil && weight > 0 {
+		return weight
+	}
+	return 1
+}
+
+// categorySwipes accumulates the swipes of one category.
+type categorySwipes struct {
+	swipes, successful           int
+	weightedSwipes, weightedHits float64
+}
+
+// getWeightedCategorySuccess reports, per category, the raw success rate of
+// the swipes on its cards along with a rate weighted by card difficulty, in
+// which swipes on harder cards count more. The category and difficulty are
+// read from the properties of card_swipe events; swipes without a category
+// are left out. The statistics filters of /stats apply.
+func (h *AnalyticsHandler) getWeightedCategorySuccess(c *gin.Context) {
+	filter, err := h.parseStatsFilter(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	category, categoryArg := h.db.JSONText("properties", categoryPropertyKey)
+	difficulty, difficultyArg := h.db.JSONText("properties", difficultyPropertyKey)
+	where, args := filter.where("events", "event_type = 'card_swipe'", category+" IS NOT NULL")
+	rows, err := h.db.QueryContext(c.Request.Context(), `
+		SELECT category, difficulty, COUNT(*), COUNT(CASE WHEN COALESCE(success, false) = true THEN 1 END)
+		FROM (
+			SELECT `+category+` as category, `+difficulty+` as difficulty, success
+			FROM events
+			`+where+`
+		) swipes
+		GROUP BY category, difficulty
+	`, append([]interface{}{categoryArg, difficultyArg, categoryArg}, args...)...)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get weighted category success rates"})
+		return
+	}
+	defer rows.Close()
+
+	totals := make(map[string]*categorySwipes)
+	for rows.Next() {
+		var name string
+		var level sql.NullString
+		var swipes, successful int
+		if err := rows.Scan(&name, &level, &swipes, &successful); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get weighted category success rates"})
+			return
+		}
+		if totals[name] == nil {
+			totals[name] = &categorySwipes{}
+		}
+		weight := h.difficultyWeight(level.String)
+		total := totals[name]
+		total.swipes += swipes
+		total.successful += successful
+		total.weightedSwipes += weight * float64(swipes)
+		total.weightedHits += weight * float64(successful)
+	}
+	if err := rows.Err(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get weighted category success rates"})
+		return
+	}
+
+	categories := make([]gin.H, 0, len(totals))
+	for name, total := range totals {
+		categories = append(categories, gin.H{
+			"category":              name,
+			"swipes":                total.swipes,
+			"successful_swipes":     total.successful,
+			"success_rate":          100 * float64(total.successful) / float64(total.swipes),
+			"weighted_success_rate": 100 * total.weightedHits / total.weightedSwipes,
+		})
+	}
+	sort.Slice(categories, func(i, j int) bool {
+		return categories[i]["category"].(string) < categories[j]["category"].(string)
+	})
+
+	c.JSON(http.StatusOK, gin.H{
+		"weights":    h.cfg.DifficultyWeights,
+		"categories": categories,
+	})
+}
@@ -0,0 +1,68 @@
+package api
+
+import (
+	"cyber-swipe-analytics/config"
+	"net/http"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestGetWeightedCategorySuccess(t *testing.T) {
+	h, mock := newTestHandler(t, nil)
+	expectAdminKey(mock, config.ScopeRead)
+
+	// Phishing players ace the easy cards but mostly fail the hard ones;
+	// malware cards without a difficulty weigh 1, and the numeric one 3
+	mock.ExpectQuery(`SELECT category, difficulty, COUNT\(\*\).*FROM \(\s+SELECT JSON_UNQUOTE\(JSON_EXTRACT\(properties, \?\)\) as category, JSON_UNQUOTE\(JSON_EXTRACT\(properties, \?\)\) as difficulty, success\s+FROM events\s+WHERE event_type = 'card_swipe' AND JSON_UNQUOTE\(JSON_EXTRACT\(properties, \?\)\) IS NOT NULL\s+\) swipes\s+GROUP BY category, difficulty`).
+		WithArgs(`$."category"`, `$."difficulty"`, `$."category"`).
+		WillReturnRows(sqlmock.NewRows([]string{"category", "difficulty", "swipes", "successful"}).
+			AddRow("phishing", "easy", 10, 9).
+			AddRow("phishing", "hard", 10, 2).
+			AddRow("malware", nil, 4, 2).
+			AddRow("malware", "3", 4, 4))
+
+	response := serve(newTestRouter(h), http.MethodGet, "/api/analytics/categories/difficulty", nil, adminHeader)
+	if response.Code != http.StatusOK {
+		t.Fatalf("status %d, want 200: %s", response.Code, response.Body)
+	}
+	categories := decodeBody(t, response)["categories"].([]interface{})
+	want := []struct {
+		category      string
+		swipes        float64
+		raw, weighted float64
+	}{
+		{"malware", 8, 75, 87.5},
+		{"phishing", 20, 55, 37.5},
+	}
+	if len(categories) != len(want) {
+		t.Fatalf("got %d categories, want %d", len(categories), len(want))
+	}
+	for i, w := range want {
+		got := categories[i].(map[string]interface{})
+		if got["category"] != w.category || got["swipes"] != w.swipes ||
+			got["success_rate"] != w.raw || got["weighted_success_rate"] != w.weighted {
+			t.Errorf("category %d = %v, want %+v", i, got, w)
+		}
+	}
+}
+
+func TestDifficultyWeight(t *testing.T) {
+	h, _ := newTestHandler(t, map[string]string{"DIFFICULTY_WEIGHTS": `{"easy":0.5,"expert":4}`})
+	tests := []struct {
+		difficulty string
+		want       float64
+	}{
+		{"easy", 0.5},
+		{"expert", 4},
+		{"2", 2},
+		{"hard", 1},
+		{"-3", 1},
+		{"", 1},
+	}
+	for _, tt := range tests {
+		if got := h.difficultyWeight(tt.difficulty); got != tt.want {
+			t.Errorf("difficultyWeight(%q) = %v, want %v", tt.difficulty, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,80 @@
+package api
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// rawDataFields lists the fields of each raw data listing that clients can
+// select with sparse fieldsets.
+var rawDataFields = map[string][]string{
+	"sessions": {
+		"id", "session_id", "user_id", "platform", "resolution",
+		"device_model", "os_version", "ip_address", "user_agent", "created_at", "ended_at",
+	},
+	"performance": {
+		"id", "session_id", "fps", "memory_usage", "cpu_usage", "gpu_usage",
+		"network_latency", "timestamp",
+	},
+	"events": {
+		"id", "session_id", "event_type", "card_id", "direction", "success",
+		"duration", "start_x", "end_x", "max_rotation", "created_at",
+	},
+}
+
+// sessionClientFields lists the session fields that identify the client
+// and are only returned to admin-scope keys.
+var sessionClientFields = []string{"ip_address", "user_agent"}
+
+// parseFieldsets parses sparse fieldsets given as fields[listing]=a,b
+// query parameters into the selected fields per listing. Unknown listings
+// and fields are rejected.
+func parseFieldsets(c *gin.Context) (map[string][]string, error) {
+	fieldsets := make(map[string][]string)
+	for listing, value := range c.QueryMap("fields") {
+		known, ok := rawDataFields[listing]
+		if !ok {
+			return nil, fmt.Errorf("invalid fields[%s]: unknown listing, use sessions, performance, or events", listing)
+		}
+		selected := splitList(value)
+		if len(selected) == 0 {
+			return nil, fmt.Errorf("invalid fields[%s]: at least one field is required", listing)
+		}
+		for _, field := range selected {
+			if !containsString(known, field) {
+				return nil, fmt.Errorf("invalid fields[%s]: unknown field %q, use one of %s",
+					listing, field, strings.Join(known, ", "))
+			}
+		}
+		fieldsets[listing] = selected
+	}
+	return fieldsets, nil
+}
+
+// selectFields restricts every row to the given fields. A nil fields list
+// leaves the rows unchanged.
+func selectFields(rows []map[string]interface{}, fields []string) []map[string]interface{} {
+	if fields == nil || rows == nil {
+		return rows
+	}
+	selected := make([]map[string]interface{}, len(rows))
+	for i, row := range rows {
+		selected[i] = make(map[string]interface{}, len(fields))
+		for _, field := range fields {
+			selected[i][field] = row[field]
+		}
+	}
+	return selected
+}
+
+// containsString reports whether values contains value.
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
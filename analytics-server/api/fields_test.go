@@ -0,0 +1,103 @@
+package api
+
+import (
+	"cyber-swipe-analytics/config"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gin-gonic/gin"
+)
+
+func TestParseFieldsets(t *testing.T) {
+	tests := []struct {
+		query string
+		want  map[string][]string
+		ok    bool
+	}{
+		{"", map[string][]string{}, true},
+		{"fields[sessions]=session_id,platform", map[string][]string{"sessions": {"session_id", "platform"}}, true},
+		{"fields[events]=card_id&fields[performance]=fps", map[string][]string{"events": {"card_id"}, "performance": {"fps"}}, true},
+		{"fields[sessions]=password", nil, false},
+		{"fields[users]=user_id", nil, false},
+		{"fields[sessions]=", nil, false},
+	}
+	for _, tt := range tests {
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		c.Request = httptest.NewRequest(http.MethodGet, "/api/analytics/stats?"+tt.query, nil)
+		got, err := parseFieldsets(c)
+		if (err == nil) != tt.ok {
+			t.Errorf("%q: error = %v, want ok %v", tt.query, err, tt.ok)
+			continue
+		}
+		if tt.ok && !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%q: fieldsets = %v, want %v", tt.query, got, tt.want)
+		}
+	}
+}
+
+func TestSelectFields(t *testing.T) {
+	rows := []map[string]interface{}{{"session_id": "s1", "platform": "ios", "user_id": "u1"}}
+	if got := selectFields(rows, nil); !reflect.DeepEqual(got, rows) {
+		t.Errorf("without fields = %v, want rows unchanged", got)
+	}
+	want := []map[string]interface{}{{"platform": "ios"}}
+	if got := selectFields(rows, []string{"platform"}); !reflect.DeepEqual(got, want) {
+		t.Errorf("selected = %v, want %v", got, want)
+	}
+}
+
+func TestGetStatsSparseFieldsets(t *testing.T) {
+	h, mock := newTestHandler(t, nil)
+	created := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	expectAdminKey(mock, config.ScopeRead)
+	expectLastModified(mock, created)
+	mock.ExpectQuery(`FROM sessions\s+ORDER BY`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "session_id", "user_id", "platform", "resolution",
+			"device_model", "os_version", "created_at", "ended_at"}).
+			AddRow(1, "s1", "u1", "ios", "1170x2532", "iPhone 13", "17.2", created, nil))
+	mock.ExpectQuery(`SELECT COUNT\(\*\) FROM sessions`).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+	mock.ExpectQuery(`FROM performance_metrics\s+ORDER BY`).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}))
+	mock.ExpectQuery(`SELECT COUNT\(\*\) FROM performance_metrics`).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+	mock.ExpectQuery(`FROM events\s+ORDER BY`).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}))
+	mock.ExpectQuery(`SELECT COUNT\(\*\) FROM events`).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+
+	response := serve(newTestRouter(h), http.MethodGet,
+		"/api/analytics/stats?include=raw&fields[sessions]=session_id,platform", nil, adminHeader)
+	if response.Code != http.StatusOK {
+		t.Fatalf("status %d, want 200: %s", response.Code, response.Body)
+	}
+	raw := decodeBody(t, response)["raw_data"].(map[string]interface{})
+	sessions := raw["sessions"].([]interface{})
+	if len(sessions) != 1 {
+		t.Fatalf("got %d sessions, want 1", len(sessions))
+	}
+	var keys []string
+	for key := range sessions[0].(map[string]interface{}) {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	if !reflect.DeepEqual(keys, []string{"platform", "session_id"}) {
+		t.Errorf("session keys = %v, want platform and session_id", keys)
+	}
+}
+
+func TestGetStatsRejectsUnknownField(t *testing.T) {
+	h, mock := newTestHandler(t, nil)
+	expectAdminKey(mock, config.ScopeRead)
+
+	response := serve(newTestRouter(h), http.MethodGet, "/api/analytics/stats?fields[sessions]=password", nil, adminHeader)
+	if response.Code != http.StatusBadRequest {
+		t.Errorf("status %d, want 400", response.Code)
+	}
+}
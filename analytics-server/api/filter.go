@@ -0,0 +1,138 @@
+package api
+
+import (
+	"cyber-swipe-analytics/storage"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// timeColumns maps each table to the column holding its row time.
+var timeColumns = map[string]string{
+	"sessions":            "created_at",
+	"events":              "created_at",
+	"performance_metrics": "timestamp",
+	"category_stats":      "created_at",
+}
+
+// statsFilter scopes the aggregated statistics to a subset of sessions.
+// Rows of child tables (events, performance_metrics, category_stats) are
+// scoped through their session, except for the time range, which applies
+// to the rows of each table by their own time.
+type statsFilter struct {
+	// TagKey and TagValue restrict the statistics to sessions tagged with
+	// TagKey=TagValue. An empty TagKey disables the filter.
+	TagKey   string
+	TagValue string
+	// MinOSMajor restricts the statistics to sessions whose OS major
+	// version is at least this value. Zero disables the filter.
+	MinOSMajor int
+	// Platform and Resolution restrict the statistics to sessions on that
+	// platform and with that screen resolution. Empty values disable the
+	// filters.
+	Platform   string
+	Resolution string
+	// UserID restricts the statistics to one user's sessions. It is set by
+	// the per-user endpoint rather than parsed from the query.
+	UserID string
+	// Range restricts the statistics to rows recorded within it.
+	Range timeRange
+
+	// db provides the SQL dialect of the tag condition.
+	db *storage.DB
+}
+
+// parseStatsFilter reads the statistics filter from the query string.
+// The tag parameter has the form key:value.
+func (h *AnalyticsHandler) parseStatsFilter(c *gin.Context) (statsFilter, error) {
+	filter := statsFilter{db: h.db}
+
+	if tag := c.Query("tag"); tag != "" {
+		key, value, ok := strings.Cut(tag, ":")
+		if !ok || !validTagKey(key) {
+			return filter, fmt.Errorf("invalid tag filter: expected key:value")
+		}
+		filter.TagKey = key
+		filter.TagValue = value
+	}
+
+	if value := c.Query("min_os_major"); value != "" {
+		major, err := strconv.Atoi(value)
+		if err != nil || major <= 0 {
+			return filter, fmt.Errorf("invalid min_os_major: must be a positive integer")
+		}
+		filter.MinOSMajor = major
+	}
+
+	filter.Platform = c.Query("platform")
+	filter.Resolution = c.Query("resolution")
+
+	timeRange, err := parseTimeRange(c)
+	if err != nil {
+		return filter, err
+	}
+	filter.Range = timeRange
+
+	return filter, nil
+}
+
+// sessionConditions returns the conditions, without WHERE, that select
+// the filtered rows of the sessions table.
+func (f statsFilter) sessionConditions() ([]string, []interface{}) {
+	var conditions []string
+	var args []interface{}
+
+	if f.TagKey != "" {
+		tag, key := f.db.JSONText("tags", f.TagKey)
+		conditions = append(conditions, tag+" = ?")
+		args = append(args, key, f.TagValue)
+	}
+	if f.MinOSMajor > 0 {
+		conditions = append(conditions, "os_major >= ?")
+		args = append(args, f.MinOSMajor)
+	}
+	if f.Platform != "" {
+		conditions = append(conditions, "platform = ?")
+		args = append(args, f.Platform)
+	}
+	if f.Resolution != "" {
+		conditions = append(conditions, "resolution = ?")
+		args = append(args, f.Resolution)
+	}
+	if f.UserID != "" {
+		conditions = append(conditions, "user_id = ?")
+		args = append(args, f.UserID)
+	}
+
+	return conditions, args
+}
+
+// where builds a WHERE clause for table that combines the fixed conditions
+// in extra with the filter. The sessions table is filtered directly, other
+// tables through their session_id. The extra conditions come first, so
+// arguments for placeholders in extra go before the returned arguments.
+func (f statsFilter) where(table string, extra ...string) (string, []interface{}) {
+	conditions := append([]string{}, extra...)
+
+	rangeConditions, args := f.Range.conditions(timeColumns[table])
+	conditions = append(conditions, rangeConditions...)
+
+	sessionConditions, sessionArgs := f.sessionConditions()
+	args = append(args, sessionArgs...)
+	if len(sessionConditions) > 0 {
+		if table == "sessions" {
+			conditions = append(conditions, sessionConditions...)
+		} else {
+			conditions = append(conditions, fmt.Sprintf(
+				"session_id IN (SELECT session_id FROM sessions WHERE %s)",
+				strings.Join(sessionConditions, " AND ")))
+		}
+	}
+
+	if len(conditions) == 0 {
+		return "", nil
+	}
+	return "WHERE " + strings.Join(conditions, " AND "), args
+}
@@ -0,0 +1,165 @@
+package api
+
+import (
+	"context"
+	"cyber-swipe-analytics/config"
+	"cyber-swipe-analytics/storage"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gin-gonic/gin"
+)
+
+// parseTestFilter parses the statistics filter of a request to /stats
+// with the given query string.
+func parseTestFilter(t *testing.T, h *AnalyticsHandler, query string) (statsFilter, error) {
+	t.Helper()
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest(http.MethodGet, "/api/analytics/stats?"+query, nil)
+	return h.parseStatsFilter(c)
+}
+
+func TestStatsFilterTag(t *testing.T) {
+	h, _ := newTestHandler(t, nil)
+	filter, err := parseTestFilter(t, h, "tag=experiment:b")
+	if err != nil {
+		t.Fatalf("parseStatsFilter: %v", err)
+	}
+
+	where, args := filter.where("sessions")
+	if where != "WHERE JSON_UNQUOTE(JSON_EXTRACT(tags, ?)) = ?" {
+		t.Errorf("sessions where = %q", where)
+	}
+	if !reflect.DeepEqual(args, []interface{}{`$."experiment"`, "b"}) {
+		t.Errorf("sessions args = %v", args)
+	}
+
+	where, _ = filter.where("events")
+	if where != "WHERE session_id IN (SELECT session_id FROM sessions WHERE JSON_UNQUOTE(JSON_EXTRACT(tags, ?)) = ?)" {
+		t.Errorf("events where = %q", where)
+	}
+
+	// PostgreSQL extracts the tag with ->>
+	filter.db = &storage.DB{Driver: storage.DriverPostgres}
+	where, args = filter.where("sessions")
+	if where != "WHERE tags ->> ? = ?" || !reflect.DeepEqual(args, []interface{}{"experiment", "b"}) {
+		t.Errorf("postgres where = %q with %v", where, args)
+	}
+}
+
+func TestStatsFilterRejectsMalformedTag(t *testing.T) {
+	h, _ := newTestHandler(t, nil)
+	for _, query := range []string{"tag=experiment", "tag=bad%20key:b"} {
+		if _, err := parseTestFilter(t, h, query); err == nil {
+			t.Errorf("%s: want an error", query)
+		}
+	}
+}
+
+func TestCategoryStatisticsFilteredByTag(t *testing.T) {
+	h, mock := newTestHandler(t, nil)
+	filter, err := parseTestFilter(t, h, "tag=experiment:b")
+	if err != nil {
+		t.Fatalf("parseStatsFilter: %v", err)
+	}
+
+	// Only the categories of sessions tagged experiment=b are aggregated
+	mock.ExpectQuery(`FROM category_stats\s+WHERE session_id IN \(SELECT session_id FROM sessions WHERE JSON_UNQUOTE\(JSON_EXTRACT\(tags, \?\)\) = \?\)`).
+		WithArgs(`$."experiment"`, "b").
+		WillReturnRows(sqlmock.NewRows([]string{"category_name", "total_cards", "accepted_cards",
+			"avg_decision_time", "avg_completion_time", "unique_sessions"}).
+			AddRow("animals", 10, 4, 1.5, 30, 2))
+
+	categories, err := h.getCategoryStatistics(context.Background(), filter)
+	if err != nil {
+		t.Fatalf("getCategoryStatistics: %v", err)
+	}
+	if len(categories) != 1 || categories[0]["success_rate"] != 40.0 {
+		t.Errorf("categories = %v, want animals at 40%%", categories)
+	}
+}
+
+func TestStatsFilterMinOSMajor(t *testing.T) {
+	h, _ := newTestHandler(t, nil)
+	filter, err := parseTestFilter(t, h, "min_os_major=15")
+	if err != nil {
+		t.Fatalf("parseStatsFilter: %v", err)
+	}
+
+	where, args := filter.where("sessions")
+	if where != "WHERE os_major >= ?" || !reflect.DeepEqual(args, []interface{}{15}) {
+		t.Errorf("sessions where = %q with %v", where, args)
+	}
+	where, _ = filter.where("performance_metrics")
+	if where != "WHERE session_id IN (SELECT session_id FROM sessions WHERE os_major >= ?)" {
+		t.Errorf("performance_metrics where = %q", where)
+	}
+
+	for _, query := range []string{"min_os_major=0", "min_os_major=ios15"} {
+		if _, err := parseTestFilter(t, h, query); err == nil {
+			t.Errorf("%s: want an error", query)
+		}
+	}
+}
+
+func TestStatsFilterPlatformAndResolution(t *testing.T) {
+	h, _ := newTestHandler(t, nil)
+	filter, err := parseTestFilter(t, h, "platform=android&resolution=1080x2400&from=2024-05-01&to=2024-05-07")
+	if err != nil {
+		t.Fatalf("parseStatsFilter: %v", err)
+	}
+
+	// The date range applies to each table's own rows, the session
+	// filters through the session join
+	wantArgs := []interface{}{
+		time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC),
+		time.Date(2024, 5, 8, 0, 0, 0, 0, time.UTC),
+		"android", "1080x2400",
+	}
+	for table, want := range map[string]string{
+		"sessions": "WHERE created_at >= ? AND created_at < ? AND platform = ? AND resolution = ?",
+		"events":   "WHERE created_at >= ? AND created_at < ? AND session_id IN (SELECT session_id FROM sessions WHERE platform = ? AND resolution = ?)",
+	} {
+		where, args := filter.where(table)
+		if where != want {
+			t.Errorf("%s where = %q, want %q", table, where, want)
+		}
+		if !reflect.DeepEqual(args, wantArgs) {
+			t.Errorf("%s args = %v, want %v", table, args, wantArgs)
+		}
+	}
+}
+
+func TestGetStatsFilteredByPlatform(t *testing.T) {
+	h, mock := newTestHandler(t, nil)
+	expectAdminKey(mock, config.ScopeRead)
+
+	// Of the recorded iOS and Android sessions, only the Android one is
+	// aggregated
+	rows := aggregatedStatisticsRows()
+	rows[0] = sqlmock.NewRows([]string{"total_sessions", "completed_sessions", "avg_session_duration"}).AddRow(1, 1, 120.0)
+	rows[4] = sqlmock.NewRows([]string{"platform", "total_sessions", "unique_users"}).AddRow("android", 1, 1)
+	mock.ExpectQuery(`FROM sessions\s+WHERE platform = \?`).WithArgs("android").WillReturnRows(rows[0])
+	mock.ExpectQuery(`FROM performance_metrics\s+WHERE session_id IN \(SELECT session_id FROM sessions WHERE platform = \?\)`).
+		WithArgs("android").WillReturnRows(rows[1])
+	for _, r := range rows[2:] {
+		mock.ExpectQuery(`.`).WillReturnRows(r)
+	}
+
+	response := serve(newTestRouter(h), http.MethodGet, "/api/analytics/stats?include=summary&platform=android", nil, adminHeader)
+	if response.Code != http.StatusOK {
+		t.Fatalf("status %d, want 200: %s", response.Code, response.Body)
+	}
+	stats := decodeBody(t, response)["statistics"].(map[string]interface{})
+	if total := stats["sessions"].(map[string]interface{})["total_sessions"]; total != 1.0 {
+		t.Errorf("total_sessions = %v, want 1", total)
+	}
+	platforms := stats["platforms"].([]interface{})
+	if len(platforms) != 1 || platforms[0].(map[string]interface{})["platform"] != "android" {
+		t.Errorf("platforms = %v, want only android", platforms)
+	}
+}
@@ -0,0 +1,106 @@
+package api
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// getCategoryFunnel reports how far sessions get through the ordered
+// category sequence. A session reaches a category when it has recorded
+// stats for it. The order defaults to CategoryOrder and can be overridden
+// with a comma-separated order query parameter. Each step carries the
+// number of sessions that reached it, the drop-off from the previous step,
+// and its share of the sessions that reached the first category.
+func (h *AnalyticsHandler) getCategoryFunnel(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	order := h.cfg.CategoryOrder
+	if value := c.Query("order"); value != "" {
+		order = splitList(value)
+	}
+	if len(order) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "no category order: set CATEGORY_ORDER or pass order"})
+		return
+	}
+	seen := make(map[string]bool, len(order))
+	for _, category := range order {
+		if seen[category] {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "duplicate category in order: " + category})
+			return
+		}
+		seen[category] = true
+	}
+
+	placeholders := make([]string, len(order))
+	inArgs := make([]interface{}, len(order))
+	for i, category := range order {
+		placeholders[i] = "?"
+		inArgs[i] = category
+	}
+	rows, err := h.db.QueryContext(ctx, `
+		SELECT category_name, COUNT(DISTINCT session_id)
+		FROM category_stats
+		WHERE category_name IN (`+strings.Join(placeholders, ", ")+`)
+		GROUP BY category_name
+	`, inArgs...)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get category funnel"})
+		return
+	}
+	defer rows.Close()
+
+	reached := make(map[string]int, len(order))
+	for rows.Next() {
+		var category string
+		var sessions int
+		if err := rows.Scan(&category, &sessions); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get category funnel"})
+			return
+		}
+		reached[category] = sessions
+	}
+	if err := rows.Err(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get category funnel"})
+		return
+	}
+
+	first := reached[order[0]]
+	steps := make([]gin.H, 0, len(order))
+	for i, category := range order {
+		sessions := reached[category]
+		dropOff := 0
+		if i > 0 {
+			dropOff = reached[order[i-1]] - sessions
+		}
+		var share float64
+		if first > 0 {
+			share = float64(sessions) / float64(first)
+		}
+		steps = append(steps, gin.H{
+			"step":           i + 1,
+			"category":       category,
+			"sessions":       sessions,
+			"drop_off":       dropOff,
+			"share_of_first": share,
+		})
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"order": order,
+		"steps": steps,
+	})
+}
+
+// splitList splits a comma-separated list, trimming whitespace and
+// skipping empty entries.
+func splitList(value string) []string {
+	var values []string
+	for _, part := range strings.Split(value, ",") {
+		if part = strings.TrimSpace(part); part != "" {
+			values = append(values, part)
+		}
+	}
+	return values
+}
@@ -0,0 +1,88 @@
+package api
+
+import (
+	"cyber-swipe-analytics/config"
+	"net/http"
+	"reflect"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestGetCategoryFunnel(t *testing.T) {
+	h, mock := newTestHandler(t, map[string]string{"CATEGORY_ORDER": "intro,action,puzzle,finale"})
+	expectAdminKey(mock, config.ScopeRead)
+
+	// Four sessions start, three get to action, one to puzzle and none
+	// finish
+	mock.ExpectQuery(`FROM category_stats\s+WHERE category_name IN \(\?, \?, \?, \?\)`).
+		WithArgs("intro", "action", "puzzle", "finale").
+		WillReturnRows(sqlmock.NewRows([]string{"category_name", "sessions"}).
+			AddRow("action", 3).
+			AddRow("intro", 4).
+			AddRow("puzzle", 1))
+
+	response := serve(newTestRouter(h), http.MethodGet, "/api/analytics/categories/funnel", nil, adminHeader)
+	if response.Code != http.StatusOK {
+		t.Fatalf("status %d, want 200: %s", response.Code, response.Body)
+	}
+	steps := decodeBody(t, response)["steps"].([]interface{})
+	want := []struct {
+		category string
+		sessions float64
+		dropOff  float64
+		share    float64
+	}{
+		{"intro", 4, 0, 1},
+		{"action", 3, 1, 0.75},
+		{"puzzle", 1, 2, 0.25},
+		{"finale", 0, 1, 0},
+	}
+	if len(steps) != len(want) {
+		t.Fatalf("got %d steps, want %d", len(steps), len(want))
+	}
+	for i, step := range want {
+		got := steps[i].(map[string]interface{})
+		if got["category"] != step.category || got["sessions"] != step.sessions ||
+			got["drop_off"] != step.dropOff || got["share_of_first"] != step.share {
+			t.Errorf("step %d = %v, want %+v", i+1, got, step)
+		}
+	}
+}
+
+func TestGetCategoryFunnelOrderParameter(t *testing.T) {
+	h, mock := newTestHandler(t, nil)
+	router := newTestRouter(h)
+
+	// Without CATEGORY_ORDER the order must be passed
+	expectAdminKey(mock, config.ScopeRead)
+	if response := serve(router, http.MethodGet, "/api/analytics/categories/funnel", nil, adminHeader); response.Code != http.StatusBadRequest {
+		t.Errorf("no order: status %d, want 400", response.Code)
+	}
+
+	expectAdminKey(mock, config.ScopeRead)
+	if response := serve(router, http.MethodGet, "/api/analytics/categories/funnel?order=a,b,a", nil, adminHeader); response.Code != http.StatusBadRequest {
+		t.Errorf("duplicate category: status %d, want 400", response.Code)
+	}
+
+	expectAdminKey(mock, config.ScopeRead)
+	mock.ExpectQuery(`WHERE category_name IN \(\?, \?\)`).
+		WithArgs("b", "a").
+		WillReturnRows(sqlmock.NewRows([]string{"category_name", "sessions"}))
+	response := serve(router, http.MethodGet, "/api/analytics/categories/funnel?order=b,%20a", nil, adminHeader)
+	if response.Code != http.StatusOK {
+		t.Fatalf("status %d, want 200: %s", response.Code, response.Body)
+	}
+	if order := decodeBody(t, response)["order"]; !reflect.DeepEqual(order, []interface{}{"b", "a"}) {
+		t.Errorf("order = %v, want [b a]", order)
+	}
+}
+
+func TestSplitList(t *testing.T) {
+	if got := splitList(" a, ,b ,"); !reflect.DeepEqual(got, []string{"a", "b"}) {
+		t.Errorf("splitList = %q, want [a b]", got)
+	}
+	if got := splitList(""); got != nil {
+		t.Errorf("splitList(\"\") = %q, want nil", got)
+	}
+}
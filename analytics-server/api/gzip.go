@@ -0,0 +1,93 @@
+package api
+
+import (
+	"compress/gzip"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// gzipWriter compresses the response body. Compression starts with the
+// first write, so bodiless responses stay empty, and is skipped when the
+// handler set its own Content-Encoding, as the Prometheus handler does.
+type gzipWriter struct {
+	gin.ResponseWriter
+	gz          *gzip.Writer
+	passThrough bool
+}
+
+func (w *gzipWriter) Write(data []byte) (int, error) {
+	if w.gz == nil && !w.passThrough {
+		header := w.Header()
+		if header.Get("Content-Encoding") != "" {
+			w.passThrough = true
+		} else {
+			header.Set("Content-Encoding", "gzip")
+			header.Del("Content-Length")
+			w.gz = gzip.NewWriter(w.ResponseWriter)
+		}
+	}
+	if w.passThrough {
+		return w.ResponseWriter.Write(data)
+	}
+	return w.gz.Write(data)
+}
+
+func (w *gzipWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+// Flush sends the data compressed so far, so streamed responses such as the
+// CSV exports still reach the client incrementally.
+func (w *gzipWriter) Flush() {
+	if w.gz != nil {
+		w.gz.Flush()
+	}
+	w.ResponseWriter.Flush()
+}
+
+// close writes the end of the compressed stream, if one was started.
+func (w *gzipWriter) close() {
+	if w.gz != nil {
+		w.gz.Close()
+	}
+}
+
+// Gzip compresses the responses of GET requests from clients that accept
+// gzip. WebSocket upgrades are left alone.
+func Gzip() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Header("Vary", "Accept-Encoding")
+		if c.Request.Method != http.MethodGet ||
+			!acceptsGzip(c.Request.Header.Get("Accept-Encoding")) ||
+			c.Request.Header.Get("Upgrade") != "" {
+			c.Next()
+			return
+		}
+
+		writer := &gzipWriter{ResponseWriter: c.Writer}
+		c.Writer = writer
+		defer writer.close()
+		c.Next()
+	}
+}
+
+// acceptsGzip reports whether an Accept-Encoding header value lists gzip
+// without refusing it with q=0.
+func acceptsGzip(acceptEncoding string) bool {
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		coding, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		if !strings.EqualFold(strings.TrimSpace(coding), "gzip") {
+			continue
+		}
+		name, value, _ := strings.Cut(strings.TrimSpace(params), "=")
+		if strings.TrimSpace(name) != "q" {
+			return true
+		}
+		q, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+		return err == nil && q > 0
+	}
+	return false
+}
@@ -0,0 +1,173 @@
+package api
+
+import (
+	"bytes"
+	"compress/gzip"
+	"cyber-swipe-analytics/config"
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gin-gonic/gin"
+)
+
+// newGzipRouter returns a router compressing responses the way main sets
+// it up, with the middleware in place before the routes.
+func newGzipRouter(h *AnalyticsHandler) *gin.Engine {
+	router := gin.New()
+	router.Use(Gzip())
+	SetupRoutes(router, h)
+	return router
+}
+
+// gzipHeader returns adminHeader along with an Accept-Encoding header
+// accepting gzip.
+func gzipHeader() http.Header {
+	header := http.Header{"Accept-Encoding": {"gzip, deflate"}}
+	for key, values := range adminHeader {
+		header[key] = values
+	}
+	return header
+}
+
+// gunzipBody checks the response is gzip-encoded and returns its
+// decompressed body.
+func gunzipBody(t *testing.T, response *httptest.ResponseRecorder) []byte {
+	t.Helper()
+	if encoding := response.Header().Get("Content-Encoding"); encoding != "gzip" {
+		t.Fatalf("Content-Encoding = %q, want gzip", encoding)
+	}
+	reader, err := gzip.NewReader(response.Body)
+	if err != nil {
+		t.Fatalf("gzip.NewReader: %v", err)
+	}
+	body, err := io.ReadAll(reader)
+	if err != nil {
+		t.Fatalf("decompressing body: %v", err)
+	}
+	return body
+}
+
+func TestGzipStats(t *testing.T) {
+	h, mock := newTestHandler(t, nil)
+	router := newGzipRouter(h)
+
+	expectAdminKey(mock, config.ScopeRead)
+	for _, rows := range aggregatedStatisticsRows() {
+		mock.ExpectQuery(`.`).WillReturnRows(rows)
+	}
+	response := serve(router, http.MethodGet, "/api/analytics/stats?include=summary", nil, gzipHeader())
+	if response.Code != http.StatusOK {
+		t.Fatalf("status %d, want 200", response.Code)
+	}
+	var body map[string]interface{}
+	if err := json.Unmarshal(gunzipBody(t, response), &body); err != nil {
+		t.Fatalf("decompressed body is not JSON: %v", err)
+	}
+	if _, ok := body["statistics"]; !ok {
+		t.Errorf("body = %v, want statistics", body)
+	}
+	if vary := response.Header().Get("Vary"); vary != "Accept-Encoding" {
+		t.Errorf("Vary = %q, want Accept-Encoding", vary)
+	}
+}
+
+func TestGzipSkipsClientsNotAcceptingIt(t *testing.T) {
+	h, mock := newPingTestHandler(t)
+	router := newGzipRouter(h)
+
+	// Health probes rarely send Accept-Encoding and get plain JSON
+	for _, acceptEncoding := range []string{"", "identity", "gzip;q=0"} {
+		mock.ExpectPing()
+		response := serve(router, http.MethodGet, "/health", nil, http.Header{"Accept-Encoding": {acceptEncoding}})
+		if response.Code != http.StatusOK || response.Header().Get("Content-Encoding") != "" {
+			t.Errorf("%q: status %d, Content-Encoding %q", acceptEncoding, response.Code, response.Header().Get("Content-Encoding"))
+			continue
+		}
+		if status := decodeBody(t, response)["status"]; status != "ok" {
+			t.Errorf("%q: status = %v, want ok", acceptEncoding, status)
+		}
+	}
+}
+
+func TestGzipHealthCheck(t *testing.T) {
+	h, mock := newPingTestHandler(t)
+	mock.ExpectPing()
+
+	response := serve(newGzipRouter(h), http.MethodGet, "/health", nil, http.Header{"Accept-Encoding": {"gzip"}})
+	if response.Code != http.StatusOK {
+		t.Fatalf("status %d, want 200", response.Code)
+	}
+	var body map[string]interface{}
+	if err := json.Unmarshal(gunzipBody(t, response), &body); err != nil || body["status"] != "ok" {
+		t.Errorf("body = %v (%v), want status ok", body, err)
+	}
+}
+
+func TestGzipEventsCSV(t *testing.T) {
+	h, mock := newTestHandler(t, nil)
+	created := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	expectAdminKey(mock, config.ScopeRead)
+	expectLastModified(mock, created)
+
+	// The stream spans several flushes, each of which must leave the
+	// compressed stream intact
+	const events = csvFlushRows*2 + 7
+	rows := sqlmock.NewRows(eventCSVColumns)
+	for id := 1; id <= events; id++ {
+		rows.AddRow(id, "s1", "card_swipe", "c1", "left", false, 0.5, 300.0, 20.0, -8.5, created)
+	}
+	mock.ExpectQuery(`FROM events\s+ORDER BY created_at, id`).WillReturnRows(rows)
+
+	response := serve(newGzipRouter(h), http.MethodGet, "/api/analytics/stats?format=csv&table=events", nil, gzipHeader())
+	if response.Code != http.StatusOK || response.Header().Get("Content-Type") != csvContentType {
+		t.Fatalf("status %d with %q", response.Code, response.Header().Get("Content-Type"))
+	}
+	if !response.Flushed {
+		t.Error("the CSV stream was not flushed")
+	}
+	records, err := csv.NewReader(bytes.NewReader(gunzipBody(t, response))).ReadAll()
+	if err != nil {
+		t.Fatalf("malformed CSV: %v", err)
+	}
+	if len(records)-1 != events {
+		t.Errorf("got %d rows, want %d", len(records)-1, events)
+	}
+}
+
+func TestGzipLeavesPostRequestsAlone(t *testing.T) {
+	h, mock := newTestHandler(t, nil)
+	expectSessionNotOptedOut(mock, "s1")
+	mock.ExpectExec(`INSERT INTO events`).WillReturnResult(sqlmock.NewResult(1, 1))
+
+	response := serve(newGzipRouter(h), http.MethodPost, "/api/analytics/event",
+		map[string]interface{}{"session_id": "s1", "event_type": "button_tap"}, http.Header{"Accept-Encoding": {"gzip"}})
+	if response.Code != http.StatusCreated || response.Header().Get("Content-Encoding") != "" {
+		t.Errorf("status %d, Content-Encoding %q; want an uncompressed 201", response.Code, response.Header().Get("Content-Encoding"))
+	}
+}
+
+func TestAcceptsGzip(t *testing.T) {
+	tests := []struct {
+		acceptEncoding string
+		want           bool
+	}{
+		{"gzip", true},
+		{"deflate, GZIP", true},
+		{"br;q=1.0, gzip;q=0.8", true},
+		{"gzip;q=0", false},
+		{"gzip; q=0.0", false},
+		{"identity", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		if got := acceptsGzip(tt.acceptEncoding); got != tt.want {
+			t.Errorf("acceptsGzip(%q) = %v, want %v", tt.acceptEncoding, got, tt.want)
+		}
+	}
+}
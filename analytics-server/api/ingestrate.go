@@ -0,0 +1,91 @@
+package api
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ingestionWindows are the windows over which the ingestion rate is
+// reported, shortest first.
+var ingestionWindows = []struct {
+	name    string
+	seconds int64
+}{
+	{"1m", 60},
+	{"5m", 300},
+	{"15m", 900},
+}
+
+// ingestionHistorySeconds is the length of the sliding window kept in
+// memory; it must cover the longest reporting window.
+const ingestionHistorySeconds = 900
+
+// ingestionRate counts inserted events in a sliding window of one-second
+// slots. It only lives in memory, so it starts empty after a restart.
+type ingestionRate struct {
+	mu      sync.Mutex
+	started time.Time
+	// counts and seconds form a ring buffer indexed by Unix second; a slot
+	// is only valid while its second matches.
+	counts  [ingestionHistorySeconds]int64
+	seconds [ingestionHistorySeconds]int64
+}
+
+func newIngestionRate(now time.Time) *ingestionRate {
+	return &ingestionRate{started: now}
+}
+
+// record adds n inserted events at now.
+func (r *ingestionRate) record(n int, now time.Time) {
+	second := now.Unix()
+	slot := second % ingestionHistorySeconds
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.seconds[slot] != second {
+		r.seconds[slot] = second
+		r.counts[slot] = 0
+	}
+	r.counts[slot] += int64(n)
+}
+
+// rates returns the events per second over each of ingestionWindows,
+// keyed by window name. Shortly after startup a window is shortened to
+// the time elapsed since then, so the rate is not diluted by seconds in
+// which the server was not running.
+func (r *ingestionRate) rates(now time.Time) map[string]float64 {
+	current := now.Unix()
+	uptime := int64(now.Sub(r.started)/time.Second) + 1
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	rates := make(map[string]float64, len(ingestionWindows))
+	for _, window := range ingestionWindows {
+		var total int64
+		for slot, second := range r.seconds {
+			if second > current-window.seconds && second <= current {
+				total += r.counts[slot]
+			}
+		}
+		span := window.seconds
+		if uptime < span {
+			span = uptime
+		}
+		rates[window.name] = float64(total) / float64(span)
+	}
+	return rates
+}
+
+// getIngestionRate reports the number of events inserted per second over
+// the last 1, 5, and 15 minutes, for capacity planning. The rate is
+// tracked in memory and restarts from zero with the server.
+func (h *AnalyticsHandler) getIngestionRate(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"events_per_second": h.ingestion.rates(time.Now()),
+		"since":             h.ingestion.started.UTC().Format(time.RFC3339),
+	})
+}
@@ -0,0 +1,51 @@
+package api
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestIngestionRate(t *testing.T) {
+	start := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	rate := newIngestionRate(start)
+
+	// One event per second for a minute, well after startup
+	burst := start.Add(20 * time.Minute)
+	for i := 0; i < 60; i++ {
+		rate.record(1, burst.Add(time.Duration(i)*time.Second))
+	}
+	rates := rate.rates(burst.Add(59 * time.Second))
+	want := map[string]float64{"1m": 1, "5m": 60.0 / 300, "15m": 60.0 / 900}
+	for window, expected := range want {
+		if math.Abs(rates[window]-expected) > 1e-9 {
+			t.Errorf("%s rate = %v, want %v", window, rates[window], expected)
+		}
+	}
+
+	// Two minutes later the burst has left the 1m window only
+	rates = rate.rates(burst.Add(3 * time.Minute))
+	if rates["1m"] != 0 || math.Abs(rates["5m"]-0.2) > 1e-9 {
+		t.Errorf("rates after the burst = %v", rates)
+	}
+
+	// Slots reused a full history later start over
+	later := burst.Add(ingestionHistorySeconds * time.Second)
+	rate.record(3, later)
+	if got := rate.rates(later)["1m"]; math.Abs(got-3.0/60) > 1e-9 {
+		t.Errorf("1m rate after wrapping = %v, want %v", got, 3.0/60)
+	}
+}
+
+func TestIngestionRateAfterStartup(t *testing.T) {
+	start := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	rate := newIngestionRate(start)
+
+	// Five seconds after a restart the windows cover only those seconds
+	rate.record(10, start.Add(4*time.Second))
+	for window, got := range rate.rates(start.Add(4 * time.Second)) {
+		if got != 2 {
+			t.Errorf("%s rate = %v, want 2", window, got)
+		}
+	}
+}
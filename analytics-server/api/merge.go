@@ -0,0 +1,52 @@
+package api
+
+import (
+	"cyber-swipe-analytics/storage"
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// MergeSessionsRequest represents the data required to merge sessions
+// that belong to one logical play.
+type MergeSessionsRequest struct {
+	PrimarySessionID    string   `json:"primary_session_id" binding:"required,max=255"`
+	SecondarySessionIDs []string `json:"secondary_session_ids" binding:"required,min=1,max=100,dive,required,max=255"`
+}
+
+// mergeSessions folds secondary sessions, e.g. ones created by a
+// reconnect, into a primary session: their events, performance metrics,
+// and category stats are reassigned to the primary session and the
+// secondary sessions are deleted, in a single transaction.
+func (h *AnalyticsHandler) mergeSessions(c *gin.Context) {
+	var request MergeSessionsRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": describeBindingError(err).Error()})
+		return
+	}
+
+	seen := map[string]bool{request.PrimarySessionID: true}
+	for _, id := range request.SecondarySessionIDs {
+		if seen[id] {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "session IDs must be distinct: " + id})
+			return
+		}
+		seen[id] = true
+	}
+
+	result, err := h.db.MergeSessions(c.Request.Context(), request.PrimarySessionID, request.SecondarySessionIDs)
+	switch {
+	case errors.Is(err, storage.ErrSessionNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "One or more sessions were not found"})
+		return
+	case errors.Is(err, storage.ErrMergeConflict):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to merge sessions"})
+		return
+	}
+
+	c.JSON(http.StatusOK, result)
+}
@@ -0,0 +1,100 @@
+package api
+
+import (
+	"cyber-swipe-analytics/config"
+	"net/http"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestMergeSessions(t *testing.T) {
+	h, mock := newTestHandler(t, nil)
+	expectAdminKey(mock, config.ScopeAdmin)
+
+	// s2's events and samples move to s1, which has no category stats to
+	// fold in, and s2 is deleted
+	mock.ExpectBegin()
+	mock.ExpectQuery(`SELECT id FROM sessions WHERE session_id IN \(\?, \?\) FOR UPDATE`).
+		WithArgs("s1", "s2").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1).AddRow(2))
+	mock.ExpectExec(`UPDATE events SET session_id = \? WHERE session_id IN \(\?\)`).
+		WithArgs("s1", "s2").
+		WillReturnResult(sqlmock.NewResult(0, 5))
+	mock.ExpectExec(`UPDATE performance_metrics SET session_id = \? WHERE session_id IN \(\?\)`).
+		WithArgs("s1", "s2").
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectExec(`INSERT INTO category_stats`).
+		WithArgs("s1", "s2").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(`DELETE FROM category_stats WHERE session_id IN \(\?\)`).
+		WithArgs("s2").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(`DELETE FROM sessions WHERE session_id IN \(\?\)`).
+		WithArgs("s2").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	response := serve(newTestRouter(h), http.MethodPost, "/api/analytics/sessions/merge",
+		map[string]interface{}{"primary_session_id": "s1", "secondary_session_ids": []string{"s2"}}, adminHeader)
+	if response.Code != http.StatusOK {
+		t.Fatalf("status %d, want 200: %s", response.Code, response.Body)
+	}
+	body := decodeBody(t, response)
+	moved := body["moved"].(map[string]interface{})
+	if moved["events"] != 5.0 || moved["performance_metrics"] != 2.0 || body["sessions_deleted"] != 1.0 {
+		t.Errorf("body = %v, want 5 events and 2 samples moved, 1 session deleted", body)
+	}
+}
+
+func TestMergeSessionsErrors(t *testing.T) {
+	tests := []struct {
+		name       string
+		body       map[string]interface{}
+		expect     func(sqlmock.Sqlmock)
+		wantStatus int
+	}{
+		{
+			name:       "no secondary sessions",
+			body:       map[string]interface{}{"primary_session_id": "s1", "secondary_session_ids": []string{}},
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "primary listed as secondary",
+			body:       map[string]interface{}{"primary_session_id": "s1", "secondary_session_ids": []string{"s2", "s1"}},
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name: "unknown session",
+			body: map[string]interface{}{"primary_session_id": "s1", "secondary_session_ids": []string{"s2"}},
+			expect: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(`FOR UPDATE`).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+				mock.ExpectRollback()
+			},
+			wantStatus: http.StatusNotFound,
+		},
+		{
+			name: "overlapping sequence numbers",
+			body: map[string]interface{}{"primary_session_id": "s1", "secondary_session_ids": []string{"s2"}},
+			expect: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(`FOR UPDATE`).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1).AddRow(2))
+				mock.ExpectExec(`UPDATE events`).WillReturnError(duplicateKeyError)
+				mock.ExpectRollback()
+			},
+			wantStatus: http.StatusConflict,
+		},
+	}
+	for _, tt := range tests {
+		h, mock := newTestHandler(t, nil)
+		expectAdminKey(mock, config.ScopeAdmin)
+		if tt.expect != nil {
+			tt.expect(mock)
+		}
+		response := serve(newTestRouter(h), http.MethodPost, "/api/analytics/sessions/merge", tt.body, adminHeader)
+		if response.Code != tt.wantStatus {
+			t.Errorf("%s: status %d, want %d: %s", tt.name, response.Code, tt.wantStatus, response.Body)
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package api
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/prometheus/common/expfmt"
+)
+
+// scrapeRequestCount scrapes /metrics from router and returns the value of
+// http_requests_total for the given labels, or 0 when there is no sample.
+func scrapeRequestCount(t *testing.T, router http.Handler, method, route, status string) float64 {
+	t.Helper()
+	response := serve(router, http.MethodGet, "/metrics", nil, nil)
+	if response.Code != http.StatusOK {
+		t.Fatalf("/metrics: status %d", response.Code)
+	}
+
+	var parser expfmt.TextParser
+	families, err := parser.TextToMetricFamilies(strings.NewReader(response.Body.String()))
+	if err != nil {
+		t.Fatalf("invalid exposition output: %v", err)
+	}
+	want := map[string]string{"method": method, "route": route, "status": status}
+	for _, metric := range families["http_requests_total"].GetMetric() {
+		matched := 0
+		for _, label := range metric.GetLabel() {
+			if want[label.GetName()] == label.GetValue() {
+				matched++
+			}
+		}
+		if matched == len(want) {
+			return metric.GetCounter().GetValue()
+		}
+	}
+	return 0
+}
+
+// newMetricsRouter returns a router serving the routes of h behind the
+// metrics middleware, as main sets it up.
+func newMetricsRouter(h *AnalyticsHandler) *gin.Engine {
+	router := gin.New()
+	router.Use(Metrics())
+	SetupRoutes(router, h)
+	return router
+}
+
+func TestMetricsCountsRequests(t *testing.T) {
+	h, _ := newTestHandler(t, nil)
+	router := newMetricsRouter(h)
+
+	// The counters are process-wide, so compare against the count before
+	before := scrapeRequestCount(t, router, http.MethodGet, "/health", "200")
+	for i := 0; i < 3; i++ {
+		if response := serve(router, http.MethodGet, "/health", nil, nil); response.Code != http.StatusOK {
+			t.Fatalf("/health: status %d", response.Code)
+		}
+	}
+	if got := scrapeRequestCount(t, router, http.MethodGet, "/health", "200"); got != before+3 {
+		t.Errorf("http_requests_total for /health = %v, want %v", got, before+3)
+	}
+}
+
+func TestMetricsLabelsUnmatchedRoutes(t *testing.T) {
+	h, _ := newTestHandler(t, nil)
+	router := newMetricsRouter(h)
+
+	before := scrapeRequestCount(t, router, http.MethodGet, unmatchedRoute, "404")
+	serve(router, http.MethodGet, "/no/such/path", nil, nil)
+	if got := scrapeRequestCount(t, router, http.MethodGet, unmatchedRoute, "404"); got != before+1 {
+		t.Errorf("http_requests_total for unmatched routes = %v, want %v", got, before+1)
+	}
+}
+
+func TestRecordEventCountsRecordedEvents(t *testing.T) {
+	h, mock := newTestHandler(t, nil)
+	router := newTestRouter(h)
+	before := testutil.ToFloat64(eventsRecordedTotal)
+
+	expectSessionNotOptedOut(mock, "s1")
+	mock.ExpectExec(`INSERT INTO events`).WillReturnResult(sqlmock.NewResult(1, 1))
+	response := serve(router, http.MethodPost, "/api/analytics/event", map[string]interface{}{"session_id": "s1", "event_type": "button_tap"}, nil)
+	if response.Code != http.StatusCreated {
+		t.Fatalf("status %d, want 201: %s", response.Code, response.Body)
+	}
+
+	// A refused event is not counted
+	serve(router, http.MethodPost, "/api/analytics/event", map[string]interface{}{"event_type": "button_tap"}, nil)
+
+	if got := testutil.ToFloat64(eventsRecordedTotal); got != before+1 {
+		t.Errorf("analytics_events_recorded_total = %v, want %v", got, before+1)
+	}
+}
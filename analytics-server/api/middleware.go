@@ -0,0 +1,203 @@
+package api
+
+import (
+	"crypto/rand"
+	"cyber-swipe-analytics/config"
+	"cyber-swipe-analytics/storage"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	mathrand "math/rand/v2"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// adminScopeKey is the gin context key holding the scope of the
+// authenticated admin key.
+const adminScopeKey = "admin_scope"
+
+// requireScope returns a middleware that authenticates the X-Admin-Secret
+// header against the api_keys table and requires the key's scope to be at
+// least scope. Unknown and revoked keys are rejected with 401, keys with an
+// insufficient scope with 403. The key's scope is stored in the context
+// under adminScopeKey.
+func (h *AnalyticsHandler) requireScope(scope string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		adminSecret := c.GetHeader("X-Admin-Secret")
+		if adminSecret == "" {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Missing admin secret key"})
+			return
+		}
+
+		keyScope, ok, err := h.db.LookupAPIKey(c.Request.Context(), adminSecret)
+		if errors.Is(err, storage.ErrAPIKeyRevoked) {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Admin key has been revoked"})
+			return
+		}
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "Failed to verify admin key"})
+			return
+		}
+		if !ok {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Invalid admin secret key"})
+			return
+		}
+
+		if config.ScopeRank(keyScope) < config.ScopeRank(scope) {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{
+				"error":          "Insufficient admin scope",
+				"required_scope": scope,
+			})
+			return
+		}
+
+		c.Set(adminScopeKey, keyScope)
+		c.Next()
+	}
+}
+
+// exportRetryAfter is the Retry-After value, in seconds, sent when all
+// export slots are taken.
+const exportRetryAfter = 5
+
+// limitExports returns a middleware that serves at most
+// MaxConcurrentExports requests at a time, so that several expensive
+// exports cannot saturate the database. Requests beyond the limit are
+// rejected with 429 and a Retry-After header instead of waiting.
+func (h *AnalyticsHandler) limitExports() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		select {
+		case h.exports <- struct{}{}:
+		default:
+			c.Header("Retry-After", strconv.Itoa(exportRetryAfter))
+			c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{"error": "Too many concurrent exports, retry later"})
+			return
+		}
+		defer func() { <-h.exports }()
+		c.Next()
+	}
+}
+
+// requestIDHeader carries the request ID in both directions.
+const requestIDHeader = "X-Request-ID"
+
+// requestIDKey is the gin context key holding the request ID.
+const requestIDKey = "request_id"
+
+// maxRequestIDLen bounds client-supplied request IDs.
+const maxRequestIDLen = 128
+
+// RequestID assigns every request an ID, reusing a well-formed
+// X-Request-ID header from the client or generating a new one. The ID is
+// echoed in the response header and attached to the request context so
+// that failing database queries can be traced back to the request.
+func RequestID() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		requestID := c.GetHeader(requestIDHeader)
+		if !validRequestID(requestID) {
+			requestID = newRequestID()
+		}
+
+		c.Set(requestIDKey, requestID)
+		c.Header(requestIDHeader, requestID)
+		c.Request = c.Request.WithContext(storage.WithRequestID(c.Request.Context(), requestID))
+
+		c.Next()
+	}
+}
+
+// timeoutStatusWriter turns the 500 response of a request whose database
+// calls exceeded DB_QUERY_TIMEOUT into a 503.
+type timeoutStatusWriter struct {
+	gin.ResponseWriter
+	timedOut *atomic.Bool
+}
+
+func (w *timeoutStatusWriter) WriteHeader(code int) {
+	if code == http.StatusInternalServerError && w.timedOut.Load() {
+		code = http.StatusServiceUnavailable
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+// QueryTimeouts answers requests that failed because a database call
+// exceeded DB_QUERY_TIMEOUT with 503 instead of 500, so clients know the
+// request can be retried.
+func QueryTimeouts() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, timedOut := storage.WithTimeoutFlag(c.Request.Context())
+		c.Request = c.Request.WithContext(ctx)
+		c.Writer = &timeoutStatusWriter{ResponseWriter: c.Writer, timedOut: timedOut}
+		c.Next()
+	}
+}
+
+// errorBody returns an error response body with message, carrying the
+// request ID when EchoRequestID is enabled.
+func (h *AnalyticsHandler) errorBody(c *gin.Context, message string) gin.H {
+	body := gin.H{"error": message}
+	if h.cfg.EchoRequestID {
+		if requestID := c.GetString(requestIDKey); requestID != "" {
+			body["request_id"] = requestID
+		}
+	}
+	return body
+}
+
+// validRequestID reports whether a client-supplied request ID is non-empty,
+// reasonably short, and made of printable ASCII without spaces.
+func validRequestID(requestID string) bool {
+	if requestID == "" || len(requestID) > maxRequestIDLen {
+		return false
+	}
+	for i := 0; i < len(requestID); i++ {
+		if requestID[i] <= ' ' || requestID[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+// newRequestID returns a random 128-bit hex-encoded request ID.
+func newRequestID() string {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return fmt.Sprintf("%x", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(b[:])
+}
+
+// RequestLogger logs one line per request. Failed (non-2xx) and slow
+// requests are always logged; other requests are logged with probability
+// LogSampleRate. Every line carries the sample rate it was logged at, so
+// log volume can be scaled back up when counting requests.
+func RequestLogger(cfg *config.Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		path := c.Request.URL.Path
+
+		c.Next()
+
+		latency := time.Since(start)
+		status := c.Writer.Status()
+
+		sampleRate := 1.0
+		failed := status < 200 || status >= 300
+		slow := latency >= cfg.LogSlowThreshold
+		if !failed && !slow {
+			sampleRate = cfg.LogSampleRate
+			if mathrand.Float64() >= sampleRate {
+				return
+			}
+		}
+
+		log.Printf("%s %s %d %v request_id=%s client_ip=%s sample_rate=%g",
+			c.Request.Method, path, status, latency,
+			c.GetString(requestIDKey), c.ClientIP(), sampleRate)
+	}
+}
@@ -0,0 +1,294 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"cyber-swipe-analytics/config"
+	"cyber-swipe-analytics/storage"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gin-gonic/gin"
+)
+
+func TestRequireScope(t *testing.T) {
+	tests := []struct {
+		keyScope string
+		required string
+		want     int
+	}{
+		{config.ScopeRead, config.ScopeRead, http.StatusOK},
+		{config.ScopeRead, config.ScopeDelete, http.StatusForbidden},
+		{config.ScopeRead, config.ScopeAdmin, http.StatusForbidden},
+		{config.ScopeDelete, config.ScopeRead, http.StatusOK},
+		{config.ScopeDelete, config.ScopeDelete, http.StatusOK},
+		{config.ScopeDelete, config.ScopeAdmin, http.StatusForbidden},
+		{config.ScopeAdmin, config.ScopeRead, http.StatusOK},
+		{config.ScopeAdmin, config.ScopeDelete, http.StatusOK},
+		{config.ScopeAdmin, config.ScopeAdmin, http.StatusOK},
+	}
+	for _, tt := range tests {
+		h, mock := newTestHandler(t, nil)
+		router := gin.New()
+		router.GET("/protected", h.requireScope(tt.required), func(c *gin.Context) {
+			c.JSON(http.StatusOK, gin.H{"scope": c.GetString(adminScopeKey)})
+		})
+		expectAdminKey(mock, tt.keyScope)
+
+		response := serve(router, http.MethodGet, "/protected", nil, adminHeader)
+		if response.Code != tt.want {
+			t.Errorf("%s key on %s endpoint: status %d, want %d", tt.keyScope, tt.required, response.Code, tt.want)
+			continue
+		}
+		if tt.want == http.StatusOK && decodeBody(t, response)["scope"] != tt.keyScope {
+			t.Errorf("%s key on %s endpoint: scope not stored in the context", tt.keyScope, tt.required)
+		}
+	}
+}
+
+func TestRequireScopeMissingHeader(t *testing.T) {
+	h, _ := newTestHandler(t, nil)
+
+	response := serve(newTestRouter(h), http.MethodGet, "/api/analytics/stats", nil, nil)
+	if response.Code != http.StatusUnauthorized {
+		t.Errorf("status %d, want 401", response.Code)
+	}
+}
+
+func TestRequireScopeRejectsKeys(t *testing.T) {
+	tests := []struct {
+		name   string
+		expect func(*sqlmock.ExpectedQuery)
+		want   int
+	}{
+		{"revoked", func(q *sqlmock.ExpectedQuery) {
+			q.WillReturnRows(sqlmock.NewRows([]string{"scope", "revoked_at"}).AddRow(config.ScopeAdmin, time.Now()))
+		}, http.StatusUnauthorized},
+		{"unknown", func(q *sqlmock.ExpectedQuery) {
+			q.WillReturnRows(sqlmock.NewRows([]string{"scope", "revoked_at"}))
+		}, http.StatusUnauthorized},
+		{"lookup failure", func(q *sqlmock.ExpectedQuery) {
+			q.WillReturnError(deadlockError)
+		}, http.StatusInternalServerError},
+	}
+	for _, tt := range tests {
+		h, mock := newTestHandler(t, nil)
+		tt.expect(mock.ExpectQuery(`SELECT scope, revoked_at FROM api_keys WHERE key_hash = \?`).
+			WithArgs(storage.HashAPIKey("test-admin-key")))
+
+		response := serve(newTestRouter(h), http.MethodGet, "/api/analytics/stats", nil, adminHeader)
+		if response.Code != tt.want {
+			t.Errorf("%s key: status %d, want %d", tt.name, response.Code, tt.want)
+		}
+	}
+}
+
+func TestRequestID(t *testing.T) {
+	router := gin.New()
+	router.Use(RequestID())
+	router.GET("/ping", func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{
+			"request_id": c.GetString(requestIDKey),
+			"context_id": storage.RequestIDFromContext(c.Request.Context()),
+		})
+	})
+
+	// A well-formed client ID is reused and attached to the context
+	response := serve(router, http.MethodGet, "/ping", nil, http.Header{requestIDHeader: {"client-123"}})
+	body := decodeBody(t, response)
+	if response.Header().Get(requestIDHeader) != "client-123" || body["request_id"] != "client-123" || body["context_id"] != "client-123" {
+		t.Errorf("client ID not reused: header %q, body %v", response.Header().Get(requestIDHeader), body)
+	}
+
+	// A malformed one is replaced
+	response = serve(router, http.MethodGet, "/ping", nil, http.Header{requestIDHeader: {"bad id"}})
+	if id := response.Header().Get(requestIDHeader); id == "bad id" || len(id) != 32 {
+		t.Errorf("malformed client ID not replaced: %q", id)
+	}
+}
+
+func TestRequestLoggerAlwaysLogsErrors(t *testing.T) {
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+
+	// Nothing is sampled, so only failed requests reach the log
+	cfg := &config.Config{LogSampleRate: 0, LogSlowThreshold: time.Hour}
+	router := gin.New()
+	router.Use(RequestID(), RequestLogger(cfg))
+	router.GET("/ok", func(c *gin.Context) { c.Status(http.StatusOK) })
+	router.GET("/fail", func(c *gin.Context) { c.Status(http.StatusInternalServerError) })
+
+	for i := 0; i < 20; i++ {
+		serve(router, http.MethodGet, "/ok", nil, nil)
+		serve(router, http.MethodGet, "/fail", nil, nil)
+	}
+
+	output := buf.String()
+	if strings.Contains(output, "GET /ok") {
+		t.Errorf("successful request logged at sample rate 0:\n%s", output)
+	}
+	if got := strings.Count(output, "GET /fail 500"); got != 20 {
+		t.Errorf("logged %d failed requests, want 20:\n%s", got, output)
+	}
+	if !strings.Contains(output, "sample_rate=1") {
+		t.Errorf("failed request not stamped with sample_rate=1:\n%s", output)
+	}
+}
+
+func TestRequestLoggerAlwaysLogsSlowRequests(t *testing.T) {
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+
+	cfg := &config.Config{LogSampleRate: 0, LogSlowThreshold: time.Nanosecond}
+	router := gin.New()
+	router.Use(RequestLogger(cfg))
+	router.GET("/slow", func(c *gin.Context) {
+		time.Sleep(time.Millisecond)
+		c.Status(http.StatusOK)
+	})
+
+	serve(router, http.MethodGet, "/slow", nil, nil)
+	if !strings.Contains(buf.String(), "GET /slow 200") {
+		t.Errorf("slow request not logged: %q", buf.String())
+	}
+}
+
+func TestLimitExports(t *testing.T) {
+	h, _ := newTestHandler(t, map[string]string{"MAX_CONCURRENT_EXPORTS": "2"})
+
+	entered := make(chan struct{})
+	release := make(chan struct{})
+	router := gin.New()
+	router.GET("/export", h.limitExports(), func(c *gin.Context) {
+		entered <- struct{}{}
+		<-release
+		c.Status(http.StatusOK)
+	})
+
+	// Two exports hold both slots
+	var wg sync.WaitGroup
+	codes := make([]int, 2)
+	for i := range codes {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			codes[i] = serve(router, http.MethodGet, "/export", nil, nil).Code
+		}(i)
+		<-entered
+	}
+
+	// A third is turned away instead of waiting
+	response := serve(router, http.MethodGet, "/export", nil, nil)
+	if response.Code != http.StatusTooManyRequests || response.Header().Get("Retry-After") == "" {
+		t.Errorf("third export: status %d with Retry-After %q, want 429 with a delay",
+			response.Code, response.Header().Get("Retry-After"))
+	}
+
+	close(release)
+	wg.Wait()
+	for i, code := range codes {
+		if code != http.StatusOK {
+			t.Errorf("export %d: status %d, want 200", i, code)
+		}
+	}
+
+	// The slots are free again once the exports finish
+	go func() { <-entered }()
+	if response := serve(router, http.MethodGet, "/export", nil, nil); response.Code != http.StatusOK {
+		t.Errorf("export after release: status %d, want 200", response.Code)
+	}
+}
+
+func TestGetStatsErrorsCarryRequestID(t *testing.T) {
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+
+	h, mock := newTestHandler(t, nil)
+	expectAdminKey(mock, config.ScopeRead)
+	expectLastModified(mock, time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC))
+	for _, table := range []string{"sessions", "performance_metrics", "events"} {
+		mock.ExpectQuery(`FROM ` + table + `\s+ORDER BY`).WillReturnError(deadlockError)
+	}
+
+	header := http.Header{requestIDHeader: {"req-9"}}
+	for key, values := range adminHeader {
+		header[key] = values
+	}
+	response := serve(newTestRouter(h), http.MethodGet, "/api/analytics/stats?include=raw", nil, header)
+	if response.Code != http.StatusInternalServerError {
+		t.Fatalf("status %d, want 500: %s", response.Code, response.Body)
+	}
+	if requestID := decodeBody(t, response)["request_id"]; requestID != "req-9" {
+		t.Errorf("request_id = %v, want req-9", requestID)
+	}
+
+	// The failed query is logged with the request and the section it was
+	// run for
+	if output := buf.String(); !strings.Contains(output, "Query failed [request_id=req-9 label=raw_data.events]") {
+		t.Errorf("log lacks the request ID and label of the failed query:\n%s", output)
+	}
+}
+
+func TestErrorBodyWithoutRequestIDEcho(t *testing.T) {
+	h, _ := newTestHandler(t, map[string]string{"ECHO_REQUEST_ID": "false"})
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Set(requestIDKey, "req-9")
+
+	if body := h.errorBody(c, "Failed"); len(body) != 1 || body["error"] != "Failed" {
+		t.Errorf("errorBody = %v, want only the message", body)
+	}
+}
+
+// newTimeoutRouter returns a router serving the routes of h behind the
+// query timeout middleware, with database calls bounded by timeout.
+func newTimeoutRouter(h *AnalyticsHandler, timeout time.Duration) *gin.Engine {
+	h.db.QueryTimeout = timeout
+	router := gin.New()
+	router.Use(QueryTimeouts())
+	SetupRoutes(router, h)
+	return router
+}
+
+func TestQueryTimeoutsAnswer503(t *testing.T) {
+	h, mock := newTestHandler(t, nil)
+	router := newTimeoutRouter(h, 20*time.Millisecond)
+
+	// The opt-out check hangs past DB_QUERY_TIMEOUT
+	mock.ExpectQuery(`JOIN opt_outs o ON o.user_id = s.user_id`).
+		WillDelayFor(time.Second).
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+
+	response := serve(router, http.MethodPost, "/api/analytics/event", map[string]interface{}{"session_id": "s1", "event_type": "button_tap"}, nil)
+	if response.Code != http.StatusServiceUnavailable {
+		t.Errorf("status %d, want 503: %s", response.Code, response.Body)
+	}
+}
+
+func TestCancelledRequestIsNotRetryable(t *testing.T) {
+	h, _ := newTestHandler(t, nil)
+	router := newTimeoutRouter(h, time.Second)
+
+	// A request whose client went away fails its first query without
+	// reaching the database, and isn't reported as a timeout
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	request := httptest.NewRequest(http.MethodPost, "/api/analytics/event",
+		strings.NewReader(`{"session_id": "s1", "event_type": "button_tap"}`)).WithContext(ctx)
+	request.Header.Set("Content-Type", "application/json")
+	response := httptest.NewRecorder()
+	router.ServeHTTP(response, request)
+
+	if response.Code != http.StatusInternalServerError {
+		t.Errorf("status %d, want 500: %s", response.Code, response.Body)
+	}
+}
@@ -0,0 +1,327 @@
+package api
+
+import (
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"cyber-swipe-analytics/config"
+
+	"github.com/gin-gonic/gin"
+)
+
+// openAPIOperation documents one route in the OpenAPI document.
+type openAPIOperation struct {
+	summary string
+	// scope is the admin scope the route requires, if any.
+	scope string
+	// request is a value of the JSON body the route binds, if any.
+	request interface{}
+	// status is the status of a successful response; it defaults to 200.
+	status int
+	// contentType is the media type of a successful response; it
+	// defaults to application/json.
+	contentType string
+}
+
+// openAPIOperations describes the routes by method and path. Routes
+// missing here are still listed, with a generic description, so the
+// document always covers every registered route.
+var openAPIOperations = map[string]openAPIOperation{
+	"GET /health":       {summary: "Report the server and database health"},
+	"GET /metrics":      {summary: "Prometheus scrape endpoint", contentType: "text/plain"},
+	"GET /openapi.json": {summary: "This OpenAPI document"},
+
+	"POST /api/analytics/session":           {summary: "Create or refresh a session", request: SessionRequest{}, status: http.StatusCreated},
+	"POST /api/analytics/session/end":       {summary: "End a session", request: EndSessionRequest{}},
+	"POST /api/analytics/session/end/batch": {summary: "End several sessions", request: BatchEndSessionRequest{}},
+	"POST /api/analytics/event":             {summary: "Record an event", request: EventRequest{}, status: http.StatusCreated},
+	"POST /api/analytics/events/batch":      {summary: "Record up to 500 events in one transaction", request: []EventRequest{}, status: http.StatusCreated},
+	"POST /api/analytics/performance":       {summary: "Record a performance sample", request: PerformanceMetricsRequest{}, status: http.StatusCreated},
+	"POST /api/analytics/category":          {summary: "Record category statistics", request: CategoryStatsRequest{}, status: http.StatusCreated},
+
+	"GET /api/analytics/stats":                          {summary: "Raw data and aggregated statistics", scope: config.ScopeRead},
+	"GET /api/analytics/sessions/success-rates":         {summary: "Swipe success rate per session", scope: config.ScopeRead},
+	"GET /api/analytics/correlations/fps-success":       {summary: "Swipe success by frame rate", scope: config.ScopeRead},
+	"GET /api/analytics/swipes/success-by-session-time": {summary: "Swipe success by time into the session", scope: config.ScopeRead},
+	"GET /api/analytics/swipes/success-by-hour-of-day":  {summary: "Swipe success by hour of day", scope: config.ScopeRead},
+	"GET /api/analytics/swipes/sequences":               {summary: "Most common swipe direction sequences", scope: config.ScopeRead},
+	"GET /api/analytics/events/sequence-gaps":           {summary: "Sessions with gaps in their event sequence numbers", scope: config.ScopeRead},
+	"GET /api/analytics/events/out-of-bounds":           {summary: "Events with coordinates outside the screen", scope: config.ScopeRead},
+	"GET /api/analytics/recent":                         {summary: "Recent sessions and events", scope: config.ScopeRead},
+	"GET /api/analytics/timeseries":                     {summary: "Sessions, events, and swipe success per time bucket", scope: config.ScopeRead},
+	"GET /api/analytics/performance/low-fps-devices":    {summary: "Device models with a low frame rate", scope: config.ScopeRead},
+	"GET /api/analytics/performance/by-app-version":     {summary: "Performance per app version", scope: config.ScopeRead},
+	"GET /api/analytics/performance/by-resolution":      {summary: "Performance per screen resolution", scope: config.ScopeRead},
+	"GET /api/analytics/performance/by-thermal-state":   {summary: "Frame rate per thermal state", scope: config.ScopeRead},
+	"GET /api/analytics/metrics/prometheus":             {summary: "Aggregated statistics in the Prometheus text format", scope: config.ScopeRead, contentType: "text/plain"},
+	"GET /api/analytics/categories":                     {summary: "Categories ranked by success rate or volume", scope: config.ScopeRead},
+	"GET /api/analytics/categories/funnel":              {summary: "Sessions reaching each category in order", scope: config.ScopeRead},
+	"GET /api/analytics/categories/difficulty":          {summary: "Raw and difficulty-weighted success rates per category", scope: config.ScopeRead},
+	"GET /api/analytics/users/top":                      {summary: "Most engaged users", scope: config.ScopeRead},
+	"GET /api/analytics/stream":                         {summary: "WebSocket stream of recorded events", scope: config.ScopeRead, status: http.StatusSwitchingProtocols},
+
+	"POST /api/analytics/sessions/merge": {summary: "Merge sessions into a primary session", scope: config.ScopeAdmin, request: MergeSessionsRequest{}},
+	"POST /api/analytics/purge":          {summary: "Delete data older than the retention window", scope: config.ScopeAdmin},
+
+	"GET /api/analytics/session/{session_id}":            {summary: "A session with its events, performance samples, and category stats", scope: config.ScopeRead},
+	"GET /api/analytics/session/{session_id}/categories": {summary: "Category statistics of a session", scope: config.ScopeRead},
+	"GET /api/analytics/session/{session_id}/bundle":     {summary: "Downloadable bundle of a session's data", scope: config.ScopeRead},
+
+	"GET /api/analytics/admin/property-keys":  {summary: "Registered custom event property keys", scope: config.ScopeAdmin},
+	"POST /api/analytics/admin/backfill":      {summary: "Backfill a derived column", scope: config.ScopeAdmin},
+	"POST /api/analytics/admin/compact":       {summary: "Optimize the analytics tables", scope: config.ScopeAdmin},
+	"POST /api/analytics/admin/archive":       {summary: "Move old sessions to the archive tables", scope: config.ScopeAdmin},
+	"GET /api/analytics/admin/ingestion-rate": {summary: "Recent ingestion rate", scope: config.ScopeAdmin},
+	"GET /api/analytics/admin/integrity":      {summary: "Check for orphaned and inconsistent rows", scope: config.ScopeAdmin},
+	"GET /api/analytics/admin/index-advice":   {summary: "Query plans of the core aggregations", scope: config.ScopeAdmin},
+	"GET /api/analytics/admin/counts":         {summary: "Row counts of the analytics tables", scope: config.ScopeAdmin},
+
+	"GET /api/analytics/user/{user_id}":          {summary: "Aggregated statistics of one user", scope: config.ScopeRead},
+	"DELETE /api/analytics/user/{user_id}":       {summary: "Erase all data of a user", scope: config.ScopeDelete},
+	"POST /api/analytics/user/{user_id}/opt-out": {summary: "Stop collecting data for a user", scope: config.ScopeAdmin},
+	"POST /api/analytics/user/{user_id}/opt-in":  {summary: "Resume collecting data for a user", scope: config.ScopeAdmin},
+}
+
+// getOpenAPISpec returns a handler serving the OpenAPI 3 document of the
+// routes registered on router. The document is built on the first request,
+// once all routes are registered, and the request schemas are derived from
+// the request structs, so their field names can't drift.
+func getOpenAPISpec(router *gin.Engine) gin.HandlerFunc {
+	var once sync.Once
+	var spec gin.H
+	return func(c *gin.Context) {
+		once.Do(func() {
+			spec = buildOpenAPISpec(router.Routes())
+		})
+		c.JSON(http.StatusOK, spec)
+	}
+}
+
+// buildOpenAPISpec builds the OpenAPI document describing routes.
+func buildOpenAPISpec(routes gin.RoutesInfo) gin.H {
+	schemas := gin.H{
+		"Error": gin.H{
+			"type":       "object",
+			"properties": gin.H{"error": gin.H{"type": "string"}},
+		},
+	}
+
+	paths := gin.H{}
+	for _, route := range routes {
+		path, parameters := openAPIPath(route.Path)
+		operation, ok := openAPIOperations[route.Method+" "+path]
+		if !ok {
+			operation.summary = route.Method + " " + path
+		}
+
+		status := operation.status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		success := gin.H{"description": http.StatusText(status)}
+		switch {
+		case status == http.StatusSwitchingProtocols:
+		case operation.contentType != "":
+			success["content"] = gin.H{operation.contentType: gin.H{
+				"schema": gin.H{"type": "string"},
+			}}
+		default:
+			success["content"] = gin.H{"application/json": gin.H{
+				"schema": gin.H{"type": "object"},
+			}}
+		}
+		responses := gin.H{
+			strconv.Itoa(status): success,
+			"default": gin.H{
+				"description": "Error",
+				"content": gin.H{"application/json": gin.H{
+					"schema": gin.H{"$ref": "#/components/schemas/Error"},
+				}},
+			},
+		}
+
+		entry := gin.H{
+			"summary":   operation.summary,
+			"responses": responses,
+		}
+		if len(parameters) > 0 {
+			entry["parameters"] = parameters
+		}
+		if operation.scope != "" {
+			entry["security"] = []gin.H{{"adminSecret": []string{}}}
+			entry["description"] = "Requires the " + operation.scope + " admin scope."
+		}
+		if operation.request != nil {
+			entry["requestBody"] = gin.H{
+				"required": true,
+				"content": gin.H{"application/json": gin.H{
+					"schema": openAPIRequestSchema(reflect.TypeOf(operation.request), schemas),
+				}},
+			}
+		}
+
+		item, _ := paths[path].(gin.H)
+		if item == nil {
+			item = gin.H{}
+			paths[path] = item
+		}
+		item[strings.ToLower(route.Method)] = entry
+	}
+
+	return gin.H{
+		"openapi": "3.0.3",
+		"info": gin.H{
+			"title":   "CyberSwipe Analytics API",
+			"version": "1.0.0",
+		},
+		"paths": paths,
+		"components": gin.H{
+			"schemas": schemas,
+			"securitySchemes": gin.H{
+				"adminSecret": gin.H{
+					"type": "apiKey",
+					"in":   "header",
+					"name": "X-Admin-Secret",
+				},
+			},
+		},
+	}
+}
+
+// openAPIPath converts a gin route path such as /user/:user_id to the
+// OpenAPI form /user/{user_id} and returns its path parameters.
+func openAPIPath(path string) (string, []gin.H) {
+	var parameters []gin.H
+	segments := strings.Split(path, "/")
+	for i, segment := range segments {
+		if !strings.HasPrefix(segment, ":") && !strings.HasPrefix(segment, "*") {
+			continue
+		}
+		name := segment[1:]
+		segments[i] = "{" + name + "}"
+		parameters = append(parameters, gin.H{
+			"name":     name,
+			"in":       "path",
+			"required": true,
+			"schema":   gin.H{"type": "string"},
+		})
+	}
+	return strings.Join(segments, "/"), parameters
+}
+
+// openAPIRequestSchema returns the schema of a request body of type t.
+// Structs are added to schemas under their type name and referenced.
+func openAPIRequestSchema(t reflect.Type, schemas gin.H) gin.H {
+	if t.Kind() == reflect.Slice {
+		return gin.H{"type": "array", "items": openAPIRequestSchema(t.Elem(), schemas)}
+	}
+	if _, ok := schemas[t.Name()]; !ok {
+		schemas[t.Name()] = openAPIStructSchema(t)
+	}
+	return gin.H{"$ref": "#/components/schemas/" + t.Name()}
+}
+
+// openAPIStructSchema derives the schema of a request struct from the json
+// and binding tags of its fields. Fields tagged openapi:"-" are set by the
+// server and left out.
+func openAPIStructSchema(t reflect.Type) gin.H {
+	properties := gin.H{}
+	var required []string
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if !field.IsExported() || name == "-" || field.Tag.Get("openapi") == "-" {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+
+		// Rules after dive apply to the elements of a slice
+		rules, elementRules, _ := strings.Cut(field.Tag.Get("binding"), ",dive")
+		schema := openAPITypeSchema(field.Type)
+		if applyBindingRules(schema, field.Type, rules) {
+			required = append(required, name)
+		}
+		if items, ok := schema["items"].(gin.H); ok && elementRules != "" {
+			applyBindingRules(items, field.Type.Elem(), strings.TrimPrefix(elementRules, ","))
+		}
+		properties[name] = schema
+	}
+
+	schema := gin.H{"type": "object", "properties": properties}
+	if len(required) > 0 {
+		sort.Strings(required)
+		schema["required"] = required
+	}
+	return schema
+}
+
+// openAPITypeSchema returns the schema of a field of type t.
+func openAPITypeSchema(t reflect.Type) gin.H {
+	switch t.Kind() {
+	case reflect.Ptr:
+		schema := openAPITypeSchema(t.Elem())
+		schema["nullable"] = true
+		return schema
+	case reflect.String:
+		return gin.H{"type": "string"}
+	case reflect.Bool:
+		return gin.H{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return gin.H{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return gin.H{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		return gin.H{"type": "array", "items": openAPITypeSchema(t.Elem())}
+	case reflect.Map:
+		return gin.H{"type": "object", "additionalProperties": openAPITypeSchema(t.Elem())}
+	case reflect.Struct:
+		return openAPIStructSchema(t)
+	}
+	return gin.H{}
+}
+
+// applyBindingRules adds the constraints of the comma-separated validator
+// rules to the schema of a value of type t, and reports whether the rules
+// make the value required.
+func applyBindingRules(schema gin.H, t reflect.Type, rules string) bool {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	required := false
+	for _, rule := range strings.Split(rules, ",") {
+		name, param, _ := strings.Cut(rule, "=")
+		switch name {
+		case "required":
+			required = true
+		case "oneof":
+			schema["enum"] = strings.Fields(param)
+		case "min", "max":
+			n, err := strconv.ParseFloat(param, 64)
+			if err != nil {
+				continue
+			}
+			switch t.Kind() {
+			case reflect.String:
+				schema[name+"Length"] = int(n)
+			case reflect.Slice, reflect.Array:
+				schema[name+"Items"] = int(n)
+			case reflect.Map:
+				schema[name+"Properties"] = int(n)
+			default:
+				if name == "min" {
+					schema["minimum"] = n
+				} else {
+					schema["maximum"] = n
+				}
+			}
+		}
+	}
+	return required
+}
@@ -0,0 +1,142 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// fetchOpenAPISpec fetches and parses /openapi.json from router.
+func fetchOpenAPISpec(t *testing.T, router *gin.Engine) map[string]interface{} {
+	t.Helper()
+	response := serve(router, http.MethodGet, "/openapi.json", nil, nil)
+	if response.Code != http.StatusOK {
+		t.Fatalf("status %d, want 200: %s", response.Code, response.Body)
+	}
+	var spec map[string]interface{}
+	if err := json.Unmarshal(response.Body.Bytes(), &spec); err != nil {
+		t.Fatalf("/openapi.json is not JSON: %v", err)
+	}
+	return spec
+}
+
+func TestOpenAPISpecDocumentsEvent(t *testing.T) {
+	h, _ := newTestHandler(t, nil)
+	spec := fetchOpenAPISpec(t, newTestRouter(h))
+	if spec["openapi"] != "3.0.3" {
+		t.Errorf("openapi = %v, want 3.0.3", spec["openapi"])
+	}
+
+	event, ok := spec["paths"].(map[string]interface{})["/api/analytics/event"].(map[string]interface{})["post"].(map[string]interface{})
+	if !ok {
+		t.Fatal("POST /api/analytics/event is not documented")
+	}
+	schema := event["requestBody"].(map[string]interface{})["content"].(map[string]interface{})["application/json"].(map[string]interface{})["schema"].(map[string]interface{})
+	if schema["$ref"] != "#/components/schemas/EventRequest" {
+		t.Errorf("request schema = %v, want a reference to EventRequest", schema)
+	}
+	if _, ok := event["responses"].(map[string]interface{})["201"]; !ok {
+		t.Errorf("responses = %v, want 201", event["responses"])
+	}
+
+	// The schema follows the json and binding tags of the struct
+	eventRequest := spec["components"].(map[string]interface{})["schemas"].(map[string]interface{})["EventRequest"].(map[string]interface{})
+	properties := eventRequest["properties"].(map[string]interface{})
+	if len(properties) != reflect.TypeOf(EventRequest{}).NumField() {
+		t.Errorf("got %d properties, want one per EventRequest field", len(properties))
+	}
+	for name, want := range map[string]string{"session_id": "string", "success": "boolean", "seq": "integer", "duration": "number"} {
+		property, _ := properties[name].(map[string]interface{})
+		if property["type"] != want {
+			t.Errorf("%s = %v, want type %s", name, property, want)
+		}
+	}
+	if sessionID := properties["session_id"].(map[string]interface{}); sessionID["maxLength"] != 255.0 {
+		t.Errorf("session_id maxLength = %v, want 255", sessionID["maxLength"])
+	}
+	if !reflect.DeepEqual(eventRequest["required"], []interface{}{"event_type", "session_id"}) {
+		t.Errorf("required = %v, want event_type and session_id", eventRequest["required"])
+	}
+}
+
+func TestOpenAPISpecCoversEveryRoute(t *testing.T) {
+	h, _ := newTestHandler(t, nil)
+	router := newTestRouter(h)
+	paths := fetchOpenAPISpec(t, router)["paths"].(map[string]interface{})
+
+	// Every route is listed, and described beyond the generic summary
+	for _, route := range router.Routes() {
+		path, _ := openAPIPath(route.Path)
+		item, _ := paths[path].(map[string]interface{})
+		operation, ok := item[strings.ToLower(route.Method)].(map[string]interface{})
+		if !ok {
+			t.Errorf("%s %s is not documented", route.Method, path)
+			continue
+		}
+		if operation["summary"] == route.Method+" "+path {
+			t.Errorf("%s %s has no entry in openAPIOperations", route.Method, path)
+		}
+	}
+}
+
+func TestOpenAPISpecOmitsServerSetFields(t *testing.T) {
+	h, _ := newTestHandler(t, nil)
+	spec := fetchOpenAPISpec(t, newTestRouter(h))
+
+	// The client origin is taken from the connection, not the body
+	session := spec["components"].(map[string]interface{})["schemas"].(map[string]interface{})["SessionRequest"].(map[string]interface{})
+	properties := session["properties"].(map[string]interface{})
+	for _, name := range []string{"ip_address", "user_agent"} {
+		if _, ok := properties[name]; ok {
+			t.Errorf("SessionRequest documents %s", name)
+		}
+	}
+	if _, ok := properties["session_id"]; !ok {
+		t.Error("SessionRequest does not document session_id")
+	}
+}
+
+func TestOpenAPIStructSchema(t *testing.T) {
+	type request struct {
+		Name     string   `json:"name" binding:"required,min=3,max=20"`
+		Mode     string   `json:"mode,omitempty" binding:"omitempty,oneof=fast slow"`
+		Level    *float64 `json:"level,omitempty" binding:"omitempty,min=0,max=1"`
+		IDs      []string `json:"ids" binding:"required,min=1,max=10,dive,max=5"`
+		internal string
+		Skipped  string `json:"-"`
+		Untagged int
+	}
+
+	want := gin.H{
+		"type": "object",
+		"properties": gin.H{
+			"name":     gin.H{"type": "string", "minLength": 3, "maxLength": 20},
+			"mode":     gin.H{"type": "string", "enum": []string{"fast", "slow"}},
+			"level":    gin.H{"type": "number", "nullable": true, "minimum": 0.0, "maximum": 1.0},
+			"ids":      gin.H{"type": "array", "minItems": 1, "maxItems": 10, "items": gin.H{"type": "string", "maxLength": 5}},
+			"Untagged": gin.H{"type": "integer"},
+		},
+		"required": []string{"ids", "name"},
+	}
+	if got := openAPIStructSchema(reflect.TypeOf(request{})); !reflect.DeepEqual(got, want) {
+		t.Errorf("openAPIStructSchema = %v, want %v", got, want)
+	}
+}
+
+func TestOpenAPIPath(t *testing.T) {
+	path, parameters := openAPIPath("/api/analytics/session/:session_id/bundle")
+	if path != "/api/analytics/session/{session_id}/bundle" {
+		t.Errorf("path = %q", path)
+	}
+	if len(parameters) != 1 || parameters[0]["name"] != "session_id" || parameters[0]["in"] != "path" {
+		t.Errorf("parameters = %v, want session_id in the path", parameters)
+	}
+
+	if path, parameters := openAPIPath("/health"); path != "/health" || parameters != nil {
+		t.Errorf("openAPIPath(/health) = %q, %v", path, parameters)
+	}
+}
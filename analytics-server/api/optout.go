@@ -0,0 +1,119 @@
+package api
+
+import (
+	"context"
+	"cyber-swipe-analytics/storage"
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Opt-out modes controlling how ingestion answers requests for opted-out
+// users.
+const (
+	optOutModeDrop   = "drop"
+	optOutModeReject = "reject"
+)
+
+// optOutUser records that a user asked to stop data collection. Further
+// sessions and events for the user are refused by the ingestion handlers.
+// Existing data is kept; use the erasure endpoint to delete it.
+func (h *AnalyticsHandler) optOutUser(c *gin.Context) {
+	userID := c.Param("user_id")
+
+	_, err := h.db.ExecContext(c.Request.Context(), `
+		INSERT INTO opt_outs (user_id) VALUES (?)
+	`+h.db.Upsert([]string{"user_id"}), userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to record opt-out"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"status": "success", "user_id": userID, "opted_out": true})
+}
+
+// optInUser reverses an earlier opt-out so the user's data is collected
+// again.
+func (h *AnalyticsHandler) optInUser(c *gin.Context) {
+	userID := c.Param("user_id")
+
+	_, err := h.db.ExecContext(c.Request.Context(), "DELETE FROM opt_outs WHERE user_id = ?", userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to record opt-in"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"status": "success", "user_id": userID, "opted_out": false})
+}
+
+// userOptedOutQuery reports whether the user ID has opted out of data
+// collection.
+const userOptedOutQuery = "SELECT EXISTS(SELECT 1 FROM opt_outs WHERE user_id = ?)"
+
+// sessionOptedOutQuery reports whether the user owning the session ID has
+// opted out of data collection.
+const sessionOptedOutQuery = `
+	SELECT EXISTS(
+		SELECT 1
+		FROM sessions s
+		JOIN opt_outs o ON o.user_id = s.user_id
+		WHERE s.session_id = ?
+	)
+`
+
+// userOptedOut reports whether userID has opted out of data collection.
+func (h *AnalyticsHandler) userOptedOut(ctx context.Context, userID string) (bool, error) {
+	var optedOut bool
+	err := h.db.QueryRowContext(ctx, userOptedOutQuery, userID).Scan(&optedOut)
+	return optedOut, err
+}
+
+// sessionOptedOut reports whether the user owning sessionID has opted out
+// of data collection.
+func (h *AnalyticsHandler) sessionOptedOut(ctx context.Context, sessionID string) (bool, error) {
+	var optedOut bool
+	err := h.db.QueryRowContext(ctx, sessionOptedOutQuery, sessionID).Scan(&optedOut)
+	return optedOut, err
+}
+
+// refuseOptedOut writes the response for an ingestion request that must
+// not be stored because the user opted out, or for a failed opt-out check.
+// It returns true when the request was answered and must not be processed.
+// Depending on OptOutMode the request is rejected with 403 or silently
+// dropped with 202.
+func (h *AnalyticsHandler) refuseOptedOut(c *gin.Context, optedOut bool, err error) bool {
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check opt-out status"})
+		return true
+	}
+	if !optedOut {
+		return false
+	}
+	if h.cfg.OptOutMode == optOutModeReject {
+		c.JSON(http.StatusForbidden, gin.H{"error": "User has opted out of data collection"})
+	} else {
+		c.JSON(http.StatusAccepted, gin.H{"status": "dropped"})
+	}
+	return true
+}
+
+// eraseUser deletes all analytics stored for a user, e.g. for a GDPR
+// erasure request, and reports the number of rows deleted per table. The
+// user's opt-out record, if any, is kept so that collection stays stopped.
+// Users without data yield 404.
+func (h *AnalyticsHandler) eraseUser(c *gin.Context) {
+	userID := c.Param("user_id")
+
+	deleted, err := h.db.EraseUser(c.Request.Context(), userID)
+	if errors.Is(err, storage.ErrUserNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "No data stored for user"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to erase user data"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"status": "success", "user_id": userID, "deleted": deleted})
+}
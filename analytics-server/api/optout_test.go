@@ -0,0 +1,142 @@
+package api
+
+import (
+	"cyber-swipe-analytics/config"
+	"net/http"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+// expectSessionOptedOut expects the opt-out check of the user owning
+// sessionID and answers that the user opted out.
+func expectSessionOptedOut(mock sqlmock.Sqlmock, sessionID string) {
+	mock.ExpectQuery(`JOIN opt_outs o ON o.user_id = s.user_id`).
+		WithArgs(sessionID).
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+}
+
+var optedOutEvent = map[string]interface{}{"session_id": "s1", "event_type": "button_tap"}
+
+func TestRecordEventDropsOptedOutUser(t *testing.T) {
+	h, mock := newTestHandler(t, map[string]string{"OPT_OUT_MODE": "drop"})
+	expectSessionOptedOut(mock, "s1")
+
+	// No INSERT is expected: the event must not be stored
+	response := serve(newTestRouter(h), http.MethodPost, "/api/analytics/event", optedOutEvent, nil)
+	if response.Code != http.StatusAccepted {
+		t.Fatalf("status %d, want 202: %s", response.Code, response.Body)
+	}
+	if status := decodeBody(t, response)["status"]; status != "dropped" {
+		t.Errorf("status = %v, want dropped", status)
+	}
+}
+
+func TestRecordEventRejectsOptedOutUser(t *testing.T) {
+	h, mock := newTestHandler(t, map[string]string{"OPT_OUT_MODE": "reject"})
+	expectSessionOptedOut(mock, "s1")
+
+	response := serve(newTestRouter(h), http.MethodPost, "/api/analytics/event", optedOutEvent, nil)
+	if response.Code != http.StatusForbidden {
+		t.Fatalf("status %d, want 403: %s", response.Code, response.Body)
+	}
+}
+
+func TestCreateSessionDropsOptedOutUser(t *testing.T) {
+	h, mock := newTestHandler(t, nil)
+	mock.ExpectQuery(`SELECT EXISTS\(SELECT 1 FROM opt_outs WHERE user_id = \?\)`).
+		WithArgs("u1").
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+
+	response := serve(newTestRouter(h), http.MethodPost, "/api/analytics/session",
+		map[string]interface{}{"session_id": "s1", "user_id": "u1", "platform": "ios", "resolution": "1170x2532"}, nil)
+	if response.Code != http.StatusAccepted {
+		t.Fatalf("status %d, want 202: %s", response.Code, response.Body)
+	}
+}
+
+func TestOptOutAndOptIn(t *testing.T) {
+	h, mock := newTestHandler(t, nil)
+	router := newTestRouter(h)
+
+	expectAdminKey(mock, config.ScopeAdmin)
+	mock.ExpectExec(`INSERT INTO opt_outs \(user_id\) VALUES \(\?\)`).
+		WithArgs("u1").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	response := serve(router, http.MethodPost, "/api/analytics/user/u1/opt-out", nil, adminHeader)
+	if response.Code != http.StatusOK || decodeBody(t, response)["opted_out"] != true {
+		t.Fatalf("opt-out: status %d: %s", response.Code, response.Body)
+	}
+
+	expectAdminKey(mock, config.ScopeAdmin)
+	mock.ExpectExec(`DELETE FROM opt_outs WHERE user_id = \?`).
+		WithArgs("u1").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	response = serve(router, http.MethodPost, "/api/analytics/user/u1/opt-in", nil, adminHeader)
+	if response.Code != http.StatusOK || decodeBody(t, response)["opted_out"] != false {
+		t.Fatalf("opt-in: status %d: %s", response.Code, response.Body)
+	}
+}
+
+func TestOptOutRequiresAdminScope(t *testing.T) {
+	h, mock := newTestHandler(t, nil)
+	expectAdminKey(mock, config.ScopeRead)
+
+	response := serve(newTestRouter(h), http.MethodPost, "/api/analytics/user/u1/opt-out", nil, adminHeader)
+	if response.Code != http.StatusForbidden {
+		t.Errorf("status %d, want 403", response.Code)
+	}
+}
+
+func TestEraseUser(t *testing.T) {
+	h, mock := newTestHandler(t, nil)
+	router := newTestRouter(h)
+
+	// The seeded user has rows in every hot table, a pending dead letter
+	// and no archive yet
+	want := map[string]int64{"dead_letters": 1, "events": 12, "performance_metrics": 4, "category_stats": 3, "sessions": 2}
+	expectAdminKey(mock, config.ScopeDelete)
+	mock.ExpectBegin()
+	mock.ExpectQuery(`FROM information_schema.tables`).WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+	mock.ExpectQuery(`FROM dead_letters WHERE`).WillReturnRows(sqlmock.NewRows([]string{"session_id"}))
+	mock.ExpectExec(`DELETE FROM dead_letters WHERE`).WillReturnResult(sqlmock.NewResult(0, want["dead_letters"]))
+	for _, table := range []string{"events", "performance_metrics", "category_stats", "sessions"} {
+		mock.ExpectExec(`DELETE FROM ` + table + `\s+WHERE`).WithArgs("u1").WillReturnResult(sqlmock.NewResult(0, want[table]))
+	}
+	mock.ExpectCommit()
+
+	response := serve(router, http.MethodDelete, "/api/analytics/user/u1", nil, adminHeader)
+	if response.Code != http.StatusOK {
+		t.Fatalf("status %d, want 200: %s", response.Code, response.Body)
+	}
+	deleted := decodeBody(t, response)["deleted"].(map[string]interface{})
+	for table, count := range want {
+		if deleted[table] != float64(count) {
+			t.Errorf("deleted[%s] = %v, want %d", table, deleted[table], count)
+		}
+	}
+
+	// Once erased, the user has no data left
+	expectAdminKey(mock, config.ScopeDelete)
+	mock.ExpectBegin()
+	mock.ExpectQuery(`FROM information_schema.tables`).WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+	mock.ExpectQuery(`FROM dead_letters WHERE`).WillReturnRows(sqlmock.NewRows([]string{"session_id"}))
+	mock.ExpectExec(`DELETE FROM dead_letters WHERE`).WillReturnResult(sqlmock.NewResult(0, 0))
+	for _, table := range []string{"events", "performance_metrics", "category_stats", "sessions"} {
+		mock.ExpectExec(`DELETE FROM ` + table + `\s+WHERE`).WithArgs("u1").WillReturnResult(sqlmock.NewResult(0, 0))
+	}
+	mock.ExpectRollback()
+	if response := serve(router, http.MethodDelete, "/api/analytics/user/u1", nil, adminHeader); response.Code != http.StatusNotFound {
+		t.Errorf("second erasure: status %d, want 404", response.Code)
+	}
+}
+
+func TestEraseUserRequiresDeleteScope(t *testing.T) {
+	h, mock := newTestHandler(t, nil)
+	expectAdminKey(mock, config.ScopeRead)
+
+	response := serve(newTestRouter(h), http.MethodDelete, "/api/analytics/user/u1", nil, adminHeader)
+	if response.Code != http.StatusForbidden {
+		t.Errorf("status %d, want 403", response.Code)
+	}
+}
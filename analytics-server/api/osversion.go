@@ -0,0 +1,32 @@
+package api
+
+import (
+	"regexp"
+	"strconv"
+)
+
+// osVersionPattern matches the first dotted version number in an OS
+// version string, e.g. "15.4.1" in "iOS 15.4.1" or "13" in "Android 13".
+var osVersionPattern = regexp.MustCompile(`(\d+)(?:\.(\d+))?(?:\.(\d+))?`)
+
+// parseOSVersion extracts the major, minor, and patch components from an
+// OS version string. Missing components, and all of them when the string
+// contains no version number, are returned as nil so they are stored as
+// NULL.
+func parseOSVersion(raw string) (major, minor, patch interface{}) {
+	match := osVersionPattern.FindStringSubmatch(raw)
+	if match == nil {
+		return nil, nil, nil
+	}
+	component := func(s string) interface{} {
+		if s == "" {
+			return nil
+		}
+		n, err := strconv.Atoi(s)
+		if err != nil {
+			return nil
+		}
+		return n
+	}
+	return component(match[1]), component(match[2]), component(match[3])
+}
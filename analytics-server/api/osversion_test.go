@@ -0,0 +1,23 @@
+package api
+
+import "testing"
+
+func TestParseOSVersion(t *testing.T) {
+	tests := []struct {
+		raw                 string
+		major, minor, patch interface{}
+	}{
+		{"15.4.1", 15, 4, 1},
+		{"iOS 15.4", 15, 4, nil},
+		{"Android 13", 13, nil, nil},
+		{"HarmonyOS", nil, nil, nil},
+		{"", nil, nil, nil},
+	}
+	for _, tt := range tests {
+		major, minor, patch := parseOSVersion(tt.raw)
+		if major != tt.major || minor != tt.minor || patch != tt.patch {
+			t.Errorf("parseOSVersion(%q) = %v, %v, %v; want %v, %v, %v",
+				tt.raw, major, minor, patch, tt.major, tt.minor, tt.patch)
+		}
+	}
+}
@@ -0,0 +1,268 @@
+package api
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// getLowFPSDevices lists the device models whose average FPS is below a
+// threshold, worst first, with their 5th percentile FPS. The threshold
+// defaults to LowFPSThreshold and can be overridden with the threshold
+// query parameter. Sessions without a device model are grouped as
+// "unknown".
+func (h *AnalyticsHandler) getLowFPSDevices(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	threshold := h.cfg.LowFPSThreshold
+	if value := c.Query("threshold"); value != "" {
+		parsed, err := strconv.ParseFloat(value, 64)
+		if err != nil || parsed <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid threshold: must be a positive number"})
+			return
+		}
+		threshold = parsed
+	}
+
+	rows, err := h.db.QueryContext(ctx, `
+		SELECT
+			COALESCE(NULLIF(TRIM(s.device_model), ''), 'unknown') as device_model,
+			pm.fps
+		FROM performance_metrics pm
+		JOIN sessions s ON s.session_id = pm.session_id
+		WHERE pm.fps IS NOT NULL
+	`)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get FPS by device model"})
+		return
+	}
+	defer rows.Close()
+
+	samples := make(map[string][]float64)
+	for rows.Next() {
+		var model string
+		var fps float64
+		if err := rows.Scan(&model, &fps); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get FPS by device model"})
+			return
+		}
+		samples[model] = append(samples[model], fps)
+	}
+	if err := rows.Err(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get FPS by device model"})
+		return
+	}
+
+	devices := make([]map[string]interface{}, 0)
+	for model, values := range samples {
+		avgFPS := mean(values)
+		if avgFPS >= threshold {
+			continue
+		}
+		devices = append(devices, map[string]interface{}{
+			"device_model": model,
+			"samples":      len(values),
+			"avg_fps":      avgFPS,
+			"p5_fps":       percentile(values, 5),
+		})
+	}
+	sort.Slice(devices, func(i, j int) bool {
+		a, b := devices[i]["avg_fps"].(float64), devices[j]["avg_fps"].(float64)
+		if a != b {
+			return a < b
+		}
+		return devices[i]["device_model"].(string) < devices[j]["device_model"].(string)
+	})
+
+	c.JSON(http.StatusOK, gin.H{
+		"threshold": threshold,
+		"devices":   devices,
+	})
+}
+
+// resolutionPattern matches a screen resolution such as "1920x1080",
+// "1920 X 1080", or "1920*1080".
+var resolutionPattern = regexp.MustCompile(`^\s*(\d+)\s*[xX*×]\s*(\d+)\s*$`)
+
+// parseResolution extracts the width and height of a resolution string.
+// It reports false for strings that are not a valid resolution.
+func parseResolution(raw string) (width, height int, ok bool) {
+	match := resolutionPattern.FindStringSubmatch(raw)
+	if match == nil {
+		return 0, 0, false
+	}
+	width, errW := strconv.Atoi(match[1])
+	height, errH := strconv.Atoi(match[2])
+	if errW != nil || errH != nil || width <= 0 || height <= 0 {
+		return 0, 0, false
+	}
+	return width, height, true
+}
+
+// resolutionPerformance collects the performance samples of one
+// normalized resolution.
+type resolutionPerformance struct {
+	pixels     int
+	fps        []float64
+	peakMemory sql.NullInt64
+}
+
+// getPerformanceByResolution compares the average and 95th percentile FPS
+// and the peak memory usage of each screen resolution, ordered by pixel
+// count, to show what higher resolutions cost. Resolutions are normalized
+// to "WIDTHxHEIGHT"; ones that cannot be parsed are grouped as "unknown"
+// and listed last.
+func (h *AnalyticsHandler) getPerformanceByResolution(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	rows, err := h.db.QueryContext(ctx, `
+		SELECT s.resolution, pm.fps, pm.memory_usage
+		FROM performance_metrics pm
+		JOIN sessions s ON s.session_id = pm.session_id
+	`)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get performance by resolution"})
+		return
+	}
+	defer rows.Close()
+
+	groups := make(map[string]*resolutionPerformance)
+	for rows.Next() {
+		var resolution string
+		var fps sql.NullFloat64
+		var memory sql.NullInt64
+		if err := rows.Scan(&resolution, &fps, &memory); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get performance by resolution"})
+			return
+		}
+
+		name, pixels := "unknown", -1
+		if width, height, ok := parseResolution(resolution); ok {
+			name, pixels = fmt.Sprintf("%dx%d", width, height), width*height
+		}
+		group, ok := groups[name]
+		if !ok {
+			group = &resolutionPerformance{pixels: pixels}
+			groups[name] = group
+		}
+		if fps.Valid {
+			group.fps = append(group.fps, fps.Float64)
+		}
+		if memory.Valid && (!group.peakMemory.Valid || memory.Int64 > group.peakMemory.Int64) {
+			group.peakMemory = memory
+		}
+	}
+	if err := rows.Err(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get performance by resolution"})
+		return
+	}
+
+	names := make([]string, 0, len(groups))
+	for name := range groups {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		a, b := groups[names[i]], groups[names[j]]
+		if (a.pixels < 0) != (b.pixels < 0) {
+			return b.pixels < 0
+		}
+		if a.pixels != b.pixels {
+			return a.pixels < b.pixels
+		}
+		return names[i] < names[j]
+	})
+
+	resolutions := make([]gin.H, 0, len(names))
+	for _, name := range names {
+		group := groups[name]
+		entry := gin.H{
+			"resolution":  name,
+			"samples":     len(group.fps),
+			"avg_fps":     nil,
+			"p95_fps":     nil,
+			"peak_memory": nil,
+		}
+		if len(group.fps) > 0 {
+			entry["avg_fps"] = mean(group.fps)
+			entry["p95_fps"] = percentile(group.fps, 95)
+		}
+		if group.peakMemory.Valid {
+			entry["peak_memory"] = group.peakMemory.Int64
+		}
+		if group.pixels >= 0 {
+			entry["pixels"] = group.pixels
+		}
+		resolutions = append(resolutions, entry)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"resolutions": resolutions})
+}
+
+// thermalStates lists the thermal states from coolest to hottest.
+var thermalStates = []string{"nominal", "fair", "serious", "critical"}
+
+// getFPSByThermalState relates the device thermal state to FPS, to show
+// how much throttling costs. For each thermal state it reports the number
+// of samples, the average and 5th percentile FPS, and the average battery
+// level of the samples that carry one. Samples without a thermal state are
+// grouped as "unknown" and listed last.
+func (h *AnalyticsHandler) getFPSByThermalState(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	rows, err := h.db.QueryContext(ctx, `
+		SELECT COALESCE(thermal_state, 'unknown'), fps, battery_level
+		FROM performance_metrics
+		WHERE fps IS NOT NULL
+	`)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get FPS by thermal state"})
+		return
+	}
+	defer rows.Close()
+
+	fps := make(map[string][]float64)
+	battery := make(map[string][]float64)
+	for rows.Next() {
+		var state string
+		var value float64
+		var batteryLevel sql.NullFloat64
+		if err := rows.Scan(&state, &value, &batteryLevel); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get FPS by thermal state"})
+			return
+		}
+		fps[state] = append(fps[state], value)
+		if batteryLevel.Valid {
+			battery[state] = append(battery[state], batteryLevel.Float64)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get FPS by thermal state"})
+		return
+	}
+
+	states := make([]gin.H, 0, len(fps))
+	for _, state := range append(thermalStates, "unknown") {
+		values, ok := fps[state]
+		if !ok {
+			continue
+		}
+		entry := gin.H{
+			"thermal_state":     state,
+			"samples":           len(values),
+			"avg_fps":           mean(values),
+			"p5_fps":            percentile(values, 5),
+			"avg_battery_level": nil,
+		}
+		if len(battery[state]) > 0 {
+			entry["avg_battery_level"] = mean(battery[state])
+		}
+		states = append(states, entry)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"thermal_states": states})
+}
@@ -0,0 +1,270 @@
+package api
+
+import (
+	"cyber-swipe-analytics/config"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestGetLowFPSDevices(t *testing.T) {
+	h, mock := newTestHandler(t, map[string]string{"LOW_FPS_THRESHOLD": "30"})
+	expectAdminKey(mock, config.ScopeRead)
+
+	// Pixel 4 runs above the threshold and is left out; the rest come
+	// worst first, with blank models grouped as unknown by the query
+	rows := sqlmock.NewRows([]string{"device_model", "fps"}).
+		AddRow("Pixel 4", 58.0).
+		AddRow("Pixel 4", 60.0).
+		AddRow("Galaxy A10", 20.0).
+		AddRow("Galaxy A10", 24.0).
+		AddRow("unknown", 10.0).
+		AddRow("unknown", 14.0)
+	mock.ExpectQuery(`COALESCE\(NULLIF\(TRIM\(s.device_model\), ''\), 'unknown'\)`).WillReturnRows(rows)
+
+	response := serve(newTestRouter(h), http.MethodGet, "/api/analytics/performance/low-fps-devices", nil, adminHeader)
+	if response.Code != http.StatusOK {
+		t.Fatalf("status %d, want 200: %s", response.Code, response.Body)
+	}
+	body := decodeBody(t, response)
+	if body["threshold"] != 30.0 {
+		t.Errorf("threshold = %v, want 30", body["threshold"])
+	}
+	devices := body["devices"].([]interface{})
+	want := []struct {
+		model  string
+		avgFPS float64
+	}{
+		{"unknown", 12},
+		{"Galaxy A10", 22},
+	}
+	if len(devices) != len(want) {
+		t.Fatalf("got %d devices, want %d: %v", len(devices), len(want), devices)
+	}
+	for i, device := range want {
+		got := devices[i].(map[string]interface{})
+		if got["device_model"] != device.model || got["avg_fps"] != device.avgFPS || got["samples"] != 2.0 {
+			t.Errorf("device %d = %v, want %s averaging %v", i, got, device.model, device.avgFPS)
+		}
+	}
+}
+
+func TestGetLowFPSDevicesThresholdOverride(t *testing.T) {
+	h, mock := newTestHandler(t, nil)
+	expectAdminKey(mock, config.ScopeRead)
+
+	// Raising the threshold brings Pixel 4 in
+	rows := sqlmock.NewRows([]string{"device_model", "fps"}).
+		AddRow("Pixel 4", 58.0).
+		AddRow("Galaxy A10", 20.0)
+	mock.ExpectQuery(`FROM performance_metrics pm\s+JOIN sessions s`).WillReturnRows(rows)
+
+	response := serve(newTestRouter(h), http.MethodGet, "/api/analytics/performance/low-fps-devices?threshold=59", nil, adminHeader)
+	if response.Code != http.StatusOK {
+		t.Fatalf("status %d, want 200: %s", response.Code, response.Body)
+	}
+	if devices := decodeBody(t, response)["devices"].([]interface{}); len(devices) != 2 {
+		t.Errorf("got %d devices, want 2: %v", len(devices), devices)
+	}
+}
+
+func TestGetLowFPSDevicesRejectsInvalidThreshold(t *testing.T) {
+	h, mock := newTestHandler(t, nil)
+	expectAdminKey(mock, config.ScopeRead)
+
+	response := serve(newTestRouter(h), http.MethodGet, "/api/analytics/performance/low-fps-devices?threshold=-1", nil, adminHeader)
+	if response.Code != http.StatusBadRequest {
+		t.Errorf("status %d, want 400", response.Code)
+	}
+}
+
+func TestParseResolution(t *testing.T) {
+	tests := []struct {
+		raw           string
+		width, height int
+		ok            bool
+	}{
+		{"1920x1080", 1920, 1080, true},
+		{" 1170 X 2532 ", 1170, 2532, true},
+		{"800*600", 800, 600, true},
+		{"1920×1080", 1920, 1080, true},
+		{"0x600", 0, 0, false},
+		{"full hd", 0, 0, false},
+		{"", 0, 0, false},
+	}
+	for _, tt := range tests {
+		width, height, ok := parseResolution(tt.raw)
+		if width != tt.width || height != tt.height || ok != tt.ok {
+			t.Errorf("parseResolution(%q) = %d, %d, %v; want %d, %d, %v", tt.raw, width, height, ok, tt.width, tt.height, tt.ok)
+		}
+	}
+}
+
+func TestGetPerformanceByResolution(t *testing.T) {
+	h, mock := newTestHandler(t, nil)
+	expectAdminKey(mock, config.ScopeRead)
+
+	// The two spellings of 1080p are merged, 4K runs slower and the
+	// unparseable resolution is listed last
+	rows := sqlmock.NewRows([]string{"resolution", "fps", "memory_usage"}).
+		AddRow("3840x2160", 30.0, 900).
+		AddRow("1920x1080", 60.0, 400).
+		AddRow("1920 X 1080", 50.0, 500).
+		AddRow("weird", 45.0, nil).
+		AddRow("3840x2160", nil, 1200)
+	mock.ExpectQuery(`SELECT s.resolution, pm.fps, pm.memory_usage\s+FROM performance_metrics pm`).WillReturnRows(rows)
+
+	response := serve(newTestRouter(h), http.MethodGet, "/api/analytics/performance/by-resolution", nil, adminHeader)
+	if response.Code != http.StatusOK {
+		t.Fatalf("status %d, want 200: %s", response.Code, response.Body)
+	}
+	resolutions := decodeBody(t, response)["resolutions"].([]interface{})
+	want := []struct {
+		resolution string
+		samples    float64
+		avgFPS     float64
+		peakMemory interface{}
+	}{
+		{"1920x1080", 2, 55, 500.0},
+		{"3840x2160", 1, 30, 1200.0},
+		{"unknown", 1, 45, nil},
+	}
+	if len(resolutions) != len(want) {
+		t.Fatalf("got %d resolutions, want %d: %v", len(resolutions), len(want), resolutions)
+	}
+	for i, resolution := range want {
+		got := resolutions[i].(map[string]interface{})
+		if got["resolution"] != resolution.resolution || got["samples"] != resolution.samples ||
+			got["avg_fps"] != resolution.avgFPS || got["peak_memory"] != resolution.peakMemory {
+			t.Errorf("resolution %d = %v, want %+v", i, got, resolution)
+		}
+	}
+	if _, ok := resolutions[2].(map[string]interface{})["pixels"]; ok {
+		t.Error("unknown resolution has a pixel count")
+	}
+}
+
+func TestRecordPerformanceMetricsBatteryAndThermalState(t *testing.T) {
+	h, mock := newTestHandler(t, nil)
+	router := newTestRouter(h)
+
+	// The new fields are stored when present and NULL when missing
+	expectSessionNotOptedOut(mock, "s1")
+	mock.ExpectExec(`INSERT INTO performance_metrics \(\s+session_id, fps, memory_usage, cpu_usage, gpu_usage, network_latency,\s+battery_level, thermal_state, sample_rate`).
+		WithArgs("s1", 42.0, 512.0, 0.0, 0.0, 0.0, 0.15, "serious", 1.0).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	expectSessionNotOptedOut(mock, "s1")
+	mock.ExpectExec(`INSERT INTO performance_metrics`).
+		WithArgs("s1", 60.0, 256.0, 0.0, 0.0, 0.0, nil, nil, 1.0).
+		WillReturnResult(sqlmock.NewResult(2, 1))
+
+	for _, body := range []map[string]interface{}{
+		{"session_id": "s1", "fps": 42, "memory_usage": 512, "battery_level": 0.15, "thermal_state": "serious"},
+		{"session_id": "s1", "fps": 60, "memory_usage": 256},
+	} {
+		if response := serve(router, http.MethodPost, "/api/analytics/performance", body, nil); response.Code != http.StatusCreated {
+			t.Fatalf("%v: status %d, want 201: %s", body, response.Code, response.Body)
+		}
+	}
+
+	// Out-of-range values are refused
+	for _, body := range []map[string]interface{}{
+		{"session_id": "s1", "memory_usage": 256, "battery_level": 1.5},
+		{"session_id": "s1", "memory_usage": 256, "thermal_state": "hot"},
+	} {
+		if response := serve(router, http.MethodPost, "/api/analytics/performance", body, nil); response.Code != http.StatusBadRequest {
+			t.Errorf("%v: status %d, want 400", body, response.Code)
+		}
+	}
+
+	// The samples read back per thermal state, coolest first
+	expectAdminKey(mock, config.ScopeRead)
+	mock.ExpectQuery(`SELECT COALESCE\(thermal_state, 'unknown'\), fps, battery_level\s+FROM performance_metrics`).
+		WillReturnRows(sqlmock.NewRows([]string{"thermal_state", "fps", "battery_level"}).
+			AddRow("unknown", 60.0, nil).
+			AddRow("serious", 42.0, 0.15).
+			AddRow("nominal", 58.0, 0.9))
+	response := serve(router, http.MethodGet, "/api/analytics/performance/by-thermal-state", nil, adminHeader)
+	if response.Code != http.StatusOK {
+		t.Fatalf("status %d, want 200: %s", response.Code, response.Body)
+	}
+	states := decodeBody(t, response)["thermal_states"].([]interface{})
+	want := []struct {
+		state   string
+		fps     float64
+		battery interface{}
+	}{
+		{"nominal", 58, 0.9},
+		{"serious", 42, 0.15},
+		{"unknown", 60, nil},
+	}
+	if len(states) != len(want) {
+		t.Fatalf("got %d thermal states, want %d", len(states), len(want))
+	}
+	for i, state := range want {
+		got := states[i].(map[string]interface{})
+		if got["thermal_state"] != state.state || got["avg_fps"] != state.fps || got["avg_battery_level"] != state.battery {
+			t.Errorf("thermal state %d = %v, want %+v", i, got, state)
+		}
+	}
+}
+
+func TestRecordPerformanceMetricsSampling(t *testing.T) {
+	h, _ := newTestHandler(t, map[string]string{"PERFORMANCE_SAMPLE_RATE": "0.25"})
+	router := newTestRouter(h)
+	const samples = 4000
+
+	// Only the sampling decision is under test: kept samples go on to the
+	// opt-out check, which fails as no queries are expected here. Each
+	// sample comes from its own session to stay clear of the rate limit.
+	kept, dropped := 0, 0
+	for i := 0; i < samples; i++ {
+		body := map[string]interface{}{"session_id": fmt.Sprintf("s%d", i), "fps": 60, "memory_usage": 256}
+		response := serve(router, http.MethodPost, "/api/analytics/performance", body, nil)
+		switch response.Code {
+		case http.StatusAccepted:
+			if status := decodeBody(t, response)["status"]; status != "sampled_out" {
+				t.Fatalf("status = %v, want sampled_out", status)
+			}
+			dropped++
+		case http.StatusInternalServerError:
+			kept++
+		default:
+			t.Fatalf("status %d: %s", response.Code, response.Body)
+		}
+	}
+
+	// About a quarter is kept; the bounds are over five standard
+	// deviations away
+	if kept < 850 || kept > 1150 {
+		t.Errorf("kept %d of %d samples, want about %d", kept, samples, samples/4)
+	}
+	if got := h.performanceSampledOut.Load(); got != int64(dropped) {
+		t.Errorf("sampled out counter = %d, want %d", got, dropped)
+	}
+}
+
+func TestRecordPerformanceMetricsStoresSampleRate(t *testing.T) {
+	h, mock := newTestHandler(t, map[string]string{"PERFORMANCE_SAMPLE_RATE": "0.5"})
+	router := newTestRouter(h)
+
+	// The kept sample records the rate it was sampled at, so aggregates
+	// can weight it as two samples
+	expectSessionNotOptedOut(mock, "s1")
+	mock.ExpectExec(`INSERT INTO performance_metrics`).
+		WithArgs("s1", 60.0, 256.0, 0.0, 0.0, 0.0, nil, nil, 0.5).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+
+	body := map[string]interface{}{"session_id": "s1", "fps": 60, "memory_usage": 256}
+	for i := 0; ; i++ {
+		response := serve(router, http.MethodPost, "/api/analytics/performance", body, nil)
+		if response.Code == http.StatusCreated {
+			break
+		}
+		if response.Code != http.StatusAccepted || i == 63 {
+			t.Fatalf("attempt %d: status %d: %s", i, response.Code, response.Body)
+		}
+	}
+}
@@ -0,0 +1,106 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// prometheusContentType is the content type of the Prometheus text
+// exposition format.
+const prometheusContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// prometheusWriter renders gauges in the Prometheus text exposition format.
+type prometheusWriter struct {
+	builder strings.Builder
+	// described tracks the metrics whose HELP and TYPE lines were written.
+	described map[string]bool
+}
+
+func newPrometheusWriter() *prometheusWriter {
+	return &prometheusWriter{described: make(map[string]bool)}
+}
+
+// gauge writes one sample of the named gauge. labels are name/value pairs.
+func (w *prometheusWriter) gauge(name, help string, value float64, labels ...string) {
+	if !w.described[name] {
+		w.described[name] = true
+		fmt.Fprintf(&w.builder, "# HELP %s %s\n", name, help)
+		fmt.Fprintf(&w.builder, "# TYPE %s gauge\n", name)
+	}
+
+	w.builder.WriteString(name)
+	if len(labels) > 0 {
+		w.builder.WriteByte('{')
+		for i := 0; i+1 < len(labels); i += 2 {
+			if i > 0 {
+				w.builder.WriteByte(',')
+			}
+			fmt.Fprintf(&w.builder, "%s=\"%s\"", labels[i], escapeLabelValue(labels[i+1]))
+		}
+		w.builder.WriteByte('}')
+	}
+	w.builder.WriteByte(' ')
+	w.builder.WriteString(strconv.FormatFloat(value, 'g', -1, 64))
+	w.builder.WriteByte('\n')
+}
+
+func (w *prometheusWriter) String() string {
+	return w.builder.String()
+}
+
+// escapeLabelValue escapes a label value as required by the exposition
+// format.
+func escapeLabelValue(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+}
+
+// getPrometheusMetrics exposes the key aggregated game metrics as
+// Prometheus gauges, so monitoring can scrape gameplay health alongside
+// server metrics. The values come from getAggregatedStatistics.
+func (h *AnalyticsHandler) getPrometheusMetrics(c *gin.Context) {
+	stats, err := h.getAggregatedStatistics(c.Request.Context(), statsFilter{})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to calculate aggregated statistics"})
+		return
+	}
+
+	sessions := stats["sessions"].(gin.H)
+	events := stats["events"].(gin.H)
+	performance := stats["performance"].(gin.H)
+	platforms := stats["platforms"].([]map[string]interface{})
+
+	w := newPrometheusWriter()
+	w.gauge("cyberswipe_sessions_total", "Number of recorded sessions.",
+		float64(sessions["total_sessions"].(int)))
+	w.gauge("cyberswipe_events_total", "Number of recorded events.",
+		float64(events["total_events"].(int)))
+	w.gauge("cyberswipe_swipes_total", "Number of recorded card swipes.",
+		float64(events["total_swipes"].(int)))
+	w.gauge("cyberswipe_swipe_success_ratio", "Fraction of card swipes that were successful.",
+		events["swipe_success_rate"].(float64)/100)
+	w.gauge("cyberswipe_avg_fps", "Average frames per second across performance samples.",
+		performance["avg_fps"].(float64))
+	w.gauge("cyberswipe_avg_memory_usage", "Average memory usage across performance samples.",
+		performance["avg_memory_usage"].(float64))
+	w.gauge("cyberswipe_performance_samples_sampled_out", "Performance samples dropped by PERFORMANCE_SAMPLE_RATE since startup.",
+		float64(h.performanceSampledOut.Load()))
+	rates := h.ingestion.rates(time.Now())
+	for _, window := range ingestionWindows {
+		w.gauge("cyberswipe_ingested_events_per_second", "Events inserted per second over a recent window.",
+			rates[window.name], "window", window.name)
+	}
+	for _, platform := range platforms {
+		name := platform["platform"].(string)
+		w.gauge("cyberswipe_platform_sessions", "Number of sessions per platform.",
+			float64(platform["total_sessions"].(int)), "platform", name)
+		w.gauge("cyberswipe_platform_unique_users", "Number of distinct users per platform.",
+			float64(platform["unique_users"].(int)), "platform", name)
+	}
+
+	c.Data(http.StatusOK, prometheusContentType, []byte(w.String()))
+}
@@ -0,0 +1,42 @@
+package api
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/prometheus/common/expfmt"
+)
+
+func TestPrometheusWriterOutputParses(t *testing.T) {
+	w := newPrometheusWriter()
+	w.gauge("cyberswipe_sessions_total", "Number of recorded sessions.", 12)
+	w.gauge("cyberswipe_swipe_success_ratio", "Fraction of card swipes that were successful.", 0.75)
+	w.gauge("cyberswipe_platform_sessions", "Number of sessions per platform.", 8, "platform", "ios")
+	w.gauge("cyberswipe_platform_sessions", "Number of sessions per platform.", 4, "platform", `And"roid\`+"\n")
+
+	var parser expfmt.TextParser
+	families, err := parser.TextToMetricFamilies(strings.NewReader(w.String()))
+	if err != nil {
+		t.Fatalf("invalid exposition output: %v\n%s", err, w)
+	}
+
+	if got := families["cyberswipe_sessions_total"].GetMetric()[0].GetGauge().GetValue(); got != 12 {
+		t.Errorf("cyberswipe_sessions_total = %v, want 12", got)
+	}
+	if got := families["cyberswipe_swipe_success_ratio"].GetMetric()[0].GetGauge().GetValue(); got != 0.75 {
+		t.Errorf("cyberswipe_swipe_success_ratio = %v, want 0.75", got)
+	}
+
+	// Both platforms share one HELP/TYPE header and keep their labels,
+	// escaped characters included
+	platforms := families["cyberswipe_platform_sessions"]
+	if strings.Count(w.String(), "# TYPE cyberswipe_platform_sessions gauge") != 1 {
+		t.Errorf("TYPE line repeated:\n%s", w)
+	}
+	if len(platforms.GetMetric()) != 2 {
+		t.Fatalf("got %d platform samples, want 2", len(platforms.GetMetric()))
+	}
+	if label := platforms.GetMetric()[1].GetLabel()[0]; label.GetName() != "platform" || label.GetValue() != `And"roid\`+"\n" {
+		t.Errorf("label = %s=%q", label.GetName(), label.GetValue())
+	}
+}
@@ -0,0 +1,42 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+const (
+	maxEventProperties    = 20
+	maxPropertyValueLen   = 255
+	categoryPropertyKey   = "category"
+	difficultyPropertyKey = "difficulty"
+)
+
+// validateProperties checks the property count and the length and format
+// of every property key and value. Keys follow the rules of session tag
+// keys.
+func validateProperties(properties map[string]string) error {
+	if len(properties) > maxEventProperties {
+		return fmt.Errorf("too many properties: at most %d are allowed", maxEventProperties)
+	}
+	for key, value := range properties {
+		if !validTagKey(key) {
+			return fmt.Errorf("invalid property key %q: use up to %d letters, digits, '_', '.' or '-'", key, maxTagKeyLen)
+		}
+		if len(value) > maxPropertyValueLen {
+			return fmt.Errorf("property %q value exceeds %d characters", key, maxPropertyValueLen)
+		}
+	}
+	return nil
+}
+
+// encodeProperties converts properties into a value for the JSON
+// properties column, storing NULL when the event has none.
+func encodeProperties(properties map[string]string) interface{} {
+	if len(properties) == 0 {
+		return nil
+	}
+	// Encoding a map of strings can't fail
+	encoded, _ := json.Marshal(properties)
+	return string(encoded)
+}
@@ -0,0 +1,126 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+)
+
+// PropertyKeyAction values: keys beyond MaxPropertyKeys are either stored
+// with a warning or refused.
+const (
+	propertyKeyActionWarn   = "warn"
+	propertyKeyActionReject = "reject"
+)
+
+// errTooManyPropertyKeys is returned by trackPropertyKeys when new keys
+// would exceed MaxPropertyKeys and PropertyKeyAction is reject.
+var errTooManyPropertyKeys = errors.New("too many distinct property keys")
+
+// propertyKeyCache holds the registered property keys, so that events
+// reusing known keys don't query the property_keys table.
+type propertyKeyCache struct {
+	mu   sync.Mutex
+	keys map[string]bool
+}
+
+// unknown returns the property keys of events missing from the cache,
+// sorted and without repeats.
+func (cache *propertyKeyCache) unknown(events []EventRequest) []string {
+	seen := make(map[string]bool)
+	var keys []string
+	for _, event := range events {
+		for key := range event.Properties {
+			if !cache.keys[key] && !seen[key] {
+				seen[key] = true
+				keys = append(keys, key)
+			}
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// trackPropertyKeys registers the property keys of events that are not
+// registered yet, keeping the number of distinct keys in check: when new
+// keys take it above MaxPropertyKeys a warning is logged, and with
+// PropertyKeyAction reject errTooManyPropertyKeys is returned and nothing
+// is registered.
+func (h *AnalyticsHandler) trackPropertyKeys(ctx context.Context, events ...EventRequest) error {
+	cache := &h.propertyKeys
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	if len(cache.unknown(events)) == 0 {
+		return nil
+	}
+
+	// Other instances may have registered keys since the last load
+	stored, err := h.db.PropertyKeys(ctx)
+	if err != nil {
+		return err
+	}
+	cache.keys = make(map[string]bool, len(stored))
+	for _, key := range stored {
+		cache.keys[key.Key] = true
+	}
+	unknown := cache.unknown(events)
+	if len(unknown) == 0 {
+		return nil
+	}
+
+	if total := len(cache.keys) + len(unknown); total > h.cfg.MaxPropertyKeys {
+		log.Printf("Warning: %d distinct property keys exceed MAX_PROPERTY_KEYS=%d, new keys: %s",
+			total, h.cfg.MaxPropertyKeys, strings.Join(unknown, ", "))
+		if h.cfg.PropertyKeyAction == propertyKeyActionReject {
+			return errTooManyPropertyKeys
+		}
+	}
+
+	if err := h.db.AddPropertyKeys(ctx, unknown); err != nil {
+		return err
+	}
+	for _, key := range unknown {
+		cache.keys[key] = true
+	}
+	return nil
+}
+
+// refusePropertyKeys writes the response for events whose property keys
+// could not be tracked and returns true, or returns false when err is nil.
+func (h *AnalyticsHandler) refusePropertyKeys(c *gin.Context, err error) bool {
+	if err == nil {
+		return false
+	}
+	if errors.Is(err, errTooManyPropertyKeys) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf(
+			"too many distinct property keys: at most %d are allowed", h.cfg.MaxPropertyKeys)})
+		return true
+	}
+	c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to register property keys"})
+	return true
+}
+
+// getPropertyKeys lists the registered custom event property keys with
+// the time each was first stored, along with the configured limit.
+func (h *AnalyticsHandler) getPropertyKeys(c *gin.Context) {
+	keys, err := h.db.PropertyKeys(c.Request.Context())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list property keys"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"keys":   keys,
+		"count":  len(keys),
+		"limit":  h.cfg.MaxPropertyKeys,
+		"action": h.cfg.PropertyKeyAction,
+	})
+}
@@ -0,0 +1,129 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"cyber-swipe-analytics/config"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+// expectPropertyKeys expects the lookup of the registered property keys,
+// answered with stored, and the registration of added, if any.
+func expectPropertyKeys(mock sqlmock.Sqlmock, stored []string, added ...string) {
+	rows := sqlmock.NewRows([]string{"key_name", "created_at"})
+	for _, key := range stored {
+		rows.AddRow(key, time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC))
+	}
+	mock.ExpectQuery(`SELECT key_name, created_at FROM property_keys ORDER BY key_name`).WillReturnRows(rows)
+	if len(added) == 0 {
+		return
+	}
+	mock.ExpectBegin()
+	for _, key := range added {
+		mock.ExpectExec(`INSERT INTO property_keys \(key_name\) VALUES \(\?\) ON DUPLICATE KEY UPDATE key_name = key_name`).
+			WithArgs(key).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+	}
+	mock.ExpectCommit()
+}
+
+func TestTrackPropertyKeysCachesKnownKeys(t *testing.T) {
+	h, mock := newTestHandler(t, nil)
+	ctx := context.Background()
+	event := EventRequest{Properties: map[string]string{"category": "phishing", "difficulty": "hard"}}
+
+	// Only the first event looks the keys up; category was stored by
+	// another instance already
+	expectPropertyKeys(mock, []string{"category"}, "difficulty")
+	if err := h.trackPropertyKeys(ctx, event); err != nil {
+		t.Fatalf("first event: %v", err)
+	}
+	if err := h.trackPropertyKeys(ctx, event, EventRequest{}); err != nil {
+		t.Fatalf("second event: %v", err)
+	}
+}
+
+func TestTrackPropertyKeysWarnsAboveLimit(t *testing.T) {
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+
+	// In warn mode the third key is stored all the same
+	h, mock := newTestHandler(t, map[string]string{"MAX_PROPERTY_KEYS": "2"})
+	expectPropertyKeys(mock, []string{"category", "difficulty"}, "screen")
+	event := EventRequest{Properties: map[string]string{"category": "phishing", "screen": "settings"}}
+	if err := h.trackPropertyKeys(context.Background(), event); err != nil {
+		t.Fatalf("trackPropertyKeys: %v", err)
+	}
+	if !strings.Contains(buf.String(), "3 distinct property keys exceed MAX_PROPERTY_KEYS=2, new keys: screen") {
+		t.Errorf("log = %q, want a warning about the screen key", buf.String())
+	}
+}
+
+func TestRecordEventRejectsPropertyKeysAboveLimit(t *testing.T) {
+	h, mock := newTestHandler(t, map[string]string{"MAX_PROPERTY_KEYS": "2", "PROPERTY_KEY_ACTION": "reject"})
+	router := newTestRouter(h)
+
+	// Neither the keys nor the event are stored
+	expectSessionNotOptedOut(mock, "s1")
+	expectPropertyKeys(mock, []string{"category", "difficulty"})
+	body := map[string]interface{}{
+		"session_id": "s1",
+		"event_type": "button_tap",
+		"properties": map[string]interface{}{"screen": "settings"},
+	}
+	response := serve(router, http.MethodPost, "/api/analytics/event", body, nil)
+	if response.Code != http.StatusBadRequest {
+		t.Fatalf("status %d, want 400: %s", response.Code, response.Body)
+	}
+	if message := decodeBody(t, response)["error"]; message != "too many distinct property keys: at most 2 are allowed" {
+		t.Errorf("error = %v", message)
+	}
+
+	// A batch introducing the key is refused as a whole
+	expectSessionNotOptedOut(mock, "s1")
+	expectPropertyKeys(mock, []string{"category", "difficulty"})
+	known := map[string]interface{}{"session_id": "s1", "event_type": "button_tap", "properties": map[string]interface{}{"category": "malware"}}
+	response = serve(router, http.MethodPost, "/api/analytics/events/batch", []interface{}{known, body}, nil)
+	if response.Code != http.StatusBadRequest {
+		t.Errorf("batch: status %d, want 400: %s", response.Code, response.Body)
+	}
+}
+
+func TestTrackPropertyKeysFailure(t *testing.T) {
+	h, mock := newTestHandler(t, nil)
+	mock.ExpectQuery(`FROM property_keys`).WillReturnError(deadlockError)
+
+	event := EventRequest{Properties: map[string]string{"category": "phishing"}}
+	if err := h.trackPropertyKeys(context.Background(), event); !errors.Is(err, deadlockError) {
+		t.Errorf("trackPropertyKeys = %v, want the lookup error", err)
+	}
+}
+
+func TestGetPropertyKeys(t *testing.T) {
+	h, mock := newTestHandler(t, map[string]string{"MAX_PROPERTY_KEYS": "50"})
+	expectAdminKey(mock, config.ScopeAdmin)
+	expectPropertyKeys(mock, []string{"category", "difficulty"})
+
+	response := serve(newTestRouter(h), http.MethodGet, "/api/analytics/admin/property-keys", nil, adminHeader)
+	if response.Code != http.StatusOK {
+		t.Fatalf("status %d, want 200: %s", response.Code, response.Body)
+	}
+	body := decodeBody(t, response)
+	if body["count"] != 2.0 || body["limit"] != 50.0 || body["action"] != "warn" {
+		t.Errorf("body = %v, want 2 keys, limit 50, action warn", body)
+	}
+	keys := body["keys"].([]interface{})
+	first := keys[0].(map[string]interface{})
+	if first["key"] != "category" || first["first_seen"] != "2024-05-01T12:00:00Z" {
+		t.Errorf("keys = %v, want category first", keys)
+	}
+}
@@ -0,0 +1,96 @@
+package api
+
+import (
+	"context"
+	"log"
+	"math"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	defaultPurgeBatchSize = 100
+	maxPurgeBatchSize     = 1000
+	defaultPurgeBatches   = 50
+)
+
+// purgeExpiredData deletes sessions older than DataRetentionDays, with
+// their events, performance metrics, and category stats. days overrides
+// the retention window. Each call deletes at most max_batches batches of
+// batch_size sessions; callers repeat the request until done is true.
+func (h *AnalyticsHandler) purgeExpiredData(c *gin.Context) {
+	days, err := queryInt(c, "days", h.cfg.DataRetentionDays, 0, math.MaxInt32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if days <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "no retention window: set DATA_RETENTION_DAYS or pass days"})
+		return
+	}
+
+	batchSize, err := queryInt(c, "batch_size", defaultPurgeBatchSize, 1, maxPurgeBatchSize)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	maxBatches, err := queryInt(c, "max_batches", defaultPurgeBatches, 1, math.MaxInt32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	result, err := h.db.PurgeSessions(c.Request.Context(), time.Now().AddDate(0, 0, -days), batchSize, maxBatches)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to purge expired data"})
+		return
+	}
+
+	c.JSON(http.StatusOK, result)
+}
+
+// StartPurgeWorker starts a background worker that deletes sessions older
+// than DataRetentionDays every PurgeInterval. It does nothing when
+// DataRetentionDays is zero. The returned function stops the worker and
+// waits for the current run to finish.
+func (h *AnalyticsHandler) StartPurgeWorker() (stop func()) {
+	if h.cfg.DataRetentionDays <= 0 {
+		return func() {}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		ticker := time.NewTicker(h.cfg.PurgeInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				before := time.Now().AddDate(0, 0, -h.cfg.DataRetentionDays)
+				result, err := h.db.PurgeSessions(ctx, before, defaultPurgeBatchSize, defaultPurgeBatches)
+				if err != nil {
+					if ctx.Err() == nil {
+						log.Printf("Purge failed: %v", err)
+					}
+					continue
+				}
+				if result.Batches > 0 {
+					log.Printf("Purged %d sessions created before %s", result.Deleted["sessions"], before.Format(time.RFC3339))
+				}
+			}
+		}
+	}()
+
+	return func() {
+		cancel()
+		wg.Wait()
+	}
+}
@@ -0,0 +1,56 @@
+package api
+
+import (
+	"cyber-swipe-analytics/config"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestPurgeExpiredData(t *testing.T) {
+	h, mock := newTestHandler(t, map[string]string{"DATA_RETENTION_DAYS": "90"})
+	expectAdminKey(mock, config.ScopeAdmin)
+
+	// Sessions created before the 90-day window are selected; the one old
+	// session is deleted with its rows
+	mock.ExpectQuery(`FROM information_schema.tables`).WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+	mock.ExpectBegin()
+	mock.ExpectQuery(`SELECT session_id FROM sessions\s+WHERE created_at < \?`).
+		WithArgs(sqlmock.AnyArg(), defaultPurgeBatchSize).
+		WillReturnRows(sqlmock.NewRows([]string{"session_id"}).AddRow("old"))
+	for _, table := range []string{"events", "performance_metrics", "category_stats", "sessions"} {
+		mock.ExpectExec(`DELETE FROM ` + table + ` WHERE session_id IN \(\?\)`).WithArgs("old").WillReturnResult(sqlmock.NewResult(0, 1))
+	}
+	mock.ExpectCommit()
+
+	response := serve(newTestRouter(h), http.MethodPost, "/api/analytics/purge", nil, adminHeader)
+	if response.Code != http.StatusOK {
+		t.Fatalf("status %d, want 200: %s", response.Code, response.Body)
+	}
+	body := decodeBody(t, response)
+	if body["done"] != true || body["deleted"].(map[string]interface{})["sessions"] != 1.0 {
+		t.Errorf("body = %v, want one session deleted", body)
+	}
+	before, err := time.Parse(time.RFC3339, body["before"].(string))
+	if err != nil {
+		t.Fatalf("before = %v: %v", body["before"], err)
+	}
+	if cutoff := time.Now().AddDate(0, 0, -90); before.Sub(cutoff).Abs() > time.Minute {
+		t.Errorf("before = %v, want 90 days ago", before)
+	}
+}
+
+func TestPurgeExpiredDataRejectsInvalidParameters(t *testing.T) {
+	h, mock := newTestHandler(t, nil)
+	router := newTestRouter(h)
+
+	// Without DATA_RETENTION_DAYS the window must be passed
+	for _, query := range []string{"", "?days=0", "?days=30&batch_size=5000", "?days=30&max_batches=0"} {
+		expectAdminKey(mock, config.ScopeAdmin)
+		if response := serve(router, http.MethodPost, "/api/analytics/purge"+query, nil, adminHeader); response.Code != http.StatusBadRequest {
+			t.Errorf("%q: status %d, want 400", query, response.Code)
+		}
+	}
+}
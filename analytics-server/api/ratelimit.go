@@ -0,0 +1,218 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/time/rate"
+)
+
+// sessionIDHeader lets clients name the session of an ingestion request.
+// The rate limit is keyed by the sessions in the body, so the header must
+// agree with them.
+const sessionIDHeader = "X-Session-ID"
+
+// errSessionHeaderMismatch is returned by requestSessions when the
+// X-Session-ID header names another session than the body.
+var errSessionHeaderMismatch = errors.New("X-Session-ID header does not match the session_id of the body")
+
+const (
+	// rateLimitIdleTimeout is how long a session's limiter is kept after
+	// its last request.
+	rateLimitIdleTimeout = 5 * time.Minute
+	// rateLimitSweepInterval is how often idle limiters are evicted.
+	rateLimitSweepInterval = time.Minute
+)
+
+// sessionLimiters holds one token bucket per session.
+type sessionLimiters struct {
+	mu       sync.Mutex
+	limit    rate.Limit
+	burst    int
+	limiters map[string]*sessionLimiter
+}
+
+// sessionLimiter is the token bucket of one session and the time it was
+// last used.
+type sessionLimiter struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+func newSessionLimiters(rps float64, burst int) *sessionLimiters {
+	return &sessionLimiters{
+		limit:    rate.Limit(rps),
+		burst:    burst,
+		limiters: make(map[string]*sessionLimiter),
+	}
+}
+
+// reserve reserves n tokens from the bucket of sessionID, creating the
+// bucket on first use. The reservation is not OK when n exceeds the burst,
+// and must be canceled when the tokens are not available right away.
+func (l *sessionLimiters) reserve(sessionID string, n int, now time.Time) *rate.Reservation {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	entry, ok := l.limiters[sessionID]
+	if !ok {
+		entry = &sessionLimiter{limiter: rate.NewLimiter(l.limit, l.burst)}
+		l.limiters[sessionID] = entry
+	}
+	entry.lastSeen = now
+	return entry.limiter.ReserveN(now, n)
+}
+
+// sweep evicts the limiters of sessions idle since before cutoff.
+func (l *sessionLimiters) sweep(cutoff time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for sessionID, entry := range l.limiters {
+		if entry.lastSeen.Before(cutoff) {
+			delete(l.limiters, sessionID)
+		}
+	}
+}
+
+// limitSessions returns a middleware that rate limits ingestion requests
+// per session with a token bucket of RateLimitRPS tokens per second and
+// RateLimitBurst tokens. The session comes from the session_id of the JSON
+// body; a batch takes one token per event from the bucket of the event's
+// session, and a batch with more events for one session than the bucket
+// holds is rejected with 429 without Retry-After, since it can never pass.
+// Requests over the limit are rejected with 429, and the tokens already
+// taken for the other sessions of the request are given back. Requests whose X-Session-ID header names
+// another session are rejected with 400, and requests without a session
+// are left to the handler to reject.
+func (h *AnalyticsHandler) limitSessions() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if h.cfg.RateLimitRPS <= 0 {
+			c.Next()
+			return
+		}
+
+		requested, err := requestSessions(c)
+		if errors.Is(err, errSessionHeaderMismatch) {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "Failed to read request body"})
+			return
+		}
+
+		now := time.Now()
+		var taken []*rate.Reservation
+		refund := func() {
+			for _, reservation := range taken {
+				reservation.CancelAt(now)
+			}
+		}
+		for sessionID, n := range requested {
+			reservation := h.sessionLimits.reserve(sessionID, n, now)
+			if !reservation.OK() {
+				refund()
+				c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{
+					"error":      fmt.Sprintf("Batch has %d events for this session, more than the rate limit burst of %d; split it", n, h.cfg.RateLimitBurst),
+					"session_id": sessionID,
+				})
+				return
+			}
+			if delay := reservation.DelayFrom(now); delay > 0 {
+				reservation.CancelAt(now)
+				refund()
+				c.Header("Retry-After", strconv.Itoa(int(math.Ceil(delay.Seconds()))))
+				c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{
+					"error":      "Too many requests for this session, slow down",
+					"session_id": sessionID,
+				})
+				return
+			}
+			taken = append(taken, reservation)
+		}
+
+		c.Next()
+	}
+}
+
+// requestSessions returns the sessions an ingestion request writes to, with
+// the number of tokens to take from each. The sessions are read from the
+// body, which is restored for the handler, since a client could otherwise
+// spread its requests over made-up X-Session-ID values.
+func requestSessions(c *gin.Context) (map[string]int, error) {
+	body, err := c.GetRawData()
+	if err != nil {
+		return nil, err
+	}
+	c.Request.Body = io.NopCloser(bytes.NewBuffer(body))
+
+	type sessionRef struct {
+		SessionID string `json:"session_id"`
+	}
+	sessions := make(map[string]int)
+	var single sessionRef
+	var batch []sessionRef
+	if err := json.Unmarshal(body, &single); err == nil {
+		if single.SessionID != "" {
+			sessions[single.SessionID] = 1
+		}
+	} else if err := json.Unmarshal(body, &batch); err == nil {
+		for _, ref := range batch {
+			if ref.SessionID != "" {
+				sessions[ref.SessionID]++
+			}
+		}
+	}
+
+	if header := c.GetHeader(sessionIDHeader); header != "" {
+		for sessionID := range sessions {
+			if sessionID != header {
+				return nil, errSessionHeaderMismatch
+			}
+		}
+	}
+	return sessions, nil
+}
+
+// StartRateLimitSweeper starts a goroutine that evicts the rate limiters
+// of idle sessions, so the limiter map doesn't grow with every session
+// ever seen. It returns a function that stops the goroutine.
+func (h *AnalyticsHandler) StartRateLimitSweeper() (stop func()) {
+	if h.cfg.RateLimitRPS <= 0 {
+		return func() {}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		ticker := time.NewTicker(rateLimitSweepInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case now := <-ticker.C:
+				h.sessionLimits.sweep(now.Add(-rateLimitIdleTimeout))
+			}
+		}
+	}()
+
+	return func() {
+		cancel()
+		wg.Wait()
+	}
+}
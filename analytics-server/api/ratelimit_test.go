@@ -0,0 +1,150 @@
+package api
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestLimitSessionsBurstAndRecovery(t *testing.T) {
+	h, _ := newTestHandler(t, map[string]string{"RATE_LIMIT_RPS": "20", "RATE_LIMIT_BURST": "3"})
+	router := newTestRouter(h)
+
+	// The events lack an event type, so the requests let through by the
+	// limiter are refused by the handler without touching the database
+	event := map[string]interface{}{"session_id": "s1"}
+	for i := 0; i < 3; i++ {
+		if response := serve(router, http.MethodPost, "/api/analytics/event", event, nil); response.Code != http.StatusBadRequest {
+			t.Fatalf("request %d: status %d, want 400 from the handler: %s", i, response.Code, response.Body)
+		}
+	}
+	response := serve(router, http.MethodPost, "/api/analytics/event", event, nil)
+	if response.Code != http.StatusTooManyRequests {
+		t.Fatalf("over the burst: status %d, want 429: %s", response.Code, response.Body)
+	}
+	if response.Header().Get("Retry-After") == "" {
+		t.Error("429 without Retry-After")
+	}
+
+	// Other sessions have their own bucket
+	other := map[string]interface{}{"session_id": "s2"}
+	if response := serve(router, http.MethodPost, "/api/analytics/performance", other, nil); response.Code == http.StatusTooManyRequests {
+		t.Error("s2 limited by the requests of s1")
+	}
+
+	// One token is back after 1/20s
+	time.Sleep(60 * time.Millisecond)
+	if response := serve(router, http.MethodPost, "/api/analytics/event", event, nil); response.Code == http.StatusTooManyRequests {
+		t.Error("still limited after the window")
+	}
+}
+
+func TestLimitSessionsBatchTakesOneTokenPerEvent(t *testing.T) {
+	h, _ := newTestHandler(t, map[string]string{"RATE_LIMIT_RPS": "1", "RATE_LIMIT_BURST": "3"})
+	router := newTestRouter(h)
+	event := map[string]interface{}{"session_id": "s1"}
+
+	if response := serve(router, http.MethodPost, "/api/analytics/events/batch", []interface{}{event, event, event}, nil); response.Code == http.StatusTooManyRequests {
+		t.Fatalf("batch within the burst: status %d", response.Code)
+	}
+	if response := serve(router, http.MethodPost, "/api/analytics/event", event, nil); response.Code != http.StatusTooManyRequests {
+		t.Errorf("status %d, want 429: %s", response.Code, response.Body)
+	}
+}
+
+func TestLimitSessionsRejectsBatchOverBurst(t *testing.T) {
+	h, _ := newTestHandler(t, map[string]string{"RATE_LIMIT_RPS": "1", "RATE_LIMIT_BURST": "3"})
+	router := newTestRouter(h)
+	event := map[string]interface{}{"session_id": "s1"}
+
+	// Four events never fit in a bucket of three, so waiting doesn't help
+	response := serve(router, http.MethodPost, "/api/analytics/events/batch", []interface{}{event, event, event, event}, nil)
+	if response.Code != http.StatusTooManyRequests {
+		t.Fatalf("batch over the burst: status %d, want 429: %s", response.Code, response.Body)
+	}
+	if retry := response.Header().Get("Retry-After"); retry != "" {
+		t.Errorf("Retry-After = %q, want none", retry)
+	}
+
+	// The rejected batch took no tokens
+	if response := serve(router, http.MethodPost, "/api/analytics/events/batch", []interface{}{event, event, event}, nil); response.Code == http.StatusTooManyRequests {
+		t.Errorf("batch within the burst after a rejected one: %s", response.Body)
+	}
+}
+
+func TestLimitSessionsAllowsBurstSizedBatchWithDefaults(t *testing.T) {
+	h, mock := newTestHandler(t, nil)
+	expectSessionNotOptedOut(mock, "s1")
+	mock.ExpectBegin()
+	prepared := mock.ExpectPrepare(`INSERT INTO events`)
+	for id := int64(1); id <= int64(h.cfg.RateLimitBurst); id++ {
+		prepared.ExpectExec().WillReturnResult(sqlmock.NewResult(id, 1))
+	}
+	mock.ExpectCommit()
+
+	event := map[string]interface{}{"session_id": "s1", "event_type": "button_tap"}
+	batch := make([]interface{}, h.cfg.RateLimitBurst)
+	for i := range batch {
+		batch[i] = event
+	}
+	response := serve(newTestRouter(h), http.MethodPost, "/api/analytics/events/batch", batch, nil)
+	if response.Code != http.StatusCreated {
+		t.Fatalf("status %d, want 201: %s", response.Code, response.Body)
+	}
+}
+
+func TestLimitSessionsRefundsRejectedBatch(t *testing.T) {
+	h, _ := newTestHandler(t, map[string]string{"RATE_LIMIT_RPS": "1", "RATE_LIMIT_BURST": "3"})
+	router := newTestRouter(h)
+	s1 := map[string]interface{}{"session_id": "s1"}
+	s2 := map[string]interface{}{"session_id": "s2"}
+
+	// s2 is out of tokens, so a batch for both sessions is rejected and
+	// gives back what it took from s1, whichever session came first
+	serve(router, http.MethodPost, "/api/analytics/events/batch", []interface{}{s2, s2, s2}, nil)
+	for i := 0; i < 5; i++ {
+		response := serve(router, http.MethodPost, "/api/analytics/events/batch", []interface{}{s1, s2}, nil)
+		if response.Code != http.StatusTooManyRequests {
+			t.Fatalf("request %d: status %d, want 429: %s", i, response.Code, response.Body)
+		}
+	}
+	if response := serve(router, http.MethodPost, "/api/analytics/events/batch", []interface{}{s1, s1, s1}, nil); response.Code == http.StatusTooManyRequests {
+		t.Errorf("s1 limited by rejected batches: %s", response.Body)
+	}
+}
+
+func TestLimitSessionsRejectsMismatchedHeader(t *testing.T) {
+	h, _ := newTestHandler(t, nil)
+
+	header := http.Header{sessionIDHeader: {"s2"}}
+	response := serve(newTestRouter(h), http.MethodPost, "/api/analytics/event", map[string]interface{}{"session_id": "s1"}, header)
+	if response.Code != http.StatusBadRequest {
+		t.Errorf("status %d, want 400: %s", response.Code, response.Body)
+	}
+}
+
+func TestSessionLimitersSweep(t *testing.T) {
+	limits := newSessionLimiters(1, 1)
+	start := time.Now()
+
+	if limits.reserve("idle", 1, start).DelayFrom(start) > 0 || limits.reserve("active", 1, start).DelayFrom(start) > 0 {
+		t.Fatal("first requests refused")
+	}
+	if limits.reserve("active", 1, start).DelayFrom(start) == 0 {
+		t.Error("request over the burst allowed")
+	}
+	later := start.Add(3 * time.Second)
+	if limits.reserve("active", 1, later).DelayFrom(later) > 0 {
+		t.Error("request refused after the bucket refilled")
+	}
+
+	limits.sweep(start.Add(time.Second))
+	if _, ok := limits.limiters["idle"]; ok {
+		t.Error("idle limiter kept")
+	}
+	if _, ok := limits.limiters["active"]; !ok {
+		t.Error("active limiter evicted")
+	}
+}
@@ -0,0 +1,422 @@
+package api
+
+import (
+	"database/sql"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	defaultPageLimit = 100
+	maxPageLimit     = 1000
+)
+
+// getSessionSuccessRates lists the swipe success rate of every session,
+// worst first, to help spot sessions where the user struggled. Sessions
+// with fewer than min_swipes swipes are left out to reduce noise.
+func (h *AnalyticsHandler) getSessionSuccessRates(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	minSwipes, err := queryInt(c, "min_swipes", 1, 1, maxPageLimit)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	limit, err := queryInt(c, "limit", defaultPageLimit, 1, maxPageLimit)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	offset, err := queryInt(c, "offset", 0, 0, math.MaxInt32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var total int
+	err = h.db.QueryRowContext(ctx, `
+		SELECT COUNT(*) FROM (
+			SELECT session_id
+			FROM events
+			WHERE event_type = 'card_swipe'
+			GROUP BY session_id
+			HAVING COUNT(*) >= ?
+		) filtered
+	`, minSwipes).Scan(&total)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to count sessions"})
+		return
+	}
+
+	rows, err := h.db.QueryContext(ctx, `
+		SELECT
+			session_id,
+			COUNT(*) as swipes,
+			COUNT(CASE WHEN COALESCE(success, false) = true THEN 1 END) as successful_swipes
+		FROM events
+		WHERE event_type = 'card_swipe'
+		GROUP BY session_id
+		HAVING COUNT(*) >= ?
+		ORDER BY COUNT(CASE WHEN COALESCE(success, false) = true THEN 1 END) * 1.0 / COUNT(*) ASC, session_id ASC
+		LIMIT ? OFFSET ?
+	`, minSwipes, limit, offset)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get session success rates"})
+		return
+	}
+	defer rows.Close()
+
+	sessions := make([]map[string]interface{}, 0)
+	for rows.Next() {
+		var sessionID string
+		var swipes, successfulSwipes int
+		if err := rows.Scan(&sessionID, &swipes, &successfulSwipes); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get session success rates"})
+			return
+		}
+		sessions = append(sessions, map[string]interface{}{
+			"session_id":   sessionID,
+			"swipes":       swipes,
+			"success_rate": float64(successfulSwipes) / float64(swipes) * 100,
+		})
+	}
+	if err := rows.Err(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get session success rates"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"sessions": sessions,
+		"pagination": gin.H{
+			"total":  total,
+			"limit":  limit,
+			"offset": offset,
+		},
+	})
+}
+
+// getFPSSuccessCorrelation correlates frame rate with swipe success. Each
+// swipe is paired with the performance sample of the same session whose
+// timestamp is closest to the swipe, and the Pearson coefficient between
+// that sample's FPS and the swipe outcome (1 for success, 0 otherwise) is
+// returned. Swipes in sessions without FPS samples are skipped.
+func (h *AnalyticsHandler) getFPSSuccessCorrelation(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	rows, err := h.db.QueryContext(ctx, `
+		SELECT
+			COALESCE(e.success, false) as success,
+			(
+				SELECT pm.fps
+				FROM performance_metrics pm
+				WHERE pm.session_id = e.session_id AND pm.fps IS NOT NULL
+				ORDER BY ABS(`+h.db.SecondsBetween("pm.timestamp", "e.created_at")+`), pm.id
+				LIMIT 1
+			) as nearest_fps
+		FROM events e
+		WHERE e.event_type = 'card_swipe'
+	`)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get FPS samples for swipes"})
+		return
+	}
+	defer rows.Close()
+
+	var fps, outcomes []float64
+	for rows.Next() {
+		var success bool
+		var nearestFPS sql.NullFloat64
+		if err := rows.Scan(&success, &nearestFPS); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get FPS samples for swipes"})
+			return
+		}
+		if !nearestFPS.Valid {
+			continue
+		}
+		outcome := 0.0
+		if success {
+			outcome = 1
+		}
+		fps = append(fps, nearestFPS.Float64)
+		outcomes = append(outcomes, outcome)
+	}
+	if err := rows.Err(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get FPS samples for swipes"})
+		return
+	}
+
+	var correlation interface{}
+	if r, ok := pearson(fps, outcomes); ok {
+		correlation = r
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"correlation": correlation,
+		"sample_size": len(fps),
+	})
+}
+
+// defaultSessionTimeEdges are the default bucket edges, in seconds since
+// session start, for getSuccessBySessionTime.
+const defaultSessionTimeEdges = "30,60,120,300,600"
+
+// getSuccessBySessionTime buckets swipes by the time elapsed since their
+// session was created and reports the swipe success rate per bucket, which
+// reveals learning or fatigue over the course of a session. Bucket edges
+// are given in seconds through the edges parameter.
+func (h *AnalyticsHandler) getSuccessBySessionTime(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	edges, err := parseBucketEdges(c.DefaultQuery("edges", defaultSessionTimeEdges))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	rows, err := h.db.QueryContext(ctx, `
+		SELECT
+			`+h.db.BucketIndex(h.db.SecondsBetween("s.created_at", "e.created_at"), len(edges))+` as bucket,
+			COUNT(*) as swipes,
+			COUNT(CASE WHEN COALESCE(e.success, false) = true THEN 1 END) as successful_swipes
+		FROM events e
+		JOIN sessions s ON s.session_id = e.session_id
+		WHERE e.event_type = 'card_swipe' AND e.created_at >= s.created_at
+		GROUP BY bucket
+	`, edgeArgs(edges)...)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get success rate by session time"})
+		return
+	}
+	defer rows.Close()
+
+	swipes := make([]int, len(edges)+1)
+	successful := make([]int, len(edges)+1)
+	for rows.Next() {
+		var bucket, total, success int
+		if err := rows.Scan(&bucket, &total, &success); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get success rate by session time"})
+			return
+		}
+		if bucket >= 0 && bucket < len(swipes) {
+			swipes[bucket] = total
+			successful[bucket] = success
+		}
+	}
+	if err := rows.Err(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get success rate by session time"})
+		return
+	}
+
+	buckets := make([]map[string]interface{}, 0, len(swipes))
+	for i := range swipes {
+		var successRate interface{}
+		if swipes[i] > 0 {
+			successRate = float64(successful[i]) / float64(swipes[i]) * 100
+		}
+		bucket := map[string]interface{}{
+			"bucket":        bucketLabel(edges, i, 0, "s"),
+			"start_seconds": 0.0,
+			"swipes":        swipes[i],
+			"success_rate":  successRate,
+		}
+		if i > 0 {
+			bucket["start_seconds"] = edges[i-1]
+		}
+		if i < len(edges) {
+			bucket["end_seconds"] = edges[i]
+		}
+		buckets = append(buckets, bucket)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"buckets": buckets})
+}
+
+const (
+	defaultSequenceLength = 3
+	maxSequenceLength     = 10
+	defaultSequenceLimit  = 10
+)
+
+// getSwipeSequences finds the most common runs of n consecutive swipe
+// directions within a session (n-grams), such as left, left, right. For
+// every n-gram it reports the total number of occurrences and the number
+// of sessions it appears in. Sequences are assembled in Go from the
+// session's swipes in chronological order.
+func (h *AnalyticsHandler) getSwipeSequences(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	n, err := queryInt(c, "n", defaultSequenceLength, 1, maxSequenceLength)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	limit, err := queryInt(c, "limit", defaultSequenceLimit, 1, maxPageLimit)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	rows, err := h.db.QueryContext(ctx, `
+		SELECT session_id, LOWER(TRIM(direction)) as direction
+		FROM events
+		WHERE event_type = 'card_swipe'
+			AND direction IS NOT NULL AND TRIM(direction) <> ''
+		ORDER BY session_id, created_at, id
+	`)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get swipe sequences"})
+		return
+	}
+	defer rows.Close()
+
+	occurrences := make(map[string]int)
+	sessions := make(map[string]int)
+
+	var currentSession string
+	var sequence []string
+	countSequence := func() {
+		seen := make(map[string]bool)
+		for i := 0; i+n <= len(sequence); i++ {
+			key := strings.Join(sequence[i:i+n], ",")
+			occurrences[key]++
+			if !seen[key] {
+				seen[key] = true
+				sessions[key]++
+			}
+		}
+	}
+
+	for rows.Next() {
+		var sessionID, direction string
+		if err := rows.Scan(&sessionID, &direction); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get swipe sequences"})
+			return
+		}
+		if sessionID != currentSession {
+			countSequence()
+			currentSession = sessionID
+			sequence = sequence[:0]
+		}
+		sequence = append(sequence, direction)
+	}
+	if err := rows.Err(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get swipe sequences"})
+		return
+	}
+	countSequence()
+
+	keys := make([]string, 0, len(occurrences))
+	for key := range occurrences {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if occurrences[keys[i]] != occurrences[keys[j]] {
+			return occurrences[keys[i]] > occurrences[keys[j]]
+		}
+		return keys[i] < keys[j]
+	})
+	if len(keys) > limit {
+		keys = keys[:limit]
+	}
+
+	sequences := make([]map[string]interface{}, 0, len(keys))
+	for _, key := range keys {
+		sequences = append(sequences, map[string]interface{}{
+			"sequence":    strings.Split(key, ","),
+			"occurrences": occurrences[key],
+			"sessions":    sessions[key],
+		})
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"n":         n,
+		"sequences": sequences,
+	})
+}
+
+// getSequenceGaps lists sessions whose event sequence numbers have gaps,
+// i.e. events that were dropped between the first and last one received.
+// Only events carrying a sequence number are considered. Sessions with the
+// most missing events come first.
+func (h *AnalyticsHandler) getSequenceGaps(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	limit, err := queryInt(c, "limit", defaultPageLimit, 1, maxPageLimit)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	offset, err := queryInt(c, "offset", 0, 0, math.MaxInt32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var total int
+	err = h.db.QueryRowContext(ctx, `
+		SELECT COUNT(*) FROM (
+			SELECT session_id
+			FROM events
+			WHERE seq IS NOT NULL
+			GROUP BY session_id
+			HAVING MAX(seq) - MIN(seq) + 1 > COUNT(*)
+		) gapped
+	`).Scan(&total)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to count sessions with sequence gaps"})
+		return
+	}
+
+	rows, err := h.db.QueryContext(ctx, `
+		SELECT
+			session_id,
+			MIN(seq) as first_seq,
+			MAX(seq) as last_seq,
+			COUNT(*) as received
+		FROM events
+		WHERE seq IS NOT NULL
+		GROUP BY session_id
+		HAVING MAX(seq) - MIN(seq) + 1 > COUNT(*)
+		ORDER BY MAX(seq) - MIN(seq) + 1 - COUNT(*) DESC, session_id ASC
+		LIMIT ? OFFSET ?
+	`, limit, offset)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get sequence gaps"})
+		return
+	}
+	defer rows.Close()
+
+	sessions := make([]map[string]interface{}, 0)
+	for rows.Next() {
+		var sessionID string
+		var firstSeq, lastSeq, received int
+		if err := rows.Scan(&sessionID, &firstSeq, &lastSeq, &received); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get sequence gaps"})
+			return
+		}
+		sessions = append(sessions, map[string]interface{}{
+			"session_id": sessionID,
+			"first_seq":  firstSeq,
+			"last_seq":   lastSeq,
+			"received":   received,
+			"missing":    lastSeq - firstSeq + 1 - received,
+		})
+	}
+	if err := rows.Err(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get sequence gaps"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"sessions": sessions,
+		"pagination": gin.H{
+			"total":  total,
+			"limit":  limit,
+			"offset": offset,
+		},
+	})
+}
@@ -0,0 +1,202 @@
+package api
+
+import (
+	"cyber-swipe-analytics/config"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestGetSessionSuccessRates(t *testing.T) {
+	h, mock := newTestHandler(t, nil)
+	expectAdminKey(mock, config.ScopeRead)
+
+	// Sessions below min_swipes are filtered out and the rest come worst
+	// first
+	mock.ExpectQuery(`SELECT COUNT\(\*\) FROM \(.*HAVING COUNT\(\*\) >= \?`).
+		WithArgs(3).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(2))
+	mock.ExpectQuery(`HAVING COUNT\(\*\) >= \?\s+ORDER BY .* / COUNT\(\*\) ASC, session_id ASC\s+LIMIT \? OFFSET \?`).
+		WithArgs(3, 100, 0).
+		WillReturnRows(sqlmock.NewRows([]string{"session_id", "swipes", "successful_swipes"}).
+			AddRow("struggling", 4, 1).
+			AddRow("smooth", 5, 5))
+
+	response := serve(newTestRouter(h), http.MethodGet, "/api/analytics/sessions/success-rates?min_swipes=3", nil, adminHeader)
+	if response.Code != http.StatusOK {
+		t.Fatalf("status %d, want 200: %s", response.Code, response.Body)
+	}
+	body := decodeBody(t, response)
+	sessions := body["sessions"].([]interface{})
+	if len(sessions) != 2 {
+		t.Fatalf("got %d sessions, want 2", len(sessions))
+	}
+	first, second := sessions[0].(map[string]interface{}), sessions[1].(map[string]interface{})
+	if first["session_id"] != "struggling" || first["success_rate"] != 25.0 {
+		t.Errorf("first session = %v, want struggling at 25%%", first)
+	}
+	if second["session_id"] != "smooth" || second["success_rate"] != 100.0 {
+		t.Errorf("second session = %v, want smooth at 100%%", second)
+	}
+	if total := body["pagination"].(map[string]interface{})["total"]; total != 2.0 {
+		t.Errorf("total = %v, want 2", total)
+	}
+}
+
+func TestGetSessionSuccessRatesRejectsInvalidMinSwipes(t *testing.T) {
+	h, mock := newTestHandler(t, nil)
+	expectAdminKey(mock, config.ScopeRead)
+
+	response := serve(newTestRouter(h), http.MethodGet, "/api/analytics/sessions/success-rates?min_swipes=0", nil, adminHeader)
+	if response.Code != http.StatusBadRequest {
+		t.Errorf("status %d, want 400", response.Code)
+	}
+}
+
+func TestGetFPSSuccessCorrelation(t *testing.T) {
+	h, mock := newTestHandler(t, nil)
+	expectAdminKey(mock, config.ScopeRead)
+
+	// Swipes succeed at high frame rates and fail at low ones; the swipe
+	// without an FPS sample is skipped
+	mock.ExpectQuery(`ORDER BY ABS\(TIMESTAMPDIFF\(MICROSECOND, pm.timestamp, e.created_at\) / 1000000\), pm.id`).
+		WillReturnRows(sqlmock.NewRows([]string{"success", "nearest_fps"}).
+			AddRow(true, 60).
+			AddRow(true, 58).
+			AddRow(false, 20).
+			AddRow(false, 24).
+			AddRow(true, nil))
+
+	response := serve(newTestRouter(h), http.MethodGet, "/api/analytics/correlations/fps-success", nil, adminHeader)
+	if response.Code != http.StatusOK {
+		t.Fatalf("status %d, want 200: %s", response.Code, response.Body)
+	}
+	body := decodeBody(t, response)
+	if body["sample_size"] != 4.0 {
+		t.Errorf("sample_size = %v, want 4", body["sample_size"])
+	}
+	if r, ok := body["correlation"].(float64); !ok || r < 0.99 {
+		t.Errorf("correlation = %v, want close to 1", body["correlation"])
+	}
+}
+
+func TestGetFPSSuccessCorrelationUndefined(t *testing.T) {
+	h, mock := newTestHandler(t, nil)
+	expectAdminKey(mock, config.ScopeRead)
+	mock.ExpectQuery(`nearest_fps`).
+		WillReturnRows(sqlmock.NewRows([]string{"success", "nearest_fps"}).AddRow(true, 60))
+
+	response := serve(newTestRouter(h), http.MethodGet, "/api/analytics/correlations/fps-success", nil, adminHeader)
+	if body := decodeBody(t, response); body["correlation"] != nil {
+		t.Errorf("correlation = %v, want null for a single sample", body["correlation"])
+	}
+}
+
+func TestGetSuccessBySessionTime(t *testing.T) {
+	h, mock := newTestHandler(t, nil)
+	expectAdminKey(mock, config.ScopeRead)
+
+	// Swipes in the first 30 seconds and after a minute; none in between
+	mock.ExpectQuery(`INTERVAL\(TIMESTAMPDIFF\(MICROSECOND, s.created_at, e.created_at\) / 1000000, \?, \?\) as bucket`).
+		WithArgs(30.0, 60.0).
+		WillReturnRows(sqlmock.NewRows([]string{"bucket", "swipes", "successful_swipes"}).
+			AddRow(0, 4, 1).
+			AddRow(2, 2, 2))
+
+	response := serve(newTestRouter(h), http.MethodGet, "/api/analytics/swipes/success-by-session-time?edges=30,60", nil, adminHeader)
+	if response.Code != http.StatusOK {
+		t.Fatalf("status %d, want 200: %s", response.Code, response.Body)
+	}
+	buckets := decodeBody(t, response)["buckets"].([]interface{})
+	want := []struct {
+		label       string
+		swipes      float64
+		successRate interface{}
+	}{
+		{"0s-30s", 4, 25.0},
+		{"30s-60s", 0, nil},
+		{"60s+", 2, 100.0},
+	}
+	if len(buckets) != len(want) {
+		t.Fatalf("got %d buckets, want %d", len(buckets), len(want))
+	}
+	for i, w := range want {
+		bucket := buckets[i].(map[string]interface{})
+		if bucket["bucket"] != w.label || bucket["swipes"] != w.swipes || bucket["success_rate"] != w.successRate {
+			t.Errorf("bucket %d = %v, want %s with %v swipes at %v", i, bucket, w.label, w.swipes, w.successRate)
+		}
+	}
+}
+
+func TestGetSwipeSequences(t *testing.T) {
+	h, mock := newTestHandler(t, nil)
+	expectAdminKey(mock, config.ScopeRead)
+
+	// Both sessions reject twice then accept; the trigrams spanning the
+	// two sessions must not be counted
+	rows := sqlmock.NewRows([]string{"session_id", "direction"})
+	for _, event := range [][2]string{
+		{"s1", "left"}, {"s1", "left"}, {"s1", "right"},
+		{"s2", "up"}, {"s2", "left"}, {"s2", "left"}, {"s2", "right"},
+	} {
+		rows.AddRow(event[0], event[1])
+	}
+	mock.ExpectQuery(`ORDER BY session_id, created_at, id`).WillReturnRows(rows)
+
+	response := serve(newTestRouter(h), http.MethodGet, "/api/analytics/swipes/sequences?n=3&limit=5", nil, adminHeader)
+	if response.Code != http.StatusOK {
+		t.Fatalf("status %d, want 200: %s", response.Code, response.Body)
+	}
+	body := decodeBody(t, response)
+	sequences := body["sequences"].([]interface{})
+	if len(sequences) != 2 {
+		t.Fatalf("got %d sequences, want 2: %v", len(sequences), sequences)
+	}
+	top := sequences[0].(map[string]interface{})
+	if fmt.Sprint(top["sequence"]) != "[left left right]" || top["occurrences"] != 2.0 || top["sessions"] != 2.0 {
+		t.Errorf("top sequence = %v, want left,left,right in 2 sessions", top)
+	}
+	if other := sequences[1].(map[string]interface{}); fmt.Sprint(other["sequence"]) != "[up left left]" || other["occurrences"] != 1.0 {
+		t.Errorf("second sequence = %v, want up,left,left once", other)
+	}
+}
+
+func TestGetSwipeSequencesRejectsInvalidLength(t *testing.T) {
+	h, mock := newTestHandler(t, nil)
+	expectAdminKey(mock, config.ScopeRead)
+
+	response := serve(newTestRouter(h), http.MethodGet, "/api/analytics/swipes/sequences?n=0", nil, adminHeader)
+	if response.Code != http.StatusBadRequest {
+		t.Errorf("status %d, want 400", response.Code)
+	}
+}
+
+func TestGetSequenceGaps(t *testing.T) {
+	h, mock := newTestHandler(t, nil)
+	expectAdminKey(mock, config.ScopeRead)
+
+	// s1 received 1-10 but lost three events; s2 started at 5 and lost one
+	mock.ExpectQuery(`SELECT COUNT\(\*\) FROM \(.*HAVING MAX\(seq\) - MIN\(seq\) \+ 1 > COUNT\(\*\)`).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(2))
+	mock.ExpectQuery(`ORDER BY MAX\(seq\) - MIN\(seq\) \+ 1 - COUNT\(\*\) DESC, session_id ASC\s+LIMIT \? OFFSET \?`).
+		WithArgs(100, 0).
+		WillReturnRows(sqlmock.NewRows([]string{"session_id", "first_seq", "last_seq", "received"}).
+			AddRow("s1", 1, 10, 7).
+			AddRow("s2", 5, 8, 3))
+
+	response := serve(newTestRouter(h), http.MethodGet, "/api/analytics/events/sequence-gaps", nil, adminHeader)
+	if response.Code != http.StatusOK {
+		t.Fatalf("status %d, want 200: %s", response.Code, response.Body)
+	}
+	sessions := decodeBody(t, response)["sessions"].([]interface{})
+	if len(sessions) != 2 {
+		t.Fatalf("got %d sessions, want 2", len(sessions))
+	}
+	for i, missing := range []float64{3, 1} {
+		if got := sessions[i].(map[string]interface{})["missing"]; got != missing {
+			t.Errorf("session %d missing = %v, want %v", i, got, missing)
+		}
+	}
+}
@@ -1,16 +1,26 @@
 package api
 
 import (
+	"context"
+	"cyber-swipe-analytics/config"
 	"cyber-swipe-analytics/storage"
 	"fmt"
 	"io"
 	"net/http"
-	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
 	"time"
 
 	"bytes"
 
 	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"math"
+	mathrand "math/rand/v2"
 
 	"github.com/gin-gonic/gin"
 )
@@ -19,17 +29,52 @@ import (
 // It provides methods for session management, event recording,
 // and statistics retrieval.
 type AnalyticsHandler struct {
-	db *storage.DB
+	db  *storage.DB
+	cfg *config.Config
+	// ingestion tracks the recent event ingestion rate.
+	ingestion *ingestionRate
+	// exports holds one slot per export being served.
+	exports chan struct{}
+	// performanceSampledOut counts the performance samples dropped by
+	// PerformanceSampleRate since startup.
+	performanceSampledOut atomic.Int64
+	// tableCounts caches the admin table counts.
+	tableCounts tableCountsCache
+	// sessionLimits rate limits ingestion per session.
+	sessionLimits *sessionLimiters
+	// events fans recorded events out to the live event stream.
+	events *eventHub
+	// propertyKeys caches the registered custom property keys.
+	propertyKeys propertyKeyCache
+}
+
+// NewAnalyticsHandler creates an AnalyticsHandler backed by the given
+// database and configuration.
+func NewAnalyticsHandler(db *storage.DB, cfg *config.Config) *AnalyticsHandler {
+	return &AnalyticsHandler{
+		db:            db,
+		cfg:           cfg,
+		ingestion:     newIngestionRate(time.Now()),
+		exports:       make(chan struct{}, cfg.MaxConcurrentExports),
+		sessionLimits: newSessionLimiters(cfg.RateLimitRPS, cfg.RateLimitBurst),
+		events:        newEventHub(),
+	}
 }
 
 // SetupRoutes configures all HTTP routes for the analytics server.
 // It sets up endpoints for health checks, session management,
 // event recording, and statistics retrieval.
-func SetupRoutes(router *gin.Engine, db *storage.DB) {
-	handler := &AnalyticsHandler{db: db}
+func SetupRoutes(router *gin.Engine, handler *AnalyticsHandler) {
 
 	// Health check endpoint (no authentication required)
-	router.GET("/health", HealthCheck)
+	router.GET("/health", handler.healthCheck)
+
+	// Prometheus scrape endpoint. It is unauthenticated, so keep it
+	// firewalled from the public internet.
+	router.GET("/metrics", metricsHandler())
+
+	// OpenAPI document describing the routes and request bodies
+	router.GET("/openapi.json", getOpenAPISpec(router))
 
 	// Analytics API endpoints group
 	analytics := router.Group("/api/analytics")
@@ -37,20 +82,110 @@ func SetupRoutes(router *gin.Engine, db *storage.DB) {
 		// Session management endpoints
 		analytics.POST("/session", handler.createSession)
 		analytics.POST("/session/end", handler.endSession)
-
-		// Event recording endpoints
-		analytics.POST("/event", handler.recordEvent)
-		analytics.POST("/performance", handler.recordPerformanceMetrics)
-		analytics.POST("/category", handler.recordCategoryStats)
+		analytics.POST("/session/end/batch", handler.endSessions)
+
+		// Event recording endpoints, rate limited per session
+		recording := analytics.Group("", handler.limitSessions())
+		{
+			recording.POST("/event", handler.recordEvent)
+			recording.POST("/events/batch", handler.recordEvents)
+			recording.POST("/performance", handler.recordPerformanceMetrics)
+			recording.POST("/category", handler.recordCategoryStats)
+		}
 
 		// Statistics retrieval endpoint
-		analytics.GET("/stats", handler.getStats)
+		analytics.GET("/stats", handler.requireScope(config.ScopeRead), handler.limitExports(), handler.getStats)
+
+		// Reporting endpoints
+		reports := analytics.Group("", handler.requireScope(config.ScopeRead))
+		{
+			reports.GET("/sessions/success-rates", handler.getSessionSuccessRates)
+			reports.GET("/correlations/fps-success", handler.getFPSSuccessCorrelation)
+			reports.GET("/swipes/success-by-session-time", handler.getSuccessBySessionTime)
+			reports.GET("/swipes/success-by-hour-of-day", handler.getSuccessByHourOfDay)
+			reports.GET("/swipes/sequences", handler.getSwipeSequences)
+			reports.GET("/events/sequence-gaps", handler.getSequenceGaps)
+			reports.GET("/events/out-of-bounds", handler.getOutOfBoundsEvents)
+			reports.GET("/recent", handler.getRecentActivity)
+			reports.GET("/timeseries", handler.getTimeseries)
+			reports.GET("/performance/low-fps-devices", handler.getLowFPSDevices)
+			reports.GET("/performance/by-app-version", handler.getPerformanceByAppVersion)
+			reports.GET("/performance/by-resolution", handler.getPerformanceByResolution)
+			reports.GET("/performance/by-thermal-state", handler.getFPSByThermalState)
+			reports.GET("/metrics/prometheus", handler.getPrometheusMetrics)
+			reports.GET("/categories", handler.getCategories)
+			reports.GET("/categories/funnel", handler.getCategoryFunnel)
+			reports.GET("/categories/difficulty", handler.getWeightedCategorySuccess)
+			reports.GET("/users/top", handler.getTopUsers)
+		}
+
+		// Live event stream, which browsers authenticate through a
+		// WebSocket subprotocol
+		analytics.GET("/stream", streamSecret(), handler.requireScope(config.ScopeRead), handler.streamEvents)
+
+		// Session maintenance endpoints
+		analytics.POST("/sessions/merge", handler.requireScope(config.ScopeAdmin), handler.mergeSessions)
+		analytics.POST("/purge", handler.requireScope(config.ScopeAdmin), handler.purgeExpiredData)
+
+		// Session inspection endpoints
+		sessionReports := analytics.Group("/session/:session_id", handler.requireScope(config.ScopeRead))
+		{
+			sessionReports.GET("", handler.getSessionDetail)
+			sessionReports.GET("/categories", handler.getSessionCategories)
+			sessionReports.GET("/bundle", handler.getSessionBundle)
+		}
+
+		// Administrative endpoints
+		admin := analytics.Group("/admin", handler.requireScope(config.ScopeAdmin))
+		{
+			admin.POST("/backfill", handler.backfillDerivedColumn)
+			admin.POST("/compact", handler.compactDatabase)
+			admin.POST("/archive", handler.archiveSessions)
+			admin.GET("/ingestion-rate", handler.getIngestionRate)
+			admin.GET("/integrity", handler.checkIntegrity)
+			admin.GET("/index-advice", handler.getIndexAdvice)
+			admin.GET("/counts", handler.getTableCounts)
+			admin.GET("/property-keys", handler.getPropertyKeys)
+		}
+
+		// Per-user report
+		analytics.GET("/user/:user_id", handler.requireScope(config.ScopeRead), handler.getUserStats)
+
+		// Privacy endpoints
+		analytics.DELETE("/user/:user_id", handler.requireScope(config.ScopeDelete), handler.eraseUser)
+		privacy := analytics.Group("/user/:user_id", handler.requireScope(config.ScopeAdmin))
+		{
+			privacy.POST("/opt-out", handler.optOutUser)
+			privacy.POST("/opt-in", handler.optInUser)
+		}
 	}
 }
 
-// HealthCheck handles the health check endpoint.
-// Returns a simple status response indicating the server is operational.
-func HealthCheck(c *gin.Context) {
+// healthPingTimeout bounds the database ping of the health check, so a
+// hung database fails the check quickly.
+const healthPingTimeout = 2 * time.Second
+
+// healthCheck handles the health check endpoint. It pings the database and
+// reports the server as degraded with 503 when the ping fails, so load
+// balancers stop routing to an instance that can't reach its database.
+func (h *AnalyticsHandler) healthCheck(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(c.Request.Context(), healthPingTimeout)
+	defer cancel()
+
+	if err := h.db.PingContext(ctx); err != nil {
+		log.Printf("Health check database ping failed: %v", err)
+		category := "database_unavailable"
+		if errors.Is(err, context.DeadlineExceeded) {
+			category = "database_timeout"
+		}
+		c.JSON(http.StatusServiceUnavailable, gin.H{
+			"status":  "degraded",
+			"version": "1.0.0",
+			"error":   category,
+		})
+		return
+	}
+
 	c.JSON(http.StatusOK, gin.H{
 		"status":  "ok",
 		"version": "1.0.0",
@@ -59,12 +194,19 @@ func HealthCheck(c *gin.Context) {
 
 // SessionRequest represents the data required to create a new analytics session.
 type SessionRequest struct {
-	SessionID   string `json:"session_id" binding:"required"`
-	UserID      string `json:"user_id" binding:"required"`
-	Platform    string `json:"platform" binding:"required"`
-	Resolution  string `json:"resolution" binding:"required"`
-	DeviceModel string `json:"device_model,omitempty"`
-	OSVersion   string `json:"os_version,omitempty"`
+	SessionID   string            `json:"session_id" binding:"required,max=255"`
+	UserID      string            `json:"user_id" binding:"required,max=255"`
+	Platform    string            `json:"platform" binding:"required,max=50"`
+	Resolution  string            `json:"resolution" binding:"required,max=50"`
+	DeviceModel string            `json:"device_model,omitempty" binding:"max=255"`
+	OSVersion   string            `json:"os_version,omitempty" binding:"max=50"`
+	AppVersion  string            `json:"app_version,omitempty" binding:"max=50"`
+	Tags        map[string]string `json:"tags,omitempty"`
+	// IPAddress and UserAgent describe the request that created the
+	// session. They are set by createSession, never taken from the body,
+	// and kept in the JSON form so dead-lettered sessions retain them.
+	IPAddress string `json:"ip_address,omitempty" openapi:"-"`
+	UserAgent string `json:"user_agent,omitempty" openapi:"-"`
 }
 
 // createSession handles the creation of a new analytics session.
@@ -73,41 +215,106 @@ func (h *AnalyticsHandler) createSession(c *gin.Context) {
 	var session SessionRequest
 
 	if err := c.ShouldBindJSON(&session); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": describeBindingError(err).Error()})
+		return
+	}
+	// The client IP honours forwarding headers from TRUSTED_PROXIES only
+	session.IPAddress = c.ClientIP()
+	session.UserAgent = c.Request.UserAgent()
+
+	if err := validateTags(session.Tags); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
-	_, err := h.db.Exec(`
-		INSERT INTO sessions (session_id, user_id, platform, resolution, device_model, os_version)
-		VALUES (?, ?, ?, ?, ?, ?)
-	`, session.SessionID, session.UserID, session.Platform, session.Resolution, session.DeviceModel, session.OSVersion)
+	if err := h.validateUserID(session.UserID); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if looksLikeEmail(session.UserID) {
+		// The ID itself is not logged, as it is personal data
+		log.Printf("Warning: session %s has a user_id that looks like an email address", session.SessionID)
+	}
 
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create session"})
+	optedOut, err := h.userOptedOut(c.Request.Context(), session.UserID)
+	if h.refuseOptedOut(c, optedOut, err) {
+		return
+	}
+
+	if err := h.insertSession(c.Request.Context(), session); err != nil {
+		h.handleIngestError(c, deadLetterSession, session, err, "Failed to create session")
 		return
 	}
 
 	c.JSON(http.StatusCreated, gin.H{"status": "success"})
 }
 
+// refreshedSessionColumns are the columns updated when a session is
+// created again, e.g. by a client retrying the request. The user, origin,
+// and creation time of the session stay unchanged.
+var refreshedSessionColumns = []string{
+	"platform", "resolution", "device_model", "os_version",
+	"os_major", "os_minor", "os_patch", "app_version", "tags",
+}
+
+// insertSession stores a validated session. The OS version is also stored
+// as numeric components to allow version range queries. Creating an
+// existing session refreshes its device and platform fields instead of
+// failing on the unique session_id.
+func (h *AnalyticsHandler) insertSession(ctx context.Context, session SessionRequest) error {
+	query, args, err := h.insertSessionStatement(session)
+	if err != nil {
+		return err
+	}
+	_, err = h.db.ExecContext(ctx, query, args...)
+	return err
+}
+
+// insertSessionStatement returns the upsert of insertSession and its
+// arguments.
+func (h *AnalyticsHandler) insertSessionStatement(session SessionRequest) (string, []interface{}, error) {
+	tags, err := encodeTags(session.Tags)
+	if err != nil {
+		return "", nil, err
+	}
+
+	osMajor, osMinor, osPatch := parseOSVersion(session.OSVersion)
+
+	assignments := make([]string, len(refreshedSessionColumns))
+	for i, column := range refreshedSessionColumns {
+		assignments[i] = column + " = " + h.db.Inserted(column)
+	}
+
+	query := `
+		INSERT INTO sessions (
+			session_id, user_id, platform, resolution, device_model, os_version,
+			os_major, os_minor, os_patch, app_version, tags, ip_address, user_agent
+		) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	` + h.db.Upsert([]string{"session_id"}, assignments...)
+	return query, []interface{}{session.SessionID, session.UserID, session.Platform, session.Resolution, session.DeviceModel, session.OSVersion,
+		osMajor, osMinor, osPatch, session.AppVersion, tags, nullIfEmpty(session.IPAddress), nullIfEmpty(session.UserAgent)}, nil
+}
+
 // EndSessionRequest represents the data required to end an existing analytics session.
 type EndSessionRequest struct {
-	SessionID string `json:"session_id" binding:"required"`
+	SessionID string `json:"session_id" binding:"required,max=255"`
 }
 
 // endSession handles the termination of an existing analytics session.
 // It validates the session ID and updates the session's end time in the database.
 func (h *AnalyticsHandler) endSession(c *gin.Context) {
+	ctx := c.Request.Context()
+
 	var request EndSessionRequest
 
 	if err := c.ShouldBindJSON(&request); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		c.JSON(http.StatusBadRequest, gin.H{"error": describeBindingError(err).Error()})
 		return
 	}
 
-	_, err := h.db.Exec(`
+	_, err := h.db.ExecContext(ctx, `
 		UPDATE sessions 
-		SET ended_at = CURRENT_TIMESTAMP 
+		SET ended_at = CURRENT_TIMESTAMP(3)
 		WHERE session_id = ? AND ended_at IS NULL
 	`, request.SessionID)
 
@@ -119,17 +326,125 @@ func (h *AnalyticsHandler) endSession(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"status": "success"})
 }
 
+// BatchEndSessionRequest represents the data required to end several
+// analytics sessions at once. At most 100 sessions can be listed.
+type BatchEndSessionRequest struct {
+	SessionIDs []string `json:"session_ids" binding:"required,min=1,max=100,dive,required,max=255"`
+}
+
+// endSessions ends all listed sessions that are still open with a single
+// UPDATE, e.g. when a client reconnects after a crash. It returns the
+// number of sessions ended and the listed IDs that were not open, either
+// because they were already ended or do not exist.
+func (h *AnalyticsHandler) endSessions(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	var request BatchEndSessionRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": describeBindingError(err).Error()})
+		return
+	}
+
+	var sessionIDs []string
+	seen := make(map[string]bool, len(request.SessionIDs))
+	for _, id := range request.SessionIDs {
+		if !seen[id] {
+			seen[id] = true
+			sessionIDs = append(sessionIDs, id)
+		}
+	}
+
+	ended, notOpen, err := h.endOpenSessions(ctx, sessionIDs)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to end sessions"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":   "success",
+		"ended":    ended,
+		"not_open": notOpen,
+	})
+}
+
+// endOpenSessions ends the open sessions among sessionIDs. The open ones
+// are locked before the update, so the returned list of IDs that were not
+// open matches what the update changed.
+func (h *AnalyticsHandler) endOpenSessions(ctx context.Context, sessionIDs []string) (int64, []string, error) {
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(sessionIDs)), ", ")
+	args := make([]interface{}, len(sessionIDs))
+	for i, id := range sessionIDs {
+		args[i] = id
+	}
+
+	open := make(map[string]bool)
+	var ended int64
+	err := h.db.WithTx(ctx, func(tx *sql.Tx) error {
+		rows, err := tx.QueryContext(ctx, h.db.Rebind(`
+			SELECT session_id FROM sessions
+			WHERE session_id IN (`+placeholders+`) AND ended_at IS NULL
+			FOR UPDATE
+		`), args...)
+		if err != nil {
+			return err
+		}
+		for rows.Next() {
+			var id string
+			if err := rows.Scan(&id); err != nil {
+				rows.Close()
+				return err
+			}
+			open[id] = true
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+
+		result, err := tx.ExecContext(ctx, h.db.Rebind(`
+			UPDATE sessions
+			SET ended_at = CURRENT_TIMESTAMP(3)
+			WHERE session_id IN (`+placeholders+`) AND ended_at IS NULL
+		`), args...)
+		if err != nil {
+			return err
+		}
+		ended, err = result.RowsAffected()
+		return err
+	})
+	if err != nil {
+		return 0, nil, err
+	}
+
+	notOpen := make([]string, 0)
+	for _, id := range sessionIDs {
+		if !open[id] {
+			notOpen = append(notOpen, id)
+		}
+	}
+	return ended, notOpen, nil
+}
+
 // EventRequest represents the data required to record a user interaction event.
 type EventRequest struct {
-	SessionID   string  `json:"session_id" binding:"required"`
-	EventType   string  `json:"event_type" binding:"required"`
-	CardID      string  `json:"card_id,omitempty"`
-	Direction   string  `json:"direction,omitempty"`
+	SessionID   string  `json:"session_id" binding:"required,max=255"`
+	EventType   string  `json:"event_type" binding:"required,max=50"`
+	CardID      string  `json:"card_id,omitempty" binding:"max=255"`
+	Direction   string  `json:"direction,omitempty" binding:"max=10"`
 	Success     bool    `json:"success,omitempty"`
 	Duration    float64 `json:"duration,omitempty"`
 	StartX      float64 `json:"start_x,omitempty"`
 	EndX        float64 `json:"end_x,omitempty"`
 	MaxRotation float64 `json:"max_rotation,omitempty"`
+	// Seq is an optional sequence number, increasing within the session,
+	// used to detect duplicate and dropped events.
+	Seq *int `json:"seq,omitempty"`
+	// ClientEventID is an optional client-generated ID, unique across all
+	// events, that makes resending the event safe.
+	ClientEventID string `json:"client_event_id,omitempty" binding:"max=255"`
+	// Properties holds optional custom properties of the event, such as
+	// the category and difficulty of the swiped card.
+	Properties map[string]string `json:"properties,omitempty"`
 }
 
 // recordEvent handles the recording of a user interaction event.
@@ -148,37 +463,182 @@ func (h *AnalyticsHandler) recordEvent(c *gin.Context) {
 	var event EventRequest
 
 	if err := c.ShouldBindJSON(&event); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": describeBindingError(err).Error()})
+		return
+	}
+
+	if !allowedEventTypes[event.EventType] {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":             fmt.Sprintf("unknown event type %q", event.EventType),
+			"valid_event_types": validEventTypes(),
+		})
+		return
+	}
+
+	if missing := h.missingEventFields(event.EventType, requestBody); len(missing) > 0 {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":          fmt.Sprintf("missing required fields for event type %s", event.EventType),
+			"missing_fields": missing,
+		})
+		return
+	}
+
+	if err := h.validateRotation(event); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
-	_, err = h.db.Exec(`
-		INSERT INTO events (
-			session_id, event_type, card_id, direction, success,
-			duration, start_x, end_x, max_rotation
-		) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
-	`,
-		event.SessionID, event.EventType, event.CardID, event.Direction,
-		event.Success, event.Duration, event.StartX, event.EndX,
-		event.MaxRotation,
-	)
+	if err := validateProperties(event.Properties); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
 
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to record event"})
+	optedOut, err := h.sessionOptedOut(c.Request.Context(), event.SessionID)
+	if h.refuseOptedOut(c, optedOut, err) {
+		return
+	}
+
+	if h.refusePropertyKeys(c, h.trackPropertyKeys(c.Request.Context(), event)) {
+		return
+	}
+
+	if err := h.insertEvent(c.Request.Context(), event); err != nil {
+		if errors.Is(err, errDuplicateEvent) {
+			// The client resent an event that was already stored
+			c.JSON(http.StatusOK, gin.H{"status": "duplicate"})
+			return
+		}
+		h.handleIngestError(c, deadLetterEvent, event, err, "Failed to record event")
 		return
 	}
 
 	c.JSON(http.StatusCreated, gin.H{"status": "success"})
 }
 
+// allowedEventTypes is the set of event types accepted by the ingestion
+// endpoints, so that client typos don't end up in the aggregations. Add new
+// event types here.
+var allowedEventTypes = map[string]bool{
+	"card_swipe":          true,
+	"card_view":           true,
+	"session_start":       true,
+	"button_tap":          true,
+	eventTutorialComplete: true,
+	eventTutorialSkip:     true,
+	eventUndo:             true,
+}
+
+// validEventTypes returns the allowed event types in alphabetical order.
+func validEventTypes() []string {
+	types := make([]string, 0, len(allowedEventTypes))
+	for eventType := range allowedEventTypes {
+		types = append(types, eventType)
+	}
+	sort.Strings(types)
+	return types
+}
+
+// missingEventFields returns the fields required for eventType by
+// EventRequiredFields that the raw JSON body lacks. A field given as null
+// or an empty string counts as missing.
+func (h *AnalyticsHandler) missingEventFields(eventType string, body []byte) []string {
+	required := h.cfg.EventRequiredFields[eventType]
+	if len(required) == 0 {
+		return nil
+	}
+
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(body, &fields); err != nil {
+		return required
+	}
+	var missing []string
+	for _, name := range required {
+		value := strings.TrimSpace(string(fields[name]))
+		if value == "" || value == "null" || value == `""` {
+			missing = append(missing, name)
+		}
+	}
+	return missing
+}
+
+// validateRotation checks the event's max_rotation against the configured
+// MaxRotation.
+func (h *AnalyticsHandler) validateRotation(event EventRequest) error {
+	if math.Abs(event.MaxRotation) > h.cfg.MaxRotation {
+		return fmt.Errorf("max_rotation must be between -%g and %g degrees", h.cfg.MaxRotation, h.cfg.MaxRotation)
+	}
+	return nil
+}
+
+// errDuplicateEvent is returned by insertEvent when an event with the same
+// session and sequence number, or the same client event ID, is already
+// stored.
+var errDuplicateEvent = errors.New("duplicate event")
+
+// isDuplicateEvent reports whether err is a duplicate key error caused by
+// one of the event's deduplication keys. Events carrying neither a sequence
+// number nor a client event ID can't be duplicates.
+func isDuplicateEvent(event EventRequest, err error) bool {
+	return (event.Seq != nil || event.ClientEventID != "") && storage.IsDuplicateKeyError(err)
+}
+
+// insertEventQuery inserts one event; its arguments come from
+// insertEventArgs.
+const insertEventQuery = `
+	INSERT INTO events (
+		session_id, event_type, card_id, direction, success,
+		duration, start_x, end_x, max_rotation,
+		swipe_distance, swipe_velocity, seq, client_event_id, properties
+	) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+`
+
+// insertEventArgs returns the arguments of insertEventQuery for event,
+// including its derived columns.
+func insertEventArgs(event EventRequest) []interface{} {
+	distance, velocity := swipeDerivedValues(event)
+	return []interface{}{
+		event.SessionID, event.EventType, event.CardID, event.Direction,
+		event.Success, event.Duration, event.StartX, event.EndX,
+		event.MaxRotation, distance, velocity, event.Seq,
+		nullIfEmpty(event.ClientEventID), encodeProperties(event.Properties),
+	}
+}
+
+// insertEvent stores a validated event along with its derived columns.
+func (h *AnalyticsHandler) insertEvent(ctx context.Context, event EventRequest) error {
+	_, err := h.db.ExecContext(ctx, insertEventQuery, insertEventArgs(event)...)
+	if isDuplicateEvent(event, err) {
+		return errDuplicateEvent
+	}
+	if err == nil {
+		h.eventsRecorded(event)
+	}
+	return err
+}
+
+// eventsRecorded counts stored events in the ingestion metrics and
+// publishes them to the live stream. Events stored in a transaction are
+// passed once it has committed.
+func (h *AnalyticsHandler) eventsRecorded(events ...EventRequest) {
+	h.ingestion.record(len(events), time.Now())
+	eventsRecordedTotal.Add(float64(len(events)))
+	for _, event := range events {
+		h.events.publish(event)
+	}
+}
+
 // PerformanceMetricsRequest represents the data required to record performance metrics.
 type PerformanceMetricsRequest struct {
-	SessionID      string  `json:"session_id" binding:"required"`
+	SessionID      string  `json:"session_id" binding:"required,max=255"`
 	FPS            float64 `json:"fps,omitempty"`
 	MemoryUsage    float64 `json:"memory_usage" binding:"required"`
 	CPUUsage       float64 `json:"cpu_usage,omitempty"`
 	GPUUsage       float64 `json:"gpu_usage,omitempty"`
 	NetworkLatency float64 `json:"network_latency,omitempty"`
+	// BatteryLevel is the battery charge between 0 and 1, if known.
+	BatteryLevel *float64 `json:"battery_level,omitempty" binding:"omitempty,min=0,max=1"`
+	// ThermalState is the device thermal state reported by the OS, if known.
+	ThermalState string `json:"thermal_state,omitempty" binding:"omitempty,oneof=nominal fair serious critical"`
 }
 
 // recordPerformanceMetrics handles the recording of performance metrics.
@@ -187,181 +647,678 @@ func (h *AnalyticsHandler) recordPerformanceMetrics(c *gin.Context) {
 	var metrics PerformanceMetricsRequest
 
 	if err := c.ShouldBindJSON(&metrics); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		c.JSON(http.StatusBadRequest, gin.H{"error": describeBindingError(err).Error()})
 		return
 	}
 
-	_, err := h.db.Exec(`
-		INSERT INTO performance_metrics (
-			session_id, fps, memory_usage, cpu_usage, gpu_usage, network_latency
-		) VALUES (?, ?, ?, ?, ?, ?)
-	`,
-		metrics.SessionID, metrics.FPS, metrics.MemoryUsage,
-		metrics.CPUUsage, metrics.GPUUsage, metrics.NetworkLatency,
-	)
+	if h.cfg.PerformanceSampleRate < 1 && mathrand.Float64() >= h.cfg.PerformanceSampleRate {
+		h.performanceSampledOut.Add(1)
+		c.JSON(http.StatusAccepted, gin.H{"status": "sampled_out"})
+		return
+	}
 
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to record performance metrics"})
+	optedOut, err := h.sessionOptedOut(c.Request.Context(), metrics.SessionID)
+	if h.refuseOptedOut(c, optedOut, err) {
+		return
+	}
+
+	if err := h.insertPerformanceMetrics(c.Request.Context(), metrics); err != nil {
+		h.handleIngestError(c, deadLetterPerformance, metrics, err, "Failed to record performance metrics")
 		return
 	}
 
 	c.JSON(http.StatusCreated, gin.H{"status": "success"})
 }
 
+// insertPerformanceMetricsQuery inserts one performance sample; its
+// arguments come from insertPerformanceMetricsArgs.
+const insertPerformanceMetricsQuery = `
+	INSERT INTO performance_metrics (
+		session_id, fps, memory_usage, cpu_usage, gpu_usage, network_latency,
+		battery_level, thermal_state, sample_rate
+	) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+`
+
+// insertPerformanceMetricsArgs returns the arguments of
+// insertPerformanceMetricsQuery for metrics, kept at the configured rate.
+func (h *AnalyticsHandler) insertPerformanceMetricsArgs(metrics PerformanceMetricsRequest) []interface{} {
+	return []interface{}{
+		metrics.SessionID, metrics.FPS, metrics.MemoryUsage,
+		metrics.CPUUsage, metrics.GPUUsage, metrics.NetworkLatency,
+		metrics.BatteryLevel, nullIfEmpty(metrics.ThermalState), h.cfg.PerformanceSampleRate,
+	}
+}
+
+// insertPerformanceMetrics stores a validated performance sample.
+func (h *AnalyticsHandler) insertPerformanceMetrics(ctx context.Context, metrics PerformanceMetricsRequest) error {
+	_, err := h.db.ExecContext(ctx, insertPerformanceMetricsQuery, h.insertPerformanceMetricsArgs(metrics)...)
+	return err
+}
+
 // CategoryStatsRequest represents the data required to record category statistics.
 type CategoryStatsRequest struct {
-	SessionID   string  `json:"session_id" binding:"required"`
-	Category    string  `json:"category,omitempty"`
+	SessionID   string  `json:"session_id" binding:"required,max=255"`
+	Category    string  `json:"category,omitempty" binding:"max=100"`
 	SuccessRate float64 `json:"success_rate,omitempty"`
 }
 
 // recordCategoryStats handles the recording of category statistics.
 // It validates the incoming request data and stores the statistics in the database.
 func (h *AnalyticsHandler) recordCategoryStats(c *gin.Context) {
+	ctx := c.Request.Context()
+
 	var stats CategoryStatsRequest
 	if err := c.ShouldBindJSON(&stats); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		c.JSON(http.StatusBadRequest, gin.H{"error": describeBindingError(err).Error()})
 		return
 	}
 
-	// Check if the session exists
-	var sessionExists bool
-	err := h.db.QueryRow("SELECT EXISTS(SELECT 1 FROM sessions WHERE session_id = ?)", stats.SessionID).Scan(&sessionExists)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to verify session"})
+	optedOut, err := h.sessionOptedOut(ctx, stats.SessionID)
+	if h.refuseOptedOut(c, optedOut, err) {
 		return
 	}
 
-	if !sessionExists {
+	err = h.insertCategoryStats(ctx, stats)
+	if errors.Is(err, errSessionNotFound) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Session not found"})
 		return
 	}
+	if err != nil {
+		h.handleIngestError(c, deadLetterCategory, stats, err, "Failed to record category statistics")
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"status": "success"})
+}
+
+// errSessionNotFound is returned by insertCategoryStats when the session
+// doesn't exist.
+var errSessionNotFound = errors.New("session not found")
+
+// insertCategoryStats inserts or updates the category stats of a session.
+// The session row is locked while the stats are written, so it can't be
+// deleted between the existence check and the upsert.
+func (h *AnalyticsHandler) insertCategoryStats(ctx context.Context, stats CategoryStatsRequest) error {
+	return h.db.WithTx(ctx, func(tx *sql.Tx) error {
+		return h.upsertCategoryStats(ctx, tx, stats)
+	})
+}
 
-	// Insert or update category stats
-	_, err = h.db.Exec(`
+// upsertCategoryStats runs the statements of insertCategoryStats on tx.
+func (h *AnalyticsHandler) upsertCategoryStats(ctx context.Context, tx *sql.Tx, stats CategoryStatsRequest) error {
+	var sessionID string
+	err := tx.QueryRowContext(ctx, h.db.Rebind("SELECT session_id FROM sessions WHERE session_id = ? FOR UPDATE"),
+		stats.SessionID).Scan(&sessionID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return errSessionNotFound
+	}
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, h.db.Rebind(`
 		INSERT INTO category_stats (
-			session_id, category_name, total_cards, accepted_cards, 
+			session_id, category_name, total_cards, accepted_cards,
 			average_decision_time, completion_time
 		) VALUES (?, ?, 1, ?, 0, 0)
-		ON DUPLICATE KEY UPDATE
-			accepted_cards = accepted_cards + VALUES(accepted_cards),
-			total_cards = total_cards + 1
-	`,
+	`+h.db.Upsert([]string{"session_id", "category_name"},
+		"accepted_cards = category_stats.accepted_cards + "+h.db.Inserted("accepted_cards"),
+		"total_cards = category_stats.total_cards + 1",
+	)),
 		stats.SessionID,
 		stats.Category,
 		stats.SuccessRate,
 	)
-
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to record category statistics"})
-		return
-	}
-
-	c.JSON(http.StatusCreated, gin.H{"status": "success"})
+	return err
 }
 
 // getStats handles the retrieval of aggregated analytics data.
 // It requires admin authentication and returns comprehensive statistics
-// about sessions, events, and performance metrics.
+// about sessions, events, and performance metrics. Sections that fail are
+// left empty and listed in errors, with a 207 status, so one failing query
+// doesn't blank the whole dashboard. include=summary skips the raw listings
+// and include=raw the aggregated statistics, along with their queries.
 func (h *AnalyticsHandler) getStats(c *gin.Context) {
-	// Verify admin authentication
-	adminSecret := c.GetHeader("X-Admin-Secret")
-	if adminSecret == "" {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Missing admin secret key"})
-		return
-	}
+	ctx := c.Request.Context()
 
-	if adminSecret != os.Getenv("ADMIN_SECRET_KEY") {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid admin secret key"})
+	filter, err := h.parseStatsFilter(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, h.errorBody(c, err.Error()))
 		return
 	}
 
-	// Retrieve raw data
-	sessionStats, err := h.getSessionStatistics()
+	// Resolve the incremental export window, if the caller asked for one
+	options, conditional, err := h.parseRawDataOptions(c)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get session statistics"})
+		c.JSON(http.StatusBadRequest, h.errorBody(c, err.Error()))
 		return
 	}
+	options.Range = filter.Range
 
-	performanceStats, err := h.getPerformanceStatistics()
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get performance statistics"})
+	include := c.DefaultQuery("include", "all")
+	if include != "all" && include != "summary" && include != "raw" {
+		c.JSON(http.StatusBadRequest, h.errorBody(c, "invalid include: must be summary, raw, or all"))
 		return
 	}
+	includeRaw := include != "summary"
+	includeSummary := include != "raw"
+
+	// Conditional requests cover the raw row exports only. The aggregated
+	// statistics also change through category upserts and deletions that
+	// no row time reflects, so they are always computed afresh, and an
+	// If-Modified-Since header sent along, as browsers do, is ignored.
+	rawExport := include == "raw"
+	if wantsCSV(c) {
+		rawExport = c.Query("table") == "events"
+	}
+	if !rawExport && conditional {
+		options.ModifiedSince = time.Time{}
+	}
+	if rawExport {
+		lastModified, err := h.getLastModified(ctx)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, h.errorBody(c, "Failed to determine last modification time"))
+			return
+		}
+		if !lastModified.IsZero() {
+			c.Header("Last-Modified", lastModified.UTC().Format(http.TimeFormat))
+		}
+		if !options.ModifiedSince.IsZero() {
+			newest := lastModified
+			if conditional {
+				// HTTP dates only carry whole seconds
+				newest = newest.Truncate(time.Second)
+			}
+			if !newest.After(options.ModifiedSince) {
+				h.setCacheControl(c, filter.Range)
+				c.Status(http.StatusNotModified)
+				return
+			}
+		}
+	}
 
-	eventStats, err := h.getEventStatistics()
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get event statistics"})
+	// The CSV format carries either the events table or the aggregated
+	// statistics
+	if wantsCSV(c) {
+		switch c.Query("table") {
+		case "":
+		case "events":
+			h.setCacheControl(c, filter.Range)
+			h.streamEventsCSV(c, options)
+			return
+		default:
+			c.JSON(http.StatusBadRequest, h.errorBody(c, "invalid table: only events can be exported as CSV"))
+			return
+		}
+
+		aggregatedStats, err := h.getAggregatedStatistics(ctx, filter)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, h.errorBody(c, "Failed to calculate aggregated statistics"))
+			return
+		}
+		h.setCacheControl(c, filter.Range)
+		writeStatsCSV(c, aggregatedStats)
 		return
 	}
 
+	// Each section is computed independently; a failing section is reported
+	// in errors instead of failing the whole response
+	sectionErrors := make(map[string]string)
+	section := func(name, message string, err error) {
+		if err != nil {
+			log.Printf("Stats section %s failed [request_id=%s]: %v", name, c.GetString(requestIDKey), err)
+			sectionErrors[name] = message
+		}
+	}
+	// labelled tags the queries of a section, so failed queries are logged
+	// with the section they belong to
+	labelled := func(name string) context.Context {
+		return storage.WithQueryLabel(ctx, name)
+	}
+
+	response := gin.H{}
+	sections := 0
+
+	// Retrieve raw data
+	if includeRaw {
+		sessionStats, sessionTotal, err := h.getSessionStatistics(labelled("raw_data.sessions"), options)
+		section("raw_data.sessions", "Failed to get session statistics", err)
+
+		performanceStats, performanceTotal, err := h.getPerformanceStatistics(labelled("raw_data.performance"), options)
+		section("raw_data.performance", "Failed to get performance statistics", err)
+
+		eventStats, eventTotal, err := h.getEventStatistics(labelled("raw_data.events"), options)
+		section("raw_data.events", "Failed to get event statistics", err)
+
+		sections += 3
+		response["raw_data"] = gin.H{
+			"sessions":    selectFields(sessionStats, options.Fields["sessions"]),
+			"performance": selectFields(performanceStats, options.Fields["performance"]),
+			"events":      selectFields(eventStats, options.Fields["events"]),
+		}
+		response["pagination"] = gin.H{
+			"limit":  options.Limit,
+			"offset": options.Offset,
+			"total": gin.H{
+				"sessions":    sessionTotal,
+				"performance": performanceTotal,
+				"events":      eventTotal,
+			},
+		}
+		response["cursors"] = gin.H{
+			"sessions":    rawDataCursor(sessionStats, options.SinceIDs["sessions"]),
+			"performance": rawDataCursor(performanceStats, options.SinceIDs["performance"]),
+			"events":      rawDataCursor(eventStats, options.SinceIDs["events"]),
+		}
+	}
+
 	// Calculate aggregated statistics
-	aggregatedStats, err := h.getAggregatedStatistics()
+	if includeSummary {
+		aggregatedStats, err := h.getAggregatedStatistics(labelled("statistics"), filter)
+		section("statistics", "Failed to calculate aggregated statistics", err)
+
+		sections++
+		response["statistics"] = aggregatedStats
+	}
+
+	status := http.StatusOK
+	if len(sectionErrors) > 0 {
+		response["errors"] = sectionErrors
+		if h.cfg.EchoRequestID {
+			response["request_id"] = c.GetString(requestIDKey)
+		}
+		status = http.StatusMultiStatus
+		if len(sectionErrors) == sections {
+			status = http.StatusInternalServerError
+		}
+	}
+
+	// Partial results must not be cached, even for a closed range
+	if status == http.StatusOK {
+		h.setCacheControl(c, filter.Range)
+	} else {
+		c.Header("Cache-Control", "no-cache")
+	}
+	c.JSON(status, response)
+}
+
+// errResultTooLarge is returned by parseRawDataOptions when the requested
+// page exceeds maxPageLimit or the configured MaxResultRows.
+var errResultTooLarge = errors.New("result exceeds the maximum number of rows")
+
+// checkResultSize logs a warning once a raw data listing reaches 80% of the
+// MaxResultRows guard, so the limit can be raised before callers hit it.
+func (h *AnalyticsHandler) checkResultSize(name string, rows int) {
+	limit := h.cfg.MaxResultRows
+	if rows >= limit*8/10 {
+		log.Printf("Warning: %s listing returned %d rows, approaching the limit of %d", name, rows, limit)
+	}
+}
+
+// rawDataOptions controls which rows the raw data helpers return.
+type rawDataOptions struct {
+	// ModifiedSince restricts the result to rows created strictly after
+	// this time. The zero value disables the filter.
+	ModifiedSince time.Time
+	// SinceIDs holds, per listing, the id cursor returned by a previous
+	// poll; only rows with a larger id are returned.
+	SinceIDs map[string]int64
+	// Fields holds, per listing, the fields to return; listings without
+	// an entry return all fields.
+	Fields map[string][]string
+	// Limit and Offset select the page of each listing to return.
+	Limit  int
+	Offset int
+	// Range restricts the listings to rows recorded within it.
+	Range timeRange
+	// UserID restricts the listings to one user's sessions. An empty
+	// UserID disables the filter.
+	UserID string
+	// ClientDetails includes the IP address and user agent of sessions,
+	// which only admin-scope keys may read.
+	ClientDetails bool
+}
+
+// rawDataCursorParams maps each raw data listing to the query parameter
+// carrying its id cursor.
+var rawDataCursorParams = map[string]string{
+	"sessions":    "sessions_since_id",
+	"performance": "performance_since_id",
+	"events":      "events_since_id",
+}
+
+// parseRawDataOptions reads the raw data options from the request. The
+// modified_since query parameter (RFC3339) takes precedence over the
+// If-Modified-Since header. The returned flag reports whether the time came
+// from the header.
+func (h *AnalyticsHandler) parseRawDataOptions(c *gin.Context) (rawDataOptions, bool, error) {
+	options := rawDataOptions{SinceIDs: make(map[string]int64)}
+
+	for listing, param := range rawDataCursorParams {
+		value := c.Query(param)
+		if value == "" {
+			continue
+		}
+		id, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || id < 0 {
+			return options, false, fmt.Errorf("invalid %s: must be a non-negative integer", param)
+		}
+		options.SinceIDs[listing] = id
+	}
+
+	options.ClientDetails = config.ScopeRank(c.GetString(adminScopeKey)) >= config.ScopeRank(config.ScopeAdmin)
+	fields, err := parseFieldsets(c)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to calculate aggregated statistics"})
-		return
+		return options, false, err
+	}
+	if !options.ClientDetails {
+		for _, field := range fields["sessions"] {
+			if containsString(sessionClientFields, field) {
+				return options, false, fmt.Errorf("invalid fields[sessions]: %s requires an admin-scope key", field)
+			}
+		}
+	}
+	options.Fields = fields
+
+	// Pages are at most maxPageLimit rows, and MaxResultRows can lower that
+	// bound on the rows a listing assembles in memory; larger pages are
+	// refused rather than truncated
+	maxLimit := min(maxPageLimit, h.cfg.MaxResultRows)
+	if options.Limit, err = queryInt(c, "limit", min(defaultPageLimit, maxLimit), 1, math.MaxInt32); err != nil {
+		return options, false, err
+	}
+	if options.Limit > maxLimit {
+		return options, false, fmt.Errorf("%w: limit must be at most %d; paginate with offset or stream the events as CSV", errResultTooLarge, maxLimit)
+	}
+	if options.Offset, err = queryInt(c, "offset", 0, 0, math.MaxInt32); err != nil {
+		return options, false, err
 	}
 
-	// Combine all data into a single response
-	response := gin.H{
-		"raw_data": gin.H{
-			"sessions":    sessionStats,
-			"performance": performanceStats,
-			"events":      eventStats,
-		},
-		"statistics": aggregatedStats,
+	if value := c.Query("modified_since"); value != "" {
+		since, err := time.Parse(time.RFC3339Nano, value)
+		if err != nil {
+			return options, false, fmt.Errorf("invalid modified_since: expected RFC3339 timestamp")
+		}
+		options.ModifiedSince = since
+		return options, false, nil
+	}
+
+	if value := c.GetHeader("If-Modified-Since"); value != "" {
+		// Malformed headers are ignored, as required by RFC 9110
+		if since, err := http.ParseTime(value); err == nil {
+			options.ModifiedSince = since
+			return options, true, nil
+		}
 	}
 
-	c.JSON(http.StatusOK, response)
+	return options, false, nil
+}
+
+// where returns a WHERE clause and its arguments restricting a listing to
+// rows whose timeColumn is after ModifiedSince and whose id is after the
+// listing's cursor, or an empty clause when neither applies.
+func (o rawDataOptions) where(listing, timeColumn string) (string, []interface{}) {
+	conditions, args := o.Range.conditions(timeColumn)
+	if !o.ModifiedSince.IsZero() {
+		conditions = append(conditions, timeColumn+" > ?")
+		args = append(args, o.ModifiedSince)
+	}
+	if sinceID, ok := o.SinceIDs[listing]; ok {
+		conditions = append(conditions, "id > ?")
+		args = append(args, sinceID)
+	}
+	if o.UserID != "" {
+		if listing == "sessions" {
+			conditions = append(conditions, "user_id = ?")
+		} else {
+			conditions = append(conditions, "session_id IN (SELECT session_id FROM sessions WHERE user_id = ?)")
+		}
+		args = append(args, o.UserID)
+	}
+	if len(conditions) == 0 {
+		return "", nil
+	}
+	return "WHERE " + strings.Join(conditions, " AND "), args
+}
+
+// countRawData counts the rows of table matching a raw listing's WHERE
+// clause, for the pagination metadata.
+func (h *AnalyticsHandler) countRawData(ctx context.Context, table, where string, args []interface{}) (int, error) {
+	var total int
+	err := h.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM "+table+" "+where, args...).Scan(&total)
+	return total, err
+}
+
+// rawDataCursor returns the id cursor to pass on the next poll of a
+// listing: the largest id in rows, or sinceID when rows is empty.
+func rawDataCursor(rows []map[string]interface{}, sinceID int64) int64 {
+	cursor := sinceID
+	for _, row := range rows {
+		if id, ok := row["id"].(int64); ok && id > cursor {
+			cursor = id
+		}
+	}
+	return cursor
+}
+
+// getLastModified returns the newest change to the raw listings: the newest
+// row timestamp across the sessions, events, and performance_metrics
+// tables, or session end, or the zero time when all of them are empty.
+func (h *AnalyticsHandler) getLastModified(ctx context.Context) (time.Time, error) {
+	var sessions, ended, events, performance sql.NullTime
+	err := h.db.QueryRowContext(ctx, `
+		SELECT
+			(SELECT MAX(created_at) FROM sessions),
+			(SELECT MAX(ended_at) FROM sessions),
+			(SELECT MAX(created_at) FROM events),
+			(SELECT MAX(timestamp) FROM performance_metrics)
+	`).Scan(&sessions, &ended, &events, &performance)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	var newest time.Time
+	for _, t := range []sql.NullTime{sessions, ended, events, performance} {
+		if t.Valid && t.Time.After(newest) {
+			newest = t.Time
+		}
+	}
+	return newest, nil
 }
 
 // getAggregatedStatistics calculates comprehensive aggregated statistics
-// from the collected analytics data.
-func (h *AnalyticsHandler) getAggregatedStatistics() (gin.H, error) {
+// from the collected analytics data, restricted to the sessions selected
+// by filter.
+func (h *AnalyticsHandler) getAggregatedStatistics(ctx context.Context, filter statsFilter) (gin.H, error) {
 	// Session statistics
-	var totalSessions int
-	err := h.db.QueryRow(`
-		SELECT COUNT(*) as total_sessions
+	// Only ended sessions have a duration; AVG yields NULL when there are
+	// none
+	var totalSessions, completedSessions int
+	var avgSessionDuration sql.NullFloat64
+	where, args := filter.where("sessions")
+	err := h.db.QueryRowContext(ctx, `
+		SELECT
+			COUNT(*) as total_sessions,
+			COUNT(ended_at) as completed_sessions,
+			AVG(CASE WHEN ended_at IS NOT NULL THEN `+h.db.SecondsBetween("created_at", "ended_at")+` END) as avg_session_duration
 		FROM sessions
-	`).Scan(&totalSessions)
+		`+where, args...).Scan(&totalSessions, &completedSessions, &avgSessionDuration)
 	if err != nil {
 		return nil, fmt.Errorf("error getting session statistics: %v", err)
 	}
 
 	// Performance metrics averages
-	var avgFPS, avgMemoryUsage, avgCPUUsage, avgGPUUsage, avgNetworkLatency sql.NullFloat64
-	err = h.db.QueryRow(`
+	// Each stored sample stands for 1/sample_rate samples, so averages are
+	// weighted accordingly and stay correct when the sample rate changes
+	var avgFPS, avgMemoryUsage, avgCPUUsage, avgGPUUsage, avgNetworkLatency, estimatedSamples sql.NullFloat64
+	var storedSamples int
+	where, args = filter.where("performance_metrics")
+	err = h.db.QueryRowContext(ctx, `
 		SELECT 
-			AVG(COALESCE(fps, 0)) as avg_fps,
-			AVG(COALESCE(memory_usage, 0)) as avg_memory,
-			AVG(COALESCE(cpu_usage, 0)) as avg_cpu,
-			AVG(COALESCE(gpu_usage, 0)) as avg_gpu,
-			AVG(COALESCE(network_latency, 0)) as avg_network
+			SUM(COALESCE(fps, 0) / COALESCE(sample_rate, 1)) / SUM(1 / COALESCE(sample_rate, 1)) as avg_fps,
+			SUM(COALESCE(memory_usage, 0) / COALESCE(sample_rate, 1)) / SUM(1 / COALESCE(sample_rate, 1)) as avg_memory,
+			SUM(COALESCE(cpu_usage, 0) / COALESCE(sample_rate, 1)) / SUM(1 / COALESCE(sample_rate, 1)) as avg_cpu,
+			SUM(COALESCE(gpu_usage, 0) / COALESCE(sample_rate, 1)) / SUM(1 / COALESCE(sample_rate, 1)) as avg_gpu,
+			SUM(COALESCE(network_latency, 0) / COALESCE(sample_rate, 1)) / SUM(1 / COALESCE(sample_rate, 1)) as avg_network,
+			COUNT(*) as stored_samples,
+			SUM(1 / COALESCE(sample_rate, 1)) as estimated_samples
 		FROM performance_metrics
-	`).Scan(&avgFPS, &avgMemoryUsage, &avgCPUUsage, &avgGPUUsage, &avgNetworkLatency)
+		`+where, args...).Scan(&avgFPS, &avgMemoryUsage, &avgCPUUsage, &avgGPUUsage, &avgNetworkLatency, &storedSamples, &estimatedSamples)
 	if err != nil {
 		return nil, fmt.Errorf("error getting performance metrics: %v", err)
 	}
 
 	// Event statistics
-	var totalEvents, totalSwipes, successfulSwipes int
+	// Swipes without a recorded success value count as unsuccessful
+	var totalEvents, totalSwipes, successfulSwipes, totalUndos int
 	var avgSwipeDuration, avgSwipeDistance, avgRotation sql.NullFloat64
-	err = h.db.QueryRow(`
+	where, args = filter.where("events")
+	err = h.db.QueryRowContext(ctx, `
 		SELECT 
 			COUNT(*) as total_events,
 			COUNT(CASE WHEN event_type = 'card_swipe' THEN 1 END) as total_swipes,
-			COUNT(CASE WHEN event_type = 'card_swipe' AND success = true THEN 1 END) as successful_swipes,
+			COUNT(CASE WHEN event_type = 'card_swipe' AND COALESCE(success, false) = true THEN 1 END) as successful_swipes,
 			AVG(CASE WHEN event_type = 'card_swipe' THEN COALESCE(duration, 0) ELSE NULL END) as avg_duration,
 			AVG(CASE WHEN event_type = 'card_swipe' THEN COALESCE(ABS(end_x - start_x), 0) ELSE NULL END) as avg_distance,
-			AVG(CASE WHEN event_type = 'card_swipe' THEN COALESCE(max_rotation, 0) ELSE NULL END) as avg_rotation
+			AVG(CASE WHEN event_type = 'card_swipe' AND ABS(COALESCE(max_rotation, 0)) <= ? THEN COALESCE(max_rotation, 0) ELSE NULL END) as avg_rotation,
+			COUNT(CASE WHEN event_type = ? THEN 1 END) as total_undos
 		FROM events
-	`).Scan(&totalEvents, &totalSwipes, &successfulSwipes, &avgSwipeDuration, &avgSwipeDistance, &avgRotation)
+		`+where, append([]interface{}{h.cfg.MaxRotation, eventUndo}, args...)...).Scan(&totalEvents, &totalSwipes, &successfulSwipes, &avgSwipeDuration, &avgSwipeDistance, &avgRotation, &totalUndos)
 	if err != nil {
 		return nil, fmt.Errorf("error getting event statistics: %v", err)
 	}
 
-	// Category statistics
-	rows, err := h.db.Query(`
+	categoryStats, err := h.getCategoryStatistics(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+
+	// Platform distribution
+	where, args = filter.where("sessions")
+	rows, err := h.db.QueryContext(ctx, `
+		SELECT 
+			platform,
+			COUNT(*) as total_sessions,
+			COUNT(DISTINCT user_id) as unique_users
+		FROM sessions
+		`+where+`
+		GROUP BY platform
+		ORDER BY total_sessions DESC
+	`, args...)
+	if err != nil {
+		return nil, fmt.Errorf("error getting platform statistics: %v", err)
+	}
+	defer rows.Close()
+
+	var platformStats []map[string]interface{}
+	for rows.Next() {
+		var platform string
+		var totalSessions, uniqueUsers int
+		if err := rows.Scan(&platform, &totalSessions, &uniqueUsers); err != nil {
+			return nil, fmt.Errorf("error scanning platform statistics: %v", err)
+		}
+		platformStats = append(platformStats, map[string]interface{}{
+			"platform":       platform,
+			"total_sessions": totalSessions,
+			"unique_users":   uniqueUsers,
+		})
+	}
+
+	sessionLengthHistogram, err := h.getSessionLengthHistogram(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+
+	sessionsPerUser, err := h.getSessionsPerUser(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+
+	rotationByDirection, err := h.getRotationByDirection(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+
+	decisionTime, err := h.getDecisionTimeStatistics(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+
+	completionByPlatform, err := h.getCompletionByPlatform(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+
+	tutorial, err := h.getTutorialStatistics(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+
+	undosByCard, err := h.getUndosByCard(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+
+	successByVelocity, err := h.getSuccessByVelocity(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+
+	// Calculate swipe success rate (handle division by zero)
+	swipeSuccessRate := 0.0
+	if totalSwipes > 0 {
+		swipeSuccessRate = float64(successfulSwipes) / float64(totalSwipes) * 100
+	}
+	undoRate := 0.0
+	if totalSwipes > 0 {
+		undoRate = float64(totalUndos) / float64(totalSwipes)
+	}
+
+	return gin.H{
+		"sessions": gin.H{
+			"total_sessions":               totalSessions,
+			"completed_sessions":           completedSessions,
+			"abandoned_sessions":           totalSessions - completedSessions,
+			"avg_session_duration_seconds": avgSessionDuration.Float64,
+			"session_length_histogram":     sessionLengthHistogram,
+			"sessions_per_user":            sessionsPerUser,
+		},
+		"performance": gin.H{
+			"stored_samples":      storedSamples,
+			"estimated_samples":   estimatedSamples.Float64,
+			"avg_fps":             avgFPS.Float64,
+			"avg_memory_usage":    avgMemoryUsage.Float64,
+			"avg_cpu_usage":       avgCPUUsage.Float64,
+			"avg_gpu_usage":       avgGPUUsage.Float64,
+			"avg_network_latency": avgNetworkLatency.Float64,
+		},
+		"events": gin.H{
+			"total_events":          totalEvents,
+			"total_swipes":          totalSwipes,
+			"successful_swipes":     successfulSwipes,
+			"swipe_success_rate":    swipeSuccessRate,
+			"avg_swipe_duration":    avgSwipeDuration.Float64,
+			"avg_swipe_distance":    avgSwipeDistance.Float64,
+			"avg_rotation":          avgRotation.Float64,
+			"rotation_by_direction": rotationByDirection,
+			"decision_time":         decisionTime,
+			"total_undos":           totalUndos,
+			"undo_rate":             undoRate,
+			"undos_by_card":         undosByCard,
+			"success_by_velocity":   successByVelocity,
+		},
+		"categories":             categoryStats,
+		"platforms":              platformStats,
+		"completion_by_platform": completionByPlatform,
+		"tutorial":               tutorial,
+	}, nil
+}
+
+// getCategoryStatistics aggregates the category stats per category, with
+// the share of cards accepted as success_rate, ordered by total cards.
+func (h *AnalyticsHandler) getCategoryStatistics(ctx context.Context, filter statsFilter) ([]map[string]interface{}, error) {
+	where, args := filter.where("category_stats")
+	rows, err := h.db.QueryContext(ctx, `
 		SELECT 
 			category_name,
 			COALESCE(SUM(total_cards), 0) as total_cards,
@@ -370,9 +1327,10 @@ func (h *AnalyticsHandler) getAggregatedStatistics() (gin.H, error) {
 			AVG(COALESCE(completion_time, 0)) as avg_completion_time,
 			COUNT(DISTINCT session_id) as unique_sessions
 		FROM category_stats
+		`+where+`
 		GROUP BY category_name
 		ORDER BY total_cards DESC
-	`)
+	`, args...)
 	if err != nil {
 		return nil, fmt.Errorf("error getting category statistics: %v", err)
 	}
@@ -400,111 +1358,433 @@ func (h *AnalyticsHandler) getAggregatedStatistics() (gin.H, error) {
 			"unique_sessions":     uniqueSessions,
 		})
 	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error reading category statistics: %v", err)
+	}
+	return categoryStats, nil
+}
 
-	// Platform distribution
-	rows, err = h.db.Query(`
-		SELECT 
+// getSessionLengthHistogram counts the swipes of every session and groups the
+// sessions into the buckets configured by SessionLengthBuckets. Sessions
+// without any swipes are not part of the histogram.
+func (h *AnalyticsHandler) getSessionLengthHistogram(ctx context.Context, filter statsFilter) ([]map[string]interface{}, error) {
+	bounds := h.cfg.SessionLengthBuckets
+	counts := make([]int, len(bounds)+1)
+
+	where, args := filter.where("events", "event_type = 'card_swipe'")
+	rows, err := h.db.QueryContext(ctx, `
+		SELECT COUNT(*) as swipe_count
+		FROM events
+		`+where+`
+		GROUP BY session_id
+	`, args...)
+	if err != nil {
+		return nil, fmt.Errorf("error getting session lengths: %v", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var swipeCount int
+		if err := rows.Scan(&swipeCount); err != nil {
+			return nil, fmt.Errorf("error scanning session lengths: %v", err)
+		}
+		bucket := len(bounds)
+		for i, upper := range bounds {
+			if swipeCount <= upper {
+				bucket = i
+				break
+			}
+		}
+		counts[bucket]++
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error reading session lengths: %v", err)
+	}
+
+	histogram := make([]map[string]interface{}, 0, len(counts))
+	lower := 1
+	for i, count := range counts {
+		bucket := map[string]interface{}{
+			"min_swipes": lower,
+			"sessions":   count,
+		}
+		if i < len(bounds) {
+			bucket["range"] = fmt.Sprintf("%d-%d", lower, bounds[i])
+			bucket["max_swipes"] = bounds[i]
+			lower = bounds[i] + 1
+		} else {
+			bucket["range"] = fmt.Sprintf("%d+", lower)
+		}
+		histogram = append(histogram, bucket)
+	}
+
+	return histogram, nil
+}
+
+// getSessionsPerUser computes how often users come back: the average,
+// median, and 95th percentile number of sessions per user, and how many
+// users only ever played a single session.
+func (h *AnalyticsHandler) getSessionsPerUser(ctx context.Context, filter statsFilter) (gin.H, error) {
+	where, args := filter.where("sessions")
+	rows, err := h.db.QueryContext(ctx, `
+		SELECT COUNT(*) as session_count
+		FROM sessions
+		`+where+`
+		GROUP BY user_id
+	`, args...)
+	if err != nil {
+		return nil, fmt.Errorf("error getting sessions per user: %v", err)
+	}
+	defer rows.Close()
+
+	var counts []float64
+	singleSessionUsers := 0
+	for rows.Next() {
+		var count int
+		if err := rows.Scan(&count); err != nil {
+			return nil, fmt.Errorf("error scanning sessions per user: %v", err)
+		}
+		if count == 1 {
+			singleSessionUsers++
+		}
+		counts = append(counts, float64(count))
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error reading sessions per user: %v", err)
+	}
+
+	return gin.H{
+		"users":                 len(counts),
+		"avg_sessions_per_user": mean(counts),
+		"p50_sessions_per_user": percentile(counts, 50),
+		"p95_sessions_per_user": percentile(counts, 95),
+		"single_session_users":  singleSessionUsers,
+		"returning_users":       len(counts) - singleSessionUsers,
+	}, nil
+}
+
+// getCompletionByPlatform counts, per platform, the sessions that were
+// properly ended and those that were abandoned, i.e. never ended, along
+// with the fraction of sessions completed.
+func (h *AnalyticsHandler) getCompletionByPlatform(ctx context.Context, filter statsFilter) ([]map[string]interface{}, error) {
+	where, args := filter.where("sessions")
+	rows, err := h.db.QueryContext(ctx, `
+		SELECT
 			platform,
 			COUNT(*) as total_sessions,
-			COUNT(DISTINCT user_id) as unique_users
+			COUNT(ended_at) as completed_sessions
 		FROM sessions
+		`+where+`
 		GROUP BY platform
-		ORDER BY total_sessions DESC
-	`)
+		ORDER BY platform
+	`, args...)
 	if err != nil {
-		return nil, fmt.Errorf("error getting platform statistics: %v", err)
+		return nil, fmt.Errorf("error getting completion by platform: %v", err)
 	}
 	defer rows.Close()
 
-	var platformStats []map[string]interface{}
+	completion := make([]map[string]interface{}, 0)
 	for rows.Next() {
 		var platform string
-		var totalSessions, uniqueUsers int
-		if err := rows.Scan(&platform, &totalSessions, &uniqueUsers); err != nil {
-			return nil, fmt.Errorf("error scanning platform statistics: %v", err)
+		var total, completed int
+		if err := rows.Scan(&platform, &total, &completed); err != nil {
+			return nil, fmt.Errorf("error scanning completion by platform: %v", err)
 		}
-		platformStats = append(platformStats, map[string]interface{}{
-			"platform":       platform,
-			"total_sessions": totalSessions,
-			"unique_users":   uniqueUsers,
+		completion = append(completion, map[string]interface{}{
+			"platform":           platform,
+			"total_sessions":     total,
+			"completed_sessions": completed,
+			"abandoned_sessions": total - completed,
+			"completion_rate":    float64(completed) / float64(total),
 		})
 	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error reading completion by platform: %v", err)
+	}
 
-	// Calculate swipe success rate (handle division by zero)
-	swipeSuccessRate := 0.0
-	if totalSwipes > 0 {
-		swipeSuccessRate = float64(successfulSwipes) / float64(totalSwipes) * 100
+	return completion, nil
+}
+
+// eventUndo is the event type recorded when the user takes back a swipe;
+// its card_id names the card whose swipe was undone.
+const eventUndo = "undo"
+
+// maxUndoCards is the number of most undone cards listed in the events
+// statistics.
+const maxUndoCards = 20
+
+// getUndosByCard counts undo events per card and returns the most undone
+// cards, most undone first. Undos without a card_id are left out.
+func (h *AnalyticsHandler) getUndosByCard(ctx context.Context, filter statsFilter) ([]map[string]interface{}, error) {
+	where, args := filter.where("events", "event_type = ?", "card_id IS NOT NULL", "card_id <> ''")
+	rows, err := h.db.QueryContext(ctx, `
+		SELECT card_id, COUNT(*) as undos
+		FROM events
+		`+where+`
+		GROUP BY card_id
+		ORDER BY undos DESC, card_id
+		LIMIT ?
+	`, append(append([]interface{}{eventUndo}, args...), maxUndoCards)...)
+	if err != nil {
+		return nil, fmt.Errorf("error getting undos by card: %v", err)
+	}
+	defer rows.Close()
+
+	cards := make([]map[string]interface{}, 0)
+	for rows.Next() {
+		var cardID string
+		var undos int
+		if err := rows.Scan(&cardID, &undos); err != nil {
+			return nil, fmt.Errorf("error scanning undos by card: %v", err)
+		}
+		cards = append(cards, map[string]interface{}{
+			"card_id": cardID,
+			"undos":   undos,
+		})
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error getting undos by card: %v", err)
+	}
+
+	return cards, nil
+}
+
+// Tutorial event types. A session that both skipped and completed the
+// tutorial counts as completed.
+const (
+	eventTutorialComplete = "tutorial_complete"
+	eventTutorialSkip     = "tutorial_skip"
+)
+
+// getTutorialStatistics reports how many sessions and users completed or
+// skipped the tutorial, based on tutorial_complete and tutorial_skip
+// events, relative to all sessions and users. Sessions with neither never
+// reached the end of the tutorial.
+func (h *AnalyticsHandler) getTutorialStatistics(ctx context.Context, filter statsFilter) (gin.H, error) {
+	where, args := filter.where("sessions")
+	var totalSessions, totalUsers, completedSessions, skippedSessions, completedUsers int
+	err := h.db.QueryRowContext(ctx, `
+		SELECT
+			COUNT(*) as total_sessions,
+			COUNT(DISTINCT user_id) as total_users,
+			COUNT(CASE WHEN tutorial.completed = 1 THEN 1 END) as completed_sessions,
+			COUNT(CASE WHEN tutorial.skipped = 1 AND tutorial.completed = 0 THEN 1 END) as skipped_sessions,
+			COUNT(DISTINCT CASE WHEN tutorial.completed = 1 THEN user_id END) as completed_users
+		FROM sessions
+		LEFT JOIN (
+			SELECT
+				session_id as tutorial_session_id,
+				MAX(CASE WHEN event_type = ? THEN 1 ELSE 0 END) as completed,
+				MAX(CASE WHEN event_type = ? THEN 1 ELSE 0 END) as skipped
+			FROM events
+			WHERE event_type IN (?, ?)
+			GROUP BY session_id
+		) tutorial ON tutorial.tutorial_session_id = sessions.session_id
+		`+where,
+		append([]interface{}{eventTutorialComplete, eventTutorialSkip, eventTutorialComplete, eventTutorialSkip}, args...)...,
+	).Scan(&totalSessions, &totalUsers, &completedSessions, &skippedSessions, &completedUsers)
+	if err != nil {
+		return nil, fmt.Errorf("error getting tutorial statistics: %v", err)
+	}
+
+	var sessionRate, userRate float64
+	if totalSessions > 0 {
+		sessionRate = float64(completedSessions) / float64(totalSessions)
+	}
+	if totalUsers > 0 {
+		userRate = float64(completedUsers) / float64(totalUsers)
 	}
 
 	return gin.H{
-		"sessions": gin.H{
-			"total_sessions": totalSessions,
-		},
-		"performance": gin.H{
-			"avg_fps":             avgFPS.Float64,
-			"avg_memory_usage":    avgMemoryUsage.Float64,
-			"avg_cpu_usage":       avgCPUUsage.Float64,
-			"avg_gpu_usage":       avgGPUUsage.Float64,
-			"avg_network_latency": avgNetworkLatency.Float64,
-		},
-		"events": gin.H{
-			"total_events":       totalEvents,
-			"total_swipes":       totalSwipes,
-			"successful_swipes":  successfulSwipes,
-			"swipe_success_rate": swipeSuccessRate,
-			"avg_swipe_duration": avgSwipeDuration.Float64,
-			"avg_swipe_distance": avgSwipeDistance.Float64,
-			"avg_rotation":       avgRotation.Float64,
-		},
-		"categories": categoryStats,
-		"platforms":  platformStats,
+		"completed_sessions":      completedSessions,
+		"skipped_sessions":        skippedSessions,
+		"session_completion_rate": sessionRate,
+		"completed_users":         completedUsers,
+		"user_completion_rate":    userRate,
 	}, nil
 }
 
+// decisionTimeTrim is the fraction of the fastest and the slowest swipes
+// left out of the trimmed mean decision time.
+const decisionTimeTrim = 0.1
+
+// getDecisionTimeStatistics computes the median and trimmed mean duration
+// of real swipes, i.e. those travelling more than MinSwipeDistance. Taps
+// and accidental touches with little or no distance would otherwise skew
+// the average decision time.
+func (h *AnalyticsHandler) getDecisionTimeStatistics(ctx context.Context, filter statsFilter) (gin.H, error) {
+	where, args := filter.where("events",
+		"event_type = 'card_swipe'",
+		"duration IS NOT NULL",
+		"swipe_distance > ?")
+	args = append([]interface{}{h.cfg.MinSwipeDistance}, args...)
+	rows, err := h.db.QueryContext(ctx, `
+		SELECT duration
+		FROM events
+		`+where, args...)
+	if err != nil {
+		return nil, fmt.Errorf("error getting decision times: %v", err)
+	}
+	defer rows.Close()
+
+	var durations []float64
+	for rows.Next() {
+		var duration float64
+		if err := rows.Scan(&duration); err != nil {
+			return nil, fmt.Errorf("error scanning decision times: %v", err)
+		}
+		durations = append(durations, duration)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error reading decision times: %v", err)
+	}
+
+	return gin.H{
+		"min_swipe_distance": h.cfg.MinSwipeDistance,
+		"swipes":             len(durations),
+		"median":             percentile(durations, 50),
+		"trimmed_mean":       trimmedMean(durations, decisionTimeTrim),
+	}, nil
+}
+
+// getRotationByDirection computes the average and 95th percentile of the
+// absolute max_rotation of swipes, grouped by normalized swipe direction.
+// Rotations beyond MaxRotation, recorded before validation existed, are
+// left out.
+// Percentiles are computed in Go since MySQL has no percentile aggregate.
+func (h *AnalyticsHandler) getRotationByDirection(ctx context.Context, filter statsFilter) ([]map[string]interface{}, error) {
+	where, args := filter.where("events",
+		"event_type = 'card_swipe'",
+		"direction IS NOT NULL AND TRIM(direction) <> ''",
+		"max_rotation IS NOT NULL",
+		"ABS(max_rotation) <= ?")
+	args = append([]interface{}{h.cfg.MaxRotation}, args...)
+	rows, err := h.db.QueryContext(ctx, `
+		SELECT
+			LOWER(TRIM(direction)) as direction,
+			ABS(max_rotation) as rotation
+		FROM events
+		`+where, args...)
+	if err != nil {
+		return nil, fmt.Errorf("error getting rotation by direction: %v", err)
+	}
+	defer rows.Close()
+
+	rotations := make(map[string][]float64)
+	var directions []string
+	for rows.Next() {
+		var direction string
+		var rotation float64
+		if err := rows.Scan(&direction, &rotation); err != nil {
+			return nil, fmt.Errorf("error scanning rotation by direction: %v", err)
+		}
+		if _, seen := rotations[direction]; !seen {
+			directions = append(directions, direction)
+		}
+		rotations[direction] = append(rotations[direction], rotation)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error reading rotation by direction: %v", err)
+	}
+
+	sort.Strings(directions)
+	breakdown := make([]map[string]interface{}, 0, len(directions))
+	for _, direction := range directions {
+		values := rotations[direction]
+		breakdown = append(breakdown, map[string]interface{}{
+			"direction":    direction,
+			"swipes":       len(values),
+			"avg_rotation": mean(values),
+			"p95_rotation": percentile(values, 95),
+		})
+	}
+
+	return breakdown, nil
+}
+
 // getSessionStatistics retrieves aggregated statistics about user sessions.
-func (h *AnalyticsHandler) getSessionStatistics() ([]map[string]interface{}, error) {
-	rows, err := h.db.Query(`
+// The IP address and user agent of each session are only selected when
+// options.ClientDetails is set.
+func (h *AnalyticsHandler) getSessionStatistics(ctx context.Context, options rawDataOptions) ([]map[string]interface{}, int, error) {
+	where, args := options.where("sessions", "created_at")
+	clientColumns := ""
+	if options.ClientDetails {
+		clientColumns = `
+			ip_address,
+			user_agent,`
+	}
+	rows, err := h.db.QueryContext(ctx, `
 		SELECT 
+			id,
 			session_id,
 			user_id,
 			platform,
 			resolution,
 			device_model,
-			os_version,
-			created_at
+			os_version,`+clientColumns+`
+			created_at,
+			ended_at
 		FROM sessions
-		ORDER BY created_at DESC
-	`)
+		`+where+`
+		ORDER BY created_at DESC, id DESC
+		LIMIT ? OFFSET ?
+	`, append(args, options.Limit, options.Offset)...)
 	if err != nil {
-		return nil, err
+		return nil, 0, err
 	}
 	defer rows.Close()
 
 	var sessions []map[string]interface{}
 	for rows.Next() {
-		var sessionID, userID, platform, resolution, deviceModel, osVersion string
+		var id int64
+		var sessionID, userID, platform, resolution string
+		var deviceModel, osVersion, ipAddress, userAgent sql.NullString
 		var createdAt time.Time
-		if err := rows.Scan(&sessionID, &userID, &platform, &resolution, &deviceModel, &osVersion, &createdAt); err != nil {
-			return nil, err
+		var endedAt sql.NullTime
+		dest := []interface{}{&id, &sessionID, &userID, &platform, &resolution, &deviceModel, &osVersion}
+		if options.ClientDetails {
+			dest = append(dest, &ipAddress, &userAgent)
+		}
+		if err := rows.Scan(append(dest, &createdAt, &endedAt)...); err != nil {
+			return nil, 0, err
 		}
-		sessions = append(sessions, map[string]interface{}{
+		session := map[string]interface{}{
+			"id":           id,
 			"session_id":   sessionID,
 			"user_id":      userID,
 			"platform":     platform,
 			"resolution":   resolution,
-			"device_model": deviceModel,
-			"os_version":   osVersion,
+			"device_model": deviceModel.String,
+			"os_version":   osVersion.String,
 			"created_at":   createdAt,
-		})
+			"ended_at":     nullableTime(endedAt),
+		}
+		if options.ClientDetails {
+			session["ip_address"] = nullableString(ipAddress)
+			session["user_agent"] = nullableString(userAgent)
+		}
+		sessions = append(sessions, session)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, 0, err
 	}
+	h.checkResultSize("sessions", len(sessions))
 
-	return sessions, nil
+	total, err := h.countRawData(ctx, "sessions", where, args)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return sessions, total, nil
 }
 
 // getPerformanceStatistics retrieves aggregated statistics about performance metrics.
-func (h *AnalyticsHandler) getPerformanceStatistics() ([]map[string]interface{}, error) {
-	rows, err := h.db.Query(`
+func (h *AnalyticsHandler) getPerformanceStatistics(ctx context.Context, options rawDataOptions) ([]map[string]interface{}, int, error) {
+	where, args := options.where("performance", "timestamp")
+	rows, err := h.db.QueryContext(ctx, `
 		SELECT 
+			id,
 			session_id,
 			fps,
 			memory_usage,
@@ -513,22 +1793,26 @@ func (h *AnalyticsHandler) getPerformanceStatistics() ([]map[string]interface{},
 			network_latency,
 			timestamp
 		FROM performance_metrics
-		ORDER BY timestamp DESC
-	`)
+		`+where+`
+		ORDER BY timestamp DESC, id DESC
+		LIMIT ? OFFSET ?
+	`, append(args, options.Limit, options.Offset)...)
 	if err != nil {
-		return nil, err
+		return nil, 0, err
 	}
 	defer rows.Close()
 
 	var metrics []map[string]interface{}
 	for rows.Next() {
+		var id int64
 		var sessionID string
 		var fps, memoryUsage, cpuUsage, gpuUsage, networkLatency float64
 		var timestamp time.Time
-		if err := rows.Scan(&sessionID, &fps, &memoryUsage, &cpuUsage, &gpuUsage, &networkLatency, &timestamp); err != nil {
-			return nil, err
+		if err := rows.Scan(&id, &sessionID, &fps, &memoryUsage, &cpuUsage, &gpuUsage, &networkLatency, &timestamp); err != nil {
+			return nil, 0, err
 		}
 		metrics = append(metrics, map[string]interface{}{
+			"id":              id,
 			"session_id":      sessionID,
 			"fps":             fps,
 			"memory_usage":    memoryUsage,
@@ -539,13 +1823,25 @@ func (h *AnalyticsHandler) getPerformanceStatistics() ([]map[string]interface{},
 		})
 	}
 
-	return metrics, nil
+	if err := rows.Err(); err != nil {
+		return nil, 0, err
+	}
+	h.checkResultSize("performance", len(metrics))
+
+	total, err := h.countRawData(ctx, "performance_metrics", where, args)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return metrics, total, nil
 }
 
 // getEventStatistics retrieves aggregated statistics about user events.
-func (h *AnalyticsHandler) getEventStatistics() ([]map[string]interface{}, error) {
-	rows, err := h.db.Query(`
+func (h *AnalyticsHandler) getEventStatistics(ctx context.Context, options rawDataOptions) ([]map[string]interface{}, int, error) {
+	where, args := options.where("events", "created_at")
+	rows, err := h.db.QueryContext(ctx, `
 		SELECT 
+			id,
 			session_id,
 			event_type,
 			card_id,
@@ -557,28 +1853,34 @@ func (h *AnalyticsHandler) getEventStatistics() ([]map[string]interface{}, error
 			max_rotation,
 			created_at
 		FROM events
-		ORDER BY created_at DESC
-	`)
+		`+where+`
+		ORDER BY created_at DESC, id DESC
+		LIMIT ? OFFSET ?
+	`, append(args, options.Limit, options.Offset)...)
 	if err != nil {
-		return nil, err
+		return nil, 0, err
 	}
 	defer rows.Close()
 
 	var events []map[string]interface{}
 	for rows.Next() {
+		var id int64
 		var sessionID, eventType, cardID, direction string
-		var success bool
+		// Rows recorded before success was required have none; they
+		// count as unsuccessful, as in the aggregates
+		var success sql.NullBool
 		var duration, startX, endX, maxRotation float64
 		var createdAt time.Time
-		if err := rows.Scan(&sessionID, &eventType, &cardID, &direction, &success, &duration, &startX, &endX, &maxRotation, &createdAt); err != nil {
-			return nil, err
+		if err := rows.Scan(&id, &sessionID, &eventType, &cardID, &direction, &success, &duration, &startX, &endX, &maxRotation, &createdAt); err != nil {
+			return nil, 0, err
 		}
 		events = append(events, map[string]interface{}{
+			"id":           id,
 			"session_id":   sessionID,
 			"event_type":   eventType,
 			"card_id":      cardID,
 			"direction":    direction,
-			"success":      success,
+			"success":      success.Valid && success.Bool,
 			"duration":     duration,
 			"start_x":      startX,
 			"end_x":        endX,
@@ -587,5 +1889,15 @@ func (h *AnalyticsHandler) getEventStatistics() ([]map[string]interface{}, error
 		})
 	}
 
-	return events, nil
+	if err := rows.Err(); err != nil {
+		return nil, 0, err
+	}
+	h.checkResultSize("events", len(events))
+
+	total, err := h.countRawData(ctx, "events", where, args)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return events, total, nil
 }
@@ -0,0 +1,1595 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"cyber-swipe-analytics/config"
+	"cyber-swipe-analytics/storage"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestMain(m *testing.M) {
+	gin.SetMode(gin.TestMode)
+	os.Exit(m.Run())
+}
+
+// testEnv holds the database variables config.Load requires. The database
+// itself is replaced by sqlmock.
+var testEnv = map[string]string{
+	"DB_HOST":     "localhost",
+	"DB_PORT":     "3306",
+	"DB_USER":     "analytics",
+	"DB_PASSWORD": "secret",
+	"DB_NAME":     "analytics",
+}
+
+// newTestHandler returns a handler backed by a sqlmock MySQL database and
+// configured from the defaults, overridden by env. The expectations set on
+// the returned mock must all be met by the end of the test.
+func newTestHandler(t *testing.T, env map[string]string) (*AnalyticsHandler, sqlmock.Sqlmock) {
+	t.Helper()
+	for key, value := range testEnv {
+		t.Setenv(key, value)
+	}
+	for key, value := range env {
+		t.Setenv(key, value)
+	}
+	cfg, err := config.Load()
+	if err != nil {
+		t.Fatalf("config.Load: %v", err)
+	}
+
+	database, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("sqlmock.New: %v", err)
+	}
+	t.Cleanup(func() {
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Error(err)
+		}
+		database.Close()
+	})
+	return NewAnalyticsHandler(&storage.DB{DB: database, Driver: storage.DriverMySQL}, cfg), mock
+}
+
+// newTestRouter returns a router serving the routes of h behind the
+// request ID middleware.
+func newTestRouter(h *AnalyticsHandler) *gin.Engine {
+	router := gin.New()
+	router.Use(RequestID())
+	SetupRoutes(router, h)
+	return router
+}
+
+// serve sends a request to handler and returns the recorded response. A
+// non-nil body is encoded as JSON.
+func serve(handler http.Handler, method, path string, body interface{}, header http.Header) *httptest.ResponseRecorder {
+	var payload io.Reader
+	if body != nil {
+		encoded, err := json.Marshal(body)
+		if err != nil {
+			panic(err)
+		}
+		payload = bytes.NewReader(encoded)
+	}
+	request := httptest.NewRequest(method, path, payload)
+	if body != nil {
+		request.Header.Set("Content-Type", "application/json")
+	}
+	for key, values := range header {
+		for _, value := range values {
+			request.Header.Add(key, value)
+		}
+	}
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, request)
+	return recorder
+}
+
+// decodeBody decodes the JSON object in the body of response.
+func decodeBody(t *testing.T, response *httptest.ResponseRecorder) map[string]interface{} {
+	t.Helper()
+	var body map[string]interface{}
+	if err := json.Unmarshal(response.Body.Bytes(), &body); err != nil {
+		t.Fatalf("decoding response %q: %v", response.Body.String(), err)
+	}
+	return body
+}
+
+func TestRecordEventLeavesCreatedAtToMillisecondDefault(t *testing.T) {
+	h, mock := newTestHandler(t, nil)
+
+	// The insert leaves created_at out, so the TIMESTAMP(3) column takes
+	// its CURRENT_TIMESTAMP(3) default and keeps the milliseconds that
+	// order events within the same second
+	if strings.Contains(insertEventQuery, "created_at") {
+		t.Fatalf("insertEventQuery sets created_at:%s", insertEventQuery)
+	}
+	expectSessionNotOptedOut(mock, "s1")
+	mock.ExpectExec(`^\s*INSERT INTO events \(\s+session_id, event_type, card_id, direction, success,\s+duration, start_x, end_x, max_rotation,\s+swipe_distance, swipe_velocity, seq, client_event_id, properties\s+\) VALUES`).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+
+	response := serve(newTestRouter(h), http.MethodPost, "/api/analytics/event",
+		map[string]interface{}{"session_id": "s1", "event_type": "button_tap"}, nil)
+	if response.Code != http.StatusCreated {
+		t.Fatalf("status %d, want 201: %s", response.Code, response.Body)
+	}
+}
+
+func TestGetSessionLengthHistogram(t *testing.T) {
+	h, mock := newTestHandler(t, map[string]string{"SESSION_LENGTH_BUCKETS": "5,10"})
+
+	// One swipe count per session
+	rows := sqlmock.NewRows([]string{"swipe_count"})
+	for _, count := range []int{1, 5, 6, 10, 11, 30, 3} {
+		rows.AddRow(count)
+	}
+	mock.ExpectQuery(`SELECT COUNT\(\*\) as swipe_count\s+FROM events\s+WHERE event_type = 'card_swipe'\s+GROUP BY session_id`).
+		WillReturnRows(rows)
+
+	histogram, err := h.getSessionLengthHistogram(context.Background(), statsFilter{db: h.db})
+	if err != nil {
+		t.Fatalf("getSessionLengthHistogram: %v", err)
+	}
+
+	want := []struct {
+		rangeLabel string
+		sessions   int
+	}{
+		{"1-5", 3},
+		{"6-10", 2},
+		{"11+", 2},
+	}
+	if len(histogram) != len(want) {
+		t.Fatalf("got %d buckets, want %d: %v", len(histogram), len(want), histogram)
+	}
+	for i, bucket := range want {
+		if histogram[i]["range"] != bucket.rangeLabel || histogram[i]["sessions"] != bucket.sessions {
+			t.Errorf("bucket %d = %v, want range %s with %d sessions", i, histogram[i], bucket.rangeLabel, bucket.sessions)
+		}
+	}
+	if _, ok := histogram[2]["max_swipes"]; ok {
+		t.Errorf("open-ended bucket has max_swipes: %v", histogram[2])
+	}
+}
+
+// adminHeader carries the admin key the tests authenticate with.
+var adminHeader = http.Header{"X-Admin-Secret": {"test-admin-key"}}
+
+// expectAdminKey expects the lookup of the admin key in adminHeader and
+// answers it with scope.
+func expectAdminKey(mock sqlmock.Sqlmock, scope string) {
+	mock.ExpectQuery(`SELECT scope, revoked_at FROM api_keys WHERE key_hash = \?`).
+		WithArgs(storage.HashAPIKey("test-admin-key")).
+		WillReturnRows(sqlmock.NewRows([]string{"scope", "revoked_at"}).AddRow(scope, nil))
+}
+
+// Positions of the queries in aggregatedStatisticsRows.
+const (
+	aggregatedEventsQuery      = 2
+	aggregatedUndosByCardQuery = 11
+)
+
+// aggregatedStatisticsRows returns rows answering the queries of
+// getAggregatedStatistics in the order they run: two sessions of one
+// user on iOS, one of them ended, with a single successful swipe.
+func aggregatedStatisticsRows() []*sqlmock.Rows {
+	return []*sqlmock.Rows{
+		sqlmock.NewRows([]string{"total_sessions", "completed_sessions", "avg_session_duration"}).AddRow(2, 1, 300.0),
+		sqlmock.NewRows([]string{"avg_fps", "avg_memory", "avg_cpu", "avg_gpu", "avg_network", "stored_samples", "estimated_samples"}).
+			AddRow(55.0, 300.0, 0.2, 0.1, 40.0, 4, 4.0),
+		sqlmock.NewRows([]string{"total_events", "total_swipes", "successful_swipes", "avg_duration", "avg_distance", "avg_rotation", "total_undos"}).
+			AddRow(1, 1, 1, 0.8, 290.0, 12.0, 0),
+		sqlmock.NewRows([]string{"category_name", "total_cards", "accepted_cards", "average_decision_time"}),
+		sqlmock.NewRows([]string{"platform", "total_sessions", "unique_users"}).AddRow("ios", 2, 1),
+		sqlmock.NewRows([]string{"swipe_count"}).AddRow(1),
+		sqlmock.NewRows([]string{"session_count"}).AddRow(2),
+		sqlmock.NewRows([]string{"direction", "rotation"}),
+		sqlmock.NewRows([]string{"duration"}),
+		sqlmock.NewRows([]string{"platform", "total_sessions", "completed_sessions"}).AddRow("ios", 2, 1),
+		sqlmock.NewRows([]string{"total_sessions", "total_users", "completed_sessions", "skipped_sessions", "completed_users"}).
+			AddRow(2, 1, 0, 0, 0),
+		sqlmock.NewRows([]string{"card_id", "undos"}),
+		sqlmock.NewRows([]string{"bucket", "swipes", "successful_swipes"}),
+	}
+}
+
+// expectLastModified expects the lookup of the newest row time and
+// answers it with newest for every table.
+func expectLastModified(mock sqlmock.Sqlmock, newest time.Time) {
+	mock.ExpectQuery(`SELECT MAX\(created_at\) FROM sessions`).
+		WillReturnRows(sqlmock.NewRows([]string{"sessions", "ended", "events", "performance"}).AddRow(newest, newest, newest, newest))
+}
+
+func TestGetStatsIncrementalPull(t *testing.T) {
+	h, mock := newTestHandler(t, nil)
+	router := newTestRouter(h)
+
+	// Nothing was recorded since the previous pull
+	lastPull := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	expectAdminKey(mock, config.ScopeRead)
+	expectLastModified(mock, lastPull)
+	header := http.Header{"If-Modified-Since": {lastPull.Format(http.TimeFormat)}}
+	for key, values := range adminHeader {
+		header[key] = values
+	}
+	response := serve(router, http.MethodGet, "/api/analytics/stats?include=raw", nil, header)
+	if response.Code != http.StatusNotModified {
+		t.Fatalf("unchanged pull: status %d, want 304: %s", response.Code, response.Body)
+	}
+
+	// A session was created after the previous pull; only rows newer than
+	// the pull are listed
+	created := lastPull.Add(1500 * time.Millisecond)
+	since := lastPull.Format(time.RFC3339Nano)
+	expectAdminKey(mock, config.ScopeRead)
+	expectLastModified(mock, created)
+	mock.ExpectQuery(`FROM sessions\s+WHERE created_at > \?`).
+		WithArgs(lastPull, 100, 0).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "session_id", "user_id", "platform", "resolution",
+			"device_model", "os_version", "created_at", "ended_at"}).
+			AddRow(7, "s7", "u1", "ios", "1170x2532", nil, nil, created, nil))
+	mock.ExpectQuery(`SELECT COUNT\(\*\) FROM sessions WHERE created_at > \?`).
+		WithArgs(lastPull).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+	mock.ExpectQuery(`FROM performance_metrics\s+WHERE timestamp > \?`).
+		WithArgs(lastPull, 100, 0).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}))
+	mock.ExpectQuery(`SELECT COUNT\(\*\) FROM performance_metrics WHERE timestamp > \?`).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+	mock.ExpectQuery(`FROM events\s+WHERE created_at > \?`).
+		WithArgs(lastPull, 100, 0).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}))
+	mock.ExpectQuery(`SELECT COUNT\(\*\) FROM events WHERE created_at > \?`).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+
+	response = serve(router, http.MethodGet, "/api/analytics/stats?include=raw&modified_since="+since, nil, adminHeader)
+	if response.Code != http.StatusOK {
+		t.Fatalf("incremental pull: status %d, want 200: %s", response.Code, response.Body)
+	}
+	if got := response.Header().Get("Last-Modified"); got != created.Format(http.TimeFormat) {
+		t.Errorf("Last-Modified = %q, want %q", got, created.Format(http.TimeFormat))
+	}
+	sessions := decodeBody(t, response)["raw_data"].(map[string]interface{})["sessions"].([]interface{})
+	if len(sessions) != 1 || sessions[0].(map[string]interface{})["session_id"] != "s7" {
+		t.Errorf("sessions = %v, want only s7", sessions)
+	}
+}
+
+func TestGetStatsConditionalCoversRawExportsOnly(t *testing.T) {
+	h, mock := newTestHandler(t, nil)
+	router := newTestRouter(h)
+	lastPull := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	header := http.Header{"If-Modified-Since": {lastPull.Format(http.TimeFormat)}}
+	for key, values := range adminHeader {
+		header[key] = values
+	}
+
+	// A browser revalidating the dashboard gets the aggregates afresh:
+	// category upserts and deletions change them without a newer row, so
+	// no validator is sent and the header doesn't filter the listings
+	expectAdminKey(mock, config.ScopeRead)
+	for _, table := range []string{"sessions", "performance_metrics", "events"} {
+		mock.ExpectQuery(`FROM ` + table + `\s+ORDER BY`).WillReturnRows(sqlmock.NewRows([]string{"id"}))
+		mock.ExpectQuery(`SELECT COUNT\(\*\) FROM ` + table + `$`).WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+	}
+	for _, rows := range aggregatedStatisticsRows() {
+		mock.ExpectQuery(`.`).WillReturnRows(rows)
+	}
+	response := serve(router, http.MethodGet, "/api/analytics/stats", nil, header)
+	if response.Code != http.StatusOK {
+		t.Fatalf("all: status %d, want 200: %s", response.Code, response.Body)
+	}
+	if got := response.Header().Get("Last-Modified"); got != "" {
+		t.Errorf("all: Last-Modified = %q, want none", got)
+	}
+
+	// Ending a session changes its listed row, so it counts as a change
+	// although no row was created since
+	ended := lastPull.Add(time.Hour)
+	expectAdminKey(mock, config.ScopeRead)
+	mock.ExpectQuery(`SELECT MAX\(created_at\) FROM sessions\),\s+\(SELECT MAX\(ended_at\) FROM sessions\)`).
+		WillReturnRows(sqlmock.NewRows([]string{"sessions", "ended", "events", "performance"}).
+			AddRow(lastPull.Add(-time.Hour), ended, lastPull, nil))
+	for _, table := range []string{"sessions", "performance_metrics", "events"} {
+		mock.ExpectQuery(`FROM ` + table + `\s+WHERE`).WillReturnRows(sqlmock.NewRows([]string{"id"}))
+		mock.ExpectQuery(`SELECT COUNT\(\*\) FROM ` + table).WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+	}
+	response = serve(router, http.MethodGet, "/api/analytics/stats?include=raw", nil, header)
+	if response.Code != http.StatusOK {
+		t.Fatalf("raw: status %d, want 200: %s", response.Code, response.Body)
+	}
+	if got := response.Header().Get("Last-Modified"); got != ended.Format(http.TimeFormat) {
+		t.Errorf("raw: Last-Modified = %q, want %q", got, ended.Format(http.TimeFormat))
+	}
+}
+
+func TestParseRawDataOptionsResultRowGuard(t *testing.T) {
+	h, _ := newTestHandler(t, map[string]string{"MAX_RESULT_ROWS": "50"})
+
+	tests := []struct {
+		query     string
+		wantLimit int
+		wantErr   bool
+	}{
+		{"", 50, false},
+		{"limit=50", 50, false},
+		{"limit=51", 0, true},
+		{"limit=0", 0, true},
+	}
+	for _, tt := range tests {
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		c.Request = httptest.NewRequest(http.MethodGet, "/api/analytics/stats?"+tt.query, nil)
+
+		options, _, err := h.parseRawDataOptions(c)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%q: error = %v, want error %v", tt.query, err, tt.wantErr)
+			continue
+		}
+		if !tt.wantErr && options.Limit != tt.wantLimit {
+			t.Errorf("%q: limit = %d, want %d", tt.query, options.Limit, tt.wantLimit)
+		}
+	}
+}
+
+func TestParseRawDataOptionsPageLimit(t *testing.T) {
+	h, _ := newTestHandler(t, nil)
+
+	// The page size is capped below the default MaxResultRows
+	for query, wantErr := range map[string]bool{"limit=1000": false, "limit=1001": true} {
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		c.Request = httptest.NewRequest(http.MethodGet, "/api/analytics/stats?"+query, nil)
+
+		if _, _, err := h.parseRawDataOptions(c); (err != nil) != wantErr {
+			t.Errorf("%q: error = %v, want error %v", query, err, wantErr)
+		}
+	}
+}
+
+func TestGetStatsRejectsPagesBeyondResultRowGuard(t *testing.T) {
+	h, mock := newTestHandler(t, map[string]string{"MAX_RESULT_ROWS": "50"})
+	expectAdminKey(mock, config.ScopeRead)
+
+	response := serve(newTestRouter(h), http.MethodGet, "/api/analytics/stats?limit=500", nil, adminHeader)
+	if response.Code != http.StatusBadRequest {
+		t.Fatalf("status %d, want 400: %s", response.Code, response.Body)
+	}
+	message, _ := decodeBody(t, response)["error"].(string)
+	if !strings.Contains(message, "limit must be at most 50; paginate") {
+		t.Errorf("error = %q, want the limit and a hint to paginate", message)
+	}
+}
+
+func TestRawDataListingWarnsNearResultRowGuard(t *testing.T) {
+	h, mock := newTestHandler(t, map[string]string{"MAX_RESULT_ROWS": "10"})
+	var logs bytes.Buffer
+	log.SetOutput(&logs)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+
+	// Seven rows stay quiet, eight reach 80% of the guard
+	for _, n := range []int{7, 8} {
+		rows := sqlmock.NewRows([]string{"id", "session_id", "event_type", "card_id", "direction",
+			"success", "duration", "start_x", "end_x", "max_rotation", "created_at"})
+		for id := n; id > 0; id-- {
+			rows.AddRow(id, "s1", "card_swipe", "c1", "left", true, 0.5, 10.0, 200.0, 5.0, time.Now())
+		}
+		mock.ExpectQuery(`FROM events`).WillReturnRows(rows)
+		mock.ExpectQuery(`SELECT COUNT\(\*\) FROM events`).WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(n))
+		logs.Reset()
+		if _, _, err := h.getEventStatistics(context.Background(), rawDataOptions{Limit: 10}); err != nil {
+			t.Fatalf("getEventStatistics: %v", err)
+		}
+		if warned := strings.Contains(logs.String(), "events listing returned"); warned != (n == 8) {
+			t.Errorf("%d rows: warned = %v: %s", n, warned, logs.String())
+		}
+	}
+}
+
+func TestGetRotationByDirection(t *testing.T) {
+	h, mock := newTestHandler(t, nil)
+
+	rows := sqlmock.NewRows([]string{"direction", "rotation"})
+	for _, rotation := range []float64{10, 20, 30, 40, 50} {
+		rows.AddRow("left", rotation)
+	}
+	rows.AddRow("right", 5).AddRow("right", 15)
+	mock.ExpectQuery(`LOWER\(TRIM\(direction\)\) as direction,\s+ABS\(max_rotation\) as rotation`).
+		WithArgs(h.cfg.MaxRotation).
+		WillReturnRows(rows)
+
+	breakdown, err := h.getRotationByDirection(context.Background(), statsFilter{db: h.db})
+	if err != nil {
+		t.Fatalf("getRotationByDirection: %v", err)
+	}
+
+	want := []map[string]interface{}{
+		{"direction": "left", "swipes": 5, "avg_rotation": 30.0, "p95_rotation": 48.0},
+		{"direction": "right", "swipes": 2, "avg_rotation": 10.0, "p95_rotation": 14.5},
+	}
+	if len(breakdown) != len(want) {
+		t.Fatalf("got %d directions, want %d: %v", len(breakdown), len(want), breakdown)
+	}
+	for i, direction := range want {
+		for key, value := range direction {
+			if got, ok := breakdown[i][key].(float64); ok {
+				if math.Abs(got-value.(float64)) > 1e-9 {
+					t.Errorf("%s %s = %v, want %v", direction["direction"], key, got, value)
+				}
+			} else if breakdown[i][key] != value {
+				t.Errorf("%s %s = %v, want %v", direction["direction"], key, breakdown[i][key], value)
+			}
+		}
+	}
+}
+
+// expectUserNotOptedOut expects the opt-out check of a new session's user.
+func expectUserNotOptedOut(mock sqlmock.Sqlmock, userID string) {
+	mock.ExpectQuery(`SELECT EXISTS\(SELECT 1 FROM opt_outs WHERE user_id = \?\)`).
+		WithArgs(userID).
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+}
+
+// expectSessionNotOptedOut expects the opt-out check of the user owning
+// sessionID.
+func expectSessionNotOptedOut(mock sqlmock.Sqlmock, sessionID string) {
+	mock.ExpectQuery(`JOIN opt_outs o ON o.user_id = s.user_id`).
+		WithArgs(sessionID).
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+}
+
+func TestValidateRotation(t *testing.T) {
+	h, _ := newTestHandler(t, map[string]string{"MAX_ROTATION_DEGREES": "360"})
+	tests := []struct {
+		rotation float64
+		valid    bool
+	}{
+		{0, true},
+		{-45.5, true},
+		{360, true},
+		{-360, true},
+		{360.1, false},
+		{-10000, false},
+	}
+	for _, tt := range tests {
+		err := h.validateRotation(EventRequest{MaxRotation: tt.rotation})
+		if (err == nil) != tt.valid {
+			t.Errorf("validateRotation(%v) = %v, want valid %v", tt.rotation, err, tt.valid)
+		}
+	}
+}
+
+func TestRecordEventRejectsOutOfRangeRotation(t *testing.T) {
+	h, _ := newTestHandler(t, nil)
+
+	// The event is refused before the database is touched
+	response := serve(newTestRouter(h), http.MethodPost, "/api/analytics/event", map[string]interface{}{
+		"session_id": "s1", "event_type": "button_tap", "max_rotation": 10000,
+	}, nil)
+	if response.Code != http.StatusBadRequest {
+		t.Fatalf("status %d, want 400: %s", response.Code, response.Body)
+	}
+}
+
+func TestGetStatsLastModifiedHeader(t *testing.T) {
+	h, mock := newTestHandler(t, nil)
+	router := newTestRouter(h)
+	header := http.Header{"If-Modified-Since": {time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC).Format(http.TimeFormat)}}
+	for key, values := range adminHeader {
+		header[key] = values
+	}
+
+	// The newest row of any table sets the validator, in UTC
+	events := time.Date(2024, 5, 1, 14, 30, 0, 0, time.FixedZone("CEST", 2*3600))
+	expectAdminKey(mock, config.ScopeRead)
+	mock.ExpectQuery(`SELECT MAX\(created_at\) FROM sessions`).
+		WillReturnRows(sqlmock.NewRows([]string{"sessions", "ended", "events", "performance"}).
+			AddRow(events.Add(-time.Hour), nil, events, nil))
+	response := serve(router, http.MethodGet, "/api/analytics/stats?include=raw", nil, header)
+	if response.Code != http.StatusNotModified {
+		t.Fatalf("status %d, want 304: %s", response.Code, response.Body)
+	}
+	if got := response.Header().Get("Last-Modified"); got != "Wed, 01 May 2024 12:30:00 GMT" {
+		t.Errorf("Last-Modified = %q", got)
+	}
+
+	// An empty database has no modification time to report
+	expectAdminKey(mock, config.ScopeRead)
+	mock.ExpectQuery(`SELECT MAX\(created_at\) FROM sessions`).
+		WillReturnRows(sqlmock.NewRows([]string{"sessions", "ended", "events", "performance"}).AddRow(nil, nil, nil, nil))
+	response = serve(router, http.MethodGet, "/api/analytics/stats?include=raw", nil, header)
+	if got := response.Header().Get("Last-Modified"); got != "" {
+		t.Errorf("empty database: Last-Modified = %q, want none", got)
+	}
+}
+
+func TestEndSession(t *testing.T) {
+	h, mock := newTestHandler(t, nil)
+	router := newTestRouter(h)
+	ended := time.Date(2024, 5, 1, 12, 30, 0, 0, time.UTC)
+	request := map[string]interface{}{"session_id": "s1"}
+
+	// Ending the session again matches no open row and still succeeds
+	for _, affected := range []int64{1, 0} {
+		mock.ExpectExec(`UPDATE sessions\s+SET ended_at = CURRENT_TIMESTAMP\(3\)\s+WHERE session_id = \? AND ended_at IS NULL`).
+			WithArgs("s1").
+			WillReturnResult(sqlmock.NewResult(0, affected))
+		if response := serve(router, http.MethodPost, "/api/analytics/session/end", request, nil); response.Code != http.StatusOK {
+			t.Fatalf("%d rows ended: status %d, want 200: %s", affected, response.Code, response.Body)
+		}
+	}
+
+	// The end time is listed with the session
+	mock.ExpectQuery(`FROM sessions`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "session_id", "user_id", "platform", "resolution",
+			"device_model", "os_version", "created_at", "ended_at"}).
+			AddRow(1, "s1", "u1", "ios", "1170x2532", nil, nil, ended.Add(-30*time.Minute), ended))
+	mock.ExpectQuery(`SELECT COUNT\(\*\) FROM sessions`).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+	sessions, _, err := h.getSessionStatistics(context.Background(), rawDataOptions{Limit: 100})
+	if err != nil {
+		t.Fatalf("getSessionStatistics: %v", err)
+	}
+	if got, ok := sessions[0]["ended_at"].(time.Time); !ok || !got.Equal(ended) {
+		t.Errorf("ended_at = %v, want %v", sessions[0]["ended_at"], ended)
+	}
+}
+
+func TestEndSessions(t *testing.T) {
+	h, mock := newTestHandler(t, nil)
+
+	// "open" is still running, "closed" already ended and "missing" was
+	// never created; the repeated ID is only listed once
+	mock.ExpectBegin()
+	mock.ExpectQuery(`SELECT session_id FROM sessions\s+WHERE session_id IN \(\?, \?, \?\) AND ended_at IS NULL\s+FOR UPDATE`).
+		WithArgs("open", "closed", "missing").
+		WillReturnRows(sqlmock.NewRows([]string{"session_id"}).AddRow("open"))
+	mock.ExpectExec(`UPDATE sessions\s+SET ended_at = CURRENT_TIMESTAMP\(3\)\s+WHERE session_id IN \(\?, \?, \?\) AND ended_at IS NULL`).
+		WithArgs("open", "closed", "missing").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	response := serve(newTestRouter(h), http.MethodPost, "/api/analytics/session/end/batch",
+		map[string]interface{}{"session_ids": []string{"open", "closed", "open", "missing"}}, nil)
+	if response.Code != http.StatusOK {
+		t.Fatalf("status %d, want 200: %s", response.Code, response.Body)
+	}
+	body := decodeBody(t, response)
+	if body["ended"] != 1.0 {
+		t.Errorf("ended = %v, want 1", body["ended"])
+	}
+	if notOpen := fmt.Sprint(body["not_open"]); notOpen != "[closed missing]" {
+		t.Errorf("not_open = %s, want [closed missing]", notOpen)
+	}
+}
+
+func TestEndSessionsCapsListSize(t *testing.T) {
+	h, _ := newTestHandler(t, nil)
+
+	ids := make([]string, 101)
+	for i := range ids {
+		ids[i] = fmt.Sprintf("s%d", i)
+	}
+	for _, list := range [][]string{{}, ids} {
+		response := serve(newTestRouter(h), http.MethodPost, "/api/analytics/session/end/batch",
+			map[string]interface{}{"session_ids": list}, nil)
+		if response.Code != http.StatusBadRequest {
+			t.Errorf("%d IDs: status %d, want 400", len(list), response.Code)
+		}
+	}
+}
+
+func TestRecordEventDuplicateSequence(t *testing.T) {
+	h, mock := newTestHandler(t, nil)
+	expectSessionNotOptedOut(mock, "s1")
+	mock.ExpectExec(`INSERT INTO events`).WillReturnError(duplicateKeyError)
+
+	// The resent event is acknowledged without being stored twice
+	response := serve(newTestRouter(h), http.MethodPost, "/api/analytics/event",
+		map[string]interface{}{"session_id": "s1", "event_type": "button_tap", "seq": 4}, nil)
+	if response.Code != http.StatusOK {
+		t.Fatalf("status %d, want 200: %s", response.Code, response.Body)
+	}
+	if status := decodeBody(t, response)["status"]; status != "duplicate" {
+		t.Errorf("status = %v, want duplicate", status)
+	}
+}
+
+// expectEventInsert expects the insert of one event and checks its
+// client_event_id argument.
+func expectEventInsert(mock sqlmock.Sqlmock, clientEventID driver.Value) *sqlmock.ExpectedExec {
+	args := make([]driver.Value, 14)
+	for i := range args {
+		args[i] = sqlmock.AnyArg()
+	}
+	args[12] = clientEventID
+	return mock.ExpectExec(`INSERT INTO events`).WithArgs(args...)
+}
+
+func TestRecordEventDuplicateClientEventID(t *testing.T) {
+	h, mock := newTestHandler(t, nil)
+	router := newTestRouter(h)
+	before := testutil.ToFloat64(eventsRecordedTotal)
+	event := map[string]interface{}{"session_id": "s1", "event_type": "button_tap", "client_event_id": "tap-7"}
+
+	// The retry hits the unique key on client_event_id, leaving the first
+	// row the only one
+	expectSessionNotOptedOut(mock, "s1")
+	expectEventInsert(mock, "tap-7").WillReturnResult(sqlmock.NewResult(1, 1))
+	expectSessionNotOptedOut(mock, "s1")
+	expectEventInsert(mock, "tap-7").WillReturnError(duplicateKeyError)
+
+	if response := serve(router, http.MethodPost, "/api/analytics/event", event, nil); response.Code != http.StatusCreated {
+		t.Fatalf("first send: status %d, want 201: %s", response.Code, response.Body)
+	}
+	response := serve(router, http.MethodPost, "/api/analytics/event", event, nil)
+	if response.Code != http.StatusOK {
+		t.Fatalf("retry: status %d, want 200: %s", response.Code, response.Body)
+	}
+	if status := decodeBody(t, response)["status"]; status != "duplicate" {
+		t.Errorf("retry status = %v, want duplicate", status)
+	}
+	if got := testutil.ToFloat64(eventsRecordedTotal); got != before+1 {
+		t.Errorf("recorded %v events, want 1", got-before)
+	}
+}
+
+func TestRecordEventWithoutClientEventID(t *testing.T) {
+	h, mock := newTestHandler(t, nil)
+
+	// Without the field the column is NULL, which the unique key ignores
+	expectSessionNotOptedOut(mock, "s1")
+	expectEventInsert(mock, nil).WillReturnResult(sqlmock.NewResult(1, 1))
+
+	response := serve(newTestRouter(h), http.MethodPost, "/api/analytics/event",
+		map[string]interface{}{"session_id": "s1", "event_type": "button_tap"}, nil)
+	if response.Code != http.StatusCreated {
+		t.Fatalf("status %d, want 201: %s", response.Code, response.Body)
+	}
+}
+
+func TestIsDuplicateEvent(t *testing.T) {
+	seq := 1
+	tests := []struct {
+		event EventRequest
+		err   error
+		want  bool
+	}{
+		{EventRequest{Seq: &seq}, duplicateKeyError, true},
+		{EventRequest{ClientEventID: "e1"}, duplicateKeyError, true},
+		{EventRequest{}, duplicateKeyError, false},
+		{EventRequest{Seq: &seq}, deadlockError, false},
+		{EventRequest{Seq: &seq}, nil, false},
+	}
+	for i, tt := range tests {
+		if got := isDuplicateEvent(tt.event, tt.err); got != tt.want {
+			t.Errorf("case %d: isDuplicateEvent = %v, want %v", i, got, tt.want)
+		}
+	}
+}
+
+func TestRecordEventRequiredFields(t *testing.T) {
+	h, mock := newTestHandler(t, nil)
+	router := newTestRouter(h)
+
+	// A swipe without its card is refused, naming the missing field
+	response := serve(router, http.MethodPost, "/api/analytics/event",
+		map[string]interface{}{"session_id": "s1", "event_type": "card_swipe", "direction": "left", "card_id": ""}, nil)
+	if response.Code != http.StatusBadRequest {
+		t.Fatalf("swipe without card_id: status %d, want 400: %s", response.Code, response.Body)
+	}
+	if missing := fmt.Sprint(decodeBody(t, response)["missing_fields"]); missing != "[card_id]" {
+		t.Errorf("missing_fields = %s, want [card_id]", missing)
+	}
+
+	// A session start requires nothing beyond the common fields
+	expectSessionNotOptedOut(mock, "s1")
+	mock.ExpectExec(`INSERT INTO events`).WillReturnResult(sqlmock.NewResult(1, 1))
+	response = serve(router, http.MethodPost, "/api/analytics/event",
+		map[string]interface{}{"session_id": "s1", "event_type": "session_start"}, nil)
+	if response.Code != http.StatusCreated {
+		t.Fatalf("session start: status %d, want 201: %s", response.Code, response.Body)
+	}
+}
+
+func TestRecordEventConfiguredRequiredFields(t *testing.T) {
+	h, _ := newTestHandler(t, map[string]string{"EVENT_REQUIRED_FIELDS": `{"button_tap": ["card_id", "duration"]}`})
+
+	response := serve(newTestRouter(h), http.MethodPost, "/api/analytics/event",
+		map[string]interface{}{"session_id": "s1", "event_type": "button_tap", "duration": nil}, nil)
+	if response.Code != http.StatusBadRequest {
+		t.Fatalf("status %d, want 400: %s", response.Code, response.Body)
+	}
+	if missing := fmt.Sprint(decodeBody(t, response)["missing_fields"]); missing != "[card_id duration]" {
+		t.Errorf("missing_fields = %s, want [card_id duration]", missing)
+	}
+}
+
+func TestGetDecisionTimeStatisticsExcludesTaps(t *testing.T) {
+	h, mock := newTestHandler(t, map[string]string{"MIN_SWIPE_DISTANCE": "25"})
+
+	// Taps travel no further than MIN_SWIPE_DISTANCE and are filtered out
+	// by the query; only the durations of real swipes come back
+	if distance, _ := swipeDerivedValues(EventRequest{StartX: 100, EndX: 104, Duration: 0.05}); distance > h.cfg.MinSwipeDistance {
+		t.Fatalf("tap distance %v exceeds the threshold", distance)
+	}
+	mock.ExpectQuery(`SELECT duration\s+FROM events\s+WHERE event_type = 'card_swipe' AND duration IS NOT NULL AND swipe_distance > \?`).
+		WithArgs(25.0).
+		WillReturnRows(sqlmock.NewRows([]string{"duration"}).AddRow(0.6).AddRow(0.8).AddRow(1.0).AddRow(4.0))
+
+	stats, err := h.getDecisionTimeStatistics(context.Background(), statsFilter{db: h.db})
+	if err != nil {
+		t.Fatalf("getDecisionTimeStatistics: %v", err)
+	}
+	if stats["swipes"] != 4 || stats["median"] != 0.9 || stats["min_swipe_distance"] != 25.0 {
+		t.Errorf("stats = %v, want 4 swipes with a median of 0.9", stats)
+	}
+}
+
+func TestGetStatsReportsFailedSections(t *testing.T) {
+	h, mock := newTestHandler(t, nil)
+	expectAdminKey(mock, config.ScopeRead)
+	expectLastModified(mock, time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC))
+
+	// The performance listing fails; sessions and events still come back
+	mock.ExpectQuery(`FROM sessions\s+ORDER BY created_at DESC`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "session_id", "user_id", "platform", "resolution",
+			"device_model", "os_version", "created_at", "ended_at"}).
+			AddRow(1, "s1", "u1", "ios", "1170x2532", nil, nil, time.Now(), nil))
+	mock.ExpectQuery(`SELECT COUNT\(\*\) FROM sessions`).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+	mock.ExpectQuery(`FROM performance_metrics\s+ORDER BY timestamp DESC`).WillReturnError(deadlockError)
+	mock.ExpectQuery(`FROM events\s+ORDER BY created_at DESC`).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}))
+	mock.ExpectQuery(`SELECT COUNT\(\*\) FROM events`).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+
+	response := serve(newTestRouter(h), http.MethodGet, "/api/analytics/stats?include=raw", nil, adminHeader)
+	if response.Code != http.StatusMultiStatus {
+		t.Fatalf("status %d, want 207: %s", response.Code, response.Body)
+	}
+	body := decodeBody(t, response)
+	sectionErrors := body["errors"].(map[string]interface{})
+	if len(sectionErrors) != 1 || sectionErrors["raw_data.performance"] != "Failed to get performance statistics" {
+		t.Errorf("errors = %v, want only raw_data.performance", sectionErrors)
+	}
+	sessions := body["raw_data"].(map[string]interface{})["sessions"].([]interface{})
+	if len(sessions) != 1 {
+		t.Errorf("got %d sessions, want 1", len(sessions))
+	}
+}
+
+func TestGetStatsFailsWhenEverySectionFails(t *testing.T) {
+	h, mock := newTestHandler(t, nil)
+	expectAdminKey(mock, config.ScopeRead)
+	expectLastModified(mock, time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC))
+	for _, table := range []string{"sessions", "performance_metrics", "events"} {
+		mock.ExpectQuery(`FROM ` + table + `\s+ORDER BY`).WillReturnError(deadlockError)
+	}
+
+	response := serve(newTestRouter(h), http.MethodGet, "/api/analytics/stats?include=raw", nil, adminHeader)
+	if response.Code != http.StatusInternalServerError {
+		t.Fatalf("status %d, want 500: %s", response.Code, response.Body)
+	}
+	if sectionErrors := decodeBody(t, response)["errors"].(map[string]interface{}); len(sectionErrors) != 3 {
+		t.Errorf("errors = %v, want all three listings", sectionErrors)
+	}
+}
+
+func TestGetCompletionByPlatform(t *testing.T) {
+	h, mock := newTestHandler(t, nil)
+
+	// Android has one ended session of four; web has none ended
+	mock.ExpectQuery(`COUNT\(ended_at\) as completed_sessions\s+FROM sessions\s+GROUP BY platform`).
+		WillReturnRows(sqlmock.NewRows([]string{"platform", "total_sessions", "completed_sessions"}).
+			AddRow("android", 4, 1).
+			AddRow("ios", 2, 2).
+			AddRow("web", 3, 0))
+
+	completion, err := h.getCompletionByPlatform(context.Background(), statsFilter{db: h.db})
+	if err != nil {
+		t.Fatalf("getCompletionByPlatform: %v", err)
+	}
+	want := []struct {
+		platform  string
+		abandoned int
+		rate      float64
+	}{
+		{"android", 3, 0.25},
+		{"ios", 0, 1},
+		{"web", 3, 0},
+	}
+	if len(completion) != len(want) {
+		t.Fatalf("got %d platforms, want %d", len(completion), len(want))
+	}
+	for i, platform := range want {
+		got := completion[i]
+		if got["platform"] != platform.platform || got["abandoned_sessions"] != platform.abandoned || got["completion_rate"] != platform.rate {
+			t.Errorf("platform %d = %v, want %+v", i, got, platform)
+		}
+	}
+}
+
+func TestGetStatsIDCursors(t *testing.T) {
+	h, mock := newTestHandler(t, nil)
+	router := newTestRouter(h)
+	created := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	sessionColumns := []string{"id", "session_id", "user_id", "platform", "resolution",
+		"device_model", "os_version", "created_at", "ended_at"}
+
+	// expectPoll expects one raw data poll with the given session cursor,
+	// answered with sessions, and no new performance samples or events.
+	expectPoll := func(sinceID int64, sessions *sqlmock.Rows, total int) {
+		expectAdminKey(mock, config.ScopeRead)
+		expectLastModified(mock, created)
+		mock.ExpectQuery(`FROM sessions\s+WHERE id > \?`).
+			WithArgs(sinceID, 100, 0).
+			WillReturnRows(sessions)
+		mock.ExpectQuery(`SELECT COUNT\(\*\) FROM sessions WHERE id > \?`).
+			WithArgs(sinceID).
+			WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(total))
+		mock.ExpectQuery(`FROM performance_metrics\s+WHERE id > \?`).
+			WithArgs(int64(7), 100, 0).
+			WillReturnRows(sqlmock.NewRows([]string{"id"}))
+		mock.ExpectQuery(`SELECT COUNT\(\*\) FROM performance_metrics WHERE id > \?`).
+			WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+		mock.ExpectQuery(`FROM events\s+ORDER BY`).
+			WillReturnRows(sqlmock.NewRows([]string{"id"}))
+		mock.ExpectQuery(`SELECT COUNT\(\*\) FROM events`).
+			WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+	}
+	cursors := func(response *httptest.ResponseRecorder) map[string]interface{} {
+		t.Helper()
+		if response.Code != http.StatusOK {
+			t.Fatalf("status %d, want 200: %s", response.Code, response.Body)
+		}
+		return decodeBody(t, response)["cursors"].(map[string]interface{})
+	}
+
+	// Two sessions were inserted since the first poll; the cursor moves to
+	// the newest of them
+	expectPoll(10, sqlmock.NewRows(sessionColumns).
+		AddRow(12, "s12", "u1", "ios", "1170x2532", nil, nil, created, nil).
+		AddRow(11, "s11", "u1", "ios", "1170x2532", nil, nil, created, nil), 2)
+	got := cursors(serve(router, http.MethodGet,
+		"/api/analytics/stats?include=raw&sessions_since_id=10&performance_since_id=7", nil, adminHeader))
+	if got["sessions"] != 12.0 || got["performance"] != 7.0 || got["events"] != 0.0 {
+		t.Errorf("cursors after inserts = %v, want sessions 12, performance 7, events 0", got)
+	}
+
+	// Nothing new: the cursors stay where they were
+	expectPoll(12, sqlmock.NewRows(sessionColumns), 0)
+	got = cursors(serve(router, http.MethodGet,
+		"/api/analytics/stats?include=raw&sessions_since_id=12&performance_since_id=7", nil, adminHeader))
+	if got["sessions"] != 12.0 || got["performance"] != 7.0 {
+		t.Errorf("cursors without inserts = %v, want sessions 12, performance 7", got)
+	}
+}
+
+func TestParseRawDataOptionsRejectsInvalidCursor(t *testing.T) {
+	h, _ := newTestHandler(t, nil)
+	for _, query := range []string{"sessions_since_id=-1", "events_since_id=abc"} {
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		c.Request = httptest.NewRequest(http.MethodGet, "/api/analytics/stats?"+query, nil)
+		if _, _, err := h.parseRawDataOptions(c); err == nil {
+			t.Errorf("%s: want an error", query)
+		}
+	}
+}
+
+func TestGetTutorialStatistics(t *testing.T) {
+	h, mock := newTestHandler(t, nil)
+
+	// Of five sessions by four users, two complete the tutorial, one skips
+	// it and two never reach it
+	mock.ExpectQuery(`LEFT JOIN \(\s+SELECT\s+session_id as tutorial_session_id`).
+		WithArgs(eventTutorialComplete, eventTutorialSkip, eventTutorialComplete, eventTutorialSkip).
+		WillReturnRows(sqlmock.NewRows([]string{"total_sessions", "total_users", "completed_sessions", "skipped_sessions", "completed_users"}).
+			AddRow(5, 4, 2, 1, 2))
+
+	stats, err := h.getTutorialStatistics(context.Background(), statsFilter{db: h.db})
+	if err != nil {
+		t.Fatalf("getTutorialStatistics: %v", err)
+	}
+	if stats["completed_sessions"] != 2 || stats["skipped_sessions"] != 1 {
+		t.Errorf("stats = %v, want 2 completed and 1 skipped", stats)
+	}
+	if stats["session_completion_rate"] != 0.4 || stats["user_completion_rate"] != 0.5 {
+		t.Errorf("rates = %v and %v, want 0.4 and 0.5", stats["session_completion_rate"], stats["user_completion_rate"])
+	}
+}
+
+func TestRecordEventAcceptsTutorialEvents(t *testing.T) {
+	h, mock := newTestHandler(t, nil)
+	router := newTestRouter(h)
+
+	for _, eventType := range []string{eventTutorialComplete, eventTutorialSkip} {
+		expectSessionNotOptedOut(mock, "s1")
+		mock.ExpectExec(`INSERT INTO events`).WillReturnResult(sqlmock.NewResult(1, 1))
+		response := serve(router, http.MethodPost, "/api/analytics/event",
+			map[string]interface{}{"session_id": "s1", "event_type": eventType}, nil)
+		if response.Code != http.StatusCreated {
+			t.Errorf("%s: status %d, want 201: %s", eventType, response.Code, response.Body)
+		}
+	}
+}
+
+func TestGetSessionsPerUser(t *testing.T) {
+	h, mock := newTestHandler(t, nil)
+
+	// Two users played once, the others came back 2, 4 and 7 times
+	mock.ExpectQuery(`SELECT COUNT\(\*\) as session_count\s+FROM sessions\s+GROUP BY user_id`).
+		WillReturnRows(sqlmock.NewRows([]string{"session_count"}).
+			AddRow(1).AddRow(4).AddRow(1).AddRow(7).AddRow(2))
+
+	stats, err := h.getSessionsPerUser(context.Background(), statsFilter{db: h.db})
+	if err != nil {
+		t.Fatalf("getSessionsPerUser: %v", err)
+	}
+	if stats["users"] != 5 || stats["single_session_users"] != 2 || stats["returning_users"] != 3 {
+		t.Errorf("users = %v, single = %v, returning = %v; want 5, 2, 3",
+			stats["users"], stats["single_session_users"], stats["returning_users"])
+	}
+	for key, want := range map[string]float64{
+		"avg_sessions_per_user": 3,
+		"p50_sessions_per_user": 2,
+		"p95_sessions_per_user": 6.4,
+	} {
+		if got := stats[key].(float64); math.Abs(got-want) > 1e-9 {
+			t.Errorf("%s = %v, want %v", key, got, want)
+		}
+	}
+}
+
+func TestGetSessionsPerUserWithoutSessions(t *testing.T) {
+	h, mock := newTestHandler(t, nil)
+	mock.ExpectQuery(`GROUP BY user_id`).WillReturnRows(sqlmock.NewRows([]string{"session_count"}))
+
+	stats, err := h.getSessionsPerUser(context.Background(), statsFilter{db: h.db})
+	if err != nil {
+		t.Fatalf("getSessionsPerUser: %v", err)
+	}
+	if stats["users"] != 0 || stats["avg_sessions_per_user"] != 0.0 {
+		t.Errorf("stats = %v, want no users", stats)
+	}
+}
+
+func TestRecordCategoryStatsAccumulates(t *testing.T) {
+	h, mock := newTestHandler(t, nil)
+	router := newTestRouter(h)
+	body := map[string]interface{}{"session_id": "s1", "category": "phishing", "success_rate": 1}
+
+	// The second report of the category hits the unique key and is
+	// counted on the existing row instead of failing
+	for _, affected := range []int64{1, 2} {
+		expectSessionNotOptedOut(mock, "s1")
+		mock.ExpectBegin()
+		mock.ExpectQuery(`SELECT session_id FROM sessions WHERE session_id = \? FOR UPDATE`).
+			WithArgs("s1").
+			WillReturnRows(sqlmock.NewRows([]string{"session_id"}).AddRow("s1"))
+		mock.ExpectExec(`INSERT INTO category_stats \(\s+session_id, category_name, total_cards, accepted_cards,\s+`+
+			`average_decision_time, completion_time\s+\) VALUES \(\?, \?, 1, \?, 0, 0\)\s+`+
+			`ON DUPLICATE KEY UPDATE accepted_cards = category_stats.accepted_cards \+ VALUES\(accepted_cards\), `+
+			`total_cards = category_stats.total_cards \+ 1`).
+			WithArgs("s1", "phishing", 1.0).
+			WillReturnResult(sqlmock.NewResult(1, affected))
+		mock.ExpectCommit()
+
+		if response := serve(router, http.MethodPost, "/api/analytics/category", body, nil); response.Code != http.StatusCreated {
+			t.Fatalf("report %d: status %d, want 201: %s", affected, response.Code, response.Body)
+		}
+	}
+}
+
+func TestRecordCategoryStatsUnknownSession(t *testing.T) {
+	h, mock := newTestHandler(t, nil)
+	expectSessionNotOptedOut(mock, "s1")
+	mock.ExpectBegin()
+	mock.ExpectQuery(`SELECT session_id FROM sessions WHERE session_id = \? FOR UPDATE`).
+		WithArgs("s1").
+		WillReturnRows(sqlmock.NewRows([]string{"session_id"}))
+	mock.ExpectRollback()
+
+	response := serve(newTestRouter(h), http.MethodPost, "/api/analytics/category",
+		map[string]interface{}{"session_id": "s1", "category": "phishing"}, nil)
+	if response.Code != http.StatusBadRequest {
+		t.Errorf("status %d, want 400", response.Code)
+	}
+}
+
+func TestCreateSessionRoundTrip(t *testing.T) {
+	h, mock := newTestHandler(t, nil)
+	created := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	// Every field of the request reaches its own column
+	expectUserNotOptedOut(mock, "u1")
+	mock.ExpectExec(`INSERT INTO sessions \(\s+session_id, user_id, platform, resolution, device_model, os_version,\s+`+
+		`os_major, os_minor, os_patch, app_version, tags, ip_address, user_agent\s+\)`).
+		WithArgs("s1", "u1", "ios", "1170x2532", "iPhone 13", "17.2.1", 17, 2, 1, "2.3.0", `{"cohort":"a"}`, sqlmock.AnyArg(), "CyberSwipe/2.3.0").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+
+	response := serve(newTestRouter(h), http.MethodPost, "/api/analytics/session", map[string]interface{}{
+		"session_id":   "s1",
+		"user_id":      "u1",
+		"platform":     "ios",
+		"resolution":   "1170x2532",
+		"device_model": "iPhone 13",
+		"os_version":   "17.2.1",
+		"app_version":  "2.3.0",
+		"tags":         map[string]string{"cohort": "a"},
+	}, http.Header{"User-Agent": {"CyberSwipe/2.3.0"}})
+	if response.Code != http.StatusCreated {
+		t.Fatalf("status %d, want 201: %s", response.Code, response.Body)
+	}
+
+	// The stored row reads back with the device fields and no end time
+	mock.ExpectQuery(`SELECT\s+id,\s+session_id,\s+user_id,\s+platform,\s+resolution,\s+device_model,\s+os_version,`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "session_id", "user_id", "platform", "resolution",
+			"device_model", "os_version", "created_at", "ended_at"}).
+			AddRow(1, "s1", "u1", "ios", "1170x2532", "iPhone 13", "17.2.1", created, nil))
+	mock.ExpectQuery(`SELECT COUNT\(\*\) FROM sessions`).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+
+	sessions, total, err := h.getSessionStatistics(context.Background(), rawDataOptions{Limit: 100})
+	if err != nil {
+		t.Fatalf("getSessionStatistics: %v", err)
+	}
+	if total != 1 || len(sessions) != 1 {
+		t.Fatalf("got %d of %d sessions, want 1 of 1", len(sessions), total)
+	}
+	session := sessions[0]
+	if session["device_model"] != "iPhone 13" || session["os_version"] != "17.2.1" || session["ended_at"] != nil {
+		t.Errorf("session = %v, want iPhone 13 on 17.2.1, not ended", session)
+	}
+}
+
+func TestGetStatsPaginatesRawData(t *testing.T) {
+	h, mock := newTestHandler(t, nil)
+	router := newTestRouter(h)
+	created := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	const totalEvents = 150
+
+	// expectPage expects the listings of one page over a table of 150
+	// events, newest first, and no sessions or performance samples.
+	expectPage := func(limit, offset int) {
+		expectAdminKey(mock, config.ScopeRead)
+		expectLastModified(mock, created)
+		mock.ExpectQuery(`FROM sessions\s+ORDER BY created_at DESC, id DESC\s+LIMIT \? OFFSET \?`).
+			WithArgs(limit, offset).
+			WillReturnRows(sqlmock.NewRows([]string{"id"}))
+		mock.ExpectQuery(`SELECT COUNT\(\*\) FROM sessions`).
+			WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+		mock.ExpectQuery(`FROM performance_metrics\s+ORDER BY timestamp DESC, id DESC\s+LIMIT \? OFFSET \?`).
+			WithArgs(limit, offset).
+			WillReturnRows(sqlmock.NewRows([]string{"id"}))
+		mock.ExpectQuery(`SELECT COUNT\(\*\) FROM performance_metrics`).
+			WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+		rows := sqlmock.NewRows([]string{"id", "session_id", "event_type", "card_id", "direction",
+			"success", "duration", "start_x", "end_x", "max_rotation", "created_at"})
+		for id := totalEvents - offset; id > max(totalEvents-offset-limit, 0); id-- {
+			rows.AddRow(id, "s1", "swipe", "c1", "right", true, 0.8, 10.0, 300.0, 12.0, created)
+		}
+		mock.ExpectQuery(`FROM events\s+ORDER BY created_at DESC, id DESC\s+LIMIT \? OFFSET \?`).
+			WithArgs(limit, offset).
+			WillReturnRows(rows)
+		mock.ExpectQuery(`SELECT COUNT\(\*\) FROM events`).
+			WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(totalEvents))
+	}
+	page := func(query string) (events []interface{}, pagination map[string]interface{}) {
+		t.Helper()
+		response := serve(router, http.MethodGet, "/api/analytics/stats?include=raw"+query, nil, adminHeader)
+		if response.Code != http.StatusOK {
+			t.Fatalf("%q: status %d, want 200: %s", query, response.Code, response.Body)
+		}
+		body := decodeBody(t, response)
+		return body["raw_data"].(map[string]interface{})["events"].([]interface{}),
+			body["pagination"].(map[string]interface{})
+	}
+
+	// The default page holds the 100 newest events
+	expectPage(100, 0)
+	events, pagination := page("")
+	if len(events) != 100 || events[0].(map[string]interface{})["id"] != 150.0 {
+		t.Errorf("first page: got %d events starting at %v, want 100 starting at 150", len(events), events[0])
+	}
+	if pagination["limit"] != 100.0 || pagination["offset"] != 0.0 ||
+		pagination["total"].(map[string]interface{})["events"] != 150.0 {
+		t.Errorf("first page pagination = %v, want limit 100, offset 0, 150 events", pagination)
+	}
+
+	// The second page holds the rest
+	expectPage(100, 100)
+	events, pagination = page("&offset=100")
+	if len(events) != 50 || events[49].(map[string]interface{})["id"] != 1.0 {
+		t.Errorf("second page: got %d events, want the 50 oldest", len(events))
+	}
+	if pagination["offset"] != 100.0 {
+		t.Errorf("second page offset = %v, want 100", pagination["offset"])
+	}
+}
+
+func TestGetStatsRejectsInvalidPagination(t *testing.T) {
+	h, mock := newTestHandler(t, nil)
+	router := newTestRouter(h)
+
+	for _, query := range []string{"limit=0", "limit=1001", "limit=10001", "offset=-1", "limit=ten"} {
+		expectAdminKey(mock, config.ScopeRead)
+		if response := serve(router, http.MethodGet, "/api/analytics/stats?include=raw&"+query, nil, adminHeader); response.Code != http.StatusBadRequest {
+			t.Errorf("%s: status %d, want 400", query, response.Code)
+		}
+	}
+}
+
+func TestGetStatsIncludeModes(t *testing.T) {
+	h, mock := newTestHandler(t, nil)
+	router := newTestRouter(h)
+	created := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	// expectRawData expects the three listings, each empty.
+	expectRawData := func() {
+		for _, table := range []string{"sessions", "performance_metrics", "events"} {
+			mock.ExpectQuery(`FROM ` + table + `\s+ORDER BY`).WillReturnRows(sqlmock.NewRows([]string{"id"}))
+			mock.ExpectQuery(`SELECT COUNT\(\*\) FROM ` + table).WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+		}
+	}
+	// expectSummary expects the aggregated statistics queries.
+	expectSummary := func() {
+		for _, rows := range aggregatedStatisticsRows() {
+			mock.ExpectQuery(`.`).WillReturnRows(rows)
+		}
+	}
+
+	tests := []struct {
+		include      string
+		expect       []func()
+		raw, summary bool
+	}{
+		// The summary runs none of the listing queries, which would fail
+		// as unexpected
+		{"summary", []func(){expectSummary}, false, true},
+		{"raw", []func(){expectRawData}, true, false},
+		{"all", []func(){expectRawData, expectSummary}, true, true},
+	}
+	for _, tt := range tests {
+		expectAdminKey(mock, config.ScopeRead)
+		if tt.include == "raw" {
+			expectLastModified(mock, created)
+		}
+		for _, expect := range tt.expect {
+			expect()
+		}
+		response := serve(router, http.MethodGet, "/api/analytics/stats?include="+tt.include, nil, adminHeader)
+		if response.Code != http.StatusOK {
+			t.Fatalf("%s: status %d, want 200: %s", tt.include, response.Code, response.Body)
+		}
+		body := decodeBody(t, response)
+		if _, ok := body["raw_data"]; ok != tt.raw {
+			t.Errorf("%s: raw_data present = %v, want %v", tt.include, ok, tt.raw)
+		}
+		if _, ok := body["pagination"]; ok != tt.raw {
+			t.Errorf("%s: pagination present = %v, want %v", tt.include, ok, tt.raw)
+		}
+		if _, ok := body["statistics"]; ok != tt.summary {
+			t.Errorf("%s: statistics present = %v, want %v", tt.include, ok, tt.summary)
+		}
+	}
+
+	// Leaving include out returns both
+	expectAdminKey(mock, config.ScopeRead)
+	expectRawData()
+	expectSummary()
+	response := serve(router, http.MethodGet, "/api/analytics/stats", nil, adminHeader)
+	body := decodeBody(t, response)
+	if body["raw_data"] == nil || body["statistics"] == nil {
+		t.Errorf("default: status %d, want raw_data and statistics: %s", response.Code, response.Body)
+	}
+}
+
+func TestGetStatsRejectsInvalidInclude(t *testing.T) {
+	h, mock := newTestHandler(t, nil)
+	expectAdminKey(mock, config.ScopeRead)
+
+	response := serve(newTestRouter(h), http.MethodGet, "/api/analytics/stats?include=everything", nil, adminHeader)
+	if response.Code != http.StatusBadRequest {
+		t.Errorf("status %d, want 400", response.Code)
+	}
+}
+
+func TestGetCategoryStatisticsSuccessRates(t *testing.T) {
+	h, mock := newTestHandler(t, nil)
+
+	// Success rates are unweighted shares of accepted cards; a category
+	// without cards has a rate of 0 rather than dividing by zero
+	mock.ExpectQuery(`FROM category_stats\s+GROUP BY category_name\s+ORDER BY total_cards DESC`).
+		WillReturnRows(sqlmock.NewRows([]string{"category_name", "total_cards", "accepted_cards",
+			"avg_decision_time", "avg_completion_time", "unique_sessions"}).
+			AddRow("phishing", 40.0, 30.0, 1.2, 45.0, 4).
+			AddRow("malware", 8.0, 2.0, 2.5, 30.0, 2).
+			AddRow("passwords", 0.0, 0.0, 0.0, 0.0, 1))
+
+	categories, err := h.getCategoryStatistics(context.Background(), statsFilter{db: h.db})
+	if err != nil {
+		t.Fatalf("getCategoryStatistics: %v", err)
+	}
+	want := map[string]float64{"phishing": 75, "malware": 25, "passwords": 0}
+	if len(categories) != len(want) {
+		t.Fatalf("got %d categories, want %d", len(categories), len(want))
+	}
+	for _, category := range categories {
+		name := category["category"].(string)
+		if category["success_rate"] != want[name] {
+			t.Errorf("%s success_rate = %v, want %v", name, category["success_rate"], want[name])
+		}
+	}
+}
+
+func TestGetAggregatedStatisticsUndoRate(t *testing.T) {
+	h, mock := newTestHandler(t, nil)
+
+	// Two of eight swipes were taken back, c3 twice
+	rows := aggregatedStatisticsRows()
+	rows[aggregatedEventsQuery] = sqlmock.NewRows([]string{"total_events", "total_swipes", "successful_swipes",
+		"avg_duration", "avg_distance", "avg_rotation", "total_undos"}).
+		AddRow(10, 8, 5, 0.8, 290.0, 12.0, 2)
+	rows[aggregatedUndosByCardQuery] = sqlmock.NewRows([]string{"card_id", "undos"}).AddRow("c3", 2)
+	for i, r := range rows {
+		var query *sqlmock.ExpectedQuery
+		switch i {
+		case aggregatedEventsQuery:
+			query = mock.ExpectQuery(`COUNT\(CASE WHEN event_type = \? THEN 1 END\) as total_undos`).
+				WithArgs(h.cfg.MaxRotation, eventUndo)
+		case aggregatedUndosByCardQuery:
+			query = mock.ExpectQuery(`SELECT card_id, COUNT\(\*\) as undos\s+FROM events\s+WHERE event_type = \?`).
+				WithArgs(eventUndo, maxUndoCards)
+		default:
+			query = mock.ExpectQuery(`.`)
+		}
+		query.WillReturnRows(r)
+	}
+
+	stats, err := h.getAggregatedStatistics(context.Background(), statsFilter{db: h.db})
+	if err != nil {
+		t.Fatalf("getAggregatedStatistics: %v", err)
+	}
+	events := stats["events"].(gin.H)
+	if events["total_undos"] != 2 || events["undo_rate"] != 0.25 {
+		t.Errorf("undos = %v at rate %v, want 2 at 0.25", events["total_undos"], events["undo_rate"])
+	}
+	cards := events["undos_by_card"].([]map[string]interface{})
+	if len(cards) != 1 || cards[0]["card_id"] != "c3" || cards[0]["undos"] != 2 {
+		t.Errorf("undos_by_card = %v, want c3 twice", cards)
+	}
+}
+
+func TestGetAggregatedStatisticsSessionDuration(t *testing.T) {
+	start := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	ended := []time.Time{start.Add(5 * time.Minute), start.Add(10*time.Minute + 30*time.Second)}
+	var sum time.Duration
+	for _, end := range ended {
+		sum += end.Sub(start)
+	}
+
+	tests := []struct {
+		name             string
+		total, completed int
+		avg              interface{}
+		wantAvg          float64
+	}{
+		// Two sessions ended after 300s and 630s, a third was abandoned
+		{"completed", 3, len(ended), sum.Seconds() / float64(len(ended)), 465},
+		// Without ended sessions AVG yields NULL, reported as 0
+		{"none completed", 2, 0, nil, 0},
+	}
+	for _, tt := range tests {
+		h, mock := newTestHandler(t, nil)
+		rows := aggregatedStatisticsRows()
+		rows[0] = sqlmock.NewRows([]string{"total_sessions", "completed_sessions", "avg_session_duration"}).
+			AddRow(tt.total, tt.completed, tt.avg)
+		mock.ExpectQuery(`COUNT\(ended_at\) as completed_sessions,\s+AVG\(CASE WHEN ended_at IS NOT NULL THEN TIMESTAMPDIFF\(MICROSECOND, created_at, ended_at\) / 1000000 END\)`).
+			WillReturnRows(rows[0])
+		for _, r := range rows[1:] {
+			mock.ExpectQuery(`.`).WillReturnRows(r)
+		}
+
+		stats, err := h.getAggregatedStatistics(context.Background(), statsFilter{db: h.db})
+		if err != nil {
+			t.Fatalf("%s: getAggregatedStatistics: %v", tt.name, err)
+		}
+		sessions := stats["sessions"].(gin.H)
+		if sessions["avg_session_duration_seconds"] != tt.wantAvg {
+			t.Errorf("%s: avg_session_duration_seconds = %v, want %v", tt.name, sessions["avg_session_duration_seconds"], tt.wantAvg)
+		}
+		if sessions["completed_sessions"] != tt.completed || sessions["abandoned_sessions"] != tt.total-tt.completed {
+			t.Errorf("%s: %v completed and %v abandoned, want %d and %d", tt.name,
+				sessions["completed_sessions"], sessions["abandoned_sessions"], tt.completed, tt.total-tt.completed)
+		}
+	}
+}
+
+func TestGetAggregatedStatisticsCoalescesSuccess(t *testing.T) {
+	h, mock := newTestHandler(t, nil)
+
+	// The database does the counting, so this only checks that the query
+	// counts a missing success value as unsuccessful and that the rate is
+	// derived from the counts it returns
+	rows := aggregatedStatisticsRows()
+	rows[aggregatedEventsQuery] = sqlmock.NewRows([]string{"total_events", "total_swipes", "successful_swipes",
+		"avg_duration", "avg_distance", "avg_rotation", "total_undos"}).
+		AddRow(4, 4, 2, 0.8, 290.0, 12.0, 0)
+	for i, r := range rows {
+		query := `.`
+		if i == aggregatedEventsQuery {
+			query = `COUNT\(CASE WHEN event_type = 'card_swipe' AND COALESCE\(success, false\) = true THEN 1 END\) as successful_swipes`
+		}
+		mock.ExpectQuery(query).WillReturnRows(r)
+	}
+
+	stats, err := h.getAggregatedStatistics(context.Background(), statsFilter{db: h.db})
+	if err != nil {
+		t.Fatalf("getAggregatedStatistics: %v", err)
+	}
+	events := stats["events"].(gin.H)
+	if events["total_swipes"] != 4 || events["swipe_success_rate"] != 50.0 {
+		t.Errorf("%v swipes at rate %v, want 4 at 50", events["total_swipes"], events["swipe_success_rate"])
+	}
+}
+
+func TestGetStatsRawEventsNullSuccess(t *testing.T) {
+	h, mock := newTestHandler(t, nil)
+	created := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	expectAdminKey(mock, config.ScopeRead)
+	expectLastModified(mock, created)
+	mock.ExpectQuery(`FROM sessions\s+ORDER BY`).WillReturnRows(sqlmock.NewRows([]string{"id"}))
+	mock.ExpectQuery(`SELECT COUNT\(\*\) FROM sessions`).WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+	mock.ExpectQuery(`FROM performance_metrics\s+ORDER BY`).WillReturnRows(sqlmock.NewRows([]string{"id"}))
+	mock.ExpectQuery(`SELECT COUNT\(\*\) FROM performance_metrics`).WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+
+	// The event was recorded before success was required
+	mock.ExpectQuery(`FROM events\s+ORDER BY`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "session_id", "event_type", "card_id", "direction", "success",
+			"duration", "start_x", "end_x", "max_rotation", "created_at"}).
+			AddRow(1, "s1", "card_swipe", "c1", "left", nil, 0.5, 300.0, 20.0, -8.5, created))
+	mock.ExpectQuery(`SELECT COUNT\(\*\) FROM events`).WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+
+	response := serve(newTestRouter(h), http.MethodGet, "/api/analytics/stats?include=raw", nil, adminHeader)
+	if response.Code != http.StatusOK {
+		t.Fatalf("status %d, want 200: %s", response.Code, response.Body)
+	}
+	body := decodeBody(t, response)
+	events := body["raw_data"].(map[string]interface{})["events"].([]interface{})
+	if len(events) != 1 || events[0].(map[string]interface{})["success"] != false {
+		t.Errorf("events = %v, want one unsuccessful event", events)
+	}
+	if errors, ok := body["errors"]; ok {
+		t.Errorf("errors = %v, want none", errors)
+	}
+}
+
+func TestRecordEventStoresMissingSuccessAsFalse(t *testing.T) {
+	h, mock := newTestHandler(t, nil)
+
+	// The success argument follows session_id, event_type, card_id and
+	// direction
+	args := make([]driver.Value, 14)
+	for i := range args {
+		args[i] = sqlmock.AnyArg()
+	}
+	args[4] = false
+	expectSessionNotOptedOut(mock, "s1")
+	mock.ExpectExec(`INSERT INTO events`).WithArgs(args...).WillReturnResult(sqlmock.NewResult(1, 1))
+
+	response := serve(newTestRouter(h), http.MethodPost, "/api/analytics/event",
+		map[string]interface{}{"session_id": "s1", "event_type": "card_swipe", "card_id": "c1", "direction": "left"}, nil)
+	if response.Code != http.StatusCreated {
+		t.Fatalf("status %d, want 201: %s", response.Code, response.Body)
+	}
+}
+
+func TestRecordEventUndoRequiresCard(t *testing.T) {
+	h, mock := newTestHandler(t, nil)
+	router := newTestRouter(h)
+
+	response := serve(router, http.MethodPost, "/api/analytics/event", map[string]interface{}{"session_id": "s1", "event_type": "undo"}, nil)
+	if response.Code != http.StatusBadRequest {
+		t.Errorf("undo without card: status %d, want 400", response.Code)
+	}
+
+	expectSessionNotOptedOut(mock, "s1")
+	mock.ExpectExec(`INSERT INTO events`).WillReturnResult(sqlmock.NewResult(1, 1))
+	response = serve(router, http.MethodPost, "/api/analytics/event", map[string]interface{}{"session_id": "s1", "event_type": "undo", "card_id": "c3"}, nil)
+	if response.Code != http.StatusCreated {
+		t.Errorf("undo of c3: status %d, want 201: %s", response.Code, response.Body)
+	}
+}
+
+func TestRecordEventStoresCustomProperties(t *testing.T) {
+	h, mock := newTestHandler(t, nil)
+	expectSessionNotOptedOut(mock, "s1")
+	expectPropertyKeys(mock, nil, "experiment", "screen")
+	mock.ExpectExec(`INSERT INTO events`).
+		WithArgs("s1", "button_tap", "", "", false, 0.0, 0.0, 0.0, 0.0, 0.0, nil, nil, nil,
+			`{"experiment":"b","screen":"settings"}`).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+
+	body := map[string]interface{}{
+		"session_id": "s1",
+		"event_type": "button_tap",
+		"properties": map[string]interface{}{"screen": "settings", "experiment": "b"},
+	}
+	response := serve(newTestRouter(h), http.MethodPost, "/api/analytics/event", body, nil)
+	if response.Code != http.StatusCreated {
+		t.Fatalf("status %d, want 201: %s", response.Code, response.Body)
+	}
+}
+
+func TestRecordEventRejectsInvalidProperties(t *testing.T) {
+	h, _ := newTestHandler(t, nil)
+	tooMany := make(map[string]interface{})
+	for i := 0; i <= maxEventProperties; i++ {
+		tooMany[fmt.Sprintf("key%d", i)] = "value"
+	}
+
+	// Nothing is stored, so no query is expected
+	for _, properties := range []map[string]interface{}{
+		{"bad key": "value"},
+		{"difficulty": strings.Repeat("x", maxPropertyValueLen+1)},
+		{"difficulty": 3},
+		tooMany,
+	} {
+		body := map[string]interface{}{"session_id": "s1", "event_type": "button_tap", "properties": properties}
+		response := serve(newTestRouter(h), http.MethodPost, "/api/analytics/event", body, nil)
+		if response.Code != http.StatusBadRequest {
+			t.Errorf("%v: status %d, want 400", properties, response.Code)
+		}
+	}
+}
+
+func TestRecordEventValidatesEventType(t *testing.T) {
+	h, mock := newTestHandler(t, nil)
+	router := newTestRouter(h)
+
+	expectSessionNotOptedOut(mock, "s1")
+	mock.ExpectExec(`INSERT INTO events`).WillReturnResult(sqlmock.NewResult(1, 1))
+	response := serve(router, http.MethodPost, "/api/analytics/event", map[string]interface{}{"session_id": "s1", "event_type": "card_view"}, nil)
+	if response.Code != http.StatusCreated {
+		t.Fatalf("card_view: status %d, want 201: %s", response.Code, response.Body)
+	}
+
+	// The typo is refused before any query, with the valid types listed
+	response = serve(router, http.MethodPost, "/api/analytics/event", map[string]interface{}{"session_id": "s1", "event_type": "card_swip"}, nil)
+	if response.Code != http.StatusBadRequest {
+		t.Fatalf("card_swip: status %d, want 400: %s", response.Code, response.Body)
+	}
+	valid := decodeBody(t, response)["valid_event_types"].([]interface{})
+	if len(valid) != len(allowedEventTypes) {
+		t.Fatalf("valid_event_types = %v, want all %d types", valid, len(allowedEventTypes))
+	}
+	for i, eventType := range valid {
+		if !allowedEventTypes[eventType.(string)] || i > 0 && eventType.(string) < valid[i-1].(string) {
+			t.Errorf("valid_event_types = %v, want the allowed types in order", valid)
+			break
+		}
+	}
+}
+
+func TestRecordCategoryStatsUpsertDialects(t *testing.T) {
+	tests := []struct {
+		driver string
+		lock   string
+		upsert string
+	}{
+		{
+			storage.DriverMySQL,
+			`SELECT session_id FROM sessions WHERE session_id = \? FOR UPDATE`,
+			`VALUES \(\?, \?, 1, \?, 0, 0\)\s+ON DUPLICATE KEY UPDATE accepted_cards = category_stats.accepted_cards \+ VALUES\(accepted_cards\)`,
+		},
+		{
+			storage.DriverPostgres,
+			`SELECT session_id FROM sessions WHERE session_id = \$1 FOR UPDATE`,
+			`VALUES \(\$1, \$2, 1, \$3, 0, 0\)\s+ON CONFLICT \(session_id, category_name\) DO UPDATE SET accepted_cards = category_stats.accepted_cards \+ EXCLUDED.accepted_cards`,
+		},
+	}
+	for _, tt := range tests {
+		h, mock := newTestHandler(t, nil)
+		h.db.Driver = tt.driver
+
+		expectSessionNotOptedOut(mock, "s1")
+		mock.ExpectBegin()
+		mock.ExpectQuery(tt.lock).WithArgs("s1").WillReturnRows(sqlmock.NewRows([]string{"session_id"}).AddRow("s1"))
+		mock.ExpectExec(`INSERT INTO category_stats .*`+tt.upsert).
+			WithArgs("s1", "phishing", 0.0).
+			WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectCommit()
+
+		response := serve(newTestRouter(h), http.MethodPost, "/api/analytics/category",
+			map[string]interface{}{"session_id": "s1", "category": "phishing"}, nil)
+		if response.Code != http.StatusCreated {
+			t.Errorf("%s: status %d, want 201: %s", tt.driver, response.Code, response.Body)
+		}
+	}
+}
+
+func TestRecordCategoryStatsRollsBackFailedUpsert(t *testing.T) {
+	h, mock := newTestHandler(t, nil)
+
+	// The session row was locked, then the upsert fails: nothing is
+	// committed
+	expectSessionNotOptedOut(mock, "s1")
+	mock.ExpectBegin()
+	mock.ExpectQuery(`SELECT session_id FROM sessions WHERE session_id = \? FOR UPDATE`).
+		WithArgs("s1").
+		WillReturnRows(sqlmock.NewRows([]string{"session_id"}).AddRow("s1"))
+	mock.ExpectExec(`INSERT INTO category_stats`).WillReturnError(errors.New("constraint violation"))
+	mock.ExpectRollback()
+
+	response := serve(newTestRouter(h), http.MethodPost, "/api/analytics/category",
+		map[string]interface{}{"session_id": "s1", "category": "phishing"}, nil)
+	if response.Code != http.StatusInternalServerError {
+		t.Errorf("status %d, want 500: %s", response.Code, response.Body)
+	}
+}
+
+// newPingTestHandler returns a handler like newTestHandler whose mock also
+// expects the database pings.
+func newPingTestHandler(t *testing.T) (*AnalyticsHandler, sqlmock.Sqlmock) {
+	t.Helper()
+	h, _ := newTestHandler(t, nil)
+	database, mock, err := sqlmock.New(sqlmock.MonitorPingsOption(true))
+	if err != nil {
+		t.Fatalf("sqlmock.New: %v", err)
+	}
+	t.Cleanup(func() {
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Error(err)
+		}
+		database.Close()
+	})
+	h.db = &storage.DB{DB: database, Driver: storage.DriverMySQL}
+	return h, mock
+}
+
+func TestHealthCheck(t *testing.T) {
+	tests := []struct {
+		name         string
+		pingErr      error
+		wantStatus   int
+		wantHealth   string
+		wantCategory interface{}
+	}{
+		{"healthy", nil, http.StatusOK, "ok", nil},
+		{"unreachable", errors.New("dial tcp: connection refused"), http.StatusServiceUnavailable, "degraded", "database_unavailable"},
+		{"slow", context.DeadlineExceeded, http.StatusServiceUnavailable, "degraded", "database_timeout"},
+	}
+	for _, tt := range tests {
+		h, mock := newPingTestHandler(t)
+		ping := mock.ExpectPing()
+		if tt.pingErr != nil {
+			ping.WillReturnError(tt.pingErr)
+		}
+
+		response := serve(newTestRouter(h), http.MethodGet, "/health", nil, nil)
+		if response.Code != tt.wantStatus {
+			t.Errorf("%s: status %d, want %d", tt.name, response.Code, tt.wantStatus)
+			continue
+		}
+		body := decodeBody(t, response)
+		if body["status"] != tt.wantHealth || body["error"] != tt.wantCategory {
+			t.Errorf("%s: body = %v, want status %s and error %v", tt.name, body, tt.wantHealth, tt.wantCategory)
+		}
+	}
+}
@@ -0,0 +1,124 @@
+package api
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// sessionExists reports whether a session with the given ID is stored.
+func (h *AnalyticsHandler) sessionExists(ctx context.Context, sessionID string) (bool, error) {
+	var id int64
+	err := h.db.QueryRowContext(ctx, "SELECT id FROM sessions WHERE session_id = ?", sessionID).Scan(&id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// getSessionCategories returns the raw category_stats rows of one session,
+// in the order they were recorded, to debug category accounting without
+// direct database access. Unknown sessions yield 404.
+func (h *AnalyticsHandler) getSessionCategories(c *gin.Context) {
+	ctx := c.Request.Context()
+	sessionID := c.Param("session_id")
+
+	exists, err := h.sessionExists(ctx, sessionID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to look up session"})
+		return
+	}
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Session not found"})
+		return
+	}
+
+	rows, err := h.db.QueryContext(ctx, `
+		SELECT
+			id, category_name, total_cards, accepted_cards,
+			average_decision_time, completion_time
+		FROM category_stats
+		WHERE session_id = ?
+		ORDER BY id
+	`, sessionID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get session categories"})
+		return
+	}
+	defer rows.Close()
+
+	categories := make([]map[string]interface{}, 0)
+	for rows.Next() {
+		var id int64
+		var category string
+		var totalCards, acceptedCards, completionTime sql.NullInt64
+		var averageDecisionTime sql.NullFloat64
+		if err := rows.Scan(&id, &category, &totalCards, &acceptedCards, &averageDecisionTime, &completionTime); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get session categories"})
+			return
+		}
+		categories = append(categories, map[string]interface{}{
+			"id":                    id,
+			"category":              category,
+			"total_cards":           nullableInt(totalCards),
+			"accepted_cards":        nullableInt(acceptedCards),
+			"average_decision_time": nullableFloat(averageDecisionTime),
+			"completion_time":       nullableInt(completionTime),
+		})
+	}
+	if err := rows.Err(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get session categories"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"session_id": sessionID,
+		"categories": categories,
+	})
+}
+
+// nullableInt returns the value of n, or nil when it is NULL.
+func nullableInt(n sql.NullInt64) interface{} {
+	if !n.Valid {
+		return nil
+	}
+	return n.Int64
+}
+
+// nullableFloat returns the value of f, or nil when it is NULL.
+func nullableFloat(f sql.NullFloat64) interface{} {
+	if !f.Valid {
+		return nil
+	}
+	return f.Float64
+}
+
+// nullableString returns the value of s, or nil when it is NULL.
+func nullableString(s sql.NullString) interface{} {
+	if !s.Valid {
+		return nil
+	}
+	return s.String
+}
+
+// nullableTime returns the value of t, or nil when it is NULL.
+func nullableTime(t sql.NullTime) interface{} {
+	if !t.Valid {
+		return nil
+	}
+	return t.Time
+}
+
+// nullIfEmpty returns nil for an empty string so that it is stored as NULL.
+func nullIfEmpty(s string) interface{} {
+	if s == "" {
+		return nil
+	}
+	return s
+}
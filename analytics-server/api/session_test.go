@@ -0,0 +1,302 @@
+package api
+
+import (
+	"context"
+	"cyber-swipe-analytics/config"
+	"cyber-swipe-analytics/storage"
+	"database/sql/driver"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+// expectSession expects the existence check of sessionID and answers it.
+func expectSession(mock sqlmock.Sqlmock, sessionID string, exists bool) {
+	rows := sqlmock.NewRows([]string{"id"})
+	if exists {
+		rows.AddRow(1)
+	}
+	mock.ExpectQuery(`SELECT id FROM sessions WHERE session_id = \?`).WithArgs(sessionID).WillReturnRows(rows)
+}
+
+func TestGetSessionCategories(t *testing.T) {
+	h, mock := newTestHandler(t, nil)
+	expectAdminKey(mock, config.ScopeRead)
+	expectSession(mock, "s1", true)
+	mock.ExpectQuery(`FROM category_stats\s+WHERE session_id = \?\s+ORDER BY id`).
+		WithArgs("s1").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "category_name", "total_cards", "accepted_cards",
+			"average_decision_time", "completion_time"}).
+			AddRow(3, "Sports", 10, 7, 1.25, 42).
+			AddRow(4, "Music", 5, nil, nil, nil))
+
+	response := serve(newTestRouter(h), http.MethodGet, "/api/analytics/session/s1/categories", nil, adminHeader)
+	if response.Code != http.StatusOK {
+		t.Fatalf("status %d, want 200: %s", response.Code, response.Body)
+	}
+	categories := decodeBody(t, response)["categories"].([]interface{})
+	if len(categories) != 2 {
+		t.Fatalf("got %d categories, want 2", len(categories))
+	}
+	first, second := categories[0].(map[string]interface{}), categories[1].(map[string]interface{})
+	if first["category"] != "Sports" || first["total_cards"] != 10.0 || first["accepted_cards"] != 7.0 ||
+		first["average_decision_time"] != 1.25 || first["completion_time"] != 42.0 {
+		t.Errorf("first row = %v", first)
+	}
+	if second["category"] != "Music" || second["accepted_cards"] != nil || second["completion_time"] != nil {
+		t.Errorf("second row = %v, want NULL columns as null", second)
+	}
+}
+
+func TestGetSessionCategoriesEmptyAndUnknown(t *testing.T) {
+	h, mock := newTestHandler(t, nil)
+	router := newTestRouter(h)
+
+	// A session without category rows yields an empty array, not null
+	expectAdminKey(mock, config.ScopeRead)
+	expectSession(mock, "s1", true)
+	mock.ExpectQuery(`FROM category_stats`).WithArgs("s1").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}))
+	response := serve(router, http.MethodGet, "/api/analytics/session/s1/categories", nil, adminHeader)
+	if response.Code != http.StatusOK {
+		t.Fatalf("status %d, want 200: %s", response.Code, response.Body)
+	}
+	if categories, ok := decodeBody(t, response)["categories"].([]interface{}); !ok || len(categories) != 0 {
+		t.Errorf("categories = %v, want []", decodeBody(t, response)["categories"])
+	}
+
+	expectAdminKey(mock, config.ScopeRead)
+	expectSession(mock, "nope", false)
+	if response := serve(router, http.MethodGet, "/api/analytics/session/nope/categories", nil, adminHeader); response.Code != http.StatusNotFound {
+		t.Errorf("unknown session: status %d, want 404", response.Code)
+	}
+}
+
+func TestCreateSessionIsIdempotent(t *testing.T) {
+	h, mock := newTestHandler(t, nil)
+	router := newTestRouter(h)
+
+	// Creating the session again refreshes its device fields instead of
+	// failing on the unique session_id; the user is kept
+	for i, platform := range []string{"ios", "android"} {
+		expectUserNotOptedOut(mock, "u1")
+		mock.ExpectExec(`INSERT INTO sessions .*\s+ON DUPLICATE KEY UPDATE platform = VALUES\(platform\), resolution = VALUES\(resolution\), `+
+			`device_model = VALUES\(device_model\), os_version = VALUES\(os_version\), os_major = VALUES\(os_major\), `+
+			`os_minor = VALUES\(os_minor\), os_patch = VALUES\(os_patch\), app_version = VALUES\(app_version\), tags = VALUES\(tags\)$`).
+			WithArgs("s1", "u1", platform, "1080x1920", sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(),
+				sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg()).
+			// MySQL reports an updated row as two affected rows
+			WillReturnResult(sqlmock.NewResult(1, int64(i+1)))
+
+		body := map[string]interface{}{"session_id": "s1", "user_id": "u1", "platform": platform, "resolution": "1080x1920"}
+		if response := serve(router, http.MethodPost, "/api/analytics/session", body, nil); response.Code != http.StatusCreated {
+			t.Fatalf("attempt %d: status %d, want 201: %s", i+1, response.Code, response.Body)
+		}
+	}
+}
+
+func TestCreateSessionIsIdempotentOnPostgres(t *testing.T) {
+	h, mock := newTestHandler(t, nil)
+	h.db.Driver = storage.DriverPostgres
+
+	mock.ExpectQuery(`SELECT EXISTS\(SELECT 1 FROM opt_outs WHERE user_id = \$1\)`).
+		WithArgs("u1").
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+	mock.ExpectExec(`VALUES \(\$1, .*\$13\)\s+ON CONFLICT \(session_id\) DO UPDATE SET platform = EXCLUDED.platform, .*tags = EXCLUDED.tags$`).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+
+	body := map[string]interface{}{"session_id": "s1", "user_id": "u1", "platform": "ios", "resolution": "1080x1920"}
+	if response := serve(newTestRouter(h), http.MethodPost, "/api/analytics/session", body, nil); response.Code != http.StatusCreated {
+		t.Fatalf("status %d, want 201: %s", response.Code, response.Body)
+	}
+}
+
+func TestGetSessionDetail(t *testing.T) {
+	h, mock := newTestHandler(t, nil)
+	router := newTestRouter(h)
+	start := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	// One session with two events and one performance sample
+	expectAdminKey(mock, config.ScopeRead)
+	mock.ExpectQuery(`SELECT \* FROM sessions WHERE session_id = \?`).WithArgs("s1").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "session_id", "user_id", "platform", "created_at", "ended_at"}).
+			AddRow(1, "s1", "u1", "ios", start, nil))
+	mock.ExpectQuery(`SELECT \* FROM events WHERE session_id = \? ORDER BY created_at, id`).WithArgs("s1").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "session_id", "event_type", "success", "created_at"}).
+			AddRow(7, "s1", "card_view", nil, start.Add(time.Second)).
+			AddRow(8, "s1", "card_swipe", true, start.Add(2*time.Second)))
+	mock.ExpectQuery(`SELECT \* FROM performance_metrics WHERE session_id = \? ORDER BY timestamp, id`).WithArgs("s1").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "session_id", "fps", "timestamp"}).AddRow(3, "s1", 59.5, start))
+	mock.ExpectQuery(`SELECT \* FROM category_stats WHERE session_id = \?`).WithArgs("s1").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}))
+
+	response := serve(router, http.MethodGet, "/api/analytics/session/s1", nil, adminHeader)
+	if response.Code != http.StatusOK {
+		t.Fatalf("status %d, want 200: %s", response.Code, response.Body)
+	}
+	detail := decodeBody(t, response)
+	if _, ok := detail["exported_at"]; ok {
+		t.Error("detail carries the bundle's exported_at")
+	}
+	if session := detail["session"].(map[string]interface{}); session["user_id"] != "u1" || session["ended_at"] != nil {
+		t.Errorf("session = %v", session)
+	}
+	events := detail["events"].([]interface{})
+	if len(events) != 2 ||
+		events[0].(map[string]interface{})["event_type"] != "card_view" ||
+		events[1].(map[string]interface{})["success"] != true {
+		t.Errorf("events = %v, want the view then the successful swipe", events)
+	}
+	if metrics := detail["performance_metrics"].([]interface{}); len(metrics) != 1 || metrics[0].(map[string]interface{})["fps"] != 59.5 {
+		t.Errorf("performance_metrics = %v", metrics)
+	}
+	if categories := detail["category_stats"].([]interface{}); len(categories) != 0 {
+		t.Errorf("category_stats = %v, want []", categories)
+	}
+
+	expectAdminKey(mock, config.ScopeRead)
+	mock.ExpectQuery(`SELECT \* FROM sessions`).WithArgs("nope").WillReturnRows(sqlmock.NewRows([]string{"id"}))
+	if response := serve(router, http.MethodGet, "/api/analytics/session/nope", nil, adminHeader); response.Code != http.StatusNotFound {
+		t.Errorf("unknown session: status %d, want 404", response.Code)
+	}
+}
+
+func TestGetSessionDetailRequiresAdminKey(t *testing.T) {
+	h, _ := newTestHandler(t, nil)
+
+	if response := serve(newTestRouter(h), http.MethodGet, "/api/analytics/session/s1", nil, nil); response.Code != http.StatusUnauthorized {
+		t.Errorf("status %d, want 401", response.Code)
+	}
+}
+
+func TestCreateSessionClientIPHonoursTrustedProxies(t *testing.T) {
+	// httptest requests come from 192.0.2.1
+	tests := []struct {
+		proxies []string
+		wantIP  string
+	}{
+		{[]string{"192.0.2.0/24"}, "203.0.113.7"},
+		{[]string{"127.0.0.1"}, "192.0.2.1"},
+	}
+	for _, tt := range tests {
+		h, mock := newTestHandler(t, nil)
+		router := newTestRouter(h)
+		if err := router.SetTrustedProxies(tt.proxies); err != nil {
+			t.Fatalf("SetTrustedProxies: %v", err)
+		}
+
+		expectUserNotOptedOut(mock, "u1")
+		mock.ExpectExec(`INSERT INTO sessions`).
+			WithArgs("s1", "u1", "ios", "1080x1920", sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(),
+				sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), tt.wantIP, sqlmock.AnyArg()).
+			WillReturnResult(sqlmock.NewResult(1, 1))
+
+		body := map[string]interface{}{"session_id": "s1", "user_id": "u1", "platform": "ios", "resolution": "1080x1920"}
+		header := http.Header{"X-Forwarded-For": {"203.0.113.7"}}
+		if response := serve(router, http.MethodPost, "/api/analytics/session", body, header); response.Code != http.StatusCreated {
+			t.Errorf("proxies %v: status %d, want 201: %s", tt.proxies, response.Code, response.Body)
+		}
+	}
+}
+
+func TestCreateSessionRecordsOrigin(t *testing.T) {
+	h, mock := newTestHandler(t, nil)
+
+	// The IP comes from the connection, not from the body, and the user
+	// agent from the request header
+	expectUserNotOptedOut(mock, "u1")
+	mock.ExpectExec(`INSERT INTO sessions`).
+		WithArgs("s1", "u1", "ios", "1080x1920", sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(),
+			sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), "192.0.2.1", "CyberSwipe/2.3.0 (iPhone)").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+
+	body := map[string]interface{}{
+		"session_id": "s1", "user_id": "u1", "platform": "ios", "resolution": "1080x1920",
+		"ip_address": "198.51.100.9", "user_agent": "spoofed",
+	}
+	header := http.Header{"User-Agent": {"CyberSwipe/2.3.0 (iPhone)"}}
+	if response := serve(newTestRouter(h), http.MethodPost, "/api/analytics/session", body, header); response.Code != http.StatusCreated {
+		t.Fatalf("status %d, want 201: %s", response.Code, response.Body)
+	}
+}
+
+func TestGetStatsExposesSessionOrigin(t *testing.T) {
+	h, mock := newTestHandler(t, nil)
+	created := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	mock.ExpectQuery(`SELECT\s+id,\s+session_id,.*ip_address,\s+user_agent,`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "session_id", "user_id", "platform", "resolution",
+			"device_model", "os_version", "ip_address", "user_agent", "created_at", "ended_at"}).
+			AddRow(1, "s1", "u1", "ios", "1170x2532", nil, nil, "203.0.113.7", "CyberSwipe/2.3.0", created, nil).
+			AddRow(2, "s0", "u1", "ios", "1170x2532", nil, nil, nil, nil, created, nil))
+	mock.ExpectQuery(`SELECT COUNT\(\*\) FROM sessions`).WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(2))
+
+	// Sessions recorded before the columns existed have no origin
+	sessions, _, err := h.getSessionStatistics(context.Background(), rawDataOptions{Limit: 100, ClientDetails: true})
+	if err != nil {
+		t.Fatalf("getSessionStatistics: %v", err)
+	}
+	if sessions[0]["ip_address"] != "203.0.113.7" || sessions[0]["user_agent"] != "CyberSwipe/2.3.0" {
+		t.Errorf("session = %v, want its origin", sessions[0])
+	}
+	if sessions[1]["ip_address"] != nil || sessions[1]["user_agent"] != nil {
+		t.Errorf("session = %v, want no origin", sessions[1])
+	}
+}
+
+func TestGetStatsShowsSessionOriginToAdminKeys(t *testing.T) {
+	h, mock := newTestHandler(t, nil)
+	router := newTestRouter(h)
+	created := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	// expectRawData expects a raw data pull whose sessions listing is
+	// answered with one session with the given columns.
+	expectRawData := func(scope string, columns []string, values ...driver.Value) {
+		expectAdminKey(mock, scope)
+		expectLastModified(mock, created)
+		mock.ExpectQuery(`FROM sessions\s+ORDER BY`).WillReturnRows(sqlmock.NewRows(columns).AddRow(values...))
+		mock.ExpectQuery(`SELECT COUNT\(\*\) FROM sessions`).WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+		mock.ExpectQuery(`FROM performance_metrics\s+ORDER BY`).WillReturnRows(sqlmock.NewRows([]string{"id"}))
+		mock.ExpectQuery(`SELECT COUNT\(\*\) FROM performance_metrics`).WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+		mock.ExpectQuery(`FROM events\s+ORDER BY`).WillReturnRows(sqlmock.NewRows([]string{"id"}))
+		mock.ExpectQuery(`SELECT COUNT\(\*\) FROM events`).WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+	}
+	session := func() map[string]interface{} {
+		t.Helper()
+		response := serve(router, http.MethodGet, "/api/analytics/stats?include=raw", nil, adminHeader)
+		if response.Code != http.StatusOK {
+			t.Fatalf("status %d, want 200: %s", response.Code, response.Body)
+		}
+		sessions := decodeBody(t, response)["raw_data"].(map[string]interface{})["sessions"].([]interface{})
+		return sessions[0].(map[string]interface{})
+	}
+
+	// Read-scope keys neither select nor receive the client's origin
+	expectRawData(config.ScopeRead, []string{"id", "session_id", "user_id", "platform", "resolution",
+		"device_model", "os_version", "created_at", "ended_at"},
+		1, "s1", "u1", "ios", "1170x2532", nil, nil, created, nil)
+	got := session()
+	for _, field := range []string{"ip_address", "user_agent"} {
+		if _, ok := got[field]; ok {
+			t.Errorf("read-scope session = %v, want no %s", got, field)
+		}
+	}
+
+	// Admin-scope keys get both
+	expectRawData(config.ScopeAdmin, []string{"id", "session_id", "user_id", "platform", "resolution",
+		"device_model", "os_version", "ip_address", "user_agent", "created_at", "ended_at"},
+		1, "s1", "u1", "ios", "1170x2532", nil, nil, "203.0.113.7", "CyberSwipe/2.3.0", created, nil)
+	got = session()
+	if got["ip_address"] != "203.0.113.7" || got["user_agent"] != "CyberSwipe/2.3.0" {
+		t.Errorf("admin-scope session = %v, want its origin", got)
+	}
+
+	// Read-scope keys cannot ask for the fields either
+	expectAdminKey(mock, config.ScopeRead)
+	response := serve(router, http.MethodGet, "/api/analytics/stats?include=raw&fields[sessions]=session_id,ip_address", nil, adminHeader)
+	if response.Code != http.StatusBadRequest {
+		t.Errorf("read-scope fields[sessions]=ip_address: status %d, want 400", response.Code)
+	}
+}
@@ -0,0 +1,82 @@
+package api
+
+import (
+	"math"
+	"sort"
+)
+
+// percentile returns the p-th percentile (0-100) of values using linear
+// interpolation between the closest ranks. The input does not need to be
+// sorted and is left unmodified. An empty input yields 0.
+func percentile(values []float64, p float64) float64 {
+	if len(values) == 0 {
+		return 0
+	}
+
+	sorted := make([]float64, len(values))
+	copy(sorted, values)
+	sort.Float64s(sorted)
+
+	rank := p / 100 * float64(len(sorted)-1)
+	lower := int(math.Floor(rank))
+	upper := int(math.Ceil(rank))
+	if lower == upper {
+		return sorted[lower]
+	}
+	return sorted[lower] + (sorted[upper]-sorted[lower])*(rank-float64(lower))
+}
+
+// mean returns the arithmetic mean of values, or 0 for an empty input.
+func mean(values []float64) float64 {
+	if len(values) == 0 {
+		return 0
+	}
+	sum := 0.0
+	for _, v := range values {
+		sum += v
+	}
+	return sum / float64(len(values))
+}
+
+// trimmedMean returns the mean of values after discarding the lowest and
+// highest trim fraction (0-0.5) of them, or 0 for an empty input. The
+// input is left unmodified.
+func trimmedMean(values []float64, trim float64) float64 {
+	if len(values) == 0 {
+		return 0
+	}
+
+	sorted := make([]float64, len(values))
+	copy(sorted, values)
+	sort.Float64s(sorted)
+
+	cut := int(float64(len(sorted)) * trim)
+	if 2*cut >= len(sorted) {
+		cut = (len(sorted) - 1) / 2
+	}
+	return mean(sorted[cut : len(sorted)-cut])
+}
+
+// pearson returns the Pearson correlation coefficient of the paired samples
+// xs and ys. The second return value is false when the coefficient is
+// undefined, i.e. with fewer than two pairs or when either side is constant.
+func pearson(xs, ys []float64) (float64, bool) {
+	n := len(xs)
+	if n < 2 || n != len(ys) {
+		return 0, false
+	}
+
+	meanX, meanY := mean(xs), mean(ys)
+	var covariance, varianceX, varianceY float64
+	for i := range xs {
+		dx := xs[i] - meanX
+		dy := ys[i] - meanY
+		covariance += dx * dy
+		varianceX += dx * dx
+		varianceY += dy * dy
+	}
+	if varianceX == 0 || varianceY == 0 {
+		return 0, false
+	}
+	return covariance / math.Sqrt(varianceX*varianceY), true
+}
@@ -0,0 +1,62 @@
+package api
+
+import (
+	"math"
+	"testing"
+)
+
+func TestPearson(t *testing.T) {
+	tests := []struct {
+		name   string
+		xs, ys []float64
+		want   float64
+		wantOK bool
+	}{
+		{"positive", []float64{1, 2, 3, 4}, []float64{2, 4, 6, 8}, 1, true},
+		{"negative", []float64{1, 2, 3, 4}, []float64{8, 6, 4, 2}, -1, true},
+		{"known", []float64{1, 2, 3, 4, 5}, []float64{2, 4, 5, 4, 5}, 0.7745966692414834, true},
+		{"constant", []float64{1, 2, 3}, []float64{1, 1, 1}, 0, false},
+		{"single pair", []float64{1}, []float64{1}, 0, false},
+		{"mismatched", []float64{1, 2}, []float64{1}, 0, false},
+	}
+	for _, tt := range tests {
+		got, ok := pearson(tt.xs, tt.ys)
+		if ok != tt.wantOK || math.Abs(got-tt.want) > 1e-9 {
+			t.Errorf("%s: pearson = %v, %v; want %v, %v", tt.name, got, ok, tt.want, tt.wantOK)
+		}
+	}
+}
+
+func TestPercentile(t *testing.T) {
+	values := []float64{40, 10, 30, 20}
+	for _, tt := range []struct{ p, want float64 }{{0, 10}, {50, 25}, {95, 38.5}, {100, 40}} {
+		if got := percentile(values, tt.p); math.Abs(got-tt.want) > 1e-9 {
+			t.Errorf("percentile(%v) = %v, want %v", tt.p, got, tt.want)
+		}
+	}
+	if values[0] != 40 {
+		t.Error("percentile modified its input")
+	}
+	if percentile(nil, 50) != 0 {
+		t.Error("percentile of no values is not 0")
+	}
+}
+
+func TestTrimmedMean(t *testing.T) {
+	// One stray tap and one distracted user are trimmed off at 10%
+	values := []float64{0.9, 1.1, 1, 0.01, 1, 1.2, 0.8, 1, 30, 1}
+	if got := trimmedMean(values, 0.1); math.Abs(got-1.0) > 1e-9 {
+		t.Errorf("trimmedMean = %v, want 1", got)
+	}
+	if values[3] != 0.01 {
+		t.Error("trimmedMean reordered its input")
+	}
+
+	// Trimming never empties the input
+	if got := trimmedMean([]float64{2, 4}, 0.5); got != 3 {
+		t.Errorf("trimmedMean of two values at 0.5 = %v, want 3", got)
+	}
+	if got := trimmedMean(nil, 0.1); got != 0 {
+		t.Errorf("trimmedMean(nil) = %v, want 0", got)
+	}
+}
@@ -0,0 +1,174 @@
+package api
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+)
+
+const (
+	// streamBufferSize is the number of events buffered per stream
+	// subscriber. Events beyond it are dropped for that subscriber.
+	streamBufferSize = 256
+	// streamWriteTimeout bounds each write to a stream client.
+	streamWriteTimeout = 10 * time.Second
+	// streamPingInterval is how often idle stream clients are pinged, so
+	// dead connections are noticed.
+	streamPingInterval = 30 * time.Second
+)
+
+// eventHub fans recorded events out to the connected stream clients.
+// Publishing never blocks: a subscriber whose buffer is full misses the
+// event instead of slowing down ingestion.
+type eventHub struct {
+	mu          sync.Mutex
+	subscribers map[chan []byte]struct{}
+}
+
+func newEventHub() *eventHub {
+	return &eventHub{subscribers: make(map[chan []byte]struct{})}
+}
+
+// subscribe registers a new subscriber and returns its channel.
+func (hub *eventHub) subscribe() chan []byte {
+	ch := make(chan []byte, streamBufferSize)
+	hub.mu.Lock()
+	hub.subscribers[ch] = struct{}{}
+	hub.mu.Unlock()
+	return ch
+}
+
+// unsubscribe removes the subscriber of ch.
+func (hub *eventHub) unsubscribe(ch chan []byte) {
+	hub.mu.Lock()
+	delete(hub.subscribers, ch)
+	hub.mu.Unlock()
+}
+
+// publish sends event, encoded as JSON, to every subscriber with room in
+// its buffer.
+func (hub *eventHub) publish(event EventRequest) {
+	hub.mu.Lock()
+	defer hub.mu.Unlock()
+	if len(hub.subscribers) == 0 {
+		return
+	}
+
+	message, err := json.Marshal(struct {
+		EventRequest
+		RecordedAt time.Time `json:"recorded_at"`
+	}{event, time.Now().UTC()})
+	if err != nil {
+		log.Printf("Failed to encode streamed event: %v", err)
+		return
+	}
+	for ch := range hub.subscribers {
+		select {
+		case ch <- message:
+		default:
+		}
+	}
+}
+
+const (
+	// streamProtocol is the WebSocket subprotocol of the event stream.
+	streamProtocol = "analytics-stream"
+	// streamSecretPrefix prefixes the subprotocol carrying the admin
+	// secret, since browsers can't set headers on a WebSocket handshake.
+	streamSecretPrefix = "admin-secret."
+)
+
+// streamSecret returns a middleware that takes the admin secret of a stream
+// request from an "admin-secret.<secret>" subprotocol when the
+// X-Admin-Secret header is missing, for requireScope to authenticate.
+func streamSecret() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.GetHeader("X-Admin-Secret") == "" {
+			for _, protocol := range websocket.Subprotocols(c.Request) {
+				if secret, ok := strings.CutPrefix(protocol, streamSecretPrefix); ok {
+					c.Request.Header.Set("X-Admin-Secret", secret)
+					break
+				}
+			}
+		}
+		c.Next()
+	}
+}
+
+// originAllowed reports whether origin is in allowed, the list of
+// STREAM_ALLOWED_ORIGINS, or the list allows any origin.
+func originAllowed(allowed []string, origin string) bool {
+	for _, entry := range allowed {
+		if entry == "*" || strings.EqualFold(entry, origin) {
+			return true
+		}
+	}
+	return false
+}
+
+// streamUpgrader returns the upgrader of stream requests to WebSocket
+// connections. Browsers must connect from an origin in STREAM_ALLOWED_ORIGINS;
+// requests without an Origin header don't come from a browser. Of the
+// subprotocols offered only streamProtocol is selected, so the secret is
+// never echoed back.
+func (h *AnalyticsHandler) streamUpgrader() *websocket.Upgrader {
+	return &websocket.Upgrader{
+		Subprotocols: []string{streamProtocol},
+		CheckOrigin: func(r *http.Request) bool {
+			origin := r.Header.Get("Origin")
+			return origin == "" || originAllowed(h.cfg.StreamAllowedOrigins, origin)
+		},
+	}
+}
+
+// streamEvents upgrades the request to a WebSocket and pushes every newly
+// recorded event to it as a JSON text message, for live dashboards. The
+// client only listens; the stream ends when it disconnects. Browsers offer
+// the subprotocols streamProtocol and "admin-secret.<secret>" instead of
+// sending the X-Admin-Secret header.
+func (h *AnalyticsHandler) streamEvents(c *gin.Context) {
+	conn, err := h.streamUpgrader().Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		// The upgrader has already written the error response
+		return
+	}
+	defer conn.Close()
+
+	events := h.events.subscribe()
+	defer h.events.unsubscribe(events)
+
+	// Read until the client goes away, so its close is noticed
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	ping := time.NewTicker(streamPingInterval)
+	defer ping.Stop()
+	for {
+		select {
+		case <-closed:
+			return
+		case message := <-events:
+			conn.SetWriteDeadline(time.Now().Add(streamWriteTimeout))
+			if err := conn.WriteMessage(websocket.TextMessage, message); err != nil {
+				return
+			}
+		case <-ping.C:
+			if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(streamWriteTimeout)); err != nil {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,162 @@
+package api
+
+import (
+	"bytes"
+	"cyber-swipe-analytics/config"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gorilla/websocket"
+)
+
+// subscriberCount returns the number of clients subscribed to hub.
+func subscriberCount(hub *eventHub) int {
+	hub.mu.Lock()
+	defer hub.mu.Unlock()
+	return len(hub.subscribers)
+}
+
+// waitForSubscribers waits until hub has n subscribers.
+func waitForSubscribers(t *testing.T, hub *eventHub, n int) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for subscriberCount(hub) != n {
+		if time.Now().After(deadline) {
+			t.Fatalf("got %d subscribers, want %d", subscriberCount(hub), n)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestStreamEvents(t *testing.T) {
+	h, mock := newTestHandler(t, nil)
+	server := httptest.NewServer(newTestRouter(h))
+	defer server.Close()
+
+	expectAdminKey(mock, config.ScopeRead)
+	url := "ws" + strings.TrimPrefix(server.URL, "http") + "/api/analytics/stream"
+	conn, _, err := websocket.DefaultDialer.Dial(url, adminHeader)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer conn.Close()
+	waitForSubscribers(t, h.events, 1)
+
+	// The event recorded over HTTP arrives on the socket
+	expectSessionNotOptedOut(mock, "s1")
+	mock.ExpectExec(`INSERT INTO events`).WillReturnResult(sqlmock.NewResult(1, 1))
+	body := `{"session_id": "s1", "event_type": "card_swipe", "card_id": "c1", "direction": "right", "success": true}`
+	response, err := http.Post(server.URL+"/api/analytics/event", "application/json", bytes.NewBufferString(body))
+	if err != nil {
+		t.Fatalf("POST event: %v", err)
+	}
+	response.Body.Close()
+	if response.StatusCode != http.StatusCreated {
+		t.Fatalf("POST event: status %d, want 201", response.StatusCode)
+	}
+
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	messageType, message, err := conn.ReadMessage()
+	if err != nil {
+		t.Fatalf("ReadMessage: %v", err)
+	}
+	var event map[string]interface{}
+	if messageType != websocket.TextMessage || json.Unmarshal(message, &event) != nil {
+		t.Fatalf("message %q is not a JSON text message", message)
+	}
+	if event["session_id"] != "s1" || event["card_id"] != "c1" || event["success"] != true || event["recorded_at"] == nil {
+		t.Errorf("event = %v", event)
+	}
+
+	// Closing the socket unsubscribes the client
+	conn.Close()
+	waitForSubscribers(t, h.events, 0)
+}
+
+func TestStreamEventsRequiresAdminKey(t *testing.T) {
+	h, _ := newTestHandler(t, nil)
+	server := httptest.NewServer(newTestRouter(h))
+	defer server.Close()
+
+	url := "ws" + strings.TrimPrefix(server.URL, "http") + "/api/analytics/stream"
+	_, response, err := websocket.DefaultDialer.Dial(url, nil)
+	if err == nil || response == nil || response.StatusCode != http.StatusUnauthorized {
+		t.Errorf("Dial without a key = %v, want 401", err)
+	}
+}
+
+func TestStreamEventsAcceptsSecretSubprotocol(t *testing.T) {
+	h, mock := newTestHandler(t, nil)
+	server := httptest.NewServer(newTestRouter(h))
+	defer server.Close()
+
+	// A browser can't send X-Admin-Secret and offers the secret as a
+	// subprotocol instead, which is not echoed back
+	expectAdminKey(mock, config.ScopeRead)
+	url := "ws" + strings.TrimPrefix(server.URL, "http") + "/api/analytics/stream"
+	dialer := websocket.Dialer{Subprotocols: []string{streamProtocol, streamSecretPrefix + "test-admin-key"}}
+	conn, response, err := dialer.Dial(url, nil)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer conn.Close()
+	if protocol := response.Header.Get("Sec-WebSocket-Protocol"); protocol != streamProtocol {
+		t.Errorf("Sec-WebSocket-Protocol = %q, want %s", protocol, streamProtocol)
+	}
+	waitForSubscribers(t, h.events, 1)
+}
+
+func TestStreamEventsChecksOrigin(t *testing.T) {
+	h, mock := newTestHandler(t, map[string]string{"STREAM_ALLOWED_ORIGINS": "https://dashboard.example.com"})
+	server := httptest.NewServer(newTestRouter(h))
+	defer server.Close()
+	url := "ws" + strings.TrimPrefix(server.URL, "http") + "/api/analytics/stream"
+
+	// A page of another site is refused even with a valid key
+	expectAdminKey(mock, config.ScopeRead)
+	header := http.Header{"Origin": {"https://evil.example.com"}, "X-Admin-Secret": {"test-admin-key"}}
+	_, response, err := websocket.DefaultDialer.Dial(url, header)
+	if err == nil || response == nil || response.StatusCode != http.StatusForbidden {
+		t.Errorf("Dial from another origin = %v, want 403", err)
+	}
+
+	expectAdminKey(mock, config.ScopeRead)
+	header.Set("Origin", "https://dashboard.example.com")
+	conn, _, err := websocket.DefaultDialer.Dial(url, header)
+	if err != nil {
+		t.Fatalf("Dial from the allowed origin: %v", err)
+	}
+	conn.Close()
+}
+
+func TestEventHubDropsForSlowSubscribers(t *testing.T) {
+	hub := newEventHub()
+	slow := hub.subscribe()
+
+	// Publishing past the buffer doesn't block; the overflow is dropped
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < streamBufferSize+10; i++ {
+			hub.publish(EventRequest{SessionID: "s1", EventType: "button_tap"})
+		}
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("publish blocked on a full subscriber")
+	}
+	if len(slow) != streamBufferSize {
+		t.Errorf("buffered %d events, want %d", len(slow), streamBufferSize)
+	}
+
+	hub.unsubscribe(slow)
+	if subscriberCount(hub) != 0 {
+		t.Error("subscriber kept after unsubscribe")
+	}
+}
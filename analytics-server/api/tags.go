@@ -0,0 +1,50 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+)
+
+const (
+	maxSessionTags = 20
+	maxTagKeyLen   = 64
+	maxTagValueLen = 255
+)
+
+var tagKeyPattern = regexp.MustCompile(`^[A-Za-z0-9_.-]+$`)
+
+// validTagKey reports whether key is a well-formed session tag key.
+func validTagKey(key string) bool {
+	return len(key) <= maxTagKeyLen && tagKeyPattern.MatchString(key)
+}
+
+// validateTags checks the tag count and the length and format of every
+// tag key and value.
+func validateTags(tags map[string]string) error {
+	if len(tags) > maxSessionTags {
+		return fmt.Errorf("too many tags: at most %d are allowed", maxSessionTags)
+	}
+	for key, value := range tags {
+		if !validTagKey(key) {
+			return fmt.Errorf("invalid tag key %q: use up to %d letters, digits, '_', '.' or '-'", key, maxTagKeyLen)
+		}
+		if len(value) > maxTagValueLen {
+			return fmt.Errorf("tag %q value exceeds %d characters", key, maxTagValueLen)
+		}
+	}
+	return nil
+}
+
+// encodeTags converts tags into a value for the JSON tags column, storing
+// NULL when the session has no tags.
+func encodeTags(tags map[string]string) (interface{}, error) {
+	if len(tags) == 0 {
+		return nil, nil
+	}
+	encoded, err := json.Marshal(tags)
+	if err != nil {
+		return nil, err
+	}
+	return string(encoded), nil
+}
@@ -0,0 +1,68 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestValidateTags(t *testing.T) {
+	tooMany := make(map[string]string)
+	for i := 0; i <= maxSessionTags; i++ {
+		tooMany[fmt.Sprintf("tag%d", i)] = "x"
+	}
+
+	tests := []struct {
+		name    string
+		tags    map[string]string
+		wantErr bool
+	}{
+		{"none", nil, false},
+		{"valid", map[string]string{"experiment": "b", "build.channel": "beta"}, false},
+		{"invalid key", map[string]string{"bad key": "x"}, true},
+		{"long value", map[string]string{"note": strings.Repeat("x", maxTagValueLen+1)}, true},
+		{"too many", tooMany, true},
+	}
+	for _, tt := range tests {
+		if err := validateTags(tt.tags); (err != nil) != tt.wantErr {
+			t.Errorf("%s: validateTags error = %v, want error %v", tt.name, err, tt.wantErr)
+		}
+	}
+}
+
+func TestCreateSessionStoresTags(t *testing.T) {
+	h, mock := newTestHandler(t, nil)
+	expectUserNotOptedOut(mock, "u1")
+	mock.ExpectExec(`INSERT INTO sessions`).
+		WithArgs("s1", "u1", "ios", "1170x2532", "", "", nil, nil, nil, "", `{"experiment":"b"}`, sqlmock.AnyArg(), nil).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+
+	response := serve(newTestRouter(h), http.MethodPost, "/api/analytics/session", map[string]interface{}{
+		"session_id": "s1",
+		"user_id":    "u1",
+		"platform":   "ios",
+		"resolution": "1170x2532",
+		"tags":       map[string]string{"experiment": "b"},
+	}, nil)
+	if response.Code != http.StatusCreated {
+		t.Fatalf("status %d, want 201: %s", response.Code, response.Body)
+	}
+}
+
+func TestCreateSessionRejectsInvalidTags(t *testing.T) {
+	h, _ := newTestHandler(t, nil)
+
+	response := serve(newTestRouter(h), http.MethodPost, "/api/analytics/session", map[string]interface{}{
+		"session_id": "s1",
+		"user_id":    "u1",
+		"platform":   "ios",
+		"resolution": "1170x2532",
+		"tags":       map[string]string{"bad key": "x"},
+	}, nil)
+	if response.Code != http.StatusBadRequest {
+		t.Fatalf("status %d, want 400: %s", response.Code, response.Body)
+	}
+}
@@ -0,0 +1,327 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	defaultRecentHours = 24
+	maxRecentHours     = 168
+)
+
+// timeseriesGranularities maps the granularity parameter of the timeseries
+// endpoint to the length of one bucket. Weeks start on Monday.
+var timeseriesGranularities = map[string]time.Duration{
+	"hour": time.Hour,
+	"day":  24 * time.Hour,
+	"week": 7 * 24 * time.Hour,
+}
+
+// maxTimeseriesBuckets caps the number of buckets the timeseries endpoint
+// spans.
+const maxTimeseriesBuckets = 500
+
+// parseTimezone resolves the tz query parameter to a location, defaulting
+// to UTC.
+func parseTimezone(c *gin.Context) (*time.Location, error) {
+	name := c.Query("tz")
+	if name == "" {
+		return time.UTC, nil
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return nil, fmt.Errorf("invalid tz: unknown time zone %q", name)
+	}
+	return loc, nil
+}
+
+// getRecentActivity returns hourly session and event counts for the last
+// hours hours, including the current one. Hours are aligned to the tz
+// time zone and hours without activity are reported as zero. It is a
+// lightweight view for launch monitoring.
+func (h *AnalyticsHandler) getRecentActivity(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	hours, err := queryInt(c, "hours", defaultRecentHours, 1, maxRecentHours)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	loc, err := parseTimezone(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	now := time.Now().In(loc)
+	currentHour := time.Date(now.Year(), now.Month(), now.Day(), now.Hour(), 0, 0, 0, loc)
+	start := currentHour.Add(-time.Duration(hours-1) * time.Hour)
+
+	sessions, err := h.countByHourSince(ctx, "sessions", start, hours)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to count recent sessions"})
+		return
+	}
+	events, err := h.countByHourSince(ctx, "events", start, hours)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to count recent events"})
+		return
+	}
+
+	buckets := make([]map[string]interface{}, hours)
+	for i := range buckets {
+		buckets[i] = map[string]interface{}{
+			"hour":          start.Add(time.Duration(i) * time.Hour).In(loc).Format(time.RFC3339),
+			"session_count": sessions[i],
+			"event_count":   events[i],
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"hours":    hours,
+		"timezone": loc.String(),
+		"buckets":  buckets,
+	})
+}
+
+// timeseriesBucket holds the counts of one bucket of the timeseries report.
+type timeseriesBucket struct {
+	sessions, events, swipes, successfulSwipes int
+}
+
+// getTimeseries returns session and event counts and the swipe success
+// rate per hour, day, or week, for retention charts. The range defaults to
+// the last maxTimeseriesBuckets buckets and may not span more than that.
+// Buckets are computed in the database time zone and only buckets with
+// activity are returned, oldest first.
+func (h *AnalyticsHandler) getTimeseries(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	granularity := c.DefaultQuery("granularity", "day")
+	bucketLength, ok := timeseriesGranularities[granularity]
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid granularity: must be hour, day, or week"})
+		return
+	}
+	r, err := parseTimeRange(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	to := r.To
+	if to.IsZero() {
+		to = time.Now()
+	}
+	if r.From.IsZero() {
+		r.From = to.Add(-maxTimeseriesBuckets * bucketLength)
+	} else if to.Sub(r.From) > maxTimeseriesBuckets*bucketLength {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf(
+			"invalid range: spans more than %d %s buckets", maxTimeseriesBuckets, granularity)})
+		return
+	}
+
+	buckets := make(map[time.Time]*timeseriesBucket)
+	bucketFor := func(start time.Time) *timeseriesBucket {
+		if buckets[start] == nil {
+			buckets[start] = &timeseriesBucket{}
+		}
+		return buckets[start]
+	}
+
+	conditions, args := r.conditions("created_at")
+	rows, err := h.db.QueryContext(ctx, `
+		SELECT `+h.db.TruncateTime("created_at", granularity)+` as bucket, COUNT(*)
+		FROM sessions
+		WHERE `+strings.Join(conditions, " AND ")+`
+		GROUP BY bucket
+	`, args...)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to count sessions"})
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var start time.Time
+		var count int
+		if err := rows.Scan(&start, &count); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to count sessions"})
+			return
+		}
+		bucketFor(start).sessions = count
+	}
+	if err := rows.Err(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to count sessions"})
+		return
+	}
+
+	eventRows, err := h.db.QueryContext(ctx, `
+		SELECT
+			`+h.db.TruncateTime("created_at", granularity)+` as bucket,
+			COUNT(*),
+			COUNT(CASE WHEN event_type = 'card_swipe' THEN 1 END),
+			COUNT(CASE WHEN event_type = 'card_swipe' AND COALESCE(success, false) = true THEN 1 END)
+		FROM events
+		WHERE `+strings.Join(conditions, " AND ")+`
+		GROUP BY bucket
+	`, args...)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to count events"})
+		return
+	}
+	defer eventRows.Close()
+	for eventRows.Next() {
+		var start time.Time
+		var events, swipes, successful int
+		if err := eventRows.Scan(&start, &events, &swipes, &successful); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to count events"})
+			return
+		}
+		bucket := bucketFor(start)
+		bucket.events, bucket.swipes, bucket.successfulSwipes = events, swipes, successful
+	}
+	if err := eventRows.Err(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to count events"})
+		return
+	}
+
+	starts := make([]time.Time, 0, len(buckets))
+	for start := range buckets {
+		starts = append(starts, start)
+	}
+	sort.Slice(starts, func(i, j int) bool { return starts[i].Before(starts[j]) })
+
+	series := make([]map[string]interface{}, len(starts))
+	for i, start := range starts {
+		bucket := buckets[start]
+		var successRate interface{}
+		if bucket.swipes > 0 {
+			successRate = float64(bucket.successfulSwipes) / float64(bucket.swipes) * 100
+		}
+		series[i] = map[string]interface{}{
+			"bucket":             start.Format(time.RFC3339),
+			"session_count":      bucket.sessions,
+			"event_count":        bucket.events,
+			"swipe_success_rate": successRate,
+		}
+	}
+
+	h.setCacheControl(c, r)
+	c.JSON(http.StatusOK, gin.H{
+		"granularity": granularity,
+		"from":        r.From.Format(time.RFC3339),
+		"buckets":     series,
+	})
+}
+
+// countByHourSince counts the rows of table created in each of the hours
+// hours following start. Buckets are computed from epoch seconds, so they
+// are independent of the database session time zone.
+func (h *AnalyticsHandler) countByHourSince(ctx context.Context, table string, start time.Time, hours int) ([]int, error) {
+	startUnix := start.Unix()
+	epoch := h.db.EpochSeconds("created_at")
+	rows, err := h.db.QueryContext(ctx, `
+		SELECT
+			FLOOR((`+epoch+` - ?) / 3600) as bucket,
+			COUNT(*) as total
+		FROM `+table+`
+		WHERE `+epoch+` >= ?
+		GROUP BY bucket
+	`, startUnix, startUnix)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	// FLOOR yields a decimal or floating point number depending on the
+	// database, so the bucket is scanned as a float
+	counts := make([]int, hours)
+	for rows.Next() {
+		var bucket float64
+		var total int
+		if err := rows.Scan(&bucket, &total); err != nil {
+			return nil, err
+		}
+		if i := int(bucket); i >= 0 && i < hours {
+			counts[i] = total
+		}
+	}
+	return counts, rows.Err()
+}
+
+// hourOfDayBucketSeconds is the width of the epoch buckets that
+// getSuccessByHourOfDay assigns to local hours. Every UTC offset is a
+// multiple of 15 minutes, so each bucket falls within a single local hour.
+const hourOfDayBucketSeconds = 900
+
+// getSuccessByHourOfDay returns the swipe count and success rate for each
+// hour of the day in the tz time zone, revealing whether users perform
+// worse at certain times, e.g. late at night. All 24 hours are returned;
+// success_rate is nil for hours without swipes.
+func (h *AnalyticsHandler) getSuccessByHourOfDay(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	loc, err := parseTimezone(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Events are grouped into epoch buckets in the database and mapped to
+	// local hours here, which keeps daylight saving transitions correct
+	rows, err := h.db.QueryContext(ctx, `
+		SELECT
+			FLOOR(`+h.db.EpochSeconds("created_at")+` / ?) as bucket,
+			COUNT(*) as swipes,
+			COUNT(CASE WHEN COALESCE(success, false) = true THEN 1 END) as successful_swipes
+		FROM events
+		WHERE event_type = 'card_swipe'
+		GROUP BY bucket
+	`, hourOfDayBucketSeconds)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get success rate by hour of day"})
+		return
+	}
+	defer rows.Close()
+
+	var swipes, successful [24]int
+	for rows.Next() {
+		var bucket float64
+		var total, success int
+		if err := rows.Scan(&bucket, &total, &success); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get success rate by hour of day"})
+			return
+		}
+		hour := time.Unix(int64(bucket)*hourOfDayBucketSeconds, 0).In(loc).Hour()
+		swipes[hour] += total
+		successful[hour] += success
+	}
+	if err := rows.Err(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get success rate by hour of day"})
+		return
+	}
+
+	hours := make([]map[string]interface{}, 24)
+	for hour := range hours {
+		var successRate interface{}
+		if swipes[hour] > 0 {
+			successRate = float64(successful[hour]) / float64(swipes[hour]) * 100
+		}
+		hours[hour] = map[string]interface{}{
+			"hour":         hour,
+			"swipes":       swipes[hour],
+			"success_rate": successRate,
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"timezone": loc.String(),
+		"hours":    hours,
+	})
+}
@@ -0,0 +1,162 @@
+package api
+
+import (
+	"cyber-swipe-analytics/config"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestGetRecentActivity(t *testing.T) {
+	h, mock := newTestHandler(t, nil)
+	expectAdminKey(mock, config.ScopeRead)
+
+	// Three hours of activity, with a quiet hour in between for sessions
+	// and a bucket outside the window that must be ignored
+	mock.ExpectQuery(`FLOOR\(\(UNIX_TIMESTAMP\(created_at\) - \?\) / 3600\) as bucket,\s+COUNT\(\*\) as total\s+FROM sessions`).
+		WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"bucket", "total"}).AddRow(0.0, 2).AddRow(2.0, 5).AddRow(3.0, 9))
+	mock.ExpectQuery(`FROM events`).
+		WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"bucket", "total"}).AddRow(1.0, 7).AddRow(2.0, 4))
+
+	response := serve(newTestRouter(h), http.MethodGet, "/api/analytics/recent?hours=3&tz=Europe/Berlin", nil, adminHeader)
+	if response.Code != http.StatusOK {
+		t.Fatalf("status %d, want 200: %s", response.Code, response.Body)
+	}
+	body := decodeBody(t, response)
+	if body["timezone"] != "Europe/Berlin" {
+		t.Errorf("timezone = %v", body["timezone"])
+	}
+	buckets := body["buckets"].([]interface{})
+	wantSessions := []float64{2, 0, 5}
+	wantEvents := []float64{0, 7, 4}
+	if len(buckets) != 3 {
+		t.Fatalf("got %d buckets, want 3", len(buckets))
+	}
+	var previous time.Time
+	for i, b := range buckets {
+		bucket := b.(map[string]interface{})
+		if bucket["session_count"] != wantSessions[i] || bucket["event_count"] != wantEvents[i] {
+			t.Errorf("bucket %d = %v, want %v sessions and %v events", i, bucket, wantSessions[i], wantEvents[i])
+		}
+		hour, err := time.Parse(time.RFC3339, bucket["hour"].(string))
+		if err != nil || hour.Minute() != 0 || (i > 0 && hour.Sub(previous) != time.Hour) {
+			t.Errorf("bucket %d hour = %v, want consecutive whole hours", i, bucket["hour"])
+		}
+		previous = hour
+	}
+}
+
+func TestGetRecentActivityRejectsUnknownTimezone(t *testing.T) {
+	h, mock := newTestHandler(t, nil)
+	expectAdminKey(mock, config.ScopeRead)
+
+	response := serve(newTestRouter(h), http.MethodGet, "/api/analytics/recent?tz=Mars/Olympus", nil, adminHeader)
+	if response.Code != http.StatusBadRequest {
+		t.Errorf("status %d, want 400", response.Code)
+	}
+}
+
+func TestGetSuccessByHourOfDay(t *testing.T) {
+	h, mock := newTestHandler(t, nil)
+	expectAdminKey(mock, config.ScopeRead)
+
+	// India is UTC+5:30: swipes at 18:30 UTC fall at local midnight, those
+	// at 02:45 UTC at 08:15 local time
+	bucket := func(utc time.Time) float64 { return float64(utc.Unix() / hourOfDayBucketSeconds) }
+	midnight := time.Date(2024, 5, 1, 18, 30, 0, 0, time.UTC)
+	morning := time.Date(2024, 5, 2, 2, 45, 0, 0, time.UTC)
+	mock.ExpectQuery(`FLOOR\(UNIX_TIMESTAMP\(created_at\) / \?\) as bucket`).
+		WithArgs(hourOfDayBucketSeconds).
+		WillReturnRows(sqlmock.NewRows([]string{"bucket", "swipes", "successful_swipes"}).
+			AddRow(bucket(midnight), 4, 1).
+			AddRow(bucket(midnight.Add(45*time.Minute)), 4, 1).
+			AddRow(bucket(morning), 5, 4))
+
+	response := serve(newTestRouter(h), http.MethodGet, "/api/analytics/swipes/success-by-hour-of-day?tz=Asia/Kolkata", nil, adminHeader)
+	if response.Code != http.StatusOK {
+		t.Fatalf("status %d, want 200: %s", response.Code, response.Body)
+	}
+	hours := decodeBody(t, response)["hours"].([]interface{})
+	if len(hours) != 24 {
+		t.Fatalf("got %d hours, want 24", len(hours))
+	}
+	for hour, entry := range hours {
+		got := entry.(map[string]interface{})
+		switch hour {
+		case 0:
+			if got["swipes"] != 8.0 || got["success_rate"] != 25.0 {
+				t.Errorf("hour 0 = %v, want 8 swipes at 25%%", got)
+			}
+		case 8:
+			if got["swipes"] != 5.0 || got["success_rate"] != 80.0 {
+				t.Errorf("hour 8 = %v, want 5 swipes at 80%%", got)
+			}
+		default:
+			if got["swipes"] != 0.0 || got["success_rate"] != nil {
+				t.Errorf("hour %d = %v, want no swipes", hour, got)
+			}
+		}
+	}
+}
+
+func TestGetTimeseriesDaily(t *testing.T) {
+	h, mock := newTestHandler(t, nil)
+	expectAdminKey(mock, config.ScopeRead)
+	day := func(d int) time.Time { return time.Date(2024, 5, d, 0, 0, 0, 0, time.UTC) }
+
+	// Events on three days; the second day has no sessions and no swipes
+	mock.ExpectQuery(`SELECT CAST\(DATE\(created_at\) AS DATETIME\) as bucket, COUNT\(\*\)\s+FROM sessions\s+WHERE created_at >= \? AND created_at < \?\s+GROUP BY bucket`).
+		WithArgs(day(1), day(4)).
+		WillReturnRows(sqlmock.NewRows([]string{"bucket", "count"}).AddRow(day(3), 1).AddRow(day(1), 2))
+	mock.ExpectQuery(`FROM events\s+WHERE created_at >= \? AND created_at < \?\s+GROUP BY bucket`).
+		WithArgs(day(1), day(4)).
+		WillReturnRows(sqlmock.NewRows([]string{"bucket", "events", "swipes", "successful"}).
+			AddRow(day(1), 10, 8, 6).
+			AddRow(day(2), 3, 0, 0).
+			AddRow(day(3), 5, 4, 1))
+
+	response := serve(newTestRouter(h), http.MethodGet, "/api/analytics/timeseries?granularity=day&from=2024-05-01&to=2024-05-03", nil, adminHeader)
+	if response.Code != http.StatusOK {
+		t.Fatalf("status %d, want 200: %s", response.Code, response.Body)
+	}
+	buckets := decodeBody(t, response)["buckets"].([]interface{})
+	want := []struct {
+		bucket           string
+		sessions, events float64
+		successRate      interface{}
+	}{
+		{"2024-05-01T00:00:00Z", 2, 10, 75.0},
+		{"2024-05-02T00:00:00Z", 0, 3, nil},
+		{"2024-05-03T00:00:00Z", 1, 5, 25.0},
+	}
+	if len(buckets) != len(want) {
+		t.Fatalf("got %d buckets, want %d", len(buckets), len(want))
+	}
+	for i, bucket := range want {
+		got := buckets[i].(map[string]interface{})
+		if got["bucket"] != bucket.bucket || got["session_count"] != bucket.sessions ||
+			got["event_count"] != bucket.events || got["swipe_success_rate"] != bucket.successRate {
+			t.Errorf("bucket %d = %v, want %+v", i, got, bucket)
+		}
+	}
+}
+
+func TestGetTimeseriesRejectsInvalidParameters(t *testing.T) {
+	h, mock := newTestHandler(t, nil)
+	router := newTestRouter(h)
+
+	for _, query := range []string{
+		"granularity=month",
+		"granularity=hour&from=2024-01-01&to=2024-03-01",
+		"from=2024-05-03&to=2024-05-01",
+	} {
+		expectAdminKey(mock, config.ScopeRead)
+		if response := serve(router, http.MethodGet, "/api/analytics/timeseries?"+query, nil, adminHeader); response.Code != http.StatusBadRequest {
+			t.Errorf("%s: status %d, want 400", query, response.Code)
+		}
+	}
+}
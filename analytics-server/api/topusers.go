@@ -0,0 +1,96 @@
+package api
+
+import (
+	"database/sql"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// topUserMetrics maps each ranking metric accepted by getTopUsers to the
+// column it orders by.
+var topUserMetrics = map[string]string{
+	"swipes":   "swipes",
+	"sessions": "sessions",
+}
+
+// getTopUsers ranks users by engagement, either by their total number of
+// swipes or by their number of sessions, and reports their swipe success
+// rate. The from/to range restricts the ranking to sessions created within
+// it.
+func (h *AnalyticsHandler) getTopUsers(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	metric := c.DefaultQuery("metric", "swipes")
+	orderBy, ok := topUserMetrics[metric]
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid metric: must be swipes or sessions"})
+		return
+	}
+	limit, err := queryInt(c, "limit", 10, 1, maxPageLimit)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	timeRange, err := parseTimeRange(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	where := ""
+	conditions, args := timeRange.conditions("s.created_at")
+	if len(conditions) > 0 {
+		where = "WHERE " + strings.Join(conditions, " AND ")
+	}
+
+	// Swipes without a success value count as unsuccessful; users without
+	// swipes have no success rate
+	rows, err := h.db.QueryContext(ctx, `
+		SELECT
+			s.user_id,
+			COUNT(DISTINCT s.session_id) as sessions,
+			COUNT(e.id) as swipes,
+			AVG(CASE WHEN e.success = true THEN 1.0 WHEN e.id IS NOT NULL THEN 0.0 END) as success_rate
+		FROM sessions s
+		LEFT JOIN events e ON e.session_id = s.session_id AND e.event_type = 'card_swipe'
+		`+where+`
+		GROUP BY s.user_id
+		ORDER BY `+orderBy+` DESC, s.user_id
+		LIMIT ?
+	`, append(args, limit)...)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get top users"})
+		return
+	}
+	defer rows.Close()
+
+	users := make([]map[string]interface{}, 0)
+	for rows.Next() {
+		var userID string
+		var sessions, swipes int
+		var successRate sql.NullFloat64
+		if err := rows.Scan(&userID, &sessions, &swipes, &successRate); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get top users"})
+			return
+		}
+		users = append(users, map[string]interface{}{
+			"rank":         len(users) + 1,
+			"user_id":      userID,
+			"sessions":     sessions,
+			"swipes":       swipes,
+			"success_rate": nullableFloat(successRate),
+		})
+	}
+	if err := rows.Err(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get top users"})
+		return
+	}
+
+	h.setCacheControl(c, timeRange)
+	c.JSON(http.StatusOK, gin.H{
+		"metric": metric,
+		"users":  users,
+	})
+}
@@ -0,0 +1,78 @@
+package api
+
+import (
+	"cyber-swipe-analytics/config"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestGetTopUsers(t *testing.T) {
+	h, mock := newTestHandler(t, nil)
+	expectAdminKey(mock, config.ScopeRead)
+
+	// The database ranks the users; the response keeps its order and
+	// numbers the ranks
+	mock.ExpectQuery(`GROUP BY s.user_id\s+ORDER BY sessions DESC, s.user_id\s+LIMIT \?`).
+		WithArgs(time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC), 3).
+		WillReturnRows(sqlmock.NewRows([]string{"user_id", "sessions", "swipes", "success_rate"}).
+			AddRow("u2", 9, 120, 0.75).
+			AddRow("u1", 4, 300, 0.5).
+			AddRow("u3", 2, 0, nil))
+
+	response := serve(newTestRouter(h), http.MethodGet, "/api/analytics/users/top?metric=sessions&limit=3&from=2024-05-01", nil, adminHeader)
+	if response.Code != http.StatusOK {
+		t.Fatalf("status %d, want 200: %s", response.Code, response.Body)
+	}
+	body := decodeBody(t, response)
+	if body["metric"] != "sessions" {
+		t.Errorf("metric = %v, want sessions", body["metric"])
+	}
+	users := body["users"].([]interface{})
+	want := []struct {
+		userID      string
+		successRate interface{}
+	}{
+		{"u2", 0.75},
+		{"u1", 0.5},
+		{"u3", nil},
+	}
+	if len(users) != len(want) {
+		t.Fatalf("got %d users, want %d", len(users), len(want))
+	}
+	for i, user := range want {
+		got := users[i].(map[string]interface{})
+		if got["rank"] != float64(i+1) || got["user_id"] != user.userID || got["success_rate"] != user.successRate {
+			t.Errorf("user %d = %v, want %s with success rate %v", i, got, user.userID, user.successRate)
+		}
+	}
+}
+
+func TestGetTopUsersDefaultsToSwipes(t *testing.T) {
+	h, mock := newTestHandler(t, nil)
+	expectAdminKey(mock, config.ScopeRead)
+	mock.ExpectQuery(`ORDER BY swipes DESC, s.user_id\s+LIMIT \?`).
+		WithArgs(10).
+		WillReturnRows(sqlmock.NewRows([]string{"user_id", "sessions", "swipes", "success_rate"}))
+
+	response := serve(newTestRouter(h), http.MethodGet, "/api/analytics/users/top", nil, adminHeader)
+	if response.Code != http.StatusOK {
+		t.Fatalf("status %d, want 200: %s", response.Code, response.Body)
+	}
+	if body := decodeBody(t, response); body["metric"] != "swipes" || len(body["users"].([]interface{})) != 0 {
+		t.Errorf("body = %v, want no users ranked by swipes", body)
+	}
+}
+
+func TestGetTopUsersRejectsInvalidParameters(t *testing.T) {
+	h, mock := newTestHandler(t, nil)
+	router := newTestRouter(h)
+	for _, query := range []string{"metric=undos", "limit=0", "from=someday"} {
+		expectAdminKey(mock, config.ScopeRead)
+		if response := serve(router, http.MethodGet, "/api/analytics/users/top?"+query, nil, adminHeader); response.Code != http.StatusBadRequest {
+			t.Errorf("%s: status %d, want 400", query, response.Code)
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// getUserStats returns one user's sessions and events together with the
+// aggregated statistics (performance averages, per-category success rates,
+// ...) computed over that user's sessions only. The stats filters and
+// pagination parameters apply as on /stats. Unknown users get 404.
+func (h *AnalyticsHandler) getUserStats(c *gin.Context) {
+	ctx := c.Request.Context()
+	userID := c.Param("user_id")
+
+	filter, err := h.parseStatsFilter(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	filter.UserID = userID
+
+	options, _, err := h.parseRawDataOptions(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	options.Range = filter.Range
+	options.UserID = userID
+
+	var exists bool
+	err = h.db.QueryRowContext(ctx, "SELECT EXISTS(SELECT 1 FROM sessions WHERE user_id = ?)", userID).Scan(&exists)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, h.errorBody(c, "Failed to look up user"))
+		return
+	}
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	sessions, sessionTotal, err := h.getSessionStatistics(ctx, options)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, h.errorBody(c, "Failed to get session statistics"))
+		return
+	}
+
+	events, eventTotal, err := h.getEventStatistics(ctx, options)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, h.errorBody(c, "Failed to get event statistics"))
+		return
+	}
+
+	statistics, err := h.getAggregatedStatistics(ctx, filter)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, h.errorBody(c, "Failed to calculate aggregated statistics"))
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"user_id":    userID,
+		"sessions":   selectFields(sessions, options.Fields["sessions"]),
+		"events":     selectFields(events, options.Fields["events"]),
+		"statistics": statistics,
+		"pagination": gin.H{
+			"limit":  options.Limit,
+			"offset": options.Offset,
+			"total": gin.H{
+				"sessions": sessionTotal,
+				"events":   eventTotal,
+			},
+		},
+	})
+}
@@ -0,0 +1,82 @@
+package api
+
+import (
+	"cyber-swipe-analytics/config"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+// userScope matches the condition scoping a statistics query to one user,
+// directly on sessions or through the session_id of other tables.
+const userScope = `user_id = \?`
+
+func TestGetUserStats(t *testing.T) {
+	h, mock := newTestHandler(t, nil)
+	created := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	// u1 and u2 both played; only u1's rows may be read
+	expectAdminKey(mock, config.ScopeRead)
+	mock.ExpectQuery(`SELECT EXISTS\(SELECT 1 FROM sessions WHERE user_id = \?\)`).
+		WithArgs("u1").
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+	mock.ExpectQuery(`FROM sessions\s+WHERE user_id = \?\s+ORDER BY`).
+		WithArgs("u1", 100, 0).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "session_id", "user_id", "platform", "resolution",
+			"device_model", "os_version", "created_at", "ended_at"}).
+			AddRow(1, "s1", "u1", "ios", "1170x2532", nil, nil, created, created.Add(5*time.Minute)).
+			AddRow(3, "s3", "u1", "ios", "1170x2532", nil, nil, created, nil))
+	mock.ExpectQuery(`SELECT COUNT\(\*\) FROM sessions WHERE user_id = \?`).
+		WithArgs("u1").
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(2))
+	mock.ExpectQuery(`FROM events\s+WHERE session_id IN \(SELECT session_id FROM sessions WHERE user_id = \?\)\s+ORDER BY`).
+		WithArgs("u1", 100, 0).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "session_id", "event_type", "card_id", "direction",
+			"success", "duration", "start_x", "end_x", "max_rotation", "created_at"}).
+			AddRow(7, "s1", "card_swipe", "c1", "right", true, 0.8, 10.0, 300.0, 12.0, created))
+	mock.ExpectQuery(`SELECT COUNT\(\*\) FROM events WHERE session_id IN \(SELECT session_id FROM sessions WHERE user_id = \?\)`).
+		WithArgs("u1").
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+
+	// Every aggregate is scoped to u1, in the order they are computed
+	for _, rows := range aggregatedStatisticsRows() {
+		mock.ExpectQuery(userScope).WillReturnRows(rows)
+	}
+
+	response := serve(newTestRouter(h), http.MethodGet, "/api/analytics/user/u1", nil, adminHeader)
+	if response.Code != http.StatusOK {
+		t.Fatalf("status %d, want 200: %s", response.Code, response.Body)
+	}
+	body := decodeBody(t, response)
+	for _, session := range body["sessions"].([]interface{}) {
+		if userID := session.(map[string]interface{})["user_id"]; userID != "u1" {
+			t.Errorf("session of user %v listed", userID)
+		}
+	}
+	statistics := body["statistics"].(map[string]interface{})
+	if total := statistics["sessions"].(map[string]interface{})["total_sessions"]; total != 2.0 {
+		t.Errorf("total_sessions = %v, want 2", total)
+	}
+	if fps := statistics["performance"].(map[string]interface{})["avg_fps"]; fps != 55.0 {
+		t.Errorf("avg_fps = %v, want 55", fps)
+	}
+	pagination := body["pagination"].(map[string]interface{})["total"].(map[string]interface{})
+	if pagination["sessions"] != 2.0 || pagination["events"] != 1.0 {
+		t.Errorf("pagination totals = %v, want 2 sessions and 1 event", pagination)
+	}
+}
+
+func TestGetUserStatsUnknownUser(t *testing.T) {
+	h, mock := newTestHandler(t, nil)
+	expectAdminKey(mock, config.ScopeRead)
+	mock.ExpectQuery(`SELECT EXISTS\(SELECT 1 FROM sessions WHERE user_id = \?\)`).
+		WithArgs("u3").
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+
+	response := serve(newTestRouter(h), http.MethodGet, "/api/analytics/user/u3", nil, adminHeader)
+	if response.Code != http.StatusNotFound {
+		t.Errorf("status %d, want 404", response.Code)
+	}
+}
@@ -0,0 +1,24 @@
+package api
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// emailPattern matches user IDs that look like an email address.
+var emailPattern = regexp.MustCompile(`^[^@\s]+@[^@\s]+\.[^@\s]+$`)
+
+// validateUserID checks userID against the configured UserIDPattern, if
+// any.
+func (h *AnalyticsHandler) validateUserID(userID string) error {
+	if h.cfg.UserIDPattern == nil || h.cfg.UserIDPattern.MatchString(userID) {
+		return nil
+	}
+	return fmt.Errorf("user_id does not match the required format")
+}
+
+// looksLikeEmail reports whether userID appears to be an email address,
+// which should not be collected as an analytics ID.
+func looksLikeEmail(userID string) bool {
+	return emailPattern.MatchString(userID)
+}
@@ -0,0 +1,59 @@
+package api
+
+import (
+	"net/http"
+	"testing"
+)
+
+const uuidPattern = `[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}`
+
+func TestValidateUserID(t *testing.T) {
+	h, _ := newTestHandler(t, map[string]string{"USER_ID_PATTERN": uuidPattern})
+	tests := []struct {
+		userID string
+		valid  bool
+	}{
+		{"0f8fad5b-d9cb-469f-a165-70867728950e", true},
+		{"12345", false},
+		{"player@example.com", false},
+		// The pattern must match the whole ID
+		{"x0f8fad5b-d9cb-469f-a165-70867728950e", false},
+	}
+	for _, tt := range tests {
+		if err := h.validateUserID(tt.userID); (err == nil) != tt.valid {
+			t.Errorf("validateUserID(%q) = %v, want valid %v", tt.userID, err, tt.valid)
+		}
+	}
+}
+
+func TestValidateUserIDWithoutPattern(t *testing.T) {
+	// Validation is off by default, so any ID is accepted
+	h, _ := newTestHandler(t, nil)
+	if err := h.validateUserID("12345"); err != nil {
+		t.Errorf("validateUserID without a pattern = %v", err)
+	}
+}
+
+func TestCreateSessionRejectsNonConformingUserID(t *testing.T) {
+	h, _ := newTestHandler(t, map[string]string{"USER_ID_PATTERN": uuidPattern})
+
+	response := serve(newTestRouter(h), http.MethodPost, "/api/analytics/session",
+		map[string]interface{}{"session_id": "s1", "user_id": "12345", "platform": "ios", "resolution": "1170x2532"}, nil)
+	if response.Code != http.StatusBadRequest {
+		t.Fatalf("status %d, want 400: %s", response.Code, response.Body)
+	}
+}
+
+func TestLooksLikeEmail(t *testing.T) {
+	for userID, want := range map[string]bool{
+		"player@example.com":                   true,
+		"first.last+tag@mail.example.org":      true,
+		"0f8fad5b-d9cb-469f-a165-70867728950e": false,
+		"player@localhost":                     false,
+		"@handle":                              false,
+	} {
+		if got := looksLikeEmail(userID); got != want {
+			t.Errorf("looksLikeEmail(%q) = %v, want %v", userID, got, want)
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package api
+
+import (
+	"context"
+	"fmt"
+)
+
+// swipeVelocityExpression is the velocity of a swipe in pixels per second.
+// Rows recorded before swipe_velocity was derived fall back to computing
+// it; swipes without a positive duration have no velocity.
+const swipeVelocityExpression = "COALESCE(swipe_velocity, ABS(end_x - start_x) / NULLIF(duration, 0))"
+
+// getSuccessByVelocity groups swipes into the velocity buckets configured
+// by SwipeVelocityBuckets and reports the success rate of each, to show
+// whether rushed swipes fail more often. Swipes without a measurable
+// velocity, i.e. with a zero or missing duration, are only counted.
+func (h *AnalyticsHandler) getSuccessByVelocity(ctx context.Context, filter statsFilter) (map[string]interface{}, error) {
+	edges := h.cfg.SwipeVelocityBuckets
+
+	where, args := filter.where("events", "event_type = 'card_swipe'")
+	rows, err := h.db.QueryContext(ctx, `
+		SELECT
+			CASE WHEN velocity IS NULL THEN -1 ELSE `+h.db.BucketIndex("velocity", len(edges))+` END as bucket,
+			COUNT(*) as swipes,
+			COUNT(CASE WHEN COALESCE(success, false) = true THEN 1 END) as successful_swipes
+		FROM (
+			SELECT `+swipeVelocityExpression+` as velocity, success
+			FROM events
+			`+where+`
+		) swipes
+		GROUP BY bucket
+	`, append(edgeArgs(edges), args...)...)
+	if err != nil {
+		return nil, fmt.Errorf("error getting success by velocity: %v", err)
+	}
+	defer rows.Close()
+
+	swipes := make([]int, len(edges)+1)
+	successful := make([]int, len(edges)+1)
+	unmeasured := 0
+	for rows.Next() {
+		var bucket, total, success int
+		if err := rows.Scan(&bucket, &total, &success); err != nil {
+			return nil, fmt.Errorf("error scanning success by velocity: %v", err)
+		}
+		if bucket < 0 || bucket >= len(swipes) {
+			unmeasured += total
+			continue
+		}
+		swipes[bucket] = total
+		successful[bucket] = success
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error getting success by velocity: %v", err)
+	}
+
+	buckets := make([]map[string]interface{}, 0, len(swipes))
+	for i := range swipes {
+		var successRate interface{}
+		if swipes[i] > 0 {
+			successRate = float64(successful[i]) / float64(swipes[i]) * 100
+		}
+		bucket := map[string]interface{}{
+			"bucket":       bucketLabel(edges, i, 0, "px/s"),
+			"swipes":       swipes[i],
+			"success_rate": successRate,
+		}
+		buckets = append(buckets, bucket)
+	}
+
+	return map[string]interface{}{
+		"buckets":           buckets,
+		"unmeasured_swipes": unmeasured,
+	}, nil
+}
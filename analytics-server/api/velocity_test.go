@@ -0,0 +1,72 @@
+package api
+
+import (
+	"context"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestGetSuccessByVelocity(t *testing.T) {
+	h, mock := newTestHandler(t, map[string]string{"SWIPE_VELOCITY_BUCKETS": "1000"})
+
+	// Slow swipes mostly succeed and fast ones mostly fail; swipes with
+	// a zero duration have no velocity and are only counted
+	mock.ExpectQuery(`ABS\(end_x - start_x\) / NULLIF\(duration, 0\)`).
+		WithArgs(1000.0).
+		WillReturnRows(sqlmock.NewRows([]string{"bucket", "swipes", "successful_swipes"}).
+			AddRow(0, 10, 9).
+			AddRow(1, 8, 2).
+			AddRow(-1, 3, 3))
+
+	result, err := h.getSuccessByVelocity(context.Background(), statsFilter{})
+	if err != nil {
+		t.Fatalf("getSuccessByVelocity: %v", err)
+	}
+	buckets := result["buckets"].([]map[string]interface{})
+	want := []struct {
+		label       string
+		swipes      int
+		successRate float64
+	}{
+		{"0px/s-1000px/s", 10, 90},
+		{"1000px/s+", 8, 25},
+	}
+	if len(buckets) != len(want) {
+		t.Fatalf("got %d buckets, want %d", len(buckets), len(want))
+	}
+	for i, bucket := range want {
+		got := buckets[i]
+		if got["bucket"] != bucket.label || got["swipes"] != bucket.swipes || got["success_rate"] != bucket.successRate {
+			t.Errorf("bucket %d = %v, want %+v", i, got, bucket)
+		}
+	}
+	if result["unmeasured_swipes"] != 3 {
+		t.Errorf("unmeasured_swipes = %v, want 3", result["unmeasured_swipes"])
+	}
+}
+
+func TestGetSuccessByVelocityEmptyBuckets(t *testing.T) {
+	h, mock := newTestHandler(t, nil)
+
+	// Buckets without swipes are listed with no success rate rather than 0%
+	mock.ExpectQuery(`FROM events`).WillReturnRows(sqlmock.NewRows([]string{"bucket", "swipes", "successful_swipes"}).AddRow(1, 4, 4))
+
+	result, err := h.getSuccessByVelocity(context.Background(), statsFilter{})
+	if err != nil {
+		t.Fatalf("getSuccessByVelocity: %v", err)
+	}
+	buckets := result["buckets"].([]map[string]interface{})
+	if len(buckets) != len(h.cfg.SwipeVelocityBuckets)+1 {
+		t.Fatalf("got %d buckets, want %d", len(buckets), len(h.cfg.SwipeVelocityBuckets)+1)
+	}
+	for i, bucket := range buckets {
+		if i == 1 {
+			if bucket["success_rate"] != 100.0 {
+				t.Errorf("bucket 1 success_rate = %v, want 100", bucket["success_rate"])
+			}
+		} else if bucket["swipes"] != 0 || bucket["success_rate"] != nil {
+			t.Errorf("bucket %d = %v, want no swipes and no success rate", i, bucket)
+		}
+	}
+}
@@ -1,26 +1,515 @@
 package config
 
 import (
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/url"
 	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
 )
 
 type Config struct {
+	// DBDriver selects the database: mysql (default) or postgres.
+	DBDriver   string
 	DBHost     string
 	DBPort     string
 	DBUser     string
 	DBPassword string
 	DBName     string
-	JWTSecret  string
+	// DBSSLMode is the PostgreSQL sslmode; ignored for MySQL.
+	DBSSLMode string
+	JWTSecret string
+	// Environment is the deployment environment, e.g. development or production.
+	Environment string
+
+	// SessionLengthBuckets holds the inclusive upper bounds (in swipes) of the
+	// session length histogram buckets. Sessions longer than the last bound
+	// fall into a final open-ended bucket.
+	SessionLengthBuckets []int
+	// SwipeVelocityBuckets holds the edges (in pixels per second) of the
+	// swipe velocity buckets of the success rate by velocity.
+	SwipeVelocityBuckets []float64
+
+	// MaxResultRows caps the number of rows a single raw data listing may
+	// assemble in memory; set below 1000, it lowers the largest page size.
+	MaxResultRows int
+	// MaxConcurrentExports caps the number of raw data exports served at
+	// the same time.
+	MaxConcurrentExports int
+
+	// TLSCertFile and TLSKeyFile enable HTTPS when both are set.
+	TLSCertFile string
+	TLSKeyFile  string
+	// TLSMinVersion is the lowest TLS version accepted for HTTPS handshakes.
+	TLSMinVersion uint16
+	// TrustedProxies lists the IPs and CIDRs of the reverse proxies whose
+	// forwarding headers are trusted to carry the client IP.
+	TrustedProxies []string
+	// StreamAllowedOrigins lists the origins browsers may open the event
+	// stream from; "*" allows any origin.
+	StreamAllowedOrigins []string
+	// ShutdownTimeout bounds how long the server waits for in-flight
+	// requests to finish after SIGINT or SIGTERM.
+	ShutdownTimeout time.Duration
+
+	// AdminKeys maps each admin secret to its scope (read, delete, or admin).
+	// ADMIN_SECRET_KEY, when set, is included with the admin scope. Like
+	// BootstrapAPIKey, the keys are only stored in the api_keys table at
+	// startup; requests are authenticated against that table alone.
+	AdminKeys map[string]string
+	// BootstrapAPIKey, when set, is stored in the api_keys table with the
+	// admin scope at startup, to provision the first database-backed key.
+	BootstrapAPIKey string
+
+	// DBInitStatements are SQL statements run on every new database
+	// connection, e.g. to set sql_mode or time_zone.
+	DBInitStatements []string
+	// DBQueryTimeout bounds each database call; 0 disables the limit.
+	DBQueryTimeout time.Duration
+	// DBConnectRetries is how many times the startup connection check is
+	// retried, waiting DBConnectBackoff before the first retry and doubling
+	// the wait after each one, within DBConnectTimeout overall.
+	DBConnectRetries int
+	DBConnectBackoff time.Duration
+	DBConnectTimeout time.Duration
+
+	// SchemaDriftAction controls what happens when the live database schema
+	// is missing expected tables or columns at startup: "log" or "abort".
+	SchemaDriftAction string
+
+	// DeadLetterRetryInterval is how often the dead-letter worker looks for
+	// requests to retry; it is also the base of the retry backoff.
+	DeadLetterRetryInterval time.Duration
+	// DeadLetterMaxAttempts is the number of retries before a dead letter
+	// is marked as permanently failed.
+	DeadLetterMaxAttempts int
+
+	// ArchiveAfter is the age after which sessions are moved to the archive
+	// tables by the background archiver; zero disables it.
+	ArchiveAfter time.Duration
+	// ArchiveInterval is how often the background archiver runs.
+	ArchiveInterval time.Duration
+	// DataRetentionDays is the age in days after which sessions and their
+	// data are deleted; 0 keeps data forever.
+	DataRetentionDays int
+	// PurgeInterval is how often the background purge runs.
+	PurgeInterval time.Duration
+
+	// LowFPSThreshold is the default average FPS below which a device
+	// model is reported as underperforming.
+	LowFPSThreshold float64
+
+	// LogSampleRate is the fraction (0-1) of successful requests that are
+	// logged. Failed and slow requests are always logged.
+	LogSampleRate float64
+	// PerformanceSampleRate is the fraction (0-1] of performance samples
+	// that are stored; the rest are dropped at ingestion.
+	PerformanceSampleRate float64
+
+	// LogSlowThreshold is the latency from which a request counts as slow.
+	LogSlowThreshold time.Duration
+	// RateLimitRPS is the sustained number of ingestion requests per second
+	// allowed per session; zero disables rate limiting. RateLimitBurst is
+	// the number of requests a session may send at once.
+	RateLimitRPS   float64
+	RateLimitBurst int
+
+	// CountsCacheTTL is how long the admin table counts are cached; zero
+	// disables caching.
+	CountsCacheTTL time.Duration
+	// HistoricalCacheMaxAge is the max-age of the public Cache-Control
+	// header sent for statistics over a range that ended more than
+	// CacheFreshnessMargin ago. Other ranges, and all ranges when it is
+	// zero, are sent with no-cache.
+	HistoricalCacheMaxAge time.Duration
+	CacheFreshnessMargin  time.Duration
+
+	// EchoRequestID adds the request ID to error response bodies, so
+	// clients can quote it when reporting a failure.
+	EchoRequestID bool
+
+	// EnableGzip compresses GET responses for clients that accept gzip.
+	// Disable it when a proxy in front of the server already compresses.
+	EnableGzip bool
+
+	// OptOutMode controls how ingestion answers requests for users who
+	// opted out: "drop" acknowledges them with 202, "reject" returns 403.
+	OptOutMode string
+
+	// MaxRotation is the largest absolute max_rotation, in degrees, that an
+	// event may report.
+	MaxRotation float64
+	// MinSwipeDistance is the swipe distance a swipe must exceed to count
+	// as a real swipe rather than a tap in decision time statistics.
+	MinSwipeDistance float64
+
+	// EventRequiredFields maps an event type to the fields that events of
+	// that type must carry.
+	EventRequiredFields map[string][]string
+
+	// UserIDPattern, when set, is the format every user_id must match in
+	// full; nil disables the check.
+	UserIDPattern *regexp.Regexp
+
+	// CategoryOrder is the order in which games present categories, used
+	// for the category funnel.
+	CategoryOrder []string
+
+	// DifficultyWeights maps the difficulty property of swipe events to
+	// the weight of the swipe in the difficulty-weighted category success
+	// rate. Numeric difficulties missing from it weigh their value, all
+	// others 1.
+	DifficultyWeights map[string]float64
+
+	// MaxPropertyKeys is the number of distinct custom event property keys
+	// above which new keys are logged, or refused when PropertyKeyAction is
+	// "reject" rather than "warn".
+	MaxPropertyKeys   int
+	PropertyKeyAction string
+}
+
+// Admin scopes in increasing order of privilege. A key satisfies every scope
+// up to and including its own.
+const (
+	ScopeRead   = "read"
+	ScopeDelete = "delete"
+	ScopeAdmin  = "admin"
+)
+
+// ScopeRank returns the privilege rank of scope, or 0 if it is unknown.
+func ScopeRank(scope string) int {
+	switch scope {
+	case ScopeRead:
+		return 1
+	case ScopeDelete:
+		return 2
+	case ScopeAdmin:
+		return 3
+	default:
+		return 0
+	}
 }
 
 func Load() (*Config, error) {
 	cfg := &Config{
-		DBHost:     getEnv("DB_HOST", "localhost"),
-		DBPort:     getEnv("DB_PORT", "5432"),
-		DBUser:     getEnv("DB_USER", "postgres"),
-		DBPassword: getEnv("DB_PASSWORD", "postgres"),
-		DBName:     getEnv("DB_NAME", "cyber_swipe_analytics"),
+		DBDriver:   getEnv("DB_DRIVER", "mysql"),
+		DBHost:     os.Getenv("DB_HOST"),
+		DBPort:     os.Getenv("DB_PORT"),
+		DBUser:     os.Getenv("DB_USER"),
+		DBPassword: os.Getenv("DB_PASSWORD"),
+		DBName:     os.Getenv("DB_NAME"),
+		DBSSLMode:  getEnv("DB_SSL_MODE", "disable"),
 		JWTSecret:  getEnv("JWT_SECRET", "your-secret-key"),
+
+		Environment: getEnv("ENVIRONMENT", "development"),
+	}
+
+	if cfg.DBDriver != "mysql" && cfg.DBDriver != "postgres" {
+		return nil, fmt.Errorf("invalid DB_DRIVER: must be mysql or postgres")
+	}
+
+	// Fail at startup rather than at the first database call
+	if missing := missingEnv("DB_HOST", "DB_PORT", "DB_USER", "DB_PASSWORD", "DB_NAME"); len(missing) > 0 {
+		return nil, fmt.Errorf("missing required environment variables: %s", strings.Join(missing, ", "))
+	}
+	if port, err := strconv.Atoi(cfg.DBPort); err != nil || port < 1 || port > 65535 {
+		return nil, fmt.Errorf("invalid DB_PORT: must be a port number between 1 and 65535")
+	}
+
+	buckets, err := parseIntList(getEnv("SESSION_LENGTH_BUCKETS", "5,10"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid SESSION_LENGTH_BUCKETS: %v", err)
+	}
+	cfg.SessionLengthBuckets = buckets
+
+	cfg.SwipeVelocityBuckets, err = parseFloatList(getEnv("SWIPE_VELOCITY_BUCKETS", "500,1000,2000"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid SWIPE_VELOCITY_BUCKETS: %v", err)
+	}
+
+	maxResultRows, err := getEnvInt("MAX_RESULT_ROWS", 10000)
+	if err != nil {
+		return nil, err
+	}
+	if maxResultRows <= 0 {
+		return nil, fmt.Errorf("invalid MAX_RESULT_ROWS: must be positive")
+	}
+	cfg.MaxResultRows = maxResultRows
+
+	cfg.MaxConcurrentExports, err = getEnvInt("MAX_CONCURRENT_EXPORTS", 2)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.MaxConcurrentExports <= 0 {
+		return nil, fmt.Errorf("invalid MAX_CONCURRENT_EXPORTS: must be positive")
+	}
+
+	cfg.TLSCertFile = os.Getenv("TLS_CERT_FILE")
+	cfg.TLSKeyFile = os.Getenv("TLS_KEY_FILE")
+	if (cfg.TLSCertFile == "") != (cfg.TLSKeyFile == "") {
+		return nil, fmt.Errorf("TLS_CERT_FILE and TLS_KEY_FILE must be set together")
+	}
+
+	tlsMinVersion, err := parseTLSVersion(getEnv("TLS_MIN_VERSION", "1.2"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid TLS_MIN_VERSION: %v", err)
+	}
+	cfg.TLSMinVersion = tlsMinVersion
+
+	cfg.TrustedProxies, err = parseProxyList(getEnv("TRUSTED_PROXIES", "127.0.0.1"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid TRUSTED_PROXIES: %v", err)
+	}
+
+	cfg.StreamAllowedOrigins, err = parseOriginList(getEnv("STREAM_ALLOWED_ORIGINS", "*"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid STREAM_ALLOWED_ORIGINS: %v", err)
+	}
+
+	adminKeys, err := parseAdminKeys(os.Getenv("ADMIN_KEYS"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid ADMIN_KEYS: %v", err)
+	}
+	if secret := os.Getenv("ADMIN_SECRET_KEY"); secret != "" {
+		adminKeys[secret] = ScopeAdmin
+	}
+	cfg.AdminKeys = adminKeys
+	cfg.BootstrapAPIKey = os.Getenv("BOOTSTRAP_API_KEY")
+
+	cfg.DBInitStatements, err = parseStatements(getEnv("DB_INIT_STATEMENTS", ""))
+	if err != nil {
+		return nil, fmt.Errorf("invalid DB_INIT_STATEMENTS: %v", err)
+	}
+
+	cfg.DBQueryTimeout, err = getEnvDuration("DB_QUERY_TIMEOUT", 30*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.DBQueryTimeout < 0 {
+		return nil, fmt.Errorf("invalid DB_QUERY_TIMEOUT: must not be negative")
+	}
+
+	cfg.DBConnectRetries, err = getEnvInt("DB_CONNECT_RETRIES", 5)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.DBConnectRetries < 0 {
+		return nil, fmt.Errorf("invalid DB_CONNECT_RETRIES: must not be negative")
+	}
+
+	cfg.DBConnectBackoff, err = getEnvDuration("DB_CONNECT_BACKOFF", time.Second)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.DBConnectBackoff <= 0 {
+		return nil, fmt.Errorf("invalid DB_CONNECT_BACKOFF: must be positive")
+	}
+
+	cfg.DBConnectTimeout, err = getEnvDuration("DB_CONNECT_TIMEOUT", time.Minute)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.DBConnectTimeout <= 0 {
+		return nil, fmt.Errorf("invalid DB_CONNECT_TIMEOUT: must be positive")
+	}
+
+	cfg.SchemaDriftAction = strings.ToLower(getEnv("SCHEMA_DRIFT_ACTION", "log"))
+	if cfg.SchemaDriftAction != "log" && cfg.SchemaDriftAction != "abort" {
+		return nil, fmt.Errorf("invalid SCHEMA_DRIFT_ACTION: must be log or abort")
+	}
+
+	cfg.DeadLetterRetryInterval, err = getEnvDuration("DEAD_LETTER_RETRY_INTERVAL", 30*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.DeadLetterRetryInterval <= 0 {
+		return nil, fmt.Errorf("invalid DEAD_LETTER_RETRY_INTERVAL: must be positive")
+	}
+
+	cfg.DeadLetterMaxAttempts, err = getEnvInt("DEAD_LETTER_MAX_ATTEMPTS", 5)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.DeadLetterMaxAttempts <= 0 {
+		return nil, fmt.Errorf("invalid DEAD_LETTER_MAX_ATTEMPTS: must be positive")
+	}
+
+	cfg.ArchiveAfter, err = getEnvDuration("ARCHIVE_AFTER", 0)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.ArchiveAfter < 0 {
+		return nil, fmt.Errorf("invalid ARCHIVE_AFTER: must not be negative")
+	}
+
+	cfg.ArchiveInterval, err = getEnvDuration("ARCHIVE_INTERVAL", time.Hour)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.ArchiveInterval <= 0 {
+		return nil, fmt.Errorf("invalid ARCHIVE_INTERVAL: must be positive")
+	}
+
+	cfg.DataRetentionDays, err = getEnvInt("DATA_RETENTION_DAYS", 0)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.DataRetentionDays < 0 {
+		return nil, fmt.Errorf("invalid DATA_RETENTION_DAYS: must not be negative")
+	}
+
+	cfg.PurgeInterval, err = getEnvDuration("PURGE_INTERVAL", time.Hour)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.PurgeInterval <= 0 {
+		return nil, fmt.Errorf("invalid PURGE_INTERVAL: must be positive")
+	}
+
+	cfg.LowFPSThreshold, err = getEnvFloat("LOW_FPS_THRESHOLD", 30)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.LowFPSThreshold <= 0 {
+		return nil, fmt.Errorf("invalid LOW_FPS_THRESHOLD: must be positive")
+	}
+
+	cfg.LogSampleRate, err = getEnvFloat("LOG_SAMPLE_RATE", 1)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.LogSampleRate < 0 || cfg.LogSampleRate > 1 {
+		return nil, fmt.Errorf("invalid LOG_SAMPLE_RATE: must be between 0 and 1")
+	}
+
+	cfg.EchoRequestID, err = getEnvBool("ECHO_REQUEST_ID", true)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg.EnableGzip, err = getEnvBool("ENABLE_GZIP", true)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg.PerformanceSampleRate, err = getEnvFloat("PERFORMANCE_SAMPLE_RATE", 1)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.PerformanceSampleRate <= 0 || cfg.PerformanceSampleRate > 1 {
+		return nil, fmt.Errorf("invalid PERFORMANCE_SAMPLE_RATE: must be greater than 0 and at most 1")
+	}
+
+	cfg.LogSlowThreshold, err = getEnvDuration("LOG_SLOW_THRESHOLD", time.Second)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg.RateLimitRPS, err = getEnvFloat("RATE_LIMIT_RPS", 20)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.RateLimitRPS < 0 {
+		return nil, fmt.Errorf("invalid RATE_LIMIT_RPS: must not be negative")
+	}
+	cfg.RateLimitBurst, err = getEnvInt("RATE_LIMIT_BURST", 50)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.RateLimitBurst < 1 {
+		return nil, fmt.Errorf("invalid RATE_LIMIT_BURST: must be at least 1")
+	}
+
+	cfg.CountsCacheTTL, err = getEnvDuration("COUNTS_CACHE_TTL", 10*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.CountsCacheTTL < 0 {
+		return nil, fmt.Errorf("invalid COUNTS_CACHE_TTL: must not be negative")
+	}
+
+	cfg.HistoricalCacheMaxAge, err = getEnvDuration("HISTORICAL_CACHE_MAX_AGE", time.Hour)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.HistoricalCacheMaxAge < 0 {
+		return nil, fmt.Errorf("invalid HISTORICAL_CACHE_MAX_AGE: must not be negative")
+	}
+	cfg.CacheFreshnessMargin, err = getEnvDuration("CACHE_FRESHNESS_MARGIN", time.Hour)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.CacheFreshnessMargin < 0 {
+		return nil, fmt.Errorf("invalid CACHE_FRESHNESS_MARGIN: must not be negative")
+	}
+
+	cfg.ShutdownTimeout, err = getEnvDuration("SHUTDOWN_TIMEOUT", 30*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.ShutdownTimeout <= 0 {
+		return nil, fmt.Errorf("invalid SHUTDOWN_TIMEOUT: must be positive")
+	}
+
+	cfg.OptOutMode = strings.ToLower(getEnv("OPT_OUT_MODE", "drop"))
+	if cfg.OptOutMode != "drop" && cfg.OptOutMode != "reject" {
+		return nil, fmt.Errorf("invalid OPT_OUT_MODE: must be drop or reject")
+	}
+
+	cfg.MaxRotation, err = getEnvFloat("MAX_ROTATION_DEGREES", 360)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.MaxRotation <= 0 {
+		return nil, fmt.Errorf("invalid MAX_ROTATION_DEGREES: must be positive")
+	}
+
+	cfg.MinSwipeDistance, err = getEnvFloat("MIN_SWIPE_DISTANCE", 10)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.MinSwipeDistance < 0 {
+		return nil, fmt.Errorf("invalid MIN_SWIPE_DISTANCE: must not be negative")
+	}
+
+	cfg.EventRequiredFields, err = parseRequiredFields(getEnv("EVENT_REQUIRED_FIELDS", defaultEventRequiredFields))
+	if err != nil {
+		return nil, fmt.Errorf("invalid EVENT_REQUIRED_FIELDS: %v", err)
+	}
+
+	if pattern := getEnv("USER_ID_PATTERN", ""); pattern != "" {
+		cfg.UserIDPattern, err = regexp.Compile("^(?:" + pattern + ")$")
+		if err != nil {
+			return nil, fmt.Errorf("invalid USER_ID_PATTERN: %v", err)
+		}
+	}
+
+	cfg.CategoryOrder, err = parseStringList(getEnv("CATEGORY_ORDER", ""))
+	if err != nil {
+		return nil, fmt.Errorf("invalid CATEGORY_ORDER: %v", err)
+	}
+
+	cfg.DifficultyWeights, err = parseDifficultyWeights(getEnv("DIFFICULTY_WEIGHTS", `{"easy":1,"medium":2,"hard":3}`))
+	if err != nil {
+		return nil, fmt.Errorf("invalid DIFFICULTY_WEIGHTS: %v", err)
+	}
+
+	cfg.MaxPropertyKeys, err = getEnvInt("MAX_PROPERTY_KEYS", 100)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.MaxPropertyKeys <= 0 {
+		return nil, fmt.Errorf("invalid MAX_PROPERTY_KEYS: must be positive")
+	}
+	cfg.PropertyKeyAction = strings.ToLower(getEnv("PROPERTY_KEY_ACTION", "warn"))
+	if cfg.PropertyKeyAction != "warn" && cfg.PropertyKeyAction != "reject" {
+		return nil, fmt.Errorf("invalid PROPERTY_KEY_ACTION: must be warn or reject")
 	}
 
 	return cfg, nil
@@ -33,3 +522,295 @@ func getEnv(key, defaultValue string) string {
 	}
 	return value
 }
+
+// missingEnv returns the keys among keys whose environment variable is unset
+// or empty.
+func missingEnv(keys ...string) []string {
+	var missing []string
+	for _, key := range keys {
+		if os.Getenv(key) == "" {
+			missing = append(missing, key)
+		}
+	}
+	return missing
+}
+
+// getEnvInt reads an integer environment variable, falling back to
+// defaultValue when it is unset.
+func getEnvInt(key string, defaultValue int) (int, error) {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s: %q is not an integer", key, value)
+	}
+	return n, nil
+}
+
+// getEnvFloat reads a floating point environment variable, falling back
+// to defaultValue when it is unset.
+func getEnvFloat(key string, defaultValue float64) (float64, error) {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue, nil
+	}
+	f, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s: %q is not a number", key, value)
+	}
+	return f, nil
+}
+
+// getEnvBool reads a boolean environment variable such as "true" or "0",
+// falling back to defaultValue when it is unset.
+func getEnvBool(key string, defaultValue bool) (bool, error) {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue, nil
+	}
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, fmt.Errorf("invalid %s: %q is not a boolean", key, value)
+	}
+	return b, nil
+}
+
+// getEnvDuration reads a duration environment variable such as "30s",
+// falling back to defaultValue when it is unset.
+func getEnvDuration(key string, defaultValue time.Duration) (time.Duration, error) {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue, nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s: %q is not a duration", key, value)
+	}
+	return d, nil
+}
+
+// defaultEventRequiredFields requires swipes to name the card and the
+// direction, and undos the card whose swipe they take back.
+const defaultEventRequiredFields = `{"card_swipe": ["card_id", "direction"], "undo": ["card_id"]}`
+
+// parseRequiredFields parses a JSON object mapping event types to the list
+// of fields they require.
+func parseRequiredFields(value string) (map[string][]string, error) {
+	rules := make(map[string][]string)
+	if strings.TrimSpace(value) == "" {
+		return rules, nil
+	}
+	if err := json.Unmarshal([]byte(value), &rules); err != nil {
+		return nil, fmt.Errorf("expected a JSON object of event type to field list: %v", err)
+	}
+	for eventType, fields := range rules {
+		if eventType == "" {
+			return nil, fmt.Errorf("event types must not be empty")
+		}
+		for _, field := range fields {
+			if field == "" {
+				return nil, fmt.Errorf("required fields of %q must not be empty", eventType)
+			}
+		}
+	}
+	return rules, nil
+}
+
+// parseDifficultyWeights parses a JSON object mapping card difficulties to
+// positive weights.
+func parseDifficultyWeights(value string) (map[string]float64, error) {
+	weights := make(map[string]float64)
+	if strings.TrimSpace(value) == "" {
+		return weights, nil
+	}
+	if err := json.Unmarshal([]byte(value), &weights); err != nil {
+		return nil, fmt.Errorf("expected a JSON object of difficulty to weight: %v", err)
+	}
+	for difficulty, weight := range weights {
+		if !(weight > 0) {
+			return nil, fmt.Errorf("weight of %q must be positive", difficulty)
+		}
+	}
+	return weights, nil
+}
+
+// parseAdminKeys parses a JSON object mapping admin secrets to scopes.
+func parseAdminKeys(value string) (map[string]string, error) {
+	keys := make(map[string]string)
+	if strings.TrimSpace(value) == "" {
+		return keys, nil
+	}
+	if err := json.Unmarshal([]byte(value), &keys); err != nil {
+		return nil, fmt.Errorf("expected a JSON object of key to scope: %v", err)
+	}
+	for key, scope := range keys {
+		if key == "" {
+			return nil, fmt.Errorf("admin keys must not be empty")
+		}
+		if ScopeRank(scope) == 0 {
+			return nil, fmt.Errorf("unknown scope %q, use read, delete, or admin", scope)
+		}
+	}
+	return keys, nil
+}
+
+// parseTLSVersion maps a TLS version string such as "1.2" to its crypto/tls
+// constant. Versions below 1.2 are rejected for compliance reasons.
+func parseTLSVersion(value string) (uint16, error) {
+	switch strings.TrimPrefix(strings.ToUpper(strings.TrimSpace(value)), "TLS") {
+	case "1.2":
+		return tls.VersionTLS12, nil
+	case "1.3":
+		return tls.VersionTLS13, nil
+	case "1.0", "1.1":
+		return 0, fmt.Errorf("TLS %s is not allowed, use 1.2 or 1.3", value)
+	default:
+		return 0, fmt.Errorf("unknown TLS version %q, use 1.2 or 1.3", value)
+	}
+}
+
+// parseFloatList parses a comma-separated list of positive numbers into a
+// strictly increasing slice.
+func parseFloatList(value string) ([]float64, error) {
+	var values []float64
+	for _, part := range strings.Split(value, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		f, err := strconv.ParseFloat(part, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%q is not a number", part)
+		}
+		if f <= 0 {
+			return nil, fmt.Errorf("%g must be positive", f)
+		}
+		if len(values) > 0 && f <= values[len(values)-1] {
+			return nil, fmt.Errorf("values must be strictly increasing")
+		}
+		values = append(values, f)
+	}
+	if len(values) == 0 {
+		return nil, fmt.Errorf("at least one value is required")
+	}
+	return values, nil
+}
+
+// parseIntList parses a comma-separated list of positive integers into a
+// strictly increasing slice.
+func parseIntList(value string) ([]int, error) {
+	var values []int
+	for _, part := range strings.Split(value, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		n, err := strconv.Atoi(part)
+		if err != nil {
+			return nil, fmt.Errorf("%q is not an integer", part)
+		}
+		if n <= 0 {
+			return nil, fmt.Errorf("%d must be positive", n)
+		}
+		values = append(values, n)
+	}
+	if len(values) == 0 {
+		return nil, fmt.Errorf("at least one value is required")
+	}
+	if !sort.IntsAreSorted(values) {
+		return nil, fmt.Errorf("values must be in increasing order")
+	}
+	for i := 1; i < len(values); i++ {
+		if values[i] == values[i-1] {
+			return nil, fmt.Errorf("duplicate value %d", values[i])
+		}
+	}
+	return values, nil
+}
+
+// parseStringList parses a comma-separated list of distinct names, trimming
+// whitespace and skipping empty entries.
+func parseStringList(value string) ([]string, error) {
+	var values []string
+	seen := make(map[string]bool)
+	for _, part := range strings.Split(value, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		if seen[part] {
+			return nil, fmt.Errorf("duplicate value %q", part)
+		}
+		seen[part] = true
+		values = append(values, part)
+	}
+	return values, nil
+}
+
+// parseStatements splits a semicolon-separated list of SQL statements.
+// Quoted semicolons are not supported, so statements must not contain them.
+func parseStatements(value string) ([]string, error) {
+	var statements []string
+	for _, part := range strings.Split(value, ";") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		if strings.Count(part, "'")%2 != 0 {
+			return nil, fmt.Errorf("unbalanced quotes in %q", part)
+		}
+		statements = append(statements, part)
+	}
+	return statements, nil
+}
+
+// parseProxyList parses a comma-separated list of IP addresses and CIDR
+// ranges. At least one entry is required.
+func parseProxyList(value string) ([]string, error) {
+	var proxies []string
+	for _, part := range strings.Split(value, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		if strings.Contains(part, "/") {
+			if _, _, err := net.ParseCIDR(part); err != nil {
+				return nil, fmt.Errorf("%q is not a valid CIDR", part)
+			}
+		} else if net.ParseIP(part) == nil {
+			return nil, fmt.Errorf("%q is not a valid IP address", part)
+		}
+		proxies = append(proxies, part)
+	}
+	if len(proxies) == 0 {
+		return nil, fmt.Errorf("at least one IP or CIDR is required")
+	}
+	return proxies, nil
+}
+
+// parseOriginList parses a comma-separated list of origins, each either
+// "*" or a scheme and host such as https://dashboard.example.com.
+func parseOriginList(value string) ([]string, error) {
+	var origins []string
+	for _, part := range strings.Split(value, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		if part != "*" {
+			origin, err := url.Parse(part)
+			if err != nil || (origin.Scheme != "http" && origin.Scheme != "https") || origin.Host == "" ||
+				strings.TrimSuffix(origin.Path, "/") != "" || origin.RawQuery != "" {
+				return nil, fmt.Errorf("%q is not an origin such as https://example.com", part)
+			}
+			part = origin.Scheme + "://" + origin.Host
+		}
+		origins = append(origins, part)
+	}
+	if len(origins) == 0 {
+		return nil, fmt.Errorf("at least one origin is required")
+	}
+	return origins, nil
+}
@@ -0,0 +1,435 @@
+package config
+
+import (
+	"crypto/tls"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// setRequiredEnv sets the database variables Load requires.
+func setRequiredEnv(t *testing.T) {
+	t.Helper()
+	t.Setenv("DB_HOST", "localhost")
+	t.Setenv("DB_PORT", "3306")
+	t.Setenv("DB_USER", "analytics")
+	t.Setenv("DB_PASSWORD", "secret")
+	t.Setenv("DB_NAME", "analytics")
+}
+
+func TestParseTLSVersion(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    uint16
+		wantErr bool
+	}{
+		{"1.2", tls.VersionTLS12, false},
+		{"1.3", tls.VersionTLS13, false},
+		{"TLS1.3", tls.VersionTLS13, false},
+		{"1.1", 0, true},
+		{"1.0", 0, true},
+		{"2.0", 0, true},
+	}
+	for _, tt := range tests {
+		got, err := parseTLSVersion(tt.value)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseTLSVersion(%q) error = %v, want error %v", tt.value, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("parseTLSVersion(%q) = %#x, want %#x", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestLoadTLSMinVersionDefault(t *testing.T) {
+	setRequiredEnv(t)
+	t.Setenv("TLS_MIN_VERSION", "")
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if cfg.TLSMinVersion != tls.VersionTLS12 {
+		t.Errorf("TLSMinVersion = %#x, want TLS 1.2", cfg.TLSMinVersion)
+	}
+}
+
+func TestLoadAdminKeys(t *testing.T) {
+	setRequiredEnv(t)
+	t.Setenv("ADMIN_KEYS", `{"reader-key": "read", "support-key": "delete"}`)
+	t.Setenv("ADMIN_SECRET_KEY", "root-key")
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	want := map[string]string{"reader-key": ScopeRead, "support-key": ScopeDelete, "root-key": ScopeAdmin}
+	if len(cfg.AdminKeys) != len(want) {
+		t.Fatalf("AdminKeys = %v, want %v", cfg.AdminKeys, want)
+	}
+	for key, scope := range want {
+		if cfg.AdminKeys[key] != scope {
+			t.Errorf("AdminKeys[%q] = %q, want %q", key, cfg.AdminKeys[key], scope)
+		}
+	}
+}
+
+func TestParseAdminKeysRejectsUnknownScope(t *testing.T) {
+	if _, err := parseAdminKeys(`{"key": "superuser"}`); err == nil {
+		t.Error("want an error for an unknown scope")
+	}
+	if _, err := parseAdminKeys(`{"": "read"}`); err == nil {
+		t.Error("want an error for an empty key")
+	}
+}
+
+func TestScopeRank(t *testing.T) {
+	if !(ScopeRank(ScopeRead) < ScopeRank(ScopeDelete) && ScopeRank(ScopeDelete) < ScopeRank(ScopeAdmin)) {
+		t.Error("scopes are not ranked read < delete < admin")
+	}
+	if ScopeRank("owner") != 0 {
+		t.Error("unknown scope has a rank")
+	}
+}
+
+func TestParseStatements(t *testing.T) {
+	statements, err := parseStatements(" SET SESSION sql_mode = 'STRICT_ALL_TABLES'; ;SET time_zone = '+00:00';")
+	if err != nil {
+		t.Fatalf("parseStatements: %v", err)
+	}
+	want := []string{"SET SESSION sql_mode = 'STRICT_ALL_TABLES'", "SET time_zone = '+00:00'"}
+	if !reflect.DeepEqual(statements, want) {
+		t.Errorf("parseStatements = %q, want %q", statements, want)
+	}
+
+	if _, err := parseStatements("SET time_zone = '+00:00"); err == nil {
+		t.Error("unbalanced quotes: want an error")
+	}
+}
+
+func TestParseRequiredFields(t *testing.T) {
+	rules, err := parseRequiredFields(defaultEventRequiredFields)
+	if err != nil {
+		t.Fatalf("parseRequiredFields: %v", err)
+	}
+	if !reflect.DeepEqual(rules["card_swipe"], []string{"card_id", "direction"}) || rules["session_start"] != nil {
+		t.Errorf("default rules = %v", rules)
+	}
+
+	for _, value := range []string{`["card_id"]`, `{"": ["card_id"]}`, `{"undo": [""]}`} {
+		if _, err := parseRequiredFields(value); err == nil {
+			t.Errorf("%s: want an error", value)
+		}
+	}
+}
+
+func TestLoadPerformanceSampleRate(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    float64
+		wantErr bool
+	}{
+		{"", 1, false},
+		{"0.1", 0.1, false},
+		{"1", 1, false},
+		{"0", 0, true},
+		{"1.5", 0, true},
+		{"half", 0, true},
+	}
+	for _, tt := range tests {
+		setRequiredEnv(t)
+		t.Setenv("PERFORMANCE_SAMPLE_RATE", tt.value)
+		cfg, err := Load()
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%q: Load error = %v, want error %v", tt.value, err, tt.wantErr)
+			continue
+		}
+		if err == nil && cfg.PerformanceSampleRate != tt.want {
+			t.Errorf("%q: PerformanceSampleRate = %v, want %v", tt.value, cfg.PerformanceSampleRate, tt.want)
+		}
+	}
+}
+
+func TestLoadSwipeVelocityBuckets(t *testing.T) {
+	setRequiredEnv(t)
+	t.Setenv("SWIPE_VELOCITY_BUCKETS", "250, 750")
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if !reflect.DeepEqual(cfg.SwipeVelocityBuckets, []float64{250, 750}) {
+		t.Errorf("SwipeVelocityBuckets = %v, want [250 750]", cfg.SwipeVelocityBuckets)
+	}
+
+	for _, value := range []string{"fast", "0,500", "750,250"} {
+		t.Setenv("SWIPE_VELOCITY_BUCKETS", value)
+		if _, err := Load(); err == nil {
+			t.Errorf("%q: Load succeeded, want an error", value)
+		}
+	}
+}
+
+func TestLoadRateLimit(t *testing.T) {
+	tests := []struct {
+		rps, burst string
+		wantErr    bool
+	}{
+		{"", "", false},
+		{"0", "", false},
+		{"5", "10", false},
+		{"-1", "", true},
+		{"5", "0", true},
+		{"fast", "", true},
+	}
+	for _, tt := range tests {
+		setRequiredEnv(t)
+		t.Setenv("RATE_LIMIT_RPS", tt.rps)
+		t.Setenv("RATE_LIMIT_BURST", tt.burst)
+		if _, err := Load(); (err != nil) != tt.wantErr {
+			t.Errorf("RATE_LIMIT_RPS=%q RATE_LIMIT_BURST=%q: Load error = %v, want error %v", tt.rps, tt.burst, err, tt.wantErr)
+		}
+	}
+}
+
+func TestLoadDBDriver(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    string
+		wantErr bool
+	}{
+		{"", "mysql", false},
+		{"mysql", "mysql", false},
+		{"postgres", "postgres", false},
+		{"sqlite3", "", true},
+	}
+	for _, tt := range tests {
+		setRequiredEnv(t)
+		t.Setenv("DB_DRIVER", tt.value)
+		cfg, err := Load()
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%q: Load error = %v, want error %v", tt.value, err, tt.wantErr)
+			continue
+		}
+		if err == nil && cfg.DBDriver != tt.want {
+			t.Errorf("%q: DBDriver = %q, want %q", tt.value, cfg.DBDriver, tt.want)
+		}
+	}
+}
+
+func TestLoadTrustedProxies(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    []string
+		wantErr bool
+	}{
+		{"10.0.0.0/8, 192.168.1.10,fd00::/8", []string{"10.0.0.0/8", "192.168.1.10", "fd00::/8"}, false},
+		{"", []string{"127.0.0.1"}, false},
+		{"10.0.0.0/33", nil, true},
+		{"10.0.0.0/8,proxy.internal", nil, true},
+		{" , ", nil, true},
+	}
+	for _, tt := range tests {
+		setRequiredEnv(t)
+		t.Setenv("TRUSTED_PROXIES", tt.value)
+		cfg, err := Load()
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%q: Load error = %v, want error %v", tt.value, err, tt.wantErr)
+			continue
+		}
+		if err == nil && !reflect.DeepEqual(cfg.TrustedProxies, tt.want) {
+			t.Errorf("%q: TrustedProxies = %v, want %v", tt.value, cfg.TrustedProxies, tt.want)
+		}
+	}
+}
+
+func TestLoadStreamAllowedOrigins(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    []string
+		wantErr bool
+	}{
+		{"", []string{"*"}, false},
+		{"https://dashboard.example.com/, http://localhost:3000", []string{"https://dashboard.example.com", "http://localhost:3000"}, false},
+		{"dashboard.example.com", nil, true},
+		{"https://dashboard.example.com/admin", nil, true},
+		{"ftp://example.com", nil, true},
+		{" , ", nil, true},
+	}
+	for _, tt := range tests {
+		setRequiredEnv(t)
+		t.Setenv("STREAM_ALLOWED_ORIGINS", tt.value)
+		cfg, err := Load()
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%q: Load error = %v, want error %v", tt.value, err, tt.wantErr)
+			continue
+		}
+		if err == nil && !reflect.DeepEqual(cfg.StreamAllowedOrigins, tt.want) {
+			t.Errorf("%q: StreamAllowedOrigins = %v, want %v", tt.value, cfg.StreamAllowedOrigins, tt.want)
+		}
+	}
+}
+
+func TestLoadDBConnectRetries(t *testing.T) {
+	setRequiredEnv(t)
+	t.Setenv("DB_CONNECT_RETRIES", "3")
+	t.Setenv("DB_CONNECT_BACKOFF", "250ms")
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if cfg.DBConnectRetries != 3 || cfg.DBConnectBackoff != 250*time.Millisecond || cfg.DBConnectTimeout != time.Minute {
+		t.Errorf("retries %d, backoff %v, timeout %v", cfg.DBConnectRetries, cfg.DBConnectBackoff, cfg.DBConnectTimeout)
+	}
+
+	for key, value := range map[string]string{
+		"DB_CONNECT_RETRIES": "-1",
+		"DB_CONNECT_BACKOFF": "0s",
+		"DB_CONNECT_TIMEOUT": "soon",
+	} {
+		setRequiredEnv(t)
+		t.Setenv(key, value)
+		if _, err := Load(); err == nil {
+			t.Errorf("%s=%q: Load succeeded, want an error", key, value)
+		}
+		t.Setenv(key, "")
+	}
+}
+
+func TestLoadRequiresDatabaseVariables(t *testing.T) {
+	setRequiredEnv(t)
+	t.Setenv("DB_HOST", "")
+	t.Setenv("DB_PASSWORD", "")
+	t.Setenv("DB_NAME", "")
+
+	// Every missing variable is named at once, the set ones are not
+	_, err := Load()
+	if err == nil {
+		t.Fatal("Load succeeded without the database variables")
+	}
+	for _, key := range []string{"DB_HOST", "DB_PASSWORD", "DB_NAME"} {
+		if !strings.Contains(err.Error(), key) {
+			t.Errorf("error %q does not name %s", err, key)
+		}
+	}
+	for _, key := range []string{"DB_PORT", "DB_USER"} {
+		if strings.Contains(err.Error(), key) {
+			t.Errorf("error %q names %s, which is set", err, key)
+		}
+	}
+}
+
+func TestLoadRejectsInvalidDBPort(t *testing.T) {
+	for _, value := range []string{"mysql", "3306a", "0", "65536"} {
+		setRequiredEnv(t)
+		t.Setenv("DB_PORT", value)
+		_, err := Load()
+		if err == nil || !strings.Contains(err.Error(), "DB_PORT") {
+			t.Errorf("%q: Load error = %v, want an invalid DB_PORT error", value, err)
+		}
+	}
+}
+
+func TestLoadEnableGzip(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    bool
+		wantErr bool
+	}{
+		{"", true, false},
+		{"false", false, false},
+		{"1", true, false},
+		{"sometimes", false, true},
+	}
+	for _, tt := range tests {
+		setRequiredEnv(t)
+		t.Setenv("ENABLE_GZIP", tt.value)
+		cfg, err := Load()
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%q: Load error = %v, want error %v", tt.value, err, tt.wantErr)
+			continue
+		}
+		if err == nil && cfg.EnableGzip != tt.want {
+			t.Errorf("%q: EnableGzip = %v, want %v", tt.value, cfg.EnableGzip, tt.want)
+		}
+	}
+}
+
+func TestLoadMaxResultRows(t *testing.T) {
+	setRequiredEnv(t)
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if cfg.MaxResultRows != 10000 {
+		t.Errorf("MaxResultRows = %d, want the default of 10000", cfg.MaxResultRows)
+	}
+
+	t.Setenv("MAX_RESULT_ROWS", "0")
+	if _, err := Load(); err == nil {
+		t.Error("MAX_RESULT_ROWS=0: Load succeeded, want an error")
+	}
+}
+
+func TestLoadHistoricalCache(t *testing.T) {
+	setRequiredEnv(t)
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if cfg.HistoricalCacheMaxAge != time.Hour || cfg.CacheFreshnessMargin != time.Hour {
+		t.Errorf("max age %v with margin %v, want an hour each", cfg.HistoricalCacheMaxAge, cfg.CacheFreshnessMargin)
+	}
+
+	for _, key := range []string{"HISTORICAL_CACHE_MAX_AGE", "CACHE_FRESHNESS_MARGIN"} {
+		t.Run(key, func(t *testing.T) {
+			t.Setenv(key, "-1m")
+			if _, err := Load(); err == nil {
+				t.Errorf("%s=-1m: Load succeeded, want an error", key)
+			}
+		})
+	}
+}
+
+func TestLoadDifficultyWeights(t *testing.T) {
+	setRequiredEnv(t)
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if !reflect.DeepEqual(cfg.DifficultyWeights, map[string]float64{"easy": 1, "medium": 2, "hard": 3}) {
+		t.Errorf("DifficultyWeights = %v, want easy 1, medium 2, hard 3", cfg.DifficultyWeights)
+	}
+
+	for _, value := range []string{`{"hard":0}`, `{"hard":"x"}`, `hard=3`} {
+		t.Setenv("DIFFICULTY_WEIGHTS", value)
+		if _, err := Load(); err == nil {
+			t.Errorf("DIFFICULTY_WEIGHTS=%s: Load succeeded, want an error", value)
+		}
+	}
+}
+
+func TestLoadPropertyKeyLimit(t *testing.T) {
+	setRequiredEnv(t)
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if cfg.MaxPropertyKeys != 100 || cfg.PropertyKeyAction != "warn" {
+		t.Errorf("limit %d with action %q, want 100 with warn", cfg.MaxPropertyKeys, cfg.PropertyKeyAction)
+	}
+
+	t.Setenv("PROPERTY_KEY_ACTION", "REJECT")
+	if cfg, err := Load(); err != nil || cfg.PropertyKeyAction != "reject" {
+		t.Errorf("PROPERTY_KEY_ACTION=REJECT: %v, %v; want reject", cfg, err)
+	}
+	for key, value := range map[string]string{"MAX_PROPERTY_KEYS": "0", "PROPERTY_KEY_ACTION": "drop"} {
+		t.Run(key, func(t *testing.T) {
+			t.Setenv(key, value)
+			if _, err := Load(); err == nil {
+				t.Errorf("%s=%s: Load succeeded, want an error", key, value)
+			}
+		})
+	}
+}
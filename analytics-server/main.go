@@ -1,8 +1,14 @@
 package main
 
 import (
+	"context"
+	"crypto/tls"
 	"log"
+	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"cyber-swipe-analytics/api"
 	"cyber-swipe-analytics/config"
@@ -34,16 +40,55 @@ func main() {
 	}
 	defer database.Close()
 
+	// Provision the first database-backed admin key, if configured
+	if serverConfig.BootstrapAPIKey != "" {
+		if err := database.BootstrapAPIKey(context.Background(), serverConfig.BootstrapAPIKey, "bootstrap", config.ScopeAdmin); err != nil {
+			log.Fatalf("Failed to store bootstrap API key: %v", err)
+		}
+	}
+
+	// Store the admin keys configured in the environment hashed, so they
+	// can be revoked like any other key, and revoke the ones removed from
+	// the environment since the last start
+	revoked, err := database.SyncAPIKeys(context.Background(), "environment", serverConfig.AdminKeys)
+	if err != nil {
+		log.Fatalf("Failed to store admin keys from the environment: %v", err)
+	}
+	if revoked > 0 {
+		log.Printf("Revoked %d admin keys no longer configured in the environment", revoked)
+	}
+
 	// Create and configure the HTTP router
-	router := gin.Default()
+	router := gin.New()
+	router.Use(gin.Recovery())
 
-	router.SetTrustedProxies([]string{"127.0.0.1"}) // Only trust localhost for security
+	// Only trust forwarding headers from the configured proxies
+	if err := router.SetTrustedProxies(serverConfig.TrustedProxies); err != nil {
+		log.Fatalf("Failed to set trusted proxies: %v", err)
+	}
+
+	// Assign every request an ID for log and error correlation
+	router.Use(api.RequestID())
+
+	// Log failed and slow requests, and a sample of the rest
+	router.Use(api.RequestLogger(serverConfig))
+
+	// Count requests and their latency for the /metrics endpoint
+	router.Use(api.Metrics())
+
+	// Answer requests whose database calls timed out with 503
+	router.Use(api.QueryTimeouts())
+
+	// Compress responses for clients that accept gzip, if enabled
+	if serverConfig.EnableGzip {
+		router.Use(api.Gzip())
+	}
 
 	// Add CORS middleware to allow cross-origin requests
 	router.Use(func(c *gin.Context) {
 		c.Writer.Header().Set("Access-Control-Allow-Origin", "*")
 		c.Writer.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-		c.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+		c.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, X-Request-ID, X-Session-ID")
 		if c.Request.Method == "OPTIONS" {
 			c.AbortWithStatus(204)
 			return
@@ -52,7 +97,24 @@ func main() {
 	})
 
 	// Register all API routes with the router
-	api.SetupRoutes(router, database)
+	handler := api.NewAnalyticsHandler(database, serverConfig)
+	api.SetupRoutes(router, handler)
+
+	// Retry ingestion requests that failed for transient reasons
+	stopDeadLetterWorker := handler.StartDeadLetterWorker()
+	defer stopDeadLetterWorker()
+
+	// Move old sessions out of the hot tables, if configured
+	stopArchiveWorker := handler.StartArchiveWorker()
+	defer stopArchiveWorker()
+
+	// Delete data older than the retention window, if configured
+	stopPurgeWorker := handler.StartPurgeWorker()
+	defer stopPurgeWorker()
+
+	// Forget the rate limits of idle sessions
+	stopRateLimitSweeper := handler.StartRateLimitSweeper()
+	defer stopRateLimitSweeper()
 
 	// Start the HTTP server on the configured port
 	serverPort := os.Getenv("PORT")
@@ -60,9 +122,66 @@ func main() {
 		serverPort = "8080" // Default port if not specified
 	}
 
-	log.Printf("Server starting on port %s", serverPort)
-	
-	if err := router.Run(":" + serverPort); err != nil {
-		log.Fatalf("Failed to start server: %v", err)
+	server := newServer(":"+serverPort, router, serverConfig)
+
+	serverErr := make(chan error, 1)
+	go func() {
+		if serverConfig.TLSCertFile != "" {
+			log.Printf("Server starting with HTTPS on port %s", serverPort)
+			serverErr <- server.ListenAndServeTLS(serverConfig.TLSCertFile, serverConfig.TLSKeyFile)
+		} else {
+			log.Printf("Server starting on port %s", serverPort)
+			serverErr <- server.ListenAndServe()
+		}
+	}()
+
+	// Run until the server fails or the orchestrator asks us to stop
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	select {
+	case err := <-serverErr:
+		if err != nil && err != http.ErrServerClosed {
+			// log.Fatalf skips the deferred calls, so drain the workers
+			// and close the database first
+			stopRateLimitSweeper()
+			stopPurgeWorker()
+			stopArchiveWorker()
+			stopDeadLetterWorker()
+			database.Close()
+			log.Fatalf("Failed to start server: %v", err)
+		}
+		return
+	case sig := <-signals:
+		log.Printf("Received %s, shutting down", sig)
+	}
+
+	// Let in-flight requests finish before the workers stop and the
+	// database is closed by the deferred calls above
+	shutdownServer(server, serverConfig.ShutdownTimeout)
+}
+
+// shutdownServer stops server from accepting connections and waits up to
+// timeout for in-flight requests to finish, logging how long it waited.
+func shutdownServer(server *http.Server, timeout time.Duration) {
+	start := time.Now()
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	if err := server.Shutdown(ctx); err != nil {
+		log.Printf("Shutdown did not complete after %.1fs: %v", time.Since(start).Seconds(), err)
+		return
+	}
+	log.Printf("Server stopped after waiting %.1fs for in-flight requests", time.Since(start).Seconds())
+}
+
+// newServer builds the HTTP server for the given handler. The TLS settings
+// only take effect when the server is started with ListenAndServeTLS.
+func newServer(addr string, handler http.Handler, cfg *config.Config) *http.Server {
+	return &http.Server{
+		Addr:    addr,
+		Handler: handler,
+		TLSConfig: &tls.Config{
+			MinVersion: cfg.TLSMinVersion,
+		},
 	}
 }
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"crypto/tls"
+	"cyber-swipe-analytics/config"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestNewServerTLSMinVersion(t *testing.T) {
+	for _, env := range []struct {
+		version string
+		want    uint16
+	}{
+		{"1.2", tls.VersionTLS12},
+		{"1.3", tls.VersionTLS13},
+	} {
+		t.Setenv("DB_HOST", "localhost")
+		t.Setenv("DB_PORT", "3306")
+		t.Setenv("DB_USER", "analytics")
+		t.Setenv("DB_PASSWORD", "secret")
+		t.Setenv("DB_NAME", "analytics")
+		t.Setenv("TLS_MIN_VERSION", env.version)
+		cfg, err := config.Load()
+		if err != nil {
+			t.Fatalf("config.Load: %v", err)
+		}
+
+		server := newServer(":0", http.NewServeMux(), cfg)
+		if server.TLSConfig.MinVersion != env.want {
+			t.Errorf("TLS_MIN_VERSION=%s: MinVersion = %#x, want %#x", env.version, server.TLSConfig.MinVersion, env.want)
+		}
+	}
+}
+
+func TestShutdownServerWaitsForInFlightRequests(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+	mux := http.NewServeMux()
+	mux.HandleFunc("/slow", func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+		io.WriteString(w, "done")
+	})
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &http.Server{Handler: mux}
+	serveErr := make(chan error, 1)
+	go func() { serveErr <- server.Serve(listener) }()
+
+	type result struct {
+		body string
+		err  error
+	}
+	responses := make(chan result, 1)
+	go func() {
+		response, err := http.Get("http://" + listener.Addr().String() + "/slow")
+		if err != nil {
+			responses <- result{err: err}
+			return
+		}
+		defer response.Body.Close()
+		body, err := io.ReadAll(response.Body)
+		responses <- result{string(body), err}
+	}()
+	<-started
+
+	// Shutdown stops accepting connections at once but returns only after
+	// the in-flight request was answered
+	stopped := make(chan struct{})
+	go func() {
+		shutdownServer(server, 5*time.Second)
+		close(stopped)
+	}()
+	if err := <-serveErr; err != http.ErrServerClosed {
+		t.Fatalf("Serve = %v, want ErrServerClosed", err)
+	}
+	select {
+	case <-stopped:
+		t.Fatal("shutdown returned before the in-flight request finished")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(release)
+	if got := <-responses; got.err != nil || got.body != "done" {
+		t.Errorf("in-flight request = %q, %v; want it completed", got.body, got.err)
+	}
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("shutdown did not return after the request finished")
+	}
+}
@@ -24,9 +24,18 @@ CREATE TABLE IF NOT EXISTS sessions (
     user_id VARCHAR(255) NOT NULL,
     platform VARCHAR(50) NOT NULL,
     resolution VARCHAR(50) NOT NULL,
-    device_model VARCHAR(100),
-    os_version VARCHAR(50),
-    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+    device_model VARCHAR(255) NULL,
+    os_version VARCHAR(50) NULL,
+    os_major INT NULL,
+    os_minor INT NULL,
+    os_patch INT NULL,
+    app_version VARCHAR(50) NULL,
+    tags JSON NULL,
+    ip_address VARCHAR(45) NULL,
+    user_agent TEXT NULL,
+    created_at TIMESTAMP(3) DEFAULT CURRENT_TIMESTAMP(3),
+    ended_at TIMESTAMP(3) NULL,
+    INDEX idx_sessions_user_id (user_id)
 ) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
 
 -- Create events table
@@ -41,20 +50,74 @@ CREATE TABLE IF NOT EXISTS events (
     start_x FLOAT,
     end_x FLOAT,
     max_rotation FLOAT,
-    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    swipe_distance FLOAT,
+    swipe_velocity FLOAT,
+    seq INT NULL,
+    client_event_id VARCHAR(255) NULL,
+    properties JSON NULL,
+    created_at TIMESTAMP(3) DEFAULT CURRENT_TIMESTAMP(3),
+    UNIQUE KEY uniq_events_session_seq (session_id, seq),
+    UNIQUE KEY uniq_events_client_event_id (client_event_id),
+    INDEX idx_events_type_session (event_type, session_id),
     FOREIGN KEY (session_id) REFERENCES sessions(session_id) ON DELETE CASCADE
 ) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
 
+-- Create backfill_progress table
+CREATE TABLE IF NOT EXISTS backfill_progress (
+    target_column VARCHAR(64) PRIMARY KEY,
+    last_id INT NOT NULL DEFAULT 0,
+    updated_at TIMESTAMP(3) DEFAULT CURRENT_TIMESTAMP(3) ON UPDATE CURRENT_TIMESTAMP(3)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
+
+-- Create dead_letters table
+CREATE TABLE IF NOT EXISTS dead_letters (
+    id INT AUTO_INCREMENT PRIMARY KEY,
+    kind VARCHAR(32) NOT NULL,
+    payload JSON NOT NULL,
+    last_error TEXT,
+    attempts INT NOT NULL DEFAULT 0,
+    status VARCHAR(16) NOT NULL DEFAULT 'pending',
+    next_attempt_at TIMESTAMP(3) DEFAULT CURRENT_TIMESTAMP(3),
+    created_at TIMESTAMP(3) DEFAULT CURRENT_TIMESTAMP(3),
+    INDEX idx_dead_letters_status (status, next_attempt_at)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
+
+-- Create opt_outs table
+CREATE TABLE IF NOT EXISTS opt_outs (
+    user_id VARCHAR(255) PRIMARY KEY,
+    created_at TIMESTAMP(3) DEFAULT CURRENT_TIMESTAMP(3)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
+
+-- Create api_keys table
+CREATE TABLE IF NOT EXISTS api_keys (
+    id INT AUTO_INCREMENT PRIMARY KEY,
+    key_hash CHAR(64) NOT NULL,
+    label VARCHAR(255) NOT NULL,
+    scope VARCHAR(20) NOT NULL,
+    created_at TIMESTAMP(3) DEFAULT CURRENT_TIMESTAMP(3),
+    revoked_at TIMESTAMP(3) NULL,
+    UNIQUE KEY uniq_api_keys_key_hash (key_hash)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
+
+-- Create property_keys table
+CREATE TABLE IF NOT EXISTS property_keys (
+    key_name VARCHAR(64) PRIMARY KEY,
+    created_at TIMESTAMP(3) DEFAULT CURRENT_TIMESTAMP(3)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
+
 -- Create performance_metrics table
 CREATE TABLE IF NOT EXISTS performance_metrics (
     id INT AUTO_INCREMENT PRIMARY KEY,
     session_id VARCHAR(255) NOT NULL,
-    timestamp TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    timestamp TIMESTAMP(3) DEFAULT CURRENT_TIMESTAMP(3),
     fps FLOAT,
     memory_usage BIGINT,
     cpu_usage FLOAT,
     gpu_usage FLOAT,
     network_latency INT,
+    battery_level FLOAT NULL,
+    thermal_state VARCHAR(20) NULL,
+    sample_rate FLOAT NULL,
     FOREIGN KEY (session_id) REFERENCES sessions(session_id) ON DELETE CASCADE
 ) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
 
@@ -68,6 +131,8 @@ CREATE TABLE IF NOT EXISTS category_stats (
     rejected_cards INT DEFAULT 0,
     average_decision_time FLOAT DEFAULT 0,
     completion_time INT DEFAULT 0,
-    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    created_at TIMESTAMP(3) DEFAULT CURRENT_TIMESTAMP(3),
+    UNIQUE KEY uniq_category_stats_session_category (session_id, category_name),
+    INDEX idx_category_stats_category_name (category_name),
     FOREIGN KEY (session_id) REFERENCES sessions(session_id) ON DELETE CASCADE
 ) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci; 
\ No newline at end of file
@@ -0,0 +1,93 @@
+package storage
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"sort"
+)
+
+// ErrAPIKeyRevoked is returned by LookupAPIKey for a key that exists but
+// has been revoked.
+var ErrAPIKeyRevoked = errors.New("api key has been revoked")
+
+// HashAPIKey returns the hex-encoded SHA-256 hash under which key is
+// stored. Keys themselves are never stored.
+func HashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// LookupAPIKey returns the scope of key. found is false for unknown keys;
+// revoked keys yield ErrAPIKeyRevoked.
+func (db *DB) LookupAPIKey(ctx context.Context, key string) (scope string, found bool, err error) {
+	var revokedAt sql.NullTime
+	err = db.QueryRowContext(ctx,
+		"SELECT scope, revoked_at FROM api_keys WHERE key_hash = ?", HashAPIKey(key),
+	).Scan(&scope, &revokedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, err
+	}
+	if revokedAt.Valid {
+		return "", true, ErrAPIKeyRevoked
+	}
+	return scope, true, nil
+}
+
+// BootstrapAPIKey stores key with the given label and scope, so the first
+// key can be provisioned from the environment on every startup. A key that
+// is already stored takes the given scope and keeps its label; a key that
+// was revoked stays revoked.
+func (db *DB) BootstrapAPIKey(ctx context.Context, key, label, scope string) error {
+	_, err := db.ExecContext(ctx, db.bootstrapAPIKeyQuery(), HashAPIKey(key), label, scope)
+	return err
+}
+
+// bootstrapAPIKeyQuery returns the upsert of BootstrapAPIKey.
+func (db *DB) bootstrapAPIKeyQuery() string {
+	return db.Rebind(`
+		INSERT INTO api_keys (key_hash, label, scope) VALUES (?, ?, ?)
+	` + db.Upsert([]string{"key_hash"}, "scope = "+db.Inserted("scope")))
+}
+
+// SyncAPIKeys stores keys, a map of key to scope, under label as
+// BootstrapAPIKey does, and revokes the keys stored under label that are
+// no longer among them, all in one transaction. It returns the number of
+// keys revoked.
+func (db *DB) SyncAPIKeys(ctx context.Context, label string, keys map[string]string) (int64, error) {
+	hashes := make([]string, 0, len(keys))
+	for key := range keys {
+		hashes = append(hashes, HashAPIKey(key))
+	}
+	sort.Strings(hashes)
+
+	var revoked int64
+	err := db.WithTx(ctx, func(tx *sql.Tx) error {
+		for key, scope := range keys {
+			if _, err := tx.ExecContext(ctx, db.bootstrapAPIKeyQuery(), HashAPIKey(key), label, scope); err != nil {
+				return err
+			}
+		}
+
+		query := "UPDATE api_keys SET revoked_at = CURRENT_TIMESTAMP(3) WHERE label = ? AND revoked_at IS NULL"
+		args := []interface{}{label}
+		if len(hashes) > 0 {
+			query += " AND key_hash NOT IN (" + placeholders(len(hashes)) + ")"
+			for _, hash := range hashes {
+				args = append(args, hash)
+			}
+		}
+		result, err := tx.ExecContext(ctx, db.Rebind(query), args...)
+		if err != nil {
+			return err
+		}
+		revoked, err = result.RowsAffected()
+		return err
+	})
+	return revoked, err
+}
@@ -0,0 +1,122 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestHashAPIKey(t *testing.T) {
+	// SHA-256 of "abc"
+	want := "ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad"
+	if got := HashAPIKey("abc"); got != want {
+		t.Errorf("HashAPIKey = %s, want %s", got, want)
+	}
+}
+
+func TestLookupAPIKey(t *testing.T) {
+	tests := []struct {
+		name      string
+		rows      *sqlmock.Rows
+		wantScope string
+		wantFound bool
+		wantErr   error
+	}{
+		{"valid", sqlmock.NewRows([]string{"scope", "revoked_at"}).AddRow("read", nil), "read", true, nil},
+		{"revoked", sqlmock.NewRows([]string{"scope", "revoked_at"}).AddRow("admin", time.Now()), "", true, ErrAPIKeyRevoked},
+		{"unknown", sqlmock.NewRows([]string{"scope", "revoked_at"}), "", false, nil},
+	}
+	for _, tt := range tests {
+		db, mock := newMockDB(t, DriverMySQL)
+		// Only the hash of the key is sent to the database
+		mock.ExpectQuery(`SELECT scope, revoked_at FROM api_keys WHERE key_hash = \?`).
+			WithArgs(HashAPIKey("secret")).
+			WillReturnRows(tt.rows)
+
+		scope, found, err := db.LookupAPIKey(context.Background(), "secret")
+		if scope != tt.wantScope || found != tt.wantFound || !errors.Is(err, tt.wantErr) {
+			t.Errorf("%s: LookupAPIKey = %q, %v, %v; want %q, %v, %v",
+				tt.name, scope, found, err, tt.wantScope, tt.wantFound, tt.wantErr)
+		}
+	}
+}
+
+func TestBootstrapAPIKey(t *testing.T) {
+	// An existing key takes the configured scope; its label and revocation
+	// are left as they are
+	tests := []struct {
+		driver string
+		query  string
+	}{
+		{DriverMySQL, `INSERT INTO api_keys \(key_hash, label, scope\) VALUES \(\?, \?, \?\)\s+ON DUPLICATE KEY UPDATE scope = VALUES\(scope\)$`},
+		{DriverPostgres, `INSERT INTO api_keys \(key_hash, label, scope\) VALUES \(\$1, \$2, \$3\)\s+ON CONFLICT \(key_hash\) DO UPDATE SET scope = EXCLUDED.scope$`},
+	}
+	for _, tt := range tests {
+		db, mock := newMockDB(t, tt.driver)
+		mock.ExpectExec(tt.query).
+			WithArgs(HashAPIKey("first-key"), "bootstrap", "admin").
+			WillReturnResult(sqlmock.NewResult(0, 1))
+
+		if err := db.BootstrapAPIKey(context.Background(), "first-key", "bootstrap", "admin"); err != nil {
+			t.Errorf("%s: BootstrapAPIKey: %v", tt.driver, err)
+		}
+	}
+}
+
+func TestSyncAPIKeysUpdatesScope(t *testing.T) {
+	db, mock := newMockDB(t, DriverMySQL)
+
+	// The key was stored with the read scope before and is now configured
+	// as admin; no other environment key is stored
+	mock.ExpectBegin()
+	mock.ExpectExec(`INSERT INTO api_keys .* ON DUPLICATE KEY UPDATE scope = VALUES\(scope\)`).
+		WithArgs(HashAPIKey("analyst-key"), "environment", "admin").
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectExec(`UPDATE api_keys SET revoked_at = CURRENT_TIMESTAMP\(3\) WHERE label = \? AND revoked_at IS NULL AND key_hash NOT IN \(\?\)`).
+		WithArgs("environment", HashAPIKey("analyst-key")).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectCommit()
+
+	revoked, err := db.SyncAPIKeys(context.Background(), "environment", map[string]string{"analyst-key": "admin"})
+	if err != nil || revoked != 0 {
+		t.Errorf("SyncAPIKeys = %d, %v; want nothing revoked", revoked, err)
+	}
+}
+
+func TestSyncAPIKeysRevokesRemovedKeys(t *testing.T) {
+	db, mock := newMockDB(t, DriverPostgres)
+
+	// old-key was removed from the environment and is revoked; keys with
+	// other labels are left alone
+	hashes := []string{HashAPIKey("kept-key"), HashAPIKey("other-key")}
+	if hashes[0] > hashes[1] {
+		hashes[0], hashes[1] = hashes[1], hashes[0]
+	}
+	mock.ExpectBegin()
+	mock.ExpectExec(`INSERT INTO api_keys`).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`INSERT INTO api_keys`).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`UPDATE api_keys SET revoked_at = CURRENT_TIMESTAMP\(3\) WHERE label = \$1 AND revoked_at IS NULL AND key_hash NOT IN \(\$2, \$3\)`).
+		WithArgs("environment", hashes[0], hashes[1]).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	keys := map[string]string{"kept-key": "read", "other-key": "delete"}
+	revoked, err := db.SyncAPIKeys(context.Background(), "environment", keys)
+	if err != nil || revoked != 1 {
+		t.Errorf("SyncAPIKeys = %d, %v; want 1 revoked", revoked, err)
+	}
+
+	// Without configured keys every environment key is revoked
+	db, mock = newMockDB(t, DriverMySQL)
+	mock.ExpectBegin()
+	mock.ExpectExec(`UPDATE api_keys SET revoked_at = CURRENT_TIMESTAMP\(3\) WHERE label = \? AND revoked_at IS NULL$`).
+		WithArgs("environment").
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectCommit()
+	if revoked, err := db.SyncAPIKeys(context.Background(), "environment", nil); err != nil || revoked != 2 {
+		t.Errorf("no keys: SyncAPIKeys = %d, %v; want 2 revoked", revoked, err)
+	}
+}
@@ -0,0 +1,143 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// archiveChildTables lists the tables whose rows are archived along with
+// their session.
+var archiveChildTables = []string{"events", "performance_metrics", "category_stats"}
+
+// ArchiveResult describes a finished archival run: the number of rows
+// moved per table and whether sessions old enough to archive remain.
+type ArchiveResult struct {
+	Before  time.Time        `json:"before"`
+	Batches int              `json:"batches"`
+	Moved   map[string]int64 `json:"moved"`
+	Done    bool             `json:"done"`
+}
+
+// archiveTable returns the name of the archive table of table.
+func archiveTable(table string) string {
+	return table + "_archive"
+}
+
+// ArchiveSessions moves sessions created before the given time, together
+// with their events, performance metrics, and category stats, into the
+// corresponding *_archive tables. Sessions are moved in batches of
+// batchSize, each in its own transaction, for at most maxBatches batches.
+// The archive tables are created with the layout of the hot tables when
+// missing; columns added to a hot table later must be added to its archive
+// table as well.
+func (db *DB) ArchiveSessions(ctx context.Context, before time.Time, batchSize, maxBatches int) (*ArchiveResult, error) {
+	if err := db.createArchiveTables(ctx); err != nil {
+		return nil, err
+	}
+
+	result := &ArchiveResult{Before: before, Moved: make(map[string]int64)}
+	for result.Batches < maxBatches {
+		moved, err := db.archiveBatch(ctx, before, batchSize)
+		if err != nil {
+			return nil, err
+		}
+		if moved["sessions"] == 0 {
+			result.Done = true
+			break
+		}
+		result.Batches++
+		for table, count := range moved {
+			result.Moved[table] += count
+		}
+		if moved["sessions"] < int64(batchSize) {
+			result.Done = true
+			break
+		}
+	}
+	return result, nil
+}
+
+// createArchiveTables creates the archive tables that don't exist yet.
+// They copy the columns and indexes of the hot tables but not their
+// foreign keys.
+func (db *DB) createArchiveTables(ctx context.Context) error {
+	statement := "CREATE TABLE IF NOT EXISTS %s LIKE %s"
+	if db.Driver == DriverPostgres {
+		statement = "CREATE TABLE IF NOT EXISTS %s (LIKE %s INCLUDING DEFAULTS INCLUDING INDEXES)"
+	}
+	for _, table := range append([]string{"sessions"}, archiveChildTables...) {
+		_, err := db.ExecContext(ctx, fmt.Sprintf(statement, archiveTable(table), table))
+		if err != nil {
+			return fmt.Errorf("error creating %s: %v", archiveTable(table), err)
+		}
+	}
+	return nil
+}
+
+// archiveBatch moves the oldest batchSize sessions created before the
+// given time, and their rows in the child tables, in one transaction. It
+// returns the number of rows moved per table.
+func (db *DB) archiveBatch(ctx context.Context, before time.Time, batchSize int) (map[string]int64, error) {
+	ctx, cancel := db.withQueryTimeout(ctx)
+	defer cancel()
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx, db.Rebind(`
+		SELECT session_id FROM sessions
+		WHERE created_at < ?
+		ORDER BY id
+		LIMIT ?
+		FOR UPDATE
+	`), before, batchSize)
+	if err != nil {
+		return nil, fmt.Errorf("error selecting sessions to archive: %v", err)
+	}
+	var args []interface{}
+	for rows.Next() {
+		var sessionID string
+		if err := rows.Scan(&sessionID); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("error scanning sessions to archive: %v", err)
+		}
+		args = append(args, sessionID)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error selecting sessions to archive: %v", err)
+	}
+
+	moved := make(map[string]int64)
+	if len(args) == 0 {
+		return moved, nil
+	}
+	in := placeholders(len(args))
+
+	// Children go first so that no foreign key points at a missing session
+	for _, table := range append(archiveChildTables, "sessions") {
+		result, err := tx.ExecContext(ctx, db.Rebind(fmt.Sprintf(
+			"INSERT INTO %s SELECT * FROM %s WHERE session_id IN (%s)",
+			archiveTable(table), table, in)), args...)
+		if err != nil {
+			return nil, fmt.Errorf("error copying %s to the archive: %v", table, err)
+		}
+		if moved[table], err = result.RowsAffected(); err != nil {
+			return nil, err
+		}
+		_, err = tx.ExecContext(ctx, db.Rebind(fmt.Sprintf(
+			"DELETE FROM %s WHERE session_id IN (%s)", table, in)), args...)
+		if err != nil {
+			return nil, fmt.Errorf("error deleting archived %s: %v", table, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return moved, nil
+}
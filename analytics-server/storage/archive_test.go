@@ -0,0 +1,98 @@
+package storage
+
+import (
+	"context"
+	"database/sql/driver"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+// expectArchiveBatch expects one archival batch moving sessionIDs, with
+// rowsPerTable rows copied from each child table.
+func expectArchiveBatch(mock sqlmock.Sqlmock, before time.Time, batchSize int, sessionIDs []string, rowsPerTable int64) {
+	mock.ExpectBegin()
+	rows := sqlmock.NewRows([]string{"session_id"})
+	args := make([]driver.Value, len(sessionIDs))
+	for i, id := range sessionIDs {
+		rows.AddRow(id)
+		args[i] = id
+	}
+	mock.ExpectQuery(`SELECT session_id FROM sessions\s+WHERE created_at < \?\s+ORDER BY id\s+LIMIT \?\s+FOR UPDATE`).
+		WithArgs(before, batchSize).
+		WillReturnRows(rows)
+	if len(sessionIDs) == 0 {
+		mock.ExpectRollback()
+		return
+	}
+	for _, table := range append(archiveChildTables, "sessions") {
+		copied := rowsPerTable
+		if table == "sessions" {
+			copied = int64(len(sessionIDs))
+		}
+		mock.ExpectExec(`INSERT INTO ` + table + `_archive SELECT \* FROM ` + table + ` WHERE session_id IN`).
+			WithArgs(args...).
+			WillReturnResult(sqlmock.NewResult(0, copied))
+		mock.ExpectExec(`DELETE FROM ` + table + ` WHERE session_id IN`).
+			WithArgs(args...).
+			WillReturnResult(sqlmock.NewResult(0, copied))
+	}
+	mock.ExpectCommit()
+}
+
+func TestArchiveSessions(t *testing.T) {
+	db, mock := newMockDB(t, DriverMySQL)
+	before := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	for _, table := range []string{"sessions", "events", "performance_metrics", "category_stats"} {
+		mock.ExpectExec(`CREATE TABLE IF NOT EXISTS ` + table + `_archive LIKE ` + table).
+			WillReturnResult(sqlmock.NewResult(0, 0))
+	}
+	// A full batch, then a partial one that finishes the run
+	expectArchiveBatch(mock, before, 2, []string{"s1", "s2"}, 5)
+	expectArchiveBatch(mock, before, 2, []string{"s3"}, 1)
+
+	result, err := db.ArchiveSessions(context.Background(), before, 2, 10)
+	if err != nil {
+		t.Fatalf("ArchiveSessions: %v", err)
+	}
+	if !result.Done || result.Batches != 2 {
+		t.Errorf("result = %+v, want done after 2 batches", result)
+	}
+	want := map[string]int64{"sessions": 3, "events": 6, "performance_metrics": 6, "category_stats": 6}
+	for table, count := range want {
+		if result.Moved[table] != count {
+			t.Errorf("moved %d %s, want %d", result.Moved[table], table, count)
+		}
+	}
+}
+
+func TestArchiveSessionsStopsAtMaxBatches(t *testing.T) {
+	db, mock := newMockDB(t, DriverPostgres)
+	before := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	for _, table := range []string{"sessions", "events", "performance_metrics", "category_stats"} {
+		mock.ExpectExec(`CREATE TABLE IF NOT EXISTS ` + table + `_archive \(LIKE ` + table + ` INCLUDING DEFAULTS INCLUDING INDEXES\)`).
+			WillReturnResult(sqlmock.NewResult(0, 0))
+	}
+	mock.ExpectBegin()
+	mock.ExpectQuery(`WHERE created_at < \$1\s+ORDER BY id\s+LIMIT \$2`).
+		WithArgs(before, 1).
+		WillReturnRows(sqlmock.NewRows([]string{"session_id"}).AddRow("s1"))
+	for _, table := range append(archiveChildTables, "sessions") {
+		mock.ExpectExec(`INSERT INTO ` + table + `_archive SELECT \* FROM ` + table + ` WHERE session_id IN \(\$1\)`).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec(`DELETE FROM ` + table + ` WHERE session_id IN \(\$1\)`).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+	}
+	mock.ExpectCommit()
+
+	result, err := db.ArchiveSessions(context.Background(), before, 1, 1)
+	if err != nil {
+		t.Fatalf("ArchiveSessions: %v", err)
+	}
+	if result.Done || result.Batches != 1 {
+		t.Errorf("result = %+v, want one batch and more to do", result)
+	}
+}
@@ -0,0 +1,47 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestCompact(t *testing.T) {
+	tests := []struct {
+		driver    string
+		sizeQuery string
+		statement string
+	}{
+		{DriverMySQL, `FROM information_schema.tables`, "OPTIMIZE TABLE sessions, events, performance_metrics, category_stats"},
+		{DriverPostgres, `FROM pg_class c`, "VACUUM FULL sessions, events, performance_metrics, category_stats"},
+	}
+	for _, tt := range tests {
+		db, mock := newMockDB(t, tt.driver)
+		mock.ExpectQuery(tt.sizeQuery).
+			WithArgs("sessions", "events", "performance_metrics", "category_stats").
+			WillReturnRows(sqlmock.NewRows([]string{"size"}).AddRow(4096))
+		mock.ExpectQuery(tt.statement).
+			WillReturnRows(sqlmock.NewRows([]string{"Table", "Op", "Msg_type", "Msg_text"}).
+				AddRow("analytics.sessions", "optimize", "status", "OK"))
+		mock.ExpectQuery(tt.sizeQuery).
+			WillReturnRows(sqlmock.NewRows([]string{"size"}).AddRow(1024))
+
+		result, err := db.Compact(context.Background())
+		if err != nil {
+			t.Fatalf("%s: Compact: %v", tt.driver, err)
+		}
+		if result.Statement != tt.statement || *result.SizeBefore != 4096 || *result.SizeAfter != 1024 {
+			t.Errorf("%s: result = %+v", tt.driver, result)
+		}
+	}
+}
+
+func TestCompactUnsupportedDriver(t *testing.T) {
+	db, _ := newMockDB(t, "sqlite3")
+
+	if _, err := db.Compact(context.Background()); !errors.Is(err, ErrCompactUnsupported) {
+		t.Errorf("Compact error = %v, want ErrCompactUnsupported", err)
+	}
+}
@@ -0,0 +1,40 @@
+package storage
+
+import (
+	"context"
+	"database/sql/driver"
+	"fmt"
+)
+
+// initConnector wraps a driver.Connector and runs a fixed list of
+// statements on every new connection, so that session-level settings such
+// as sql_mode or time_zone are the same on all pooled connections.
+type initConnector struct {
+	driver.Connector
+	statements []string
+}
+
+// Connect opens a connection through the wrapped connector and runs the
+// init statements on it. The connection is closed if any statement fails.
+func (c *initConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	conn, err := c.Connector.Connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if len(c.statements) == 0 {
+		return conn, nil
+	}
+
+	execer, ok := conn.(driver.ExecerContext)
+	if !ok {
+		conn.Close()
+		return nil, fmt.Errorf("driver connection does not support init statements")
+	}
+	for _, statement := range c.statements {
+		if _, err := execer.ExecContext(ctx, statement, nil); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("error running init statement %q: %v", statement, err)
+		}
+	}
+	return conn, nil
+}
@@ -0,0 +1,88 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// recordingConnector opens fakeConns that record the statements run on
+// them.
+type recordingConnector struct {
+	mu       sync.Mutex
+	executed [][]string
+	failOn   string
+}
+
+func (c *recordingConnector) Connect(context.Context) (driver.Conn, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.executed = append(c.executed, nil)
+	return &fakeConn{connector: c, index: len(c.executed) - 1}, nil
+}
+
+func (c *recordingConnector) Driver() driver.Driver { return nil }
+
+type fakeConn struct {
+	connector *recordingConnector
+	index     int
+	closed    bool
+}
+
+func (c *fakeConn) ExecContext(_ context.Context, query string, _ []driver.NamedValue) (driver.Result, error) {
+	c.connector.mu.Lock()
+	defer c.connector.mu.Unlock()
+	if query == c.connector.failOn {
+		return nil, errors.New("syntax error")
+	}
+	c.connector.executed[c.index] = append(c.connector.executed[c.index], query)
+	return driver.RowsAffected(0), nil
+}
+
+func (c *fakeConn) Prepare(string) (driver.Stmt, error) { return nil, errors.New("not supported") }
+func (c *fakeConn) Close() error                        { c.closed = true; return nil }
+func (c *fakeConn) Begin() (driver.Tx, error)           { return nil, errors.New("not supported") }
+
+func TestInitConnectorRunsStatementsOnNewConnections(t *testing.T) {
+	statements := []string{"SET SESSION sql_mode = 'STRICT_ALL_TABLES'", "SET time_zone = '+00:00'"}
+	connector := &recordingConnector{}
+	database := sql.OpenDB(&initConnector{Connector: connector, statements: statements})
+	defer database.Close()
+
+	// Holding the first connection forces the pool to open a second one
+	ctx := context.Background()
+	first, err := database.Conn(ctx)
+	if err != nil {
+		t.Fatalf("first connection: %v", err)
+	}
+	defer first.Close()
+	second, err := database.Conn(ctx)
+	if err != nil {
+		t.Fatalf("second connection: %v", err)
+	}
+	defer second.Close()
+
+	if len(connector.executed) != 2 {
+		t.Fatalf("opened %d connections, want 2", len(connector.executed))
+	}
+	for i, executed := range connector.executed {
+		if !reflect.DeepEqual(executed, statements) {
+			t.Errorf("connection %d ran %q, want %q", i, executed, statements)
+		}
+	}
+}
+
+func TestInitConnectorFailingStatement(t *testing.T) {
+	connector := &recordingConnector{failOn: "SET bogus = 1"}
+	wrapped := &initConnector{Connector: connector, statements: []string{"SET time_zone = '+00:00'", "SET bogus = 1"}}
+
+	conn, err := wrapped.Connect(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "SET bogus = 1") {
+		t.Fatalf("Connect = %v, %v; want an error naming the statement", conn, err)
+	}
+}
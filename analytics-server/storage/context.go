@@ -0,0 +1,118 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+)
+
+type requestIDKey struct{}
+
+type queryLabelKey struct{}
+
+// WithRequestID returns a copy of ctx carrying the ID of the HTTP request
+// on whose behalf queries are run.
+func WithRequestID(ctx context.Context, requestID string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, requestID)
+}
+
+// RequestIDFromContext returns the request ID stored in ctx, or an empty
+// string if there is none.
+func RequestIDFromContext(ctx context.Context) string {
+	requestID, _ := ctx.Value(requestIDKey{}).(string)
+	return requestID
+}
+
+// WithQueryLabel returns a copy of ctx labelling the queries run with it,
+// e.g. with the section of a report they compute.
+func WithQueryLabel(ctx context.Context, label string) context.Context {
+	return context.WithValue(ctx, queryLabelKey{}, label)
+}
+
+// QueryLabelFromContext returns the query label stored in ctx, or an empty
+// string if there is none.
+func QueryLabelFromContext(ctx context.Context) string {
+	label, _ := ctx.Value(queryLabelKey{}).(string)
+	return label
+}
+
+// ExecContext runs a statement like sql.DB.ExecContext, with placeholders
+// rebound for the driver and the call bounded by QueryTimeout, annotating
+// failures with the request ID from ctx.
+func (db *DB) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	callCtx, cancel := db.withQueryTimeout(ctx)
+	defer cancel()
+	result, err := db.DB.ExecContext(callCtx, db.Rebind(query), args...)
+	if err != nil {
+		return nil, queryError(ctx, timeoutError(callCtx, err))
+	}
+	return result, nil
+}
+
+// QueryContext runs a query like sql.DB.QueryContext, with placeholders
+// rebound for the driver, annotating failures with the request ID from ctx.
+// QueryTimeout bounds the query together with the reading of its rows.
+func (db *DB) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	callCtx, cancel := db.withQueryTimeout(ctx)
+	// The rows are read after returning, so the timeout can't be released
+	// here; release it at the latest when ctx ends
+	context.AfterFunc(ctx, cancel)
+	rows, err := db.DB.QueryContext(callCtx, db.Rebind(query), args...)
+	if err != nil {
+		cancel()
+		return nil, queryError(ctx, timeoutError(callCtx, err))
+	}
+	return rows, nil
+}
+
+// Row is the result of QueryRowContext. Its Scan annotates failures with
+// the request ID of the query's context.
+type Row struct {
+	ctx     context.Context
+	callCtx context.Context
+	cancel  context.CancelFunc
+	row     *sql.Row
+}
+
+// QueryRowContext runs a query like sql.DB.QueryRowContext, with
+// placeholders rebound for the driver and the call bounded by
+// QueryTimeout. Errors are deferred until Scan, as with sql.Row.
+func (db *DB) QueryRowContext(ctx context.Context, query string, args ...interface{}) *Row {
+	callCtx, cancel := db.withQueryTimeout(ctx)
+	return &Row{ctx: ctx, callCtx: callCtx, cancel: cancel, row: db.DB.QueryRowContext(callCtx, db.Rebind(query), args...)}
+}
+
+// Scan copies the columns of the row into dest. sql.ErrNoRows is returned
+// unchanged so callers can keep comparing against it.
+func (r *Row) Scan(dest ...interface{}) error {
+	defer r.cancel()
+	err := r.row.Scan(dest...)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return queryError(r.ctx, timeoutError(r.callCtx, err))
+	}
+	return err
+}
+
+// queryError logs a failed query together with the request ID and query
+// label from ctx and wraps err with them, so the failure can be traced back
+// to the request and the query.
+func queryError(ctx context.Context, err error) error {
+	queryErrorsTotal.Inc()
+	requestID := RequestIDFromContext(ctx)
+	label := QueryLabelFromContext(ctx)
+	switch {
+	case requestID == "" && label == "":
+		log.Printf("Query failed: %v", err)
+		return err
+	case label == "":
+		log.Printf("Query failed [request_id=%s]: %v", requestID, err)
+		return fmt.Errorf("request %s: %w", requestID, err)
+	case requestID == "":
+		log.Printf("Query failed [label=%s]: %v", label, err)
+		return fmt.Errorf("%s: %w", label, err)
+	}
+	log.Printf("Query failed [request_id=%s label=%s]: %v", requestID, label, err)
+	return fmt.Errorf("request %s, %s: %w", requestID, label, err)
+}
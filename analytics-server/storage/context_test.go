@@ -0,0 +1,56 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log"
+	"os"
+	"strings"
+	"testing"
+)
+
+// captureLog redirects the standard logger to a buffer for the rest of
+// the test.
+func captureLog(t *testing.T) *bytes.Buffer {
+	t.Helper()
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+	return &buf
+}
+
+func TestQueryErrorCarriesRequestID(t *testing.T) {
+	db, mock := newMockDB(t, DriverMySQL)
+	logs := captureLog(t)
+	cause := errors.New("connection reset")
+	mock.ExpectExec(`DELETE FROM sessions`).WillReturnError(cause)
+
+	ctx := WithRequestID(context.Background(), "req-42")
+	_, err := db.ExecContext(ctx, "DELETE FROM sessions WHERE session_id = ?", "s1")
+	if !errors.Is(err, cause) {
+		t.Fatalf("error = %v, want it to wrap %v", err, cause)
+	}
+	if !strings.Contains(err.Error(), "request req-42") {
+		t.Errorf("error %q lacks the request ID", err)
+	}
+	if !strings.Contains(logs.String(), "request_id=req-42") {
+		t.Errorf("log %q lacks the request ID", logs)
+	}
+}
+
+func TestQueryErrorCarriesLabel(t *testing.T) {
+	db, mock := newMockDB(t, DriverMySQL)
+	logs := captureLog(t)
+	mock.ExpectQuery(`SELECT COUNT`).WillReturnError(errors.New("table is locked"))
+
+	ctx := WithQueryLabel(WithRequestID(context.Background(), "req-7"), "statistics")
+	var count int
+	err := db.QueryRowContext(ctx, "SELECT COUNT(*) FROM events").Scan(&count)
+	if err == nil || !strings.Contains(err.Error(), "request req-7, statistics") {
+		t.Errorf("error = %v, want the request ID and label", err)
+	}
+	if !strings.Contains(logs.String(), "[request_id=req-7 label=statistics]") {
+		t.Errorf("log %q lacks the request ID and label", logs)
+	}
+}
@@ -0,0 +1,49 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// countedTables maps each table reported by TableCounts to the column
+// holding its row time.
+var countedTables = []struct{ table, timeColumn string }{
+	{"sessions", "created_at"},
+	{"events", "created_at"},
+	{"performance_metrics", "timestamp"},
+	{"category_stats", "created_at"},
+}
+
+// TableCount is the number of rows of a table and the time of its oldest
+// and newest row, which are nil for an empty table.
+type TableCount struct {
+	Rows   int64      `json:"rows"`
+	Oldest *time.Time `json:"oldest"`
+	Newest *time.Time `json:"newest"`
+}
+
+// TableCounts counts the rows of the analytics tables and reports their
+// time span.
+func (db *DB) TableCounts(ctx context.Context) (map[string]TableCount, error) {
+	counts := make(map[string]TableCount, len(countedTables))
+	for _, t := range countedTables {
+		var count TableCount
+		var oldest, newest sql.NullTime
+		err := db.QueryRowContext(ctx, fmt.Sprintf(
+			"SELECT COUNT(*), MIN(%s), MAX(%s) FROM %s", t.timeColumn, t.timeColumn, t.table,
+		)).Scan(&count.Rows, &oldest, &newest)
+		if err != nil {
+			return nil, fmt.Errorf("failed to count %s: %v", t.table, err)
+		}
+		if oldest.Valid {
+			count.Oldest = &oldest.Time
+		}
+		if newest.Valid {
+			count.Newest = &newest.Time
+		}
+		counts[t.table] = count
+	}
+	return counts, nil
+}
@@ -0,0 +1,48 @@
+package storage
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestTableCounts(t *testing.T) {
+	db, mock := newMockDB(t, DriverMySQL)
+	oldest := time.Date(2024, 5, 1, 9, 0, 0, 0, time.UTC)
+	newest := time.Date(2024, 5, 7, 18, 30, 0, 0, time.UTC)
+
+	// Each table is counted by its own time column; the empty table has
+	// no time span
+	counted := map[string]int64{"sessions": 3, "events": 40, "performance_metrics": 12}
+	for _, table := range []struct{ name, timeColumn string }{
+		{"sessions", "created_at"},
+		{"events", "created_at"},
+		{"performance_metrics", "timestamp"},
+		{"category_stats", "created_at"},
+	} {
+		rows := sqlmock.NewRows([]string{"count", "oldest", "newest"})
+		if count := counted[table.name]; count > 0 {
+			rows.AddRow(count, oldest, newest)
+		} else {
+			rows.AddRow(0, nil, nil)
+		}
+		mock.ExpectQuery(`SELECT COUNT\(\*\), MIN\(` + table.timeColumn + `\), MAX\(` + table.timeColumn + `\) FROM ` + table.name).
+			WillReturnRows(rows)
+	}
+
+	counts, err := db.TableCounts(context.Background())
+	if err != nil {
+		t.Fatalf("TableCounts: %v", err)
+	}
+	for table, rows := range counted {
+		count := counts[table]
+		if count.Rows != rows || count.Oldest == nil || !count.Oldest.Equal(oldest) || count.Newest == nil || !count.Newest.Equal(newest) {
+			t.Errorf("%s = %+v, want %d rows from %v to %v", table, count, rows, oldest, newest)
+		}
+	}
+	if empty := counts["category_stats"]; empty.Rows != 0 || empty.Oldest != nil || empty.Newest != nil {
+		t.Errorf("category_stats = %+v, want no rows and no time span", empty)
+	}
+}
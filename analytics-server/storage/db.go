@@ -1,49 +1,145 @@
 package storage
 
 import (
+	"context"
 	"cyber-swipe-analytics/config"
 	"database/sql"
+	"database/sql/driver"
 	"fmt"
+	"log"
+	"strings"
+	"time"
 
-	_ "github.com/go-sql-driver/mysql"
+	"github.com/go-sql-driver/mysql"
+	"github.com/lib/pq"
 )
 
 // DB wraps the sql.DB type to provide database operations
 // for the analytics server.
 type DB struct {
 	*sql.DB
+	// Driver is the name of the database/sql driver backing the connection.
+	Driver string
+	// QueryTimeout bounds each call of the context methods; 0 disables it.
+	QueryTimeout time.Duration
 }
 
 // InitDB initializes a new database connection using the provided configuration.
 // It establishes the connection, verifies it's working, and creates necessary tables.
 // Returns a DB instance or an error if initialization fails.
 func InitDB(cfg *config.Config) (*DB, error) {
-	// Format the connection string for MySQL
-	connectionString := fmt.Sprintf("%s:%s@tcp(%s:%s)/%s?parseTime=true",
-		cfg.DBUser, cfg.DBPassword, cfg.DBHost, cfg.DBPort, cfg.DBName)
-
-	// Open a new database connection
-	database, err := sql.Open("mysql", connectionString)
+	connector, err := newConnector(cfg)
 	if err != nil {
-		return nil, fmt.Errorf("error opening database: %v", err)
+		return nil, err
 	}
 
-	// Verify the connection is working
-	if err := database.Ping(); err != nil {
+	// Open a new database connection whose pooled connections all run the
+	// configured init statements
+	database := sql.OpenDB(&initConnector{Connector: connector, statements: cfg.DBInitStatements})
+
+	// Verify the connection is working, waiting for a database that is
+	// still starting; this also runs the init statements once, so an
+	// invalid statement fails startup
+	if err := pingWithRetry(database, cfg.DBConnectRetries, cfg.DBConnectBackoff, cfg.DBConnectTimeout); err != nil {
 		return nil, fmt.Errorf("error connecting to database: %v", err)
 	}
 
+	db := &DB{DB: database, Driver: cfg.DBDriver}
+
 	// Create required tables if they don't exist
-	if err := createTables(database); err != nil {
+	if cfg.DBDriver == DriverPostgres {
+		err = createPostgresTables(db)
+	} else {
+		err = createTables(db)
+	}
+	if err != nil {
 		return nil, fmt.Errorf("error creating tables: %v", err)
 	}
 
-	return &DB{database}, nil
+	// Detect manual schema changes that would break the handlers
+	drift, err := checkSchema(db)
+	if err != nil {
+		return nil, fmt.Errorf("error checking schema: %v", err)
+	}
+	for _, problem := range drift {
+		log.Printf("Schema drift: %s", problem)
+	}
+	if len(drift) > 0 && cfg.SchemaDriftAction == "abort" {
+		return nil, fmt.Errorf("schema drift detected: %s", strings.Join(drift, "; "))
+	}
+
+	db.QueryTimeout = cfg.DBQueryTimeout
+	return db, nil
+}
+
+// pingWithRetry pings database, retrying up to retries times with a
+// doubling backoff, so the server can start before the database is ready.
+// It gives up early when timeout has passed.
+func pingWithRetry(database *sql.DB, retries int, backoff, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	for attempt := 1; ; attempt++ {
+		err := database.PingContext(ctx)
+		if err == nil {
+			return nil
+		}
+		if attempt > retries || ctx.Err() != nil {
+			return err
+		}
+		log.Printf("Database not reachable (attempt %d of %d), retrying in %s: %v", attempt, retries+1, backoff, err)
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return err
+		}
+		backoff *= 2
+	}
+}
+
+// newConnector returns a connector for the configured DB_DRIVER.
+func newConnector(cfg *config.Config) (driver.Connector, error) {
+	switch cfg.DBDriver {
+	case DriverPostgres:
+		connectionString := fmt.Sprintf("host=%s port=%s user=%s password=%s dbname=%s sslmode=%s",
+			quoteDSNValue(cfg.DBHost), quoteDSNValue(cfg.DBPort), quoteDSNValue(cfg.DBUser),
+			quoteDSNValue(cfg.DBPassword), quoteDSNValue(cfg.DBName), quoteDSNValue(cfg.DBSSLMode))
+		connector, err := pq.NewConnector(connectionString)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing database configuration: %v", err)
+		}
+		return connector, nil
+	default:
+		// Format the connection string for MySQL
+		connectionString := fmt.Sprintf("%s:%s@tcp(%s:%s)/%s?parseTime=true",
+			cfg.DBUser, cfg.DBPassword, cfg.DBHost, cfg.DBPort, cfg.DBName)
+
+		mysqlConfig, err := mysql.ParseDSN(connectionString)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing database configuration: %v", err)
+		}
+		connector, err := mysql.NewConnector(mysqlConfig)
+		if err != nil {
+			return nil, fmt.Errorf("error opening database: %v", err)
+		}
+		return connector, nil
+	}
+}
+
+// quoteDSNValue quotes a value of a PostgreSQL key/value connection string.
+func quoteDSNValue(value string) string {
+	value = strings.ReplaceAll(value, `\`, `\\`)
+	value = strings.ReplaceAll(value, `'`, `\'`)
+	return "'" + value + "'"
 }
 
 // createTables creates the necessary database tables for the analytics system.
-// It creates tables for sessions and events if they don't already exist.
-func createTables(database *sql.DB) error {
+// It creates tables for sessions, events, performance metrics, category
+// stats, backfill progress, dead letters, opt-outs, API keys, and
+// property keys if they don't already exist.
+// Timestamp columns use millisecond precision so that events recorded within
+// the same second keep their relative order.
+func createTables(database *DB) error {
 	// Create the sessions table to store user session information
 	_, err := database.Exec(`
 		CREATE TABLE IF NOT EXISTS sessions (
@@ -52,7 +148,17 @@ func createTables(database *sql.DB) error {
 			user_id VARCHAR(255) NOT NULL,
 			platform VARCHAR(50) NOT NULL,
 			resolution VARCHAR(50) NOT NULL,
-			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+			device_model VARCHAR(255) NULL,
+			os_version VARCHAR(50) NULL,
+			os_major INT NULL,
+			os_minor INT NULL,
+			os_patch INT NULL,
+			app_version VARCHAR(50) NULL,
+			tags JSON NULL,
+			ip_address VARCHAR(45) NULL,
+			user_agent TEXT NULL,
+			created_at TIMESTAMP(3) DEFAULT CURRENT_TIMESTAMP(3),
+			ended_at TIMESTAMP(3) NULL
 		) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci
 	`)
 	if err != nil {
@@ -76,7 +182,35 @@ func createTables(database *sql.DB) error {
 			max_rotation FLOAT,
 			fps FLOAT,
 			memory_usage BIGINT,
-			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			swipe_distance FLOAT,
+			swipe_velocity FLOAT,
+			seq INT NULL,
+			client_event_id VARCHAR(255) NULL,
+			properties JSON NULL,
+			created_at TIMESTAMP(3) DEFAULT CURRENT_TIMESTAMP(3),
+			UNIQUE KEY uniq_events_session_seq (session_id, seq),
+			UNIQUE KEY uniq_events_client_event_id (client_event_id),
+			FOREIGN KEY (session_id) REFERENCES sessions(session_id) ON DELETE CASCADE
+		) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci
+	`)
+	if err != nil {
+		return err
+	}
+
+	// Create the performance_metrics table to store client performance samples
+	_, err = database.Exec(`
+		CREATE TABLE IF NOT EXISTS performance_metrics (
+			id INT AUTO_INCREMENT PRIMARY KEY,
+			session_id VARCHAR(255) NOT NULL,
+			timestamp TIMESTAMP(3) DEFAULT CURRENT_TIMESTAMP(3),
+			fps FLOAT,
+			memory_usage BIGINT,
+			cpu_usage FLOAT,
+			gpu_usage FLOAT,
+			network_latency INT,
+			battery_level FLOAT NULL,
+			thermal_state VARCHAR(20) NULL,
+			sample_rate FLOAT NULL,
 			FOREIGN KEY (session_id) REFERENCES sessions(session_id) ON DELETE CASCADE
 		) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci
 	`)
@@ -84,5 +218,386 @@ func createTables(database *sql.DB) error {
 		return err
 	}
 
+	// Create the category_stats table to store per-session category totals.
+	// The unique key lets repeated reports for a category accumulate via
+	// ON DUPLICATE KEY UPDATE.
+	_, err = database.Exec(`
+		CREATE TABLE IF NOT EXISTS category_stats (
+			id INT AUTO_INCREMENT PRIMARY KEY,
+			session_id VARCHAR(255) NOT NULL,
+			category_name VARCHAR(100) NOT NULL,
+			total_cards INT DEFAULT 0,
+			accepted_cards INT DEFAULT 0,
+			rejected_cards INT DEFAULT 0,
+			average_decision_time FLOAT DEFAULT 0,
+			completion_time INT DEFAULT 0,
+			created_at TIMESTAMP(3) DEFAULT CURRENT_TIMESTAMP(3),
+			UNIQUE KEY uniq_category_stats_session_category (session_id, category_name),
+			FOREIGN KEY (session_id) REFERENCES sessions(session_id) ON DELETE CASCADE
+		) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci
+	`)
+	if err != nil {
+		return err
+	}
+
+	// Create the backfill_progress table to track resumable backfills
+	// of derived columns
+	_, err = database.Exec(`
+		CREATE TABLE IF NOT EXISTS backfill_progress (
+			target_column VARCHAR(64) PRIMARY KEY,
+			last_id INT NOT NULL DEFAULT 0,
+			updated_at TIMESTAMP(3) DEFAULT CURRENT_TIMESTAMP(3) ON UPDATE CURRENT_TIMESTAMP(3)
+		) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci
+	`)
+	if err != nil {
+		return err
+	}
+
+	// Create the dead_letters table to hold ingestion requests that failed
+	// for transient reasons until the retry worker replays them
+	_, err = database.Exec(`
+		CREATE TABLE IF NOT EXISTS dead_letters (
+			id INT AUTO_INCREMENT PRIMARY KEY,
+			kind VARCHAR(32) NOT NULL,
+			payload JSON NOT NULL,
+			last_error TEXT,
+			attempts INT NOT NULL DEFAULT 0,
+			status VARCHAR(16) NOT NULL DEFAULT 'pending',
+			next_attempt_at TIMESTAMP(3) DEFAULT CURRENT_TIMESTAMP(3),
+			created_at TIMESTAMP(3) DEFAULT CURRENT_TIMESTAMP(3),
+			INDEX idx_dead_letters_status (status, next_attempt_at)
+		) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci
+	`)
+	if err != nil {
+		return err
+	}
+
+	// Create the opt_outs table listing users who asked to stop data collection
+	_, err = database.Exec(`
+		CREATE TABLE IF NOT EXISTS opt_outs (
+			user_id VARCHAR(255) PRIMARY KEY,
+			created_at TIMESTAMP(3) DEFAULT CURRENT_TIMESTAMP(3)
+		) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci
+	`)
+	if err != nil {
+		return err
+	}
+
+	// Create the api_keys table holding the hashed per-person admin keys
+	_, err = database.Exec(`
+		CREATE TABLE IF NOT EXISTS api_keys (
+			id INT AUTO_INCREMENT PRIMARY KEY,
+			key_hash CHAR(64) NOT NULL,
+			label VARCHAR(255) NOT NULL,
+			scope VARCHAR(20) NOT NULL,
+			created_at TIMESTAMP(3) DEFAULT CURRENT_TIMESTAMP(3),
+			revoked_at TIMESTAMP(3) NULL,
+			UNIQUE KEY uniq_api_keys_key_hash (key_hash)
+		) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci
+	`)
+	if err != nil {
+		return err
+	}
+
+	// Create the property_keys table registering the distinct custom
+	// event property keys
+	_, err = database.Exec(`
+		CREATE TABLE IF NOT EXISTS property_keys (
+			key_name VARCHAR(64) PRIMARY KEY,
+			created_at TIMESTAMP(3) DEFAULT CURRENT_TIMESTAMP(3)
+		) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci
+	`)
+	if err != nil {
+		return err
+	}
+
+	return migrateTables(database)
+}
+
+// migrateTables adds the columns and secondary indexes to tables created
+// before they were introduced.
+func migrateTables(database *DB) error {
+	for _, column := range addedColumns {
+		if err := ensureColumn(database, column); err != nil {
+			return fmt.Errorf("error adding column %s.%s: %v", column.table, column.name, err)
+		}
+	}
+	for _, index := range secondaryIndexes {
+		if err := ensureIndex(database, index); err != nil {
+			return fmt.Errorf("error creating index %s: %v", index.name, err)
+		}
+	}
+	// PostgreSQL tables were created with millisecond precision and the
+	// wider columns from the start
+	if database.Driver == DriverMySQL {
+		for _, column := range millisecondColumns {
+			if err := ensureMillisecondPrecision(database, column); err != nil {
+				return fmt.Errorf("error widening column %s.%s: %v", column.table, column.name, err)
+			}
+		}
+		for _, column := range widenedColumns {
+			if err := ensureLength(database, column); err != nil {
+				return fmt.Errorf("error widening column %s.%s: %v", column.table, column.name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// widenedColumn is a VARCHAR column declared narrower by
+// setup_database.sql than the requests accept.
+type widenedColumn struct {
+	addedColumn
+	length int64
+}
+
+// widenedColumns lists the columns to widen to the maximum length of their
+// request fields, so values passing validation are not truncated or
+// refused on insert.
+var widenedColumns = []widenedColumn{
+	{addedColumn{"sessions", "device_model", "VARCHAR(255) NULL", ""}, 255},
+}
+
+// ensureLength redefines column with its definition in its table, and in
+// the table's archive table once created, where it holds fewer than
+// column.length characters.
+func ensureLength(database *DB, column widenedColumn) error {
+	for _, table := range []string{column.table, archiveTable(column.table)} {
+		var length sql.NullInt64
+		err := database.QueryRow(`
+			SELECT character_maximum_length FROM information_schema.columns
+			WHERE table_schema = DATABASE() AND table_name = ? AND column_name = ?
+		`, table, column.name).Scan(&length)
+		if err == sql.ErrNoRows {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if length.Int64 >= column.length {
+			continue
+		}
+		if _, err := database.Exec("ALTER TABLE " + table + " MODIFY " + column.name + " " + column.definition); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// millisecondColumns lists the timestamp columns that tables created
+// before millisecond precision was introduced store in whole seconds.
+var millisecondColumns = []addedColumn{
+	{"sessions", "created_at", "TIMESTAMP(3) DEFAULT CURRENT_TIMESTAMP(3)", ""},
+	{"events", "created_at", "TIMESTAMP(3) DEFAULT CURRENT_TIMESTAMP(3)", ""},
+	{"performance_metrics", "timestamp", "TIMESTAMP(3) DEFAULT CURRENT_TIMESTAMP(3)", ""},
+	{"category_stats", "created_at", "TIMESTAMP(3) DEFAULT CURRENT_TIMESTAMP(3)", ""},
+}
+
+// ensureMillisecondPrecision redefines column with its definition in its
+// table, and in the table's archive table once created, where it stores
+// fewer than three fractional digits. Existing values keep their whole
+// seconds.
+func ensureMillisecondPrecision(database *DB, column addedColumn) error {
+	for _, table := range []string{column.table, archiveTable(column.table)} {
+		var precision sql.NullInt64
+		err := database.QueryRow(`
+			SELECT datetime_precision FROM information_schema.columns
+			WHERE table_schema = DATABASE() AND table_name = ? AND column_name = ?
+		`, table, column.name).Scan(&precision)
+		if err == sql.ErrNoRows {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if precision.Int64 >= 3 {
+			continue
+		}
+		if _, err := database.Exec("ALTER TABLE " + table + " MODIFY " + column.name + " " + column.definition); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// addedColumn is a column added to a table after its first release.
+// postgresDefinition replaces definition on PostgreSQL when the types
+// differ.
+type addedColumn struct {
+	table              string
+	name               string
+	definition         string
+	postgresDefinition string
+}
+
+// addedColumns lists the columns that existing tables may lack, including
+// the columns missing from tables created by setup_database.sql. Columns
+// are added in this order, so new entries go at the end.
+var addedColumns = []addedColumn{
+	{"sessions", "ip_address", "VARCHAR(45) NULL", ""},
+	{"sessions", "user_agent", "TEXT NULL", ""},
+	{"events", "client_event_id", "VARCHAR(255) NULL", ""},
+	{"events", "swipe_distance", "FLOAT", ""},
+	{"events", "swipe_velocity", "FLOAT", ""},
+	{"events", "start_y", "FLOAT", ""},
+	{"events", "end_y", "FLOAT", ""},
+	{"events", "fps", "FLOAT", ""},
+	{"events", "memory_usage", "BIGINT", ""},
+	{"sessions", "tags", "JSON NULL", "JSONB NULL"},
+	{"sessions", "os_major", "INT NULL", ""},
+	{"sessions", "os_minor", "INT NULL", ""},
+	{"sessions", "os_patch", "INT NULL", ""},
+	{"sessions", "app_version", "VARCHAR(50) NULL", ""},
+	{"events", "seq", "INT NULL", ""},
+	{"performance_metrics", "battery_level", "FLOAT NULL", ""},
+	{"performance_metrics", "thermal_state", "VARCHAR(20) NULL", ""},
+	{"sessions", "device_model", "VARCHAR(255) NULL", ""},
+	{"sessions", "os_version", "VARCHAR(50) NULL", ""},
+	{"sessions", "ended_at", "TIMESTAMP(3) NULL", ""},
+	{"performance_metrics", "sample_rate", "FLOAT NULL", ""},
+	{"events", "properties", "JSON NULL", "JSONB NULL"},
+}
+
+// ensureColumn adds column to its table, and to the table's archive table
+// once created, where it is missing. Both get the column in the same
+// position, so archival can keep copying rows with SELECT *.
+func ensureColumn(database *DB, column addedColumn) error {
+	definition := column.definition
+	if database.Driver == DriverPostgres && column.postgresDefinition != "" {
+		definition = column.postgresDefinition
+	}
+	for _, table := range []string{column.table, archiveTable(column.table)} {
+		var tableExists, columnExists bool
+		err := database.QueryRow(database.Rebind(`
+			SELECT
+				EXISTS(
+					SELECT 1 FROM information_schema.tables
+					WHERE table_schema = `+database.CurrentSchema()+` AND table_name = ?
+				),
+				EXISTS(
+					SELECT 1 FROM information_schema.columns
+					WHERE table_schema = `+database.CurrentSchema()+` AND table_name = ? AND column_name = ?
+				)
+		`), table, table, column.name).Scan(&tableExists, &columnExists)
+		if err != nil {
+			return err
+		}
+		if !tableExists || columnExists {
+			continue
+		}
+		if _, err := database.Exec("ALTER TABLE " + table + " ADD COLUMN " + column.name + " " + definition); err != nil {
+			return err
+		}
+	}
 	return nil
 }
+
+// secondaryIndex is an index on the columns that reports filter and join
+// on, or a unique key added after the table's first release.
+type secondaryIndex struct {
+	name    string
+	table   string
+	columns string
+	unique  bool
+}
+
+// secondaryIndexes lists the secondary indexes of the analytics tables.
+// Lookups of events by session_id alone use the uniq_events_session_seq
+// key, whose first column it is.
+var secondaryIndexes = []secondaryIndex{
+	{"idx_sessions_user_id", "sessions", "user_id", false},
+	{"idx_events_type_session", "events", "event_type, session_id", false},
+	{"idx_category_stats_category_name", "category_stats", "category_name", false},
+	{"uniq_events_client_event_id", "events", "client_event_id", true},
+	{"uniq_events_session_seq", "events", "session_id, seq", true},
+	{"uniq_category_stats_session_category", "category_stats", "session_id, category_name", true},
+}
+
+// duplicateMerges holds, per unique key added after its table's first
+// release, the function folding the rows the key would reject into one, so
+// the key can be created on a populated table. PostgreSQL tables were
+// created with these keys from the start.
+var duplicateMerges = map[string]func(*DB) error{
+	"uniq_category_stats_session_category": mergeDuplicateCategoryStats,
+}
+
+// mergeDuplicateCategoryStats folds the category_stats rows of the same
+// session and category into the oldest one, as the category upsert would
+// have: the card counts and completion times are summed and the decision
+// times averaged weighted by their card counts. Tables created by
+// setup_database.sql before the unique key got a new row for every report.
+func mergeDuplicateCategoryStats(database *DB) error {
+	return database.WithTx(context.Background(), func(tx *sql.Tx) error {
+		_, err := tx.Exec(`
+			UPDATE category_stats c
+			JOIN (
+				SELECT
+					MIN(id) AS id,
+					SUM(total_cards) AS total_cards,
+					SUM(accepted_cards) AS accepted_cards,
+					SUM(rejected_cards) AS rejected_cards,
+					COALESCE(
+						SUM(average_decision_time * total_cards) / NULLIF(SUM(total_cards), 0),
+						AVG(average_decision_time)
+					) AS average_decision_time,
+					SUM(completion_time) AS completion_time
+				FROM category_stats
+				GROUP BY session_id, category_name
+				HAVING COUNT(*) > 1
+			) merged ON merged.id = c.id
+			SET
+				c.total_cards = merged.total_cards,
+				c.accepted_cards = merged.accepted_cards,
+				c.rejected_cards = merged.rejected_cards,
+				c.average_decision_time = merged.average_decision_time,
+				c.completion_time = merged.completion_time
+		`)
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(`
+			DELETE c FROM category_stats c
+			JOIN (
+				SELECT session_id, category_name, MIN(id) AS id
+				FROM category_stats
+				GROUP BY session_id, category_name
+				HAVING COUNT(*) > 1
+			) kept ON kept.session_id = c.session_id
+				AND kept.category_name = c.category_name
+				AND kept.id <> c.id
+		`)
+		return err
+	})
+}
+
+// ensureIndex creates index unless it exists. MySQL has no CREATE INDEX IF
+// NOT EXISTS, so information_schema is checked first there, and the rows a
+// new unique key would reject are merged by its duplicateMerges entry.
+func ensureIndex(database *DB, index secondaryIndex) error {
+	kind := "INDEX"
+	if index.unique {
+		kind = "UNIQUE INDEX"
+	}
+	if database.Driver == DriverPostgres {
+		_, err := database.Exec("CREATE " + kind + " IF NOT EXISTS " + index.name + " ON " + index.table + " (" + index.columns + ")")
+		return err
+	}
+
+	var exists bool
+	err := database.QueryRow(`
+		SELECT EXISTS(
+			SELECT 1 FROM information_schema.statistics
+			WHERE table_schema = DATABASE() AND table_name = ? AND index_name = ?
+		)
+	`, index.table, index.name).Scan(&exists)
+	if err != nil || exists {
+		return err
+	}
+	if merge := duplicateMerges[index.name]; merge != nil {
+		if err := merge(database); err != nil {
+			return fmt.Errorf("error merging duplicate rows: %v", err)
+		}
+	}
+	_, err = database.Exec("CREATE " + kind + " " + index.name + " ON " + index.table + " (" + index.columns + ")")
+	return err
+}
@@ -0,0 +1,451 @@
+package storage
+
+import (
+	"context"
+	"cyber-swipe-analytics/config"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/go-sql-driver/mysql"
+	"github.com/lib/pq"
+)
+
+// newMockDB returns a DB for driver backed by sqlmock. The expectations
+// set on the returned mock must all be met by the end of the test.
+func newMockDB(t *testing.T, driver string) (*DB, sqlmock.Sqlmock) {
+	t.Helper()
+	database, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("sqlmock.New: %v", err)
+	}
+	t.Cleanup(func() {
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Error(err)
+		}
+		database.Close()
+	})
+	return &DB{DB: database, Driver: driver}, mock
+}
+
+func TestEnsureMillisecondPrecision(t *testing.T) {
+	db, mock := newMockDB(t, DriverMySQL)
+	column := addedColumn{"events", "created_at", "TIMESTAMP(3) DEFAULT CURRENT_TIMESTAMP(3)", ""}
+
+	// The live table stores whole seconds and is widened; the archive
+	// table already stores milliseconds and is left alone
+	mock.ExpectQuery(`SELECT datetime_precision FROM information_schema.columns`).
+		WithArgs("events", "created_at").
+		WillReturnRows(sqlmock.NewRows([]string{"datetime_precision"}).AddRow(0))
+	mock.ExpectExec(`ALTER TABLE events MODIFY created_at TIMESTAMP\(3\) DEFAULT CURRENT_TIMESTAMP\(3\)`).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery(`SELECT datetime_precision FROM information_schema.columns`).
+		WithArgs("events_archive", "created_at").
+		WillReturnRows(sqlmock.NewRows([]string{"datetime_precision"}).AddRow(3))
+
+	if err := ensureMillisecondPrecision(db, column); err != nil {
+		t.Fatalf("ensureMillisecondPrecision: %v", err)
+	}
+}
+
+func TestMillisecondColumnsMigration(t *testing.T) {
+	db, mock := newMockDB(t, DriverMySQL)
+
+	// Tables created in whole seconds get every row time column, live and
+	// archived, redefined with three fractional digits and a default that
+	// keeps them
+	for _, column := range millisecondColumns {
+		for _, table := range []string{column.table, archiveTable(column.table)} {
+			mock.ExpectQuery(`SELECT datetime_precision FROM information_schema.columns`).
+				WithArgs(table, column.name).
+				WillReturnRows(sqlmock.NewRows([]string{"datetime_precision"}).AddRow(0))
+			mock.ExpectExec(`^ALTER TABLE ` + table + ` MODIFY ` + column.name + ` TIMESTAMP\(3\) DEFAULT CURRENT_TIMESTAMP\(3\)$`).
+				WillReturnResult(sqlmock.NewResult(0, 0))
+		}
+	}
+
+	for _, column := range millisecondColumns {
+		if err := ensureMillisecondPrecision(db, column); err != nil {
+			t.Fatalf("ensureMillisecondPrecision(%s.%s): %v", column.table, column.name, err)
+		}
+	}
+	for table, column := range map[string]string{"sessions": "created_at", "events": "created_at", "performance_metrics": "timestamp", "category_stats": "created_at"} {
+		covered := false
+		for _, c := range millisecondColumns {
+			covered = covered || c.table == table && c.name == column
+		}
+		if !covered {
+			t.Errorf("%s.%s is not migrated to milliseconds", table, column)
+		}
+	}
+}
+
+func TestEnsureLength(t *testing.T) {
+	db, mock := newMockDB(t, DriverMySQL)
+
+	// setup_database.sql created device_model with 100 characters, fewer
+	// than a session may send; the archive table doesn't exist yet
+	mock.ExpectQuery(`SELECT character_maximum_length FROM information_schema.columns`).
+		WithArgs("sessions", "device_model").
+		WillReturnRows(sqlmock.NewRows([]string{"character_maximum_length"}).AddRow(100))
+	mock.ExpectExec(`^ALTER TABLE sessions MODIFY device_model VARCHAR\(255\) NULL$`).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery(`SELECT character_maximum_length FROM information_schema.columns`).
+		WithArgs("sessions_archive", "device_model").
+		WillReturnRows(sqlmock.NewRows([]string{"character_maximum_length"}))
+
+	for _, column := range widenedColumns {
+		if err := ensureLength(db, column); err != nil {
+			t.Fatalf("ensureLength(%s.%s): %v", column.table, column.name, err)
+		}
+	}
+}
+
+func TestEnsureLengthKeepsWideColumns(t *testing.T) {
+	db, mock := newMockDB(t, DriverMySQL)
+	column := widenedColumn{addedColumn{"sessions", "device_model", "VARCHAR(255) NULL", ""}, 255}
+
+	for _, table := range []string{"sessions", "sessions_archive"} {
+		mock.ExpectQuery(`SELECT character_maximum_length FROM information_schema.columns`).
+			WithArgs(table, "device_model").
+			WillReturnRows(sqlmock.NewRows([]string{"character_maximum_length"}).AddRow(255))
+	}
+
+	if err := ensureLength(db, column); err != nil {
+		t.Fatalf("ensureLength: %v", err)
+	}
+}
+
+func TestCreateTablesCreatesEventsInMilliseconds(t *testing.T) {
+	db, mock := newMockDB(t, DriverMySQL)
+	stop := errors.New("stop after events")
+
+	mock.ExpectExec(`CREATE TABLE IF NOT EXISTS sessions \(.*created_at TIMESTAMP\(3\) DEFAULT CURRENT_TIMESTAMP\(3\),`).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(`CREATE TABLE IF NOT EXISTS events \(.*created_at TIMESTAMP\(3\) DEFAULT CURRENT_TIMESTAMP\(3\),`).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(`CREATE TABLE IF NOT EXISTS performance_metrics`).WillReturnError(stop)
+
+	if err := createTables(db); err != stop {
+		t.Fatalf("createTables = %v, want %v", err, stop)
+	}
+}
+
+func TestEnsureMillisecondPrecisionSkipsMissingTables(t *testing.T) {
+	db, mock := newMockDB(t, DriverMySQL)
+	column := addedColumn{"sessions", "created_at", "TIMESTAMP(3) DEFAULT CURRENT_TIMESTAMP(3)", ""}
+
+	for _, table := range []string{"sessions", "sessions_archive"} {
+		mock.ExpectQuery(`SELECT datetime_precision FROM information_schema.columns`).
+			WithArgs(table, "created_at").
+			WillReturnRows(sqlmock.NewRows([]string{"datetime_precision"}))
+	}
+
+	if err := ensureMillisecondPrecision(db, column); err != nil {
+		t.Fatalf("ensureMillisecondPrecision: %v", err)
+	}
+}
+
+func TestEnsureColumnAddsMissingColumn(t *testing.T) {
+	db, mock := newMockDB(t, DriverMySQL)
+	column := addedColumn{"events", "swipe_velocity", "FLOAT", ""}
+
+	// The live table lacks the column; the archive table doesn't exist yet
+	mock.ExpectQuery(`SELECT\s+EXISTS\(`).
+		WithArgs("events", "events", "swipe_velocity").
+		WillReturnRows(sqlmock.NewRows([]string{"table_exists", "column_exists"}).AddRow(true, false))
+	mock.ExpectExec(`ALTER TABLE events ADD COLUMN swipe_velocity FLOAT`).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery(`SELECT\s+EXISTS\(`).
+		WithArgs("events_archive", "events_archive", "swipe_velocity").
+		WillReturnRows(sqlmock.NewRows([]string{"table_exists", "column_exists"}).AddRow(false, false))
+
+	if err := ensureColumn(db, column); err != nil {
+		t.Fatalf("ensureColumn: %v", err)
+	}
+}
+
+func TestEnsureColumnKeepsExistingColumn(t *testing.T) {
+	db, mock := newMockDB(t, DriverMySQL)
+	column := addedColumn{"events", "swipe_distance", "FLOAT", ""}
+
+	for _, table := range []string{"events", "events_archive"} {
+		mock.ExpectQuery(`SELECT\s+EXISTS\(`).
+			WithArgs(table, table, "swipe_distance").
+			WillReturnRows(sqlmock.NewRows([]string{"table_exists", "column_exists"}).AddRow(true, true))
+	}
+
+	if err := ensureColumn(db, column); err != nil {
+		t.Fatalf("ensureColumn: %v", err)
+	}
+}
+
+func TestCreateTablesCreatesSessions(t *testing.T) {
+	db, mock := newMockDB(t, DriverMySQL)
+	stop := errors.New("stop after sessions")
+
+	// The sessions table has the device columns createSession writes and
+	// the end time endSession sets
+	mock.ExpectExec(`CREATE TABLE IF NOT EXISTS sessions \(.*` +
+		`device_model VARCHAR\(255\) NULL,\s+os_version VARCHAR\(50\) NULL,.*` +
+		`ended_at TIMESTAMP\(3\) NULL\s+\)`).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(`CREATE TABLE IF NOT EXISTS events`).WillReturnError(stop)
+
+	if err := createTables(db); err != stop {
+		t.Fatalf("createTables = %v, want %v", err, stop)
+	}
+}
+
+func TestCreateTablesCreatesPerformanceMetrics(t *testing.T) {
+	db, mock := newMockDB(t, DriverMySQL)
+	stop := errors.New("stop after performance_metrics")
+
+	// performance_metrics is created after the tables it references, with
+	// every column the handlers write; the next statement fails so the
+	// rest of the schema setup is skipped
+	mock.ExpectExec(`CREATE TABLE IF NOT EXISTS sessions \(`).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(`CREATE TABLE IF NOT EXISTS events \(`).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(`CREATE TABLE IF NOT EXISTS performance_metrics \(\s+` +
+		`id INT AUTO_INCREMENT PRIMARY KEY,\s+session_id VARCHAR\(255\) NOT NULL,\s+` +
+		`timestamp TIMESTAMP\(3\) DEFAULT CURRENT_TIMESTAMP\(3\),\s+fps FLOAT,\s+memory_usage BIGINT,\s+` +
+		`cpu_usage FLOAT,\s+gpu_usage FLOAT,\s+network_latency INT,\s+battery_level FLOAT NULL,\s+` +
+		`thermal_state VARCHAR\(20\) NULL,\s+sample_rate FLOAT NULL,\s+` +
+		`FOREIGN KEY \(session_id\) REFERENCES sessions\(session_id\) ON DELETE CASCADE`).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(`CREATE TABLE IF NOT EXISTS category_stats`).WillReturnError(stop)
+
+	if err := createTables(db); err != stop {
+		t.Fatalf("createTables = %v, want %v", err, stop)
+	}
+}
+
+func TestCreateTablesCreatesCategoryStats(t *testing.T) {
+	db, mock := newMockDB(t, DriverMySQL)
+	stop := errors.New("stop after category_stats")
+
+	// The unique key covers the conflict columns of the category upsert
+	for _, table := range []string{"sessions", "events", "performance_metrics"} {
+		mock.ExpectExec(`CREATE TABLE IF NOT EXISTS ` + table + ` \(`).WillReturnResult(sqlmock.NewResult(0, 0))
+	}
+	mock.ExpectExec(`CREATE TABLE IF NOT EXISTS category_stats \(\s+` +
+		`id INT AUTO_INCREMENT PRIMARY KEY,\s+session_id VARCHAR\(255\) NOT NULL,\s+category_name VARCHAR\(100\) NOT NULL,\s+` +
+		`total_cards INT DEFAULT 0,\s+accepted_cards INT DEFAULT 0,.*` +
+		`UNIQUE KEY uniq_category_stats_session_category \(session_id, category_name\),\s+` +
+		`FOREIGN KEY \(session_id\) REFERENCES sessions\(session_id\) ON DELETE CASCADE`).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(`CREATE TABLE IF NOT EXISTS backfill_progress`).WillReturnError(stop)
+
+	if err := createTables(db); err != stop {
+		t.Fatalf("createTables = %v, want %v", err, stop)
+	}
+}
+
+func TestCreatePostgresTables(t *testing.T) {
+	db, mock := newMockDB(t, DriverPostgres)
+
+	// On an up-to-date database every table is created if missing, no
+	// column is added and the indexes are created if missing
+	for range postgresSchema {
+		mock.ExpectExec(`^CREATE (TABLE|INDEX) IF NOT EXISTS`).WillReturnResult(sqlmock.NewResult(0, 0))
+	}
+	for _, column := range addedColumns {
+		for _, table := range []string{column.table, archiveTable(column.table)} {
+			mock.ExpectQuery(`table_schema = current_schema\(\) AND table_name = \$1\s+\),.*table_name = \$2 AND column_name = \$3`).
+				WithArgs(table, table, column.name).
+				WillReturnRows(sqlmock.NewRows([]string{"table_exists", "column_exists"}).AddRow(true, true))
+		}
+	}
+	for _, index := range secondaryIndexes {
+		mock.ExpectExec(`^CREATE (UNIQUE )?INDEX IF NOT EXISTS ` + index.name + ` ON ` + index.table).
+			WillReturnResult(sqlmock.NewResult(0, 0))
+	}
+
+	if err := createPostgresTables(db); err != nil {
+		t.Fatalf("createPostgresTables: %v", err)
+	}
+}
+
+func TestPostgresSchemaCoversExpectedColumns(t *testing.T) {
+	// Every column the handlers rely on is created by the PostgreSQL DDL
+	// or added by a migration
+	for table, columns := range expectedColumns {
+		var ddl string
+		for _, statement := range postgresSchema {
+			if strings.HasPrefix(statement, "CREATE TABLE IF NOT EXISTS "+table+" (") {
+				ddl = statement
+			}
+		}
+		if ddl == "" {
+			t.Errorf("table %s is not created", table)
+			continue
+		}
+		for _, column := range columns {
+			added := false
+			for _, c := range addedColumns {
+				added = added || c.table == table && c.name == column
+			}
+			if !added && !regexp.MustCompile(`(?m)^\s*`+column+` `).MatchString(ddl) {
+				t.Errorf("column %s.%s is not created", table, column)
+			}
+		}
+	}
+}
+
+func TestNewConnectorPicksDriver(t *testing.T) {
+	tests := []struct {
+		driver string
+		want   driver.Driver
+	}{
+		{DriverMySQL, &mysql.MySQLDriver{}},
+		{DriverPostgres, &pq.Driver{}},
+	}
+	for _, tt := range tests {
+		connector, err := newConnector(&config.Config{
+			DBDriver: tt.driver, DBHost: "localhost", DBPort: "5432", DBUser: "analytics",
+			DBPassword: "it's secret", DBName: "analytics", DBSSLMode: "disable",
+		})
+		if err != nil {
+			t.Fatalf("%s: newConnector: %v", tt.driver, err)
+		}
+		if got := connector.Driver(); reflect.TypeOf(got) != reflect.TypeOf(tt.want) {
+			t.Errorf("%s: driver = %T, want %T", tt.driver, got, tt.want)
+		}
+	}
+}
+
+func TestEnsureIndex(t *testing.T) {
+	db, mock := newMockDB(t, DriverMySQL)
+
+	// The missing index is created, the existing one left alone
+	mock.ExpectQuery(`FROM information_schema.statistics\s+WHERE table_schema = DATABASE\(\) AND table_name = \? AND index_name = \?`).
+		WithArgs("sessions", "idx_sessions_user_id").
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+	mock.ExpectExec(`^CREATE INDEX idx_sessions_user_id ON sessions \(user_id\)$`).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery(`FROM information_schema.statistics`).
+		WithArgs("events", "uniq_events_session_seq").
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+
+	for _, index := range []secondaryIndex{
+		{"idx_sessions_user_id", "sessions", "user_id", false},
+		{"uniq_events_session_seq", "events", "session_id, seq", true},
+	} {
+		if err := ensureIndex(db, index); err != nil {
+			t.Fatalf("ensureIndex(%s): %v", index.name, err)
+		}
+	}
+}
+
+func TestEnsureIndexMergesDuplicates(t *testing.T) {
+	db, mock := newMockDB(t, DriverMySQL)
+
+	// Tables created by setup_database.sql lack the key and may hold
+	// several rows per session and category; they are folded into one
+	// before the key is created
+	mock.ExpectQuery(`FROM information_schema.statistics`).
+		WithArgs("category_stats", "uniq_category_stats_session_category").
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+	mock.ExpectBegin()
+	mock.ExpectExec(`UPDATE category_stats c\s+JOIN \(.*SUM\(total_cards\).*GROUP BY session_id, category_name\s+HAVING COUNT\(\*\) > 1`).
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectExec(`DELETE c FROM category_stats c\s+JOIN \(.*MIN\(id\).*kept.id <> c.id`).
+		WillReturnResult(sqlmock.NewResult(0, 3))
+	mock.ExpectCommit()
+	mock.ExpectExec(`^CREATE UNIQUE INDEX uniq_category_stats_session_category ON category_stats \(session_id, category_name\)$`).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+
+	index := secondaryIndex{"uniq_category_stats_session_category", "category_stats", "session_id, category_name", true}
+	if err := ensureIndex(db, index); err != nil {
+		t.Fatalf("ensureIndex: %v", err)
+	}
+}
+
+func TestSecondaryIndexesCoverFilterColumns(t *testing.T) {
+	// Each filter and join column leads an index, so lookups by it alone
+	// can use the index
+	for _, column := range []struct{ table, name string }{
+		{"events", "session_id"},
+		{"events", "event_type"},
+		{"sessions", "user_id"},
+		{"category_stats", "category_name"},
+	} {
+		covered := false
+		for _, index := range secondaryIndexes {
+			leading := strings.TrimSpace(strings.SplitN(index.columns, ",", 2)[0])
+			covered = covered || index.table == column.table && leading == column.name
+		}
+		if !covered {
+			t.Errorf("no index leads with %s.%s", column.table, column.name)
+		}
+	}
+}
+
+// unreachableConnector fails every connection attempt and counts them.
+type unreachableConnector struct {
+	attempts int
+}
+
+func (c *unreachableConnector) Connect(context.Context) (driver.Conn, error) {
+	c.attempts++
+	return nil, errors.New("connection refused")
+}
+
+func (c *unreachableConnector) Driver() driver.Driver { return nil }
+
+func TestPingWithRetry(t *testing.T) {
+	captureLog(t)
+	connector := &unreachableConnector{}
+	database := sql.OpenDB(connector)
+	defer database.Close()
+
+	if err := pingWithRetry(database, 3, time.Millisecond, time.Minute); err == nil {
+		t.Fatal("pingWithRetry succeeded on an unreachable database")
+	}
+	if connector.attempts != 4 {
+		t.Errorf("tried %d times, want the first attempt and 3 retries", connector.attempts)
+	}
+}
+
+func TestPingWithRetryGivesUpAtTimeout(t *testing.T) {
+	captureLog(t)
+	connector := &unreachableConnector{}
+	database := sql.OpenDB(connector)
+	defer database.Close()
+
+	// The backoff would wait far longer than the overall timeout allows
+	start := time.Now()
+	if err := pingWithRetry(database, 10, time.Minute, 50*time.Millisecond); err == nil {
+		t.Fatal("pingWithRetry succeeded on an unreachable database")
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("gave up after %v, want about 50ms", elapsed)
+	}
+	if connector.attempts != 1 {
+		t.Errorf("tried %d times, want 1", connector.attempts)
+	}
+}
+
+func TestInitDBRetriesUnreachableDatabase(t *testing.T) {
+	logs := captureLog(t)
+
+	// Nothing listens on port 1, so every attempt is refused
+	_, err := InitDB(&config.Config{
+		DBDriver: DriverMySQL, DBHost: "127.0.0.1", DBPort: "1", DBUser: "analytics", DBName: "analytics",
+		DBConnectRetries: 2, DBConnectBackoff: time.Millisecond, DBConnectTimeout: 10 * time.Second,
+	})
+	if err == nil {
+		t.Fatal("InitDB succeeded without a database")
+	}
+	if got := strings.Count(logs.String(), "Database not reachable"); got != 2 {
+		t.Errorf("logged %d retries, want 2:\n%s", got, logs)
+	}
+	if !strings.Contains(logs.String(), "attempt 2 of 3") {
+		t.Errorf("log lacks the attempt count:\n%s", logs)
+	}
+}
@@ -0,0 +1,148 @@
+package storage
+
+import (
+	"strconv"
+	"strings"
+)
+
+// Supported database drivers.
+const (
+	DriverMySQL    = "mysql"
+	DriverPostgres = "postgres"
+)
+
+// Rebind rewrites the ? placeholders of query into the placeholder style of
+// the driver: unchanged for MySQL, $1, $2, ... for PostgreSQL. Question
+// marks inside quoted strings are left alone. The DB query methods rebind
+// automatically; Rebind is for statements run on a transaction.
+func (db *DB) Rebind(query string) string {
+	if db.Driver != DriverPostgres || !strings.Contains(query, "?") {
+		return query
+	}
+
+	var b strings.Builder
+	b.Grow(len(query) + 8)
+	n := 0
+	var quote byte
+	for i := 0; i < len(query); i++ {
+		ch := query[i]
+		switch {
+		case quote != 0:
+			if ch == quote {
+				quote = 0
+			}
+		case ch == '\'' || ch == '"' || ch == '`':
+			quote = ch
+		case ch == '?':
+			n++
+			b.WriteByte('$')
+			b.WriteString(strconv.Itoa(n))
+			continue
+		}
+		b.WriteByte(ch)
+	}
+	return b.String()
+}
+
+// Inserted returns the expression referring to the value that an upsert
+// tried to insert into column, for use in the assignments of Upsert.
+func (db *DB) Inserted(column string) string {
+	if db.Driver == DriverPostgres {
+		return "EXCLUDED." + column
+	}
+	return "VALUES(" + column + ")"
+}
+
+// Upsert returns the clause that turns an INSERT into an upsert: when a row
+// with the same conflict columns exists, the assignments ("column = expr")
+// are applied to it instead. Without assignments the existing row is kept
+// unchanged. MySQL ignores the conflict columns and uses whichever unique
+// key was violated.
+func (db *DB) Upsert(conflict []string, assignments ...string) string {
+	if db.Driver == DriverPostgres {
+		clause := "ON CONFLICT (" + strings.Join(conflict, ", ") + ") DO "
+		if len(assignments) == 0 {
+			return clause + "NOTHING"
+		}
+		return clause + "UPDATE SET " + strings.Join(assignments, ", ")
+	}
+
+	if len(assignments) == 0 {
+		assignments = []string{conflict[0] + " = " + conflict[0]}
+	}
+	return "ON DUPLICATE KEY UPDATE " + strings.Join(assignments, ", ")
+}
+
+// TruncateTime returns the expression rounding the timestamp column down to
+// the start of its hour, day, or week (weeks start on Monday).
+func (db *DB) TruncateTime(column, unit string) string {
+	if db.Driver == DriverPostgres {
+		return "date_trunc('" + unit + "', " + column + ")"
+	}
+	switch unit {
+	case "hour":
+		return "CAST(DATE_FORMAT(" + column + ", '%Y-%m-%d %H:00:00') AS DATETIME)"
+	case "week":
+		return "CAST(DATE_SUB(DATE(" + column + "), INTERVAL WEEKDAY(" + column + ") DAY) AS DATETIME)"
+	default:
+		return "CAST(DATE(" + column + ") AS DATETIME)"
+	}
+}
+
+// CurrentSchema returns the expression naming the schema the connection
+// works in, for filtering information_schema.
+func (db *DB) CurrentSchema() string {
+	if db.Driver == DriverPostgres {
+		return "current_schema()"
+	}
+	return "DATABASE()"
+}
+
+// EpochSeconds returns the expression converting the timestamp column into
+// seconds since the Unix epoch.
+func (db *DB) EpochSeconds(column string) string {
+	if db.Driver == DriverPostgres {
+		return "EXTRACT(EPOCH FROM " + column + ")"
+	}
+	return "UNIX_TIMESTAMP(" + column + ")"
+}
+
+// SecondsBetween returns the expression for the time from the timestamp
+// start to the timestamp end in seconds, including fractions of a second.
+func (db *DB) SecondsBetween(start, end string) string {
+	if db.Driver == DriverPostgres {
+		return "EXTRACT(EPOCH FROM (" + end + " - " + start + "))"
+	}
+	return "TIMESTAMPDIFF(MICROSECOND, " + start + ", " + end + ") / 1000000"
+}
+
+// AddMicroseconds returns the expression for the timestamp expr plus the
+// number of microseconds passed as argument.
+func (db *DB) AddMicroseconds(expr string) string {
+	if db.Driver == DriverPostgres {
+		return expr + " + CAST(? AS BIGINT) * INTERVAL '1 microsecond'"
+	}
+	return "TIMESTAMPADD(MICROSECOND, ?, " + expr + ")"
+}
+
+// BucketIndex returns the expression yielding the index of the bucket that
+// expr falls into, given n strictly increasing bucket edges passed as
+// arguments: 0 below the first edge, i from edge i on, and n from the last
+// edge on. The index is NULL when expr is NULL on PostgreSQL and -1 on
+// MySQL.
+func (db *DB) BucketIndex(expr string, n int) string {
+	if db.Driver == DriverPostgres {
+		edges := strings.TrimSuffix(strings.Repeat("CAST(? AS DOUBLE PRECISION), ", n), ", ")
+		return "width_bucket(CAST(" + expr + " AS DOUBLE PRECISION), ARRAY[" + edges + "])"
+	}
+	return "INTERVAL(" + expr + ", " + strings.TrimSuffix(strings.Repeat("?, ", n), ", ") + ")"
+}
+
+// JSONText returns the expression extracting the string stored under key
+// in the JSON object column, and the argument for its placeholder.
+func (db *DB) JSONText(column, key string) (string, interface{}) {
+	if db.Driver == DriverPostgres {
+		return column + " ->> ?", key
+	}
+	return "JSON_UNQUOTE(JSON_EXTRACT(" + column + ", ?))", "$." + strconv.Quote(key)
+}
@@ -0,0 +1,93 @@
+package storage
+
+import "testing"
+
+func TestRebind(t *testing.T) {
+	tests := []struct {
+		driver, query, want string
+	}{
+		{DriverMySQL, "SELECT * FROM events WHERE session_id = ? AND seq > ?", "SELECT * FROM events WHERE session_id = ? AND seq > ?"},
+		{DriverPostgres, "SELECT * FROM events WHERE session_id = ? AND seq > ?", "SELECT * FROM events WHERE session_id = $1 AND seq > $2"},
+		{DriverPostgres, "SELECT 1", "SELECT 1"},
+		{DriverPostgres, "SELECT '?', \"a?\" FROM t WHERE x = ?", "SELECT '?', \"a?\" FROM t WHERE x = $1"},
+		{DriverPostgres, "INSERT INTO t VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)", "INSERT INTO t VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)"},
+	}
+	for _, tt := range tests {
+		db := &DB{Driver: tt.driver}
+		if got := db.Rebind(tt.query); got != tt.want {
+			t.Errorf("%s: Rebind(%q) = %q, want %q", tt.driver, tt.query, got, tt.want)
+		}
+	}
+}
+
+func TestUpsert(t *testing.T) {
+	tests := []struct {
+		driver      string
+		conflict    []string
+		assignments []string
+		want        string
+	}{
+		{DriverMySQL, []string{"session_id", "category_name"}, []string{"total_cards = total_cards + 1"},
+			"ON DUPLICATE KEY UPDATE total_cards = total_cards + 1"},
+		{DriverPostgres, []string{"session_id", "category_name"}, []string{"total_cards = total_cards + 1"},
+			"ON CONFLICT (session_id, category_name) DO UPDATE SET total_cards = total_cards + 1"},
+		{DriverMySQL, []string{"user_id"}, nil, "ON DUPLICATE KEY UPDATE user_id = user_id"},
+		{DriverPostgres, []string{"user_id"}, nil, "ON CONFLICT (user_id) DO NOTHING"},
+		{DriverPostgres, []string{"target_column"}, []string{"last_id = 1", "updated_at = CURRENT_TIMESTAMP"},
+			"ON CONFLICT (target_column) DO UPDATE SET last_id = 1, updated_at = CURRENT_TIMESTAMP"},
+	}
+	for _, tt := range tests {
+		db := &DB{Driver: tt.driver}
+		if got := db.Upsert(tt.conflict, tt.assignments...); got != tt.want {
+			t.Errorf("%s: Upsert(%v, %v) = %q, want %q", tt.driver, tt.conflict, tt.assignments, got, tt.want)
+		}
+	}
+}
+
+func TestInserted(t *testing.T) {
+	tests := []struct {
+		driver, want string
+	}{
+		{DriverMySQL, "VALUES(accepted_cards)"},
+		{DriverPostgres, "EXCLUDED.accepted_cards"},
+	}
+	for _, tt := range tests {
+		db := &DB{Driver: tt.driver}
+		if got := db.Inserted("accepted_cards"); got != tt.want {
+			t.Errorf("%s: Inserted = %q, want %q", tt.driver, got, tt.want)
+		}
+	}
+}
+
+func TestTruncateTime(t *testing.T) {
+	tests := []struct {
+		driver, unit, want string
+	}{
+		{DriverMySQL, "hour", "CAST(DATE_FORMAT(created_at, '%Y-%m-%d %H:00:00') AS DATETIME)"},
+		{DriverMySQL, "day", "CAST(DATE(created_at) AS DATETIME)"},
+		{DriverMySQL, "week", "CAST(DATE_SUB(DATE(created_at), INTERVAL WEEKDAY(created_at) DAY) AS DATETIME)"},
+		{DriverPostgres, "hour", "date_trunc('hour', created_at)"},
+		{DriverPostgres, "week", "date_trunc('week', created_at)"},
+	}
+	for _, tt := range tests {
+		db := &DB{Driver: tt.driver}
+		if got := db.TruncateTime("created_at", tt.unit); got != tt.want {
+			t.Errorf("%s: TruncateTime(%s) = %q, want %q", tt.driver, tt.unit, got, tt.want)
+		}
+	}
+}
+
+func TestAddMicroseconds(t *testing.T) {
+	tests := []struct {
+		driver, want string
+	}{
+		{DriverMySQL, "TIMESTAMPADD(MICROSECOND, ?, CURRENT_TIMESTAMP(3))"},
+		{DriverPostgres, "CURRENT_TIMESTAMP(3) + CAST(? AS BIGINT) * INTERVAL '1 microsecond'"},
+	}
+	for _, tt := range tests {
+		db := &DB{Driver: tt.driver}
+		if got := db.AddMicroseconds("CURRENT_TIMESTAMP(3)"); got != tt.want {
+			t.Errorf("%s: AddMicroseconds = %q, want %q", tt.driver, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,144 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrUserNotFound is returned by EraseUser when no data is stored for the
+// user.
+var ErrUserNotFound = errors.New("no data stored for user")
+
+// EraseUser deletes every session of userID and all rows referencing them,
+// in the hot tables and, once created, the archive tables, in one
+// transaction, along with the dead letters still holding requests of the
+// user. It returns the number of rows deleted per table. The child
+// rows are deleted explicitly rather than through the ON DELETE CASCADE
+// foreign keys, so they are removed even where a foreign key is missing,
+// e.g. in the archive tables, and can be counted.
+func (db *DB) EraseUser(ctx context.Context, userID string) (map[string]int64, error) {
+	ctx, cancel := db.withQueryTimeout(ctx)
+	defer cancel()
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	archived, err := db.archiveTablesExist(ctx)
+	if err != nil {
+		return nil, err
+	}
+	suffixes := []string{""}
+	if archived {
+		suffixes = append(suffixes, archiveTable(""))
+	}
+
+	deleted := make(map[string]int64)
+	// The dead letters go first, as they are matched through the sessions
+	if deleted["dead_letters"], err = db.eraseDeadLetters(ctx, tx, userID, suffixes); err != nil {
+		return nil, err
+	}
+	total := deleted["dead_letters"]
+	for _, suffix := range suffixes {
+		sessions := "sessions" + suffix
+		for _, child := range archiveChildTables {
+			table := child + suffix
+			result, err := tx.ExecContext(ctx, db.Rebind(fmt.Sprintf(`
+				DELETE FROM %s
+				WHERE session_id IN (SELECT session_id FROM %s WHERE user_id = ?)
+			`, table, sessions)), userID)
+			if err != nil {
+				return nil, fmt.Errorf("error deleting %s: %v", table, err)
+			}
+			if deleted[table], err = result.RowsAffected(); err != nil {
+				return nil, err
+			}
+			total += deleted[table]
+		}
+
+		result, err := tx.ExecContext(ctx, db.Rebind("DELETE FROM "+sessions+" WHERE user_id = ?"), userID)
+		if err != nil {
+			return nil, fmt.Errorf("error deleting %s: %v", sessions, err)
+		}
+		if deleted[sessions], err = result.RowsAffected(); err != nil {
+			return nil, err
+		}
+		total += deleted[sessions]
+	}
+
+	if total == 0 {
+		return nil, ErrUserNotFound
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return deleted, nil
+}
+
+// eraseDeadLetters deletes the dead letters holding requests of userID: its
+// queued sessions, and the requests referencing either a stored session of
+// the user or one still queued. Rows in every state are deleted, as they all
+// keep the payload.
+func (db *DB) eraseDeadLetters(ctx context.Context, tx *sql.Tx, userID string, suffixes []string) (int64, error) {
+	user, userKey := db.JSONText("payload", "user_id")
+	session, sessionKey := db.JSONText("payload", "session_id")
+
+	// MySQL cannot delete from a table selected from in a subquery, so the
+	// queued sessions are looked up first
+	rows, err := tx.QueryContext(ctx, db.Rebind("SELECT "+session+" FROM dead_letters WHERE "+user+" = ?"), sessionKey, userKey, userID)
+	if err != nil {
+		return 0, fmt.Errorf("error reading dead letters: %v", err)
+	}
+	var queued []interface{}
+	for rows.Next() {
+		var sessionID sql.NullString
+		if err := rows.Scan(&sessionID); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		if sessionID.Valid {
+			queued = append(queued, sessionID.String)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	conditions := []string{user + " = ?"}
+	args := []interface{}{userKey, userID}
+	for _, suffix := range suffixes {
+		conditions = append(conditions, session+" IN (SELECT session_id FROM sessions"+suffix+" WHERE user_id = ?)")
+		args = append(args, sessionKey, userID)
+	}
+	if len(queued) > 0 {
+		conditions = append(conditions, session+" IN ("+placeholders(len(queued))+")")
+		args = append(append(args, sessionKey), queued...)
+	}
+	result, err := tx.ExecContext(ctx, db.Rebind("DELETE FROM dead_letters WHERE "+strings.Join(conditions, " OR ")), args...)
+	if err != nil {
+		return 0, fmt.Errorf("error deleting dead_letters: %v", err)
+	}
+	return result.RowsAffected()
+}
+
+// archiveTablesExist reports whether ArchiveSessions has created the
+// archive tables.
+func (db *DB) archiveTablesExist(ctx context.Context) (bool, error) {
+	var exists bool
+	err := db.QueryRowContext(ctx, `
+		SELECT EXISTS(
+			SELECT 1 FROM information_schema.tables
+			WHERE table_schema = `+db.CurrentSchema()+` AND table_name = ?
+		)
+	`, archiveTable("sessions")).Scan(&exists)
+	if err != nil {
+		return false, fmt.Errorf("error checking for archive tables: %v", err)
+	}
+	return exists, nil
+}
@@ -0,0 +1,139 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+// expectArchiveTables expects the check for the archive tables and
+// answers it.
+func expectArchiveTables(mock sqlmock.Sqlmock, exist bool) {
+	mock.ExpectQuery(`FROM information_schema.tables`).
+		WithArgs("sessions_archive").
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(exist))
+}
+
+// expectEraseDeadLetters expects the lookup of the sessions userID has
+// queued as dead letters, answering it with queued, and the deletion of the
+// dead letters matched, deleting count rows.
+func expectEraseDeadLetters(mock sqlmock.Sqlmock, userID string, queued []string, count int64) {
+	rows := sqlmock.NewRows([]string{"session_id"})
+	for _, sessionID := range queued {
+		rows.AddRow(sessionID)
+	}
+	mock.ExpectQuery(`SELECT JSON_UNQUOTE\(JSON_EXTRACT\(payload, \?\)\) FROM dead_letters WHERE JSON_UNQUOTE\(JSON_EXTRACT\(payload, \?\)\) = \?`).
+		WithArgs(`$."session_id"`, `$."user_id"`, userID).
+		WillReturnRows(rows)
+	mock.ExpectExec(`DELETE FROM dead_letters WHERE`).WillReturnResult(sqlmock.NewResult(0, count))
+}
+
+func TestEraseUser(t *testing.T) {
+	db, mock := newMockDB(t, DriverMySQL)
+
+	// The child rows go first, then the sessions, in the hot tables and
+	// then the archive tables, all in one transaction
+	mock.ExpectBegin()
+	expectArchiveTables(mock, true)
+	expectEraseDeadLetters(mock, "u1", nil, 0)
+	want := map[string]int64{
+		"dead_letters": 0, "events": 5, "performance_metrics": 2, "category_stats": 1, "sessions": 2,
+		"events_archive": 3, "performance_metrics_archive": 0, "category_stats_archive": 0, "sessions_archive": 1,
+	}
+	for _, suffix := range []string{"", "_archive"} {
+		for _, table := range []string{"events", "performance_metrics", "category_stats"} {
+			mock.ExpectExec(`DELETE FROM ` + table + suffix + `\s+WHERE session_id IN \(SELECT session_id FROM sessions` + suffix + ` WHERE user_id = \?\)`).
+				WithArgs("u1").
+				WillReturnResult(sqlmock.NewResult(0, want[table+suffix]))
+		}
+		mock.ExpectExec(`DELETE FROM sessions` + suffix + ` WHERE user_id = \?`).
+			WithArgs("u1").
+			WillReturnResult(sqlmock.NewResult(0, want["sessions"+suffix]))
+	}
+	mock.ExpectCommit()
+
+	deleted, err := db.EraseUser(context.Background(), "u1")
+	if err != nil {
+		t.Fatalf("EraseUser: %v", err)
+	}
+	if len(deleted) != len(want) {
+		t.Errorf("deleted = %v, want %v", deleted, want)
+	}
+	for table, count := range want {
+		if deleted[table] != count {
+			t.Errorf("deleted[%s] = %d, want %d", table, deleted[table], count)
+		}
+	}
+}
+
+func TestEraseUserWithoutData(t *testing.T) {
+	db, mock := newMockDB(t, DriverMySQL)
+
+	// Without archive tables only the hot tables are searched, and the
+	// empty transaction is rolled back
+	mock.ExpectBegin()
+	expectArchiveTables(mock, false)
+	expectEraseDeadLetters(mock, "nobody", nil, 0)
+	for _, table := range []string{"events", "performance_metrics", "category_stats", "sessions"} {
+		mock.ExpectExec(`DELETE FROM ` + table + `\s+WHERE`).WithArgs("nobody").WillReturnResult(sqlmock.NewResult(0, 0))
+	}
+	mock.ExpectRollback()
+
+	if _, err := db.EraseUser(context.Background(), "nobody"); !errors.Is(err, ErrUserNotFound) {
+		t.Fatalf("EraseUser = %v, want ErrUserNotFound", err)
+	}
+}
+
+func TestEraseUserRollsBackOnError(t *testing.T) {
+	db, mock := newMockDB(t, DriverPostgres)
+
+	mock.ExpectBegin()
+	expectArchiveTables(mock, false)
+	mock.ExpectQuery(`SELECT payload ->> \$1 FROM dead_letters WHERE payload ->> \$2 = \$3`).
+		WithArgs("session_id", "user_id", "u1").
+		WillReturnRows(sqlmock.NewRows([]string{"session_id"}))
+	mock.ExpectExec(`DELETE FROM dead_letters WHERE payload ->> \$1 = \$2 OR payload ->> \$3 IN \(SELECT session_id FROM sessions WHERE user_id = \$4\)$`).
+		WithArgs("user_id", "u1", "session_id", "u1").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(`DELETE FROM events\s+WHERE session_id IN \(SELECT session_id FROM sessions WHERE user_id = \$1\)`).
+		WithArgs("u1").
+		WillReturnResult(sqlmock.NewResult(0, 4))
+	mock.ExpectExec(`DELETE FROM performance_metrics`).WithArgs("u1").WillReturnError(errors.New("connection reset"))
+	mock.ExpectRollback()
+
+	if _, err := db.EraseUser(context.Background(), "u1"); err == nil {
+		t.Fatal("EraseUser succeeded, want the delete error")
+	}
+}
+
+func TestEraseUserDeletesPendingDeadLetters(t *testing.T) {
+	db, mock := newMockDB(t, DriverMySQL)
+
+	// The user has a session still queued as a dead letter, along with an
+	// event of it, and only dead letters left of the stored data: they are
+	// deleted with the rest, and the user counts as found
+	mock.ExpectBegin()
+	expectArchiveTables(mock, false)
+	mock.ExpectQuery(`FROM dead_letters WHERE`).
+		WithArgs(`$."session_id"`, `$."user_id"`, "u1").
+		WillReturnRows(sqlmock.NewRows([]string{"session_id"}).AddRow("queued"))
+	mock.ExpectExec(`DELETE FROM dead_letters WHERE JSON_UNQUOTE\(JSON_EXTRACT\(payload, \?\)\) = \?`+
+		` OR JSON_UNQUOTE\(JSON_EXTRACT\(payload, \?\)\) IN \(SELECT session_id FROM sessions WHERE user_id = \?\)`+
+		` OR JSON_UNQUOTE\(JSON_EXTRACT\(payload, \?\)\) IN \(\?\)$`).
+		WithArgs(`$."user_id"`, "u1", `$."session_id"`, "u1", `$."session_id"`, "queued").
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	for _, table := range []string{"events", "performance_metrics", "category_stats", "sessions"} {
+		mock.ExpectExec(`DELETE FROM ` + table + `\s+WHERE`).WithArgs("u1").WillReturnResult(sqlmock.NewResult(0, 0))
+	}
+	mock.ExpectCommit()
+
+	deleted, err := db.EraseUser(context.Background(), "u1")
+	if err != nil {
+		t.Fatalf("EraseUser: %v", err)
+	}
+	if deleted["dead_letters"] != 2 {
+		t.Errorf("deleted[dead_letters] = %d, want 2", deleted["dead_letters"])
+	}
+}
@@ -0,0 +1,58 @@
+package storage
+
+import (
+	"errors"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/lib/pq"
+)
+
+// permanentMySQLErrors lists MySQL error numbers caused by the data itself,
+// which fail the same way no matter how often the statement is retried.
+var permanentMySQLErrors = map[uint16]bool{
+	1048: true, // column cannot be null
+	1062: true, // duplicate entry
+	1264: true, // out of range value
+	1292: true, // incorrect datetime value
+	1366: true, // incorrect value for column
+	1406: true, // data too long for column
+	1451: true, // cannot delete or update a parent row
+	1452: true, // cannot add or update a child row
+	3140: true, // invalid JSON text
+}
+
+// permanentPostgresClasses lists PostgreSQL error classes caused by the
+// data itself: data exceptions and integrity constraint violations.
+var permanentPostgresClasses = map[pq.ErrorClass]bool{
+	"22": true,
+	"23": true,
+}
+
+// IsDuplicateKeyError reports whether err is a duplicate entry error,
+// raised when an insert violates a unique key.
+func IsDuplicateKeyError(err error) bool {
+	var mysqlErr *mysql.MySQLError
+	if errors.As(err, &mysqlErr) {
+		return mysqlErr.Number == 1062
+	}
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == "23505"
+}
+
+// IsTransientError reports whether err is a database failure that may
+// succeed when retried, such as a lost connection, a deadlock, or a lock
+// wait timeout. Errors caused by the data itself are permanent.
+func IsTransientError(err error) bool {
+	if err == nil {
+		return false
+	}
+	var mysqlErr *mysql.MySQLError
+	if errors.As(err, &mysqlErr) {
+		return !permanentMySQLErrors[mysqlErr.Number]
+	}
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		return !permanentPostgresClasses[pqErr.Code.Class()]
+	}
+	return true
+}
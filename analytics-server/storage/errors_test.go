@@ -0,0 +1,47 @@
+package storage
+
+import (
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/lib/pq"
+)
+
+func TestIsTransientError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"nil", nil, false},
+		{"lost connection", driver.ErrBadConn, true},
+		{"mysql deadlock", &mysql.MySQLError{Number: 1213}, true},
+		{"mysql lock wait timeout", &mysql.MySQLError{Number: 1205}, true},
+		{"mysql duplicate entry", &mysql.MySQLError{Number: 1062}, false},
+		{"mysql data too long", &mysql.MySQLError{Number: 1406}, false},
+		{"wrapped mysql duplicate entry", fmt.Errorf("request r1: %w", &mysql.MySQLError{Number: 1062}), false},
+		{"postgres serialization failure", &pq.Error{Code: "40001"}, true},
+		{"postgres unique violation", &pq.Error{Code: "23505"}, false},
+		{"postgres invalid text", &pq.Error{Code: "22P02"}, false},
+	}
+	for _, tt := range tests {
+		if got := IsTransientError(tt.err); got != tt.want {
+			t.Errorf("%s: IsTransientError = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestIsDuplicateKeyError(t *testing.T) {
+	if !IsDuplicateKeyError(fmt.Errorf("wrapped: %w", &mysql.MySQLError{Number: 1062})) {
+		t.Error("MySQL duplicate entry not detected")
+	}
+	if !IsDuplicateKeyError(&pq.Error{Code: "23505"}) {
+		t.Error("PostgreSQL unique violation not detected")
+	}
+	if IsDuplicateKeyError(errors.New("duplicate")) || IsDuplicateKeyError(&mysql.MySQLError{Number: 1452}) {
+		t.Error("other errors detected as duplicates")
+	}
+}
@@ -0,0 +1,143 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+)
+
+// ErrExplainUnsupported is returned by AdviseIndexes for drivers whose
+// EXPLAIN output it cannot interpret.
+var ErrExplainUnsupported = errors.New("index advice is not supported for this database driver")
+
+// adviceQuery is a representative form of one of the core aggregation
+// queries, with the index that would avoid a full scan of its table.
+type adviceQuery struct {
+	name       string
+	query      string
+	table      string
+	suggestion string
+}
+
+// adviceQueries lists the queries examined by AdviseIndexes. They use
+// fixed values in place of placeholders, which EXPLAIN treats alike.
+var adviceQueries = []adviceQuery{
+	{
+		name:       "sessions_by_time",
+		query:      "SELECT COUNT(*), COUNT(DISTINCT user_id) FROM sessions WHERE created_at >= NOW() - INTERVAL 1 DAY",
+		table:      "sessions",
+		suggestion: "CREATE INDEX idx_sessions_created_at ON sessions (created_at)",
+	},
+	{
+		name:       "sessions_by_user",
+		query:      "SELECT session_id, created_at FROM sessions WHERE user_id = ''",
+		table:      "sessions",
+		suggestion: "CREATE INDEX idx_sessions_user_id ON sessions (user_id)",
+	},
+	{
+		name:       "events_by_time",
+		query:      "SELECT event_type, COUNT(*) FROM events WHERE created_at >= NOW() - INTERVAL 1 DAY GROUP BY event_type",
+		table:      "events",
+		suggestion: "CREATE INDEX idx_events_created_at ON events (created_at)",
+	},
+	{
+		name:       "swipes_by_session",
+		query:      "SELECT session_id, COUNT(*), AVG(success) FROM events WHERE event_type = 'card_swipe' GROUP BY session_id",
+		table:      "events",
+		suggestion: "CREATE INDEX idx_events_type_session ON events (event_type, session_id)",
+	},
+	{
+		name:       "performance_by_time",
+		query:      "SELECT AVG(fps), AVG(memory_usage) FROM performance_metrics WHERE timestamp >= NOW() - INTERVAL 1 DAY",
+		table:      "performance_metrics",
+		suggestion: "CREATE INDEX idx_performance_metrics_timestamp ON performance_metrics (timestamp)",
+	},
+	{
+		name:       "categories_by_name",
+		query:      "SELECT category_name, SUM(accepted_cards), SUM(total_cards) FROM category_stats GROUP BY category_name",
+		table:      "category_stats",
+		suggestion: "CREATE INDEX idx_category_stats_category_name ON category_stats (category_name)",
+	},
+}
+
+// QueryAdvice is the EXPLAIN plan of one examined query. FullScan is set
+// when the plan reads the whole of Table and at least the configured
+// number of rows; Suggestion then holds an index that would avoid it.
+type QueryAdvice struct {
+	Name       string                   `json:"name"`
+	Query      string                   `json:"query"`
+	Plan       []map[string]interface{} `json:"plan"`
+	FullScan   bool                     `json:"full_scan"`
+	Suggestion string                   `json:"suggestion,omitempty"`
+}
+
+// AdviseIndexes runs EXPLAIN on the core aggregation queries and flags
+// full table scans of at least minRows estimated rows. It only reads; the
+// suggested indexes are left to the operator to create.
+func (db *DB) AdviseIndexes(ctx context.Context, minRows int64) ([]QueryAdvice, error) {
+	if db.Driver != DriverMySQL {
+		return nil, ErrExplainUnsupported
+	}
+
+	advice := make([]QueryAdvice, 0, len(adviceQueries))
+	for _, q := range adviceQueries {
+		plan, err := db.explain(ctx, q.query)
+		if err != nil {
+			return nil, fmt.Errorf("failed to explain %s: %v", q.name, err)
+		}
+
+		result := QueryAdvice{Name: q.name, Query: q.query, Plan: plan}
+		for _, step := range plan {
+			if step["table"] != q.table || step["type"] != "ALL" {
+				continue
+			}
+			rows, _ := strconv.ParseInt(fmt.Sprint(step["rows"]), 10, 64)
+			if rows >= minRows {
+				result.FullScan = true
+				result.Suggestion = q.suggestion
+			}
+		}
+		advice = append(advice, result)
+	}
+	return advice, nil
+}
+
+// explain returns the rows of EXPLAIN query, one map of column to value
+// per plan step. NULL columns map to nil.
+func (db *DB) explain(ctx context.Context, query string) ([]map[string]interface{}, error) {
+	rows, err := db.QueryContext(ctx, "EXPLAIN "+query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+
+	var plan []map[string]interface{}
+	for rows.Next() {
+		values := make([]sql.NullString, len(columns))
+		dest := make([]interface{}, len(columns))
+		for i := range values {
+			dest[i] = &values[i]
+		}
+		if err := rows.Scan(dest...); err != nil {
+			return nil, err
+		}
+
+		step := make(map[string]interface{}, len(columns))
+		for i, column := range columns {
+			if values[i].Valid {
+				step[column] = values[i].String
+			} else {
+				step[column] = nil
+			}
+		}
+		plan = append(plan, step)
+	}
+	return plan, rows.Err()
+}
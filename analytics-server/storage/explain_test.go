@@ -0,0 +1,60 @@
+package storage
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"regexp"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+// explainColumns are the EXPLAIN columns used by the tests.
+var explainColumns = []string{"id", "select_type", "table", "type", "key", "rows"}
+
+func TestAdviseIndexes(t *testing.T) {
+	db, mock := newMockDB(t, DriverMySQL)
+
+	// The events scan is large enough to flag, the sessions scan is not,
+	// and every other query uses an index
+	plans := map[string][]driver.Value{
+		"sessions_by_time": {"1", "SIMPLE", "sessions", "ALL", nil, "40"},
+		"events_by_time":   {"1", "SIMPLE", "events", "ALL", nil, "250000"},
+	}
+	for _, q := range adviceQueries {
+		plan, ok := plans[q.name]
+		if !ok {
+			plan = []driver.Value{"1", "SIMPLE", q.table, "ref", "idx_" + q.table, "12"}
+		}
+		mock.ExpectQuery(regexp.QuoteMeta("EXPLAIN " + q.query)).
+			WillReturnRows(sqlmock.NewRows(explainColumns).AddRow(plan...))
+	}
+
+	advice, err := db.AdviseIndexes(context.Background(), 1000)
+	if err != nil {
+		t.Fatalf("AdviseIndexes: %v", err)
+	}
+	if len(advice) != len(adviceQueries) {
+		t.Fatalf("got advice for %d queries, want %d", len(advice), len(adviceQueries))
+	}
+	for _, a := range advice {
+		flagged := a.Name == "events_by_time"
+		if a.FullScan != flagged || (a.Suggestion != "") != flagged {
+			t.Errorf("%s: full scan %v with suggestion %q, want flagged %v", a.Name, a.FullScan, a.Suggestion, flagged)
+		}
+		if len(a.Plan) != 1 || a.Plan[0]["select_type"] != "SIMPLE" {
+			t.Errorf("%s: plan = %v, want the EXPLAIN row", a.Name, a.Plan)
+		}
+	}
+	if key := advice[0].Plan[0]["key"]; key != nil {
+		t.Errorf("NULL key = %v, want nil", key)
+	}
+}
+
+func TestAdviseIndexesUnsupportedDriver(t *testing.T) {
+	db, _ := newMockDB(t, DriverPostgres)
+	if _, err := db.AdviseIndexes(context.Background(), 1000); !errors.Is(err, ErrExplainUnsupported) {
+		t.Errorf("AdviseIndexes = %v, want ErrExplainUnsupported", err)
+	}
+}
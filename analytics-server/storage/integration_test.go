@@ -0,0 +1,326 @@
+//go:build integration
+
+package storage
+
+import (
+	"context"
+	"cyber-swipe-analytics/config"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+// The integration tests run against the database configured by the usual
+// DB_* variables, e.g. one of the services of docker-compose.test.yml:
+//
+//	docker compose -f docker-compose.test.yml up -d
+//	DB_HOST=127.0.0.1 DB_PORT=3307 DB_USER=analytics DB_PASSWORD=analytics \
+//		DB_NAME=analytics_test go test -tags integration ./storage
+//
+// They create the schema with InitDB and leave their rows behind, so point
+// them at a throwaway database.
+
+// integrationDB runs InitDB against the configured database and closes the
+// connection at the end of the test.
+func integrationDB(t *testing.T) *DB {
+	t.Helper()
+	cfg, err := config.Load()
+	if err != nil {
+		t.Fatalf("config.Load: %v", err)
+	}
+	db, err := InitDB(cfg)
+	if err != nil {
+		t.Fatalf("InitDB: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+// createIntegrationSession stores a session with an ID unique to this run
+// and returns the ID.
+func createIntegrationSession(t *testing.T, db *DB) string {
+	t.Helper()
+	sessionID := fmt.Sprintf("integration-%d", time.Now().UnixNano())
+	_, err := db.ExecContext(context.Background(), `
+		INSERT INTO sessions (session_id, user_id, platform, resolution)
+		VALUES (?, ?, ?, ?)
+	`, sessionID, "integration-user", "ios", "1170x2532")
+	if err != nil {
+		t.Fatalf("inserting session: %v", err)
+	}
+	return sessionID
+}
+
+func TestIntegrationInitDBStoresPerformanceMetrics(t *testing.T) {
+	// Creating the tables again on an initialized database must not fail
+	integrationDB(t)
+	db := integrationDB(t)
+	ctx := context.Background()
+	sessionID := createIntegrationSession(t, db)
+
+	_, err := db.ExecContext(ctx, `
+		INSERT INTO performance_metrics (session_id, fps, memory_usage, cpu_usage, gpu_usage, network_latency)
+		VALUES (?, ?, ?, ?, ?, ?)
+	`, sessionID, 58.5, 512, 0.4, 0.6, 45)
+	if err != nil {
+		t.Fatalf("inserting performance metrics: %v", err)
+	}
+
+	var fps float64
+	var memory, latency int64
+	var timestamp time.Time
+	err = db.QueryRowContext(ctx, `
+		SELECT fps, memory_usage, network_latency, timestamp
+		FROM performance_metrics
+		WHERE session_id = ?
+	`, sessionID).Scan(&fps, &memory, &latency, &timestamp)
+	if err != nil {
+		t.Fatalf("reading performance metrics: %v", err)
+	}
+	if fps != 58.5 || memory != 512 || latency != 45 {
+		t.Errorf("got fps %v, memory %d, latency %d; want 58.5, 512, 45", fps, memory, latency)
+	}
+	if timestamp.IsZero() {
+		t.Error("timestamp was not defaulted")
+	}
+}
+
+func TestIntegrationEventsKeepMilliseconds(t *testing.T) {
+	db := integrationDB(t)
+	ctx := context.Background()
+	sessionID := createIntegrationSession(t, db)
+
+	// Both events take the created_at default. Stored in whole seconds
+	// they would be equal or a whole second apart
+	for _, cardID := range []string{"first", "second"} {
+		_, err := db.ExecContext(ctx, `
+			INSERT INTO events (session_id, event_type, card_id)
+			VALUES (?, ?, ?)
+		`, sessionID, "button_tap", cardID)
+		if err != nil {
+			t.Fatalf("inserting event: %v", err)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	rows, err := db.QueryContext(ctx, `
+		SELECT created_at FROM events
+		WHERE session_id = ?
+		ORDER BY id
+	`, sessionID)
+	if err != nil {
+		t.Fatalf("reading events: %v", err)
+	}
+	defer rows.Close()
+	var created []time.Time
+	for rows.Next() {
+		var createdAt time.Time
+		if err := rows.Scan(&createdAt); err != nil {
+			t.Fatalf("reading events: %v", err)
+		}
+		created = append(created, createdAt)
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatalf("reading events: %v", err)
+	}
+	if len(created) != 2 {
+		t.Fatalf("read %d events, want 2", len(created))
+	}
+	if gap := created[1].Sub(created[0]); gap <= 0 || gap >= time.Second {
+		t.Errorf("created_at %v then %v, want them apart by less than a second on %s",
+			created[0], created[1], db.Driver)
+	}
+}
+
+func TestIntegrationSchemaHasNoDrift(t *testing.T) {
+	db := integrationDB(t)
+
+	// InitDB leaves every table and column the code expects in place
+	drift, err := checkSchema(db)
+	if err != nil {
+		t.Fatalf("checkSchema: %v", err)
+	}
+	if len(drift) > 0 {
+		t.Errorf("schema drift on %s: %v", db.Driver, drift)
+	}
+}
+
+func TestIntegrationCategoryUpsert(t *testing.T) {
+	db := integrationDB(t)
+	ctx := context.Background()
+	sessionID := createIntegrationSession(t, db)
+
+	// The same upsert as the category stats endpoint, in the dialect of the
+	// configured driver: the second insert adds to the first row
+	query := `
+		INSERT INTO category_stats (
+			session_id, category_name, total_cards, accepted_cards,
+			average_decision_time, completion_time
+		) VALUES (?, ?, 1, ?, 0, 0)
+	` + db.Upsert([]string{"session_id", "category_name"},
+		"accepted_cards = category_stats.accepted_cards + "+db.Inserted("accepted_cards"),
+		"total_cards = category_stats.total_cards + 1",
+	)
+	for _, accepted := range []int{1, 0} {
+		if _, err := db.ExecContext(ctx, query, sessionID, "phishing", accepted); err != nil {
+			t.Fatalf("upserting category stats on %s: %v", db.Driver, err)
+		}
+	}
+
+	var rows, total, accepted int
+	err := db.QueryRowContext(ctx, `
+		SELECT COUNT(*), SUM(total_cards), SUM(accepted_cards)
+		FROM category_stats
+		WHERE session_id = ? AND category_name = ?
+	`, sessionID, "phishing").Scan(&rows, &total, &accepted)
+	if err != nil {
+		t.Fatalf("reading category stats: %v", err)
+	}
+	if rows != 1 || total != 2 || accepted != 1 {
+		t.Errorf("got %d rows with %d cards, %d accepted; want 1 row with 2 cards, 1 accepted", rows, total, accepted)
+	}
+}
+
+func TestIntegrationInitDBCreatesSecondaryIndexes(t *testing.T) {
+	db := integrationDB(t)
+	ctx := context.Background()
+
+	for _, index := range secondaryIndexes {
+		var columns []string
+		var unique bool
+		if db.Driver == DriverPostgres {
+			var definition string
+			err := db.QueryRowContext(ctx, `
+				SELECT indexdef FROM pg_indexes
+				WHERE schemaname = `+db.CurrentSchema()+` AND tablename = ? AND indexname = ?
+			`, index.table, index.name).Scan(&definition)
+			if err != nil {
+				t.Errorf("index %s: %v", index.name, err)
+				continue
+			}
+			// e.g. CREATE UNIQUE INDEX name ON public.events USING btree (session_id, seq)
+			unique = strings.HasPrefix(definition, "CREATE UNIQUE INDEX")
+			if open := strings.LastIndex(definition, "("); open >= 0 {
+				columns = strings.Split(strings.TrimSuffix(definition[open+1:], ")"), ", ")
+			}
+		} else {
+			rows, err := db.QueryContext(ctx, `
+				SELECT column_name, non_unique FROM information_schema.statistics
+				WHERE table_schema = `+db.CurrentSchema()+` AND table_name = ? AND index_name = ?
+				ORDER BY seq_in_index
+			`, index.table, index.name)
+			if err != nil {
+				t.Fatalf("reading index %s: %v", index.name, err)
+			}
+			for rows.Next() {
+				var column string
+				var nonUnique bool
+				if err := rows.Scan(&column, &nonUnique); err != nil {
+					t.Fatalf("reading index %s: %v", index.name, err)
+				}
+				columns = append(columns, column)
+				unique = !nonUnique
+			}
+			rows.Close()
+		}
+
+		if got := strings.Join(columns, ", "); got != index.columns || unique != index.unique {
+			t.Errorf("index %s on %s: columns %q, unique %v; want %q, unique %v",
+				index.name, index.table, got, unique, index.columns, index.unique)
+		}
+	}
+}
+
+func TestIntegrationInitDBMergesDuplicateCategoryStats(t *testing.T) {
+	db := integrationDB(t)
+	if db.Driver != DriverMySQL {
+		t.Skip("PostgreSQL tables were created with the unique key")
+	}
+	ctx := context.Background()
+
+	// Turn category_stats back into the table of setup_database.sql without
+	// the unique key; the foreign key needs another index on session_id
+	// meanwhile
+	if err := ensureIndex(db, secondaryIndex{"idx_integration_category_stats_session", "category_stats", "session_id", false}); err != nil {
+		t.Fatalf("creating session index: %v", err)
+	}
+	if _, err := db.ExecContext(ctx, "DROP INDEX uniq_category_stats_session_category ON category_stats"); err != nil {
+		t.Fatalf("dropping unique key: %v", err)
+	}
+
+	// Without the key every report was stored as a row of its own
+	sessionID := createIntegrationSession(t, db)
+	for _, row := range []struct {
+		total, accepted, completion int
+		decisionTime                float64
+	}{
+		{2, 1, 10, 1},
+		{1, 1, 5, 4},
+		{1, 0, 0, 0},
+	} {
+		_, err := db.ExecContext(ctx, `
+			INSERT INTO category_stats (
+				session_id, category_name, total_cards, accepted_cards,
+				average_decision_time, completion_time
+			) VALUES (?, ?, ?, ?, ?, ?)
+		`, sessionID, "phishing", row.total, row.accepted, row.decisionTime, row.completion)
+		if err != nil {
+			t.Fatalf("inserting category stats: %v", err)
+		}
+	}
+
+	db = integrationDB(t)
+	var rows, total, accepted, completion int
+	var decisionTime float64
+	err := db.QueryRowContext(ctx, `
+		SELECT COUNT(*), SUM(total_cards), SUM(accepted_cards), SUM(completion_time), MAX(average_decision_time)
+		FROM category_stats
+		WHERE session_id = ? AND category_name = ?
+	`, sessionID, "phishing").Scan(&rows, &total, &accepted, &completion, &decisionTime)
+	if err != nil {
+		t.Fatalf("reading category stats: %v", err)
+	}
+	if rows != 1 || total != 4 || accepted != 2 || completion != 15 || decisionTime != 1.5 {
+		t.Errorf("got %d rows with %d cards, %d accepted, %ds, %vs per decision; want 1 row with 4 cards, 2 accepted, 15s, 1.5s per decision",
+			rows, total, accepted, completion, decisionTime)
+	}
+
+	var exists bool
+	err = db.QueryRowContext(ctx, `
+		SELECT EXISTS(
+			SELECT 1 FROM information_schema.statistics
+			WHERE table_schema = DATABASE() AND table_name = ? AND index_name = ?
+		)
+	`, "category_stats", "uniq_category_stats_session_category").Scan(&exists)
+	if err != nil {
+		t.Fatalf("reading indexes: %v", err)
+	}
+	if !exists {
+		t.Error("unique key not recreated")
+	}
+}
+
+func TestIntegrationSessionsStoreLongDeviceModels(t *testing.T) {
+	db := integrationDB(t)
+	ctx := context.Background()
+
+	// The session binding accepts device models of up to 255 characters
+	sessionID := fmt.Sprintf("integration-device-%d", time.Now().UnixNano())
+	deviceModel := strings.Repeat("d", 255)
+	_, err := db.ExecContext(ctx, `
+		INSERT INTO sessions (session_id, user_id, platform, resolution, device_model)
+		VALUES (?, ?, ?, ?, ?)
+	`, sessionID, "integration-user", "ios", "1170x2532", deviceModel)
+	if err != nil {
+		t.Fatalf("inserting session: %v", err)
+	}
+
+	var stored string
+	if err := db.QueryRowContext(ctx, "SELECT device_model FROM sessions WHERE session_id = ?", sessionID).Scan(&stored); err != nil {
+		t.Fatalf("reading session: %v", err)
+	}
+	if stored != deviceModel {
+		t.Errorf("device_model stored with %d characters, want 255", len(stored))
+	}
+}
@@ -0,0 +1,48 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+)
+
+// IntegrityReport counts rows that break the expected relations between
+// the tables: child rows whose session_id matches no session, and sessions
+// without any event.
+type IntegrityReport struct {
+	OrphanedRows         map[string]int64 `json:"orphaned_rows"`
+	SessionsWithoutEvent int64            `json:"sessions_without_events"`
+}
+
+// orphanChildTables lists the tables whose rows reference a session.
+var orphanChildTables = []string{"events", "performance_metrics", "category_stats"}
+
+// CheckIntegrity builds an IntegrityReport. It only reads; repairing the
+// reported rows is left to the operator.
+func (db *DB) CheckIntegrity(ctx context.Context) (*IntegrityReport, error) {
+	report := &IntegrityReport{OrphanedRows: make(map[string]int64)}
+
+	for _, table := range orphanChildTables {
+		var count int64
+		err := db.QueryRowContext(ctx, fmt.Sprintf(`
+			SELECT COUNT(*)
+			FROM %s c
+			LEFT JOIN sessions s ON s.session_id = c.session_id
+			WHERE s.session_id IS NULL
+		`, table)).Scan(&count)
+		if err != nil {
+			return nil, fmt.Errorf("failed to count orphaned %s rows: %v", table, err)
+		}
+		report.OrphanedRows[table] = count
+	}
+
+	err := db.QueryRowContext(ctx, `
+		SELECT COUNT(*)
+		FROM sessions s
+		WHERE NOT EXISTS (SELECT 1 FROM events e WHERE e.session_id = s.session_id)
+	`).Scan(&report.SessionsWithoutEvent)
+	if err != nil {
+		return nil, fmt.Errorf("failed to count sessions without events: %v", err)
+	}
+
+	return report, nil
+}
@@ -0,0 +1,35 @@
+package storage
+
+import (
+	"context"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestCheckIntegrity(t *testing.T) {
+	db, mock := newMockDB(t, DriverMySQL)
+
+	// One event was left behind by a session deleted with the foreign key
+	// checks off, and one session never recorded an event
+	orphans := map[string]int{"events": 1, "performance_metrics": 0, "category_stats": 0}
+	for _, table := range orphanChildTables {
+		mock.ExpectQuery(`FROM ` + table + ` c\s+LEFT JOIN sessions s ON s.session_id = c.session_id\s+WHERE s.session_id IS NULL`).
+			WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(orphans[table]))
+	}
+	mock.ExpectQuery(`WHERE NOT EXISTS \(SELECT 1 FROM events e WHERE e.session_id = s.session_id\)`).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+
+	report, err := db.CheckIntegrity(context.Background())
+	if err != nil {
+		t.Fatalf("CheckIntegrity: %v", err)
+	}
+	for table, count := range orphans {
+		if report.OrphanedRows[table] != int64(count) {
+			t.Errorf("orphaned %s = %d, want %d", table, report.OrphanedRows[table], count)
+		}
+	}
+	if report.SessionsWithoutEvent != 1 {
+		t.Errorf("sessions without events = %d, want 1", report.SessionsWithoutEvent)
+	}
+}
@@ -0,0 +1,134 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrSessionNotFound is returned by MergeSessions when one of the sessions
+// does not exist.
+var ErrSessionNotFound = errors.New("session not found")
+
+// ErrMergeConflict is returned by MergeSessions when the secondary
+// sessions' events cannot be moved because their sequence numbers clash
+// with the primary session's.
+var ErrMergeConflict = errors.New("event sequence numbers of the sessions overlap")
+
+// MergeResult describes a finished session merge: the number of rows
+// reassigned to the primary session per table, and the number of
+// secondary sessions deleted.
+type MergeResult struct {
+	PrimarySessionID string           `json:"primary_session_id"`
+	Moved            map[string]int64 `json:"moved"`
+	SessionsDeleted  int64            `json:"sessions_deleted"`
+}
+
+// MergeSessions reassigns the events, performance metrics, and category
+// stats of the secondary sessions to the primary session and deletes the
+// secondary sessions, all in one transaction. Category stats recorded for
+// the same category in several sessions are added up.
+func (db *DB) MergeSessions(ctx context.Context, primary string, secondaries []string) (*MergeResult, error) {
+	ctx, cancel := db.withQueryTimeout(ctx)
+	defer cancel()
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	all := append([]string{primary}, secondaries...)
+	args := make([]interface{}, len(all))
+	for i, id := range all {
+		args[i] = id
+	}
+	rows, err := tx.QueryContext(ctx, db.Rebind(fmt.Sprintf(
+		"SELECT id FROM sessions WHERE session_id IN (%s) FOR UPDATE", placeholders(len(all)))), args...)
+	if err != nil {
+		return nil, fmt.Errorf("error locking sessions: %v", err)
+	}
+	found := 0
+	for rows.Next() {
+		found++
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error locking sessions: %v", err)
+	}
+	if found != len(all) {
+		return nil, ErrSessionNotFound
+	}
+
+	secondaryArgs := args[1:]
+	in := placeholders(len(secondaries))
+	result := &MergeResult{PrimarySessionID: primary, Moved: make(map[string]int64)}
+
+	for _, table := range []string{"events", "performance_metrics"} {
+		res, err := tx.ExecContext(ctx, db.Rebind(fmt.Sprintf(
+			"UPDATE %s SET session_id = ? WHERE session_id IN (%s)", table, in)),
+			append([]interface{}{primary}, secondaryArgs...)...)
+		if IsDuplicateKeyError(err) {
+			return nil, ErrMergeConflict
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error moving %s: %v", table, err)
+		}
+		if result.Moved[table], err = res.RowsAffected(); err != nil {
+			return nil, err
+		}
+	}
+
+	// Category stats are unique per session and category, so they are
+	// folded into the primary session's rows instead of being updated. The
+	// sums are read through a derived table so that the upsert assignments
+	// can only refer to the existing row and the inserted values.
+	_, err = tx.ExecContext(ctx, db.Rebind(fmt.Sprintf(`
+		INSERT INTO category_stats (
+			session_id, category_name, total_cards, accepted_cards,
+			average_decision_time, completion_time
+		)
+		SELECT * FROM (
+			SELECT ? as session_id, category_name, SUM(total_cards) as total_cards,
+				SUM(accepted_cards) as accepted_cards,
+				AVG(average_decision_time) as average_decision_time,
+				SUM(completion_time) as completion_time
+			FROM category_stats
+			WHERE session_id IN (%s)
+			GROUP BY category_name
+		) merged
+	`, in)+db.Upsert([]string{"session_id", "category_name"},
+		"total_cards = category_stats.total_cards + "+db.Inserted("total_cards"),
+		"accepted_cards = category_stats.accepted_cards + "+db.Inserted("accepted_cards"),
+		"completion_time = category_stats.completion_time + "+db.Inserted("completion_time"),
+	)), append([]interface{}{primary}, secondaryArgs...)...)
+	if err != nil {
+		return nil, fmt.Errorf("error merging category stats: %v", err)
+	}
+	res, err := tx.ExecContext(ctx, db.Rebind(fmt.Sprintf("DELETE FROM category_stats WHERE session_id IN (%s)", in)), secondaryArgs...)
+	if err != nil {
+		return nil, fmt.Errorf("error deleting merged category stats: %v", err)
+	}
+	if result.Moved["category_stats"], err = res.RowsAffected(); err != nil {
+		return nil, err
+	}
+
+	res, err = tx.ExecContext(ctx, db.Rebind(fmt.Sprintf("DELETE FROM sessions WHERE session_id IN (%s)", in)), secondaryArgs...)
+	if err != nil {
+		return nil, fmt.Errorf("error deleting merged sessions: %v", err)
+	}
+	if result.SessionsDeleted, err = res.RowsAffected(); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// placeholders returns a comma-separated list of n query placeholders.
+func placeholders(n int) string {
+	return strings.TrimSuffix(strings.Repeat("?, ", n), ", ")
+}
@@ -0,0 +1,88 @@
+package storage
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/go-sql-driver/mysql"
+)
+
+// expectMergeLock expects the sessions of a merge to be locked, of which
+// found exist.
+func expectMergeLock(mock sqlmock.Sqlmock, found int, ids ...string) {
+	args := make([]driver.Value, len(ids))
+	for i, id := range ids {
+		args[i] = id
+	}
+	rows := sqlmock.NewRows([]string{"id"})
+	for i := 0; i < found; i++ {
+		rows.AddRow(i + 1)
+	}
+	mock.ExpectBegin()
+	mock.ExpectQuery(`SELECT id FROM sessions WHERE session_id IN \(\?(, \?)*\) FOR UPDATE`).
+		WithArgs(args...).
+		WillReturnRows(rows)
+}
+
+func TestMergeSessions(t *testing.T) {
+	db, mock := newMockDB(t, DriverMySQL)
+
+	expectMergeLock(mock, 3, "p", "s1", "s2")
+	mock.ExpectExec(`UPDATE events SET session_id = \? WHERE session_id IN \(\?, \?\)`).
+		WithArgs("p", "s1", "s2").
+		WillReturnResult(sqlmock.NewResult(0, 12))
+	mock.ExpectExec(`UPDATE performance_metrics SET session_id = \? WHERE session_id IN \(\?, \?\)`).
+		WithArgs("p", "s1", "s2").
+		WillReturnResult(sqlmock.NewResult(0, 4))
+	mock.ExpectExec(`INSERT INTO category_stats \(.*\)\s+SELECT \* FROM \(\s+SELECT \? as session_id, category_name, SUM\(total_cards\).*`+
+		`WHERE session_id IN \(\?, \?\)\s+GROUP BY category_name\s+\) merged\s+`+
+		`ON DUPLICATE KEY UPDATE total_cards = category_stats.total_cards \+ VALUES\(total_cards\)`).
+		WithArgs("p", "s1", "s2").
+		WillReturnResult(sqlmock.NewResult(0, 3))
+	mock.ExpectExec(`DELETE FROM category_stats WHERE session_id IN \(\?, \?\)`).
+		WithArgs("s1", "s2").
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectExec(`DELETE FROM sessions WHERE session_id IN \(\?, \?\)`).
+		WithArgs("s1", "s2").
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectCommit()
+
+	result, err := db.MergeSessions(context.Background(), "p", []string{"s1", "s2"})
+	if err != nil {
+		t.Fatalf("MergeSessions: %v", err)
+	}
+	want := map[string]int64{"events": 12, "performance_metrics": 4, "category_stats": 2}
+	for table, moved := range want {
+		if result.Moved[table] != moved {
+			t.Errorf("moved %d %s, want %d", result.Moved[table], table, moved)
+		}
+	}
+	if result.PrimarySessionID != "p" || result.SessionsDeleted != 2 {
+		t.Errorf("result = %+v, want 2 sessions merged into p", result)
+	}
+}
+
+func TestMergeSessionsMissingSession(t *testing.T) {
+	db, mock := newMockDB(t, DriverMySQL)
+	expectMergeLock(mock, 2, "p", "s1", "s2")
+	mock.ExpectRollback()
+
+	if _, err := db.MergeSessions(context.Background(), "p", []string{"s1", "s2"}); !errors.Is(err, ErrSessionNotFound) {
+		t.Errorf("MergeSessions = %v, want ErrSessionNotFound", err)
+	}
+}
+
+func TestMergeSessionsSequenceConflict(t *testing.T) {
+	db, mock := newMockDB(t, DriverMySQL)
+	expectMergeLock(mock, 3, "p", "s1", "s2")
+	mock.ExpectExec(`UPDATE events SET session_id = \?`).
+		WillReturnError(&mysql.MySQLError{Number: 1062, Message: "Duplicate entry"})
+	mock.ExpectRollback()
+
+	if _, err := db.MergeSessions(context.Background(), "p", []string{"s1", "s2"}); !errors.Is(err, ErrMergeConflict) {
+		t.Errorf("MergeSessions = %v, want ErrMergeConflict", err)
+	}
+}
@@ -0,0 +1,12 @@
+package storage
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// queryErrorsTotal counts failed database queries.
+var queryErrorsTotal = promauto.NewCounter(prometheus.CounterOpts{
+	Name: "analytics_db_errors_total",
+	Help: "Database queries that failed.",
+})
@@ -0,0 +1,117 @@
+package storage
+
+// postgresSchema creates the tables of createTables in the PostgreSQL
+// dialect. Keep both in sync.
+var postgresSchema = []string{
+	`CREATE TABLE IF NOT EXISTS sessions (
+		id SERIAL PRIMARY KEY,
+		session_id VARCHAR(255) NOT NULL UNIQUE,
+		user_id VARCHAR(255) NOT NULL,
+		platform VARCHAR(50) NOT NULL,
+		resolution VARCHAR(50) NOT NULL,
+		device_model VARCHAR(255) NULL,
+		os_version VARCHAR(50) NULL,
+		os_major INT NULL,
+		os_minor INT NULL,
+		os_patch INT NULL,
+		app_version VARCHAR(50) NULL,
+		tags JSONB NULL,
+		ip_address VARCHAR(45) NULL,
+		user_agent TEXT NULL,
+		created_at TIMESTAMP(3) DEFAULT CURRENT_TIMESTAMP(3),
+		ended_at TIMESTAMP(3) NULL
+	)`,
+	`CREATE TABLE IF NOT EXISTS events (
+		id SERIAL PRIMARY KEY,
+		session_id VARCHAR(255) NOT NULL REFERENCES sessions(session_id) ON DELETE CASCADE,
+		event_type VARCHAR(50) NOT NULL,
+		card_id VARCHAR(255),
+		direction VARCHAR(10),
+		success BOOLEAN,
+		duration FLOAT,
+		start_x FLOAT,
+		start_y FLOAT,
+		end_x FLOAT,
+		end_y FLOAT,
+		max_rotation FLOAT,
+		fps FLOAT,
+		memory_usage BIGINT,
+		swipe_distance FLOAT,
+		swipe_velocity FLOAT,
+		seq INT NULL,
+		client_event_id VARCHAR(255) NULL,
+		properties JSONB NULL,
+		created_at TIMESTAMP(3) DEFAULT CURRENT_TIMESTAMP(3),
+		CONSTRAINT uniq_events_session_seq UNIQUE (session_id, seq),
+		CONSTRAINT uniq_events_client_event_id UNIQUE (client_event_id)
+	)`,
+	`CREATE TABLE IF NOT EXISTS performance_metrics (
+		id SERIAL PRIMARY KEY,
+		session_id VARCHAR(255) NOT NULL REFERENCES sessions(session_id) ON DELETE CASCADE,
+		timestamp TIMESTAMP(3) DEFAULT CURRENT_TIMESTAMP(3),
+		fps FLOAT,
+		memory_usage BIGINT,
+		cpu_usage FLOAT,
+		gpu_usage FLOAT,
+		network_latency INT,
+		battery_level FLOAT NULL,
+		thermal_state VARCHAR(20) NULL,
+		sample_rate FLOAT NULL
+	)`,
+	`CREATE TABLE IF NOT EXISTS category_stats (
+		id SERIAL PRIMARY KEY,
+		session_id VARCHAR(255) NOT NULL REFERENCES sessions(session_id) ON DELETE CASCADE,
+		category_name VARCHAR(100) NOT NULL,
+		total_cards INT DEFAULT 0,
+		accepted_cards INT DEFAULT 0,
+		rejected_cards INT DEFAULT 0,
+		average_decision_time FLOAT DEFAULT 0,
+		completion_time INT DEFAULT 0,
+		created_at TIMESTAMP(3) DEFAULT CURRENT_TIMESTAMP(3),
+		CONSTRAINT uniq_category_stats_session_category UNIQUE (session_id, category_name)
+	)`,
+	`CREATE TABLE IF NOT EXISTS backfill_progress (
+		target_column VARCHAR(64) PRIMARY KEY,
+		last_id INT NOT NULL DEFAULT 0,
+		updated_at TIMESTAMP(3) DEFAULT CURRENT_TIMESTAMP(3)
+	)`,
+	`CREATE TABLE IF NOT EXISTS dead_letters (
+		id SERIAL PRIMARY KEY,
+		kind VARCHAR(32) NOT NULL,
+		payload JSONB NOT NULL,
+		last_error TEXT,
+		attempts INT NOT NULL DEFAULT 0,
+		status VARCHAR(16) NOT NULL DEFAULT 'pending',
+		next_attempt_at TIMESTAMP(3) DEFAULT CURRENT_TIMESTAMP(3),
+		created_at TIMESTAMP(3) DEFAULT CURRENT_TIMESTAMP(3)
+	)`,
+	`CREATE INDEX IF NOT EXISTS idx_dead_letters_status ON dead_letters (status, next_attempt_at)`,
+	`CREATE TABLE IF NOT EXISTS opt_outs (
+		user_id VARCHAR(255) PRIMARY KEY,
+		created_at TIMESTAMP(3) DEFAULT CURRENT_TIMESTAMP(3)
+	)`,
+	`CREATE TABLE IF NOT EXISTS api_keys (
+		id SERIAL PRIMARY KEY,
+		key_hash CHAR(64) NOT NULL,
+		label VARCHAR(255) NOT NULL,
+		scope VARCHAR(20) NOT NULL,
+		created_at TIMESTAMP(3) DEFAULT CURRENT_TIMESTAMP(3),
+		revoked_at TIMESTAMP(3) NULL,
+		CONSTRAINT uniq_api_keys_key_hash UNIQUE (key_hash)
+	)`,
+	`CREATE TABLE IF NOT EXISTS property_keys (
+		key_name VARCHAR(64) PRIMARY KEY,
+		created_at TIMESTAMP(3) DEFAULT CURRENT_TIMESTAMP(3)
+	)`,
+}
+
+// createPostgresTables creates the analytics tables on PostgreSQL if they
+// don't already exist, then applies the same migrations as createTables.
+func createPostgresTables(database *DB) error {
+	for _, statement := range postgresSchema {
+		if _, err := database.Exec(statement); err != nil {
+			return err
+		}
+	}
+	return migrateTables(database)
+}
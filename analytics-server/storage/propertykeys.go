@@ -0,0 +1,47 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// PropertyKey is a custom event property key and the time it was first
+// stored.
+type PropertyKey struct {
+	Key       string    `json:"key"`
+	FirstSeen time.Time `json:"first_seen"`
+}
+
+// PropertyKeys returns the registered property keys in alphabetical order.
+func (db *DB) PropertyKeys(ctx context.Context) ([]PropertyKey, error) {
+	rows, err := db.QueryContext(ctx, "SELECT key_name, created_at FROM property_keys ORDER BY key_name")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := make([]PropertyKey, 0)
+	for rows.Next() {
+		var key PropertyKey
+		if err := rows.Scan(&key.Key, &key.FirstSeen); err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, rows.Err()
+}
+
+// AddPropertyKeys registers keys, leaving the ones already registered
+// unchanged, in one transaction.
+func (db *DB) AddPropertyKeys(ctx context.Context, keys []string) error {
+	query := db.Rebind("INSERT INTO property_keys (key_name) VALUES (?) " + db.Upsert([]string{"key_name"}))
+	return db.WithTx(ctx, func(tx *sql.Tx) error {
+		for _, key := range keys {
+			if _, err := tx.ExecContext(ctx, query, key); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
@@ -0,0 +1,121 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// PurgeResult describes a finished purge run: the number of rows deleted
+// per table and whether sessions old enough to purge remain.
+type PurgeResult struct {
+	Before  time.Time        `json:"before"`
+	Batches int              `json:"batches"`
+	Deleted map[string]int64 `json:"deleted"`
+	Done    bool             `json:"done"`
+}
+
+// PurgeSessions deletes sessions created before the given time together
+// with their events, performance metrics, and category stats, in the hot
+// tables and, once created, the archive tables. Sessions are deleted in
+// batches of batchSize, each in its own transaction, for at most
+// maxBatches batches, so large purges don't hold long locks.
+func (db *DB) PurgeSessions(ctx context.Context, before time.Time, batchSize, maxBatches int) (*PurgeResult, error) {
+	archived, err := db.archiveTablesExist(ctx)
+	if err != nil {
+		return nil, err
+	}
+	suffixes := []string{""}
+	if archived {
+		suffixes = append(suffixes, archiveTable(""))
+	}
+
+	result := &PurgeResult{Before: before, Deleted: make(map[string]int64)}
+	for _, suffix := range suffixes {
+		done := false
+		for !done && result.Batches < maxBatches {
+			deleted, err := db.purgeBatch(ctx, suffix, before, batchSize)
+			if err != nil {
+				return nil, err
+			}
+			sessions := deleted["sessions"+suffix]
+			if sessions > 0 {
+				result.Batches++
+				for table, count := range deleted {
+					result.Deleted[table] += count
+				}
+			}
+			done = sessions < int64(batchSize)
+		}
+		if !done {
+			return result, nil
+		}
+	}
+	result.Done = true
+	return result, nil
+}
+
+// purgeBatch deletes the oldest batchSize sessions created before the
+// given time from the sessions table with the given suffix, and their rows
+// in its child tables, in one transaction. The child rows are deleted
+// explicitly, as the archive tables have no cascading foreign keys, which
+// also lets them be counted. It returns the number of rows deleted per
+// table.
+func (db *DB) purgeBatch(ctx context.Context, suffix string, before time.Time, batchSize int) (map[string]int64, error) {
+	ctx, cancel := db.withQueryTimeout(ctx)
+	defer cancel()
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	sessions := "sessions" + suffix
+	rows, err := tx.QueryContext(ctx, db.Rebind(`
+		SELECT session_id FROM `+sessions+`
+		WHERE created_at < ?
+		ORDER BY id
+		LIMIT ?
+		FOR UPDATE
+	`), before, batchSize)
+	if err != nil {
+		return nil, fmt.Errorf("error selecting sessions to purge: %v", err)
+	}
+	var args []interface{}
+	for rows.Next() {
+		var sessionID string
+		if err := rows.Scan(&sessionID); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("error scanning sessions to purge: %v", err)
+		}
+		args = append(args, sessionID)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error selecting sessions to purge: %v", err)
+	}
+
+	deleted := make(map[string]int64)
+	if len(args) == 0 {
+		return deleted, nil
+	}
+	in := placeholders(len(args))
+
+	for _, table := range append(archiveChildTables, "sessions") {
+		table += suffix
+		result, err := tx.ExecContext(ctx, db.Rebind(fmt.Sprintf(
+			"DELETE FROM %s WHERE session_id IN (%s)", table, in)), args...)
+		if err != nil {
+			return nil, fmt.Errorf("error purging %s: %v", table, err)
+		}
+		if deleted[table], err = result.RowsAffected(); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return deleted, nil
+}
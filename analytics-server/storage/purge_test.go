@@ -0,0 +1,101 @@
+package storage
+
+import (
+	"context"
+	"database/sql/driver"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+// expectPurgeBatch expects one purge batch of the sessions table with the
+// given suffix deleting sessionIDs, with rowsPerTable rows deleted from
+// each child table.
+func expectPurgeBatch(mock sqlmock.Sqlmock, suffix string, before time.Time, batchSize int, sessionIDs []string, rowsPerTable int64) {
+	mock.ExpectBegin()
+	rows := sqlmock.NewRows([]string{"session_id"})
+	args := make([]driver.Value, len(sessionIDs))
+	for i, id := range sessionIDs {
+		rows.AddRow(id)
+		args[i] = id
+	}
+	mock.ExpectQuery(`SELECT session_id FROM sessions`+suffix+`\s+WHERE created_at < \?\s+ORDER BY id\s+LIMIT \?\s+FOR UPDATE`).
+		WithArgs(before, batchSize).
+		WillReturnRows(rows)
+	if len(sessionIDs) == 0 {
+		mock.ExpectRollback()
+		return
+	}
+	for _, table := range append(archiveChildTables, "sessions") {
+		deleted := rowsPerTable
+		if table == "sessions" {
+			deleted = int64(len(sessionIDs))
+		}
+		mock.ExpectExec(`DELETE FROM ` + table + suffix + ` WHERE session_id IN`).
+			WithArgs(args...).
+			WillReturnResult(sqlmock.NewResult(0, deleted))
+	}
+	mock.ExpectCommit()
+}
+
+func TestPurgeSessions(t *testing.T) {
+	db, mock := newMockDB(t, DriverMySQL)
+	before := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	// Only the old sessions are selected: a full batch, then a partial
+	// one, and the archive's single old session
+	expectArchiveTables(mock, true)
+	expectPurgeBatch(mock, "", before, 2, []string{"old1", "old2"}, 4)
+	expectPurgeBatch(mock, "", before, 2, []string{"old3"}, 1)
+	expectPurgeBatch(mock, "_archive", before, 2, []string{"archived1"}, 2)
+
+	result, err := db.PurgeSessions(context.Background(), before, 2, 10)
+	if err != nil {
+		t.Fatalf("PurgeSessions: %v", err)
+	}
+	if !result.Done || result.Batches != 3 {
+		t.Errorf("result = %+v, want done after 3 batches", result)
+	}
+	want := map[string]int64{
+		"sessions": 3, "events": 5, "performance_metrics": 5, "category_stats": 5,
+		"sessions_archive": 1, "events_archive": 2,
+	}
+	for table, count := range want {
+		if result.Deleted[table] != count {
+			t.Errorf("deleted %d %s, want %d", result.Deleted[table], table, count)
+		}
+	}
+}
+
+func TestPurgeSessionsStopsAtMaxBatches(t *testing.T) {
+	db, mock := newMockDB(t, DriverMySQL)
+	before := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	expectArchiveTables(mock, false)
+	expectPurgeBatch(mock, "", before, 1, []string{"old1"}, 0)
+
+	result, err := db.PurgeSessions(context.Background(), before, 1, 1)
+	if err != nil {
+		t.Fatalf("PurgeSessions: %v", err)
+	}
+	if result.Done || result.Batches != 1 || result.Deleted["sessions"] != 1 {
+		t.Errorf("result = %+v, want one session deleted and more to do", result)
+	}
+}
+
+func TestPurgeSessionsWithoutExpiredData(t *testing.T) {
+	db, mock := newMockDB(t, DriverMySQL)
+	before := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	expectArchiveTables(mock, false)
+	expectPurgeBatch(mock, "", before, 100, nil, 0)
+
+	result, err := db.PurgeSessions(context.Background(), before, 100, 10)
+	if err != nil {
+		t.Fatalf("PurgeSessions: %v", err)
+	}
+	if !result.Done || result.Batches != 0 || len(result.Deleted) != 0 {
+		t.Errorf("result = %+v, want done with nothing deleted", result)
+	}
+}
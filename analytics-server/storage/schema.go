@@ -0,0 +1,106 @@
+package storage
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// expectedColumns lists, per table, the columns the handlers rely on.
+// Keep it in sync with createTables, createPostgresTables, and
+// setup_database.sql.
+var expectedColumns = map[string][]string{
+	"sessions": {
+		"id", "session_id", "user_id", "platform", "resolution",
+		"device_model", "os_version", "os_major", "os_minor", "os_patch",
+		"app_version", "tags", "ip_address", "user_agent", "created_at", "ended_at",
+	},
+	"events": {
+		"id", "session_id", "event_type", "card_id", "direction", "success",
+		"duration", "start_x", "start_y", "end_x", "end_y", "max_rotation",
+		"fps", "memory_usage", "swipe_distance", "swipe_velocity", "seq", "client_event_id",
+		"properties", "created_at",
+	},
+	"performance_metrics": {
+		"id", "session_id", "timestamp", "fps", "memory_usage",
+		"cpu_usage", "gpu_usage", "network_latency", "battery_level", "thermal_state",
+		"sample_rate",
+	},
+	"category_stats": {
+		"id", "session_id", "category_name", "total_cards", "accepted_cards",
+		"average_decision_time", "completion_time",
+	},
+	"backfill_progress": {
+		"target_column", "last_id", "updated_at",
+	},
+	"opt_outs": {
+		"user_id", "created_at",
+	},
+	"api_keys": {
+		"id", "key_hash", "label", "scope", "created_at", "revoked_at",
+	},
+	"property_keys": {
+		"key_name", "created_at",
+	},
+	"dead_letters": {
+		"id", "kind", "payload", "last_error", "attempts", "status",
+		"next_attempt_at", "created_at",
+	},
+}
+
+// checkSchema compares the live schema of the current database, read from
+// information_schema, against expectedColumns. It returns one description
+// per missing table or column; extra columns are not considered drift.
+func checkSchema(database *DB) ([]string, error) {
+	rows, err := database.Query(`
+		SELECT table_name, column_name
+		FROM information_schema.columns
+		WHERE table_schema = ` + database.CurrentSchema())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	live := make(map[string]map[string]bool)
+	for rows.Next() {
+		var table, column string
+		if err := rows.Scan(&table, &column); err != nil {
+			return nil, err
+		}
+		table = strings.ToLower(table)
+		if live[table] == nil {
+			live[table] = make(map[string]bool)
+		}
+		live[table][strings.ToLower(column)] = true
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return diffSchema(expectedColumns, live), nil
+}
+
+// diffSchema reports the tables and columns of expected that are absent
+// from live, in a stable order.
+func diffSchema(expected map[string][]string, live map[string]map[string]bool) []string {
+	tables := make([]string, 0, len(expected))
+	for table := range expected {
+		tables = append(tables, table)
+	}
+	sort.Strings(tables)
+
+	var drift []string
+	for _, table := range tables {
+		columns, ok := live[table]
+		if !ok {
+			drift = append(drift, fmt.Sprintf("table %s is missing", table))
+			continue
+		}
+		for _, column := range expected[table] {
+			if !columns[column] {
+				drift = append(drift, fmt.Sprintf("column %s.%s is missing", table, column))
+			}
+		}
+	}
+	return drift
+}
@@ -0,0 +1,94 @@
+package storage
+
+import (
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestCheckSchemaReportsMissingColumns(t *testing.T) {
+	db, mock := newMockDB(t, DriverMySQL)
+
+	// The live schema lacks events.start_y and the whole opt_outs table;
+	// extra columns are fine
+	rows := sqlmock.NewRows([]string{"table_name", "column_name"})
+	for table, columns := range expectedColumns {
+		if table == "opt_outs" {
+			continue
+		}
+		for _, column := range columns {
+			if table == "events" && column == "start_y" {
+				continue
+			}
+			rows.AddRow(table, column)
+		}
+	}
+	rows.AddRow("sessions", "legacy_flag")
+	mock.ExpectQuery(`SELECT table_name, column_name\s+FROM information_schema.columns\s+WHERE table_schema = DATABASE\(\)`).
+		WillReturnRows(rows)
+
+	drift, err := checkSchema(db)
+	if err != nil {
+		t.Fatalf("checkSchema: %v", err)
+	}
+	want := []string{"column events.start_y is missing", "table opt_outs is missing"}
+	if !reflect.DeepEqual(drift, want) {
+		t.Errorf("drift = %q, want %q", drift, want)
+	}
+}
+
+func TestAddedColumnsAreExpected(t *testing.T) {
+	for _, column := range addedColumns {
+		if !containsString(expectedColumns[column.table], column.name) {
+			t.Errorf("added column %s.%s is not in expectedColumns", column.table, column.name)
+		}
+	}
+}
+
+func TestMigrationsCoverSetupScript(t *testing.T) {
+	// A schema created by setup_database.sql, upgraded by the added
+	// columns, must not drift
+	script, err := os.ReadFile("../setup_database.sql")
+	if err != nil {
+		t.Fatal(err)
+	}
+	live := make(map[string]map[string]bool)
+	table := ""
+	for _, line := range strings.Split(string(script), "\n") {
+		fields := strings.Fields(line)
+		switch {
+		case len(fields) >= 6 && strings.Join(fields[:5], " ") == "CREATE TABLE IF NOT EXISTS":
+			table = fields[5]
+			live[table] = make(map[string]bool)
+		case strings.HasPrefix(line, ")"):
+			table = ""
+		case table != "" && len(fields) > 1 && !containsString(constraintKeywords, fields[0]):
+			live[table][fields[0]] = true
+		}
+	}
+	for _, column := range addedColumns {
+		if live[column.table] != nil {
+			live[column.table][column.name] = true
+		}
+	}
+
+	if drift := diffSchema(expectedColumns, live); len(drift) > 0 {
+		t.Errorf("drift after migrating a setup_database.sql schema: %q", drift)
+	}
+}
+
+// constraintKeywords start the lines of a CREATE TABLE that don't define
+// a column.
+var constraintKeywords = []string{"PRIMARY", "UNIQUE", "INDEX", "KEY", "FOREIGN", "CONSTRAINT"}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,49 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+)
+
+type timeoutFlagKey struct{}
+
+// WithTimeoutFlag returns a copy of ctx whose queries set the returned flag
+// when they exceed QueryTimeout, so the HTTP layer can tell a timed-out
+// request apart from other failures.
+func WithTimeoutFlag(ctx context.Context) (context.Context, *atomic.Bool) {
+	flag := new(atomic.Bool)
+	return context.WithValue(ctx, timeoutFlagKey{}, flag), flag
+}
+
+// IsTimeoutError reports whether err is caused by an exceeded deadline,
+// such as QueryTimeout.
+func IsTimeoutError(err error) bool {
+	return errors.Is(err, context.DeadlineExceeded)
+}
+
+// withQueryTimeout derives the context of one database call from ctx,
+// bounded by QueryTimeout when it is set.
+func (db *DB) withQueryTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if db.QueryTimeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, db.QueryTimeout)
+}
+
+// timeoutError marks err as a timeout when callCtx hit its deadline, since
+// drivers don't always return the context's error, and records the timeout
+// in the flag of callCtx, if any.
+func timeoutError(callCtx context.Context, err error) error {
+	if !errors.Is(callCtx.Err(), context.DeadlineExceeded) {
+		return err
+	}
+	if flag, ok := callCtx.Value(timeoutFlagKey{}).(*atomic.Bool); ok {
+		flag.Store(true)
+	}
+	if IsTimeoutError(err) {
+		return err
+	}
+	return fmt.Errorf("%w: %w", context.DeadlineExceeded, err)
+}
@@ -0,0 +1,63 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestQueryTimeoutIsReported(t *testing.T) {
+	db, mock := newMockDB(t, DriverMySQL)
+	db.QueryTimeout = 20 * time.Millisecond
+	captureLog(t)
+
+	mock.ExpectExec(`DELETE FROM sessions`).WillDelayFor(time.Second).WillReturnResult(sqlmock.NewResult(0, 1))
+
+	ctx, timedOut := WithTimeoutFlag(context.Background())
+	_, err := db.ExecContext(ctx, "DELETE FROM sessions WHERE session_id = ?", "s1")
+	if !IsTimeoutError(err) {
+		t.Fatalf("error = %v, want a timeout", err)
+	}
+	if !timedOut.Load() {
+		t.Error("timeout flag not set")
+	}
+}
+
+func TestCancelledContextIsNotATimeout(t *testing.T) {
+	db, _ := newMockDB(t, DriverMySQL)
+	db.QueryTimeout = time.Second
+	captureLog(t)
+
+	// The query isn't sent at all; the cancellation comes back as is
+	ctx, timedOut := WithTimeoutFlag(context.Background())
+	ctx, cancel := context.WithCancel(ctx)
+	cancel()
+	var count int
+	err := db.QueryRowContext(ctx, "SELECT COUNT(*) FROM sessions").Scan(&count)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("error = %v, want context.Canceled", err)
+	}
+	if IsTimeoutError(err) || timedOut.Load() {
+		t.Error("cancellation reported as a timeout")
+	}
+}
+
+func TestWithTxTimeout(t *testing.T) {
+	db, mock := newMockDB(t, DriverMySQL)
+	db.QueryTimeout = 20 * time.Millisecond
+
+	// The timeout bounds the whole transaction, not each statement
+	mock.ExpectBegin()
+	mock.ExpectRollback()
+	err := db.WithTx(context.Background(), func(tx *sql.Tx) error {
+		time.Sleep(50 * time.Millisecond)
+		return tx.Commit()
+	})
+	if !IsTimeoutError(err) {
+		t.Errorf("WithTx = %v, want a timeout", err)
+	}
+}
@@ -0,0 +1,30 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+)
+
+// WithTx runs fn in a transaction. The transaction is committed when fn
+// returns nil and rolled back otherwise, so the statements of fn take
+// effect together or not at all. fn's error is returned unchanged.
+// Statements run on the transaction must be passed through Rebind.
+// QueryTimeout bounds the whole transaction rather than each statement.
+func (db *DB) WithTx(ctx context.Context, fn func(*sql.Tx) error) error {
+	txCtx, cancel := db.withQueryTimeout(ctx)
+	defer cancel()
+
+	tx, err := db.BeginTx(txCtx, nil)
+	if err != nil {
+		return queryError(ctx, timeoutError(txCtx, err))
+	}
+	defer tx.Rollback()
+
+	if err := fn(tx); err != nil {
+		return timeoutError(txCtx, err)
+	}
+	if err := tx.Commit(); err != nil {
+		return queryError(ctx, timeoutError(txCtx, err))
+	}
+	return nil
+}
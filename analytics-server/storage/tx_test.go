@@ -0,0 +1,60 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestWithTxCommits(t *testing.T) {
+	db, mock := newMockDB(t, DriverMySQL)
+	mock.ExpectBegin()
+	mock.ExpectExec(`INSERT INTO opt_outs`).WithArgs("u1").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	err := db.WithTx(context.Background(), func(tx *sql.Tx) error {
+		_, err := tx.Exec(db.Rebind("INSERT INTO opt_outs (user_id) VALUES (?)"), "u1")
+		return err
+	})
+	if err != nil {
+		t.Fatalf("WithTx: %v", err)
+	}
+}
+
+func TestWithTxRollsBackOnError(t *testing.T) {
+	db, mock := newMockDB(t, DriverMySQL)
+	failure := errors.New("session vanished")
+
+	// The first write succeeds, then the closure fails: the transaction
+	// is rolled back, not committed, so the first write doesn't persist
+	mock.ExpectBegin()
+	mock.ExpectExec(`INSERT INTO category_stats`).WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectRollback()
+
+	err := db.WithTx(context.Background(), func(tx *sql.Tx) error {
+		if _, err := tx.Exec("INSERT INTO category_stats (session_id) VALUES (?)", "s1"); err != nil {
+			return err
+		}
+		return failure
+	})
+	if err != failure {
+		t.Fatalf("WithTx = %v, want the closure's error unchanged", err)
+	}
+}
+
+func TestWithTxBeginError(t *testing.T) {
+	db, mock := newMockDB(t, DriverMySQL)
+	mock.ExpectBegin().WillReturnError(errors.New("too many connections"))
+
+	called := false
+	err := db.WithTx(context.Background(), func(*sql.Tx) error {
+		called = true
+		return nil
+	})
+	if err == nil || called {
+		t.Errorf("WithTx = %v with the closure called %v, want an error without calling it", err, called)
+	}
+}